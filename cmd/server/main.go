@@ -1,124 +1,121 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/raybman/gomaterials-slt-sandbox/internal/handlers"
 	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/router"
 	"github.com/raybman/gomaterials-slt-sandbox/internal/service"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var cfg storeConfig
+	flag.StringVar(&cfg.backend, "store", "memory", "storage backend (memory, file, sql)")
+	flag.StringVar(&cfg.dataDir, "data-dir", "data", "directory for the file backend's log and snapshots")
+	flag.IntVar(&cfg.snapshotEvery, "snapshot-every", repository.DefaultSnapshotEvery, "log records between snapshots for the file backend")
+	flag.StringVar(&cfg.dbDriver, "db-driver", defaultDBDriver, "database/sql driver for the sql backend")
+	flag.StringVar(&cfg.dbDSN, "db-dsn", defaultDBDSN, "database connection string for the sql backend")
+	sweepEvery := flag.Duration("reservation-sweep", time.Minute, "interval between releases of expired reservations (0 disables)")
+	retention := flag.Duration("change-retention", 0, "age after which change events superseded by newer ones are removed (0 keeps them all)")
+	flag.Parse()
+
 	// Initialize components
-	repo := repository.NewInMemoryRepository()
+	repo, err := openStore(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 	svc := service.NewInventoryService(repo)
+	if *sweepEvery > 0 {
+		go sweepReservations(svc, *sweepEvery)
+	}
+	if *retention > 0 {
+		go compactChanges(svc, *retention)
+	}
 	handler := handlers.NewHandler(svc)
 
 	// Setup routes
-	mux := http.NewServeMux()
-
-	// Sellers
-	mux.HandleFunc("/api/sellers", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			handler.CreateSeller(w, r)
-		case http.MethodGet:
-			handler.ListSellers(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	// Buyers
-	mux.HandleFunc("/api/buyers", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			handler.CreateBuyer(w, r)
-		case http.MethodGet:
-			handler.ListBuyers(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	// Vendors
-	mux.HandleFunc("/api/vendors", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			handler.CreateVendor(w, r)
-		case http.MethodGet:
-			handler.ListVendors(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	// Products
-	mux.HandleFunc("/api/products", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			handler.CreateProduct(w, r)
-		case http.MethodGet:
-			handler.ListProducts(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	// Inventory
-	mux.HandleFunc("/api/inventory", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			handler.CreateInventoryItem(w, r)
-		case http.MethodGet:
-			handler.ListInventoryItems(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/api/inventory/update", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		handler.UpdateInventoryQuantity(w, r)
-	})
+	rt := router.New()
+	handler.RegisterRoutes(rt)
 
 	// Health check
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+	rt.HandleFunc("GET /health", "Health check", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
 	// Root handler
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
-			return
-		}
-		w.Write([]byte("Go Materials Inventory Management System\n\nAPI Endpoints:\n" +
-			"  POST   /api/sellers     - Create a seller\n" +
-			"  GET    /api/sellers     - List all sellers\n" +
-			"  POST   /api/buyers      - Create a buyer\n" +
-			"  GET    /api/buyers      - List all buyers\n" +
-			"  POST   /api/vendors     - Create a vendor\n" +
-			"  GET    /api/vendors     - List all vendors\n" +
-			"  POST   /api/products    - Create a product\n" +
-			"  GET    /api/products    - List all products\n" +
-			"  POST   /api/inventory   - Create an inventory item\n" +
-			"  GET    /api/inventory   - List all inventory items\n" +
-			"  POST   /api/inventory/update - Update inventory quantity\n" +
-			"  GET    /health          - Health check\n"))
+	rt.HandleFunc("GET /{$}", "API documentation", func(w http.ResponseWriter, r *http.Request) {
+		writeIndex(w, rt.Routes())
 	})
 
 	// Start server
 	port := "8080"
 	fmt.Printf("Starting server on port %s...\n", port)
 	fmt.Printf("Visit http://localhost:%s for API documentation\n", port)
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
+	if err := http.ListenAndServe(":"+port, rt); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// sweepReservations releases expired reservations every interval.
+// Reservations are also released lazily when an item is next reserved, so
+// the sweep only keeps Reserved current for items nobody is reserving.
+func sweepReservations(svc *service.InventoryService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if n, err := svc.ReleaseExpiredReservations(); err != nil {
+			log.Printf("releasing expired reservations: %v", err)
+		} else if n > 0 {
+			log.Printf("released %d expired reservations", n)
+		}
+	}
+}
+
+// compactChanges removes change events older than retention every hour,
+// keeping what as-of queries since then need
+func compactChanges(svc *service.InventoryService, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		if n, err := svc.CompactChanges(retention); err != nil {
+			log.Printf("compacting the change log: %v", err)
+		} else if n > 0 {
+			log.Printf("removed %d change events older than %v", n, retention)
+		}
+	}
+}
+
+// writeIndex writes the plain-text API documentation served at /
+func writeIndex(w http.ResponseWriter, routes []router.Route) {
+	current := make(map[string]bool)
+	fmt.Fprint(w, "Go Materials Inventory Management System\n\nAPI Endpoints:\n")
+	for _, route := range routes {
+		if !route.Deprecated {
+			current[route.Method+" "+route.Path] = true
+			fmt.Fprintf(w, "  %-7s %-32s - %s\n", route.Method, route.Path, route.Summary)
+		}
+	}
+
+	fmt.Fprintf(w, "\nDeprecated (%s routes are also served under /api without the version):\n", handlers.APIPrefix)
+	for _, route := range routes {
+		alias := route.Method + " " + handlers.APIPrefix + strings.TrimPrefix(route.Path, "/api")
+		if route.Deprecated && !current[alias] {
+			fmt.Fprintf(w, "  %-7s %-32s - %s\n", route.Method, route.Path, route.Summary)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository/migrations"
+)
+
+const migrateUsage = `usage: server migrate [flags] <command>
+
+Commands:
+  up          apply all pending migrations
+  down        roll back the most recent migration
+  goto N      migrate up or down to version N (0 removes everything)
+  version     print the current schema version
+
+Flags:
+`
+
+// runMigrate implements the migrate subcommand for the SQL backend
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	driver := fs.String("db-driver", defaultDBDriver, "database/sql driver name")
+	dsn := fs.String("db-dsn", defaultDBDSN, "database connection string")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open(*driver, *dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch fs.Arg(0) {
+	case "up":
+		err = migrations.Up(db)
+	case "down":
+		err = migrations.Down(db)
+	case "goto":
+		if fs.NArg() != 2 {
+			return fmt.Errorf("goto needs a target version")
+		}
+		target, convErr := strconv.Atoi(fs.Arg(1))
+		if convErr != nil {
+			return fmt.Errorf("invalid version %q", fs.Arg(1))
+		}
+		err = migrations.Migrate(db, target)
+	case "version":
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := migrations.Version(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("schema version %d (latest %d)\n", version, migrations.Latest())
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	defaultDBDriver = "sqlite"
+	defaultDBDSN    = "file:inventory.db?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+)
+
+// storeConfig holds the flags that select and configure the storage backend
+type storeConfig struct {
+	backend       string
+	dataDir       string
+	snapshotEvery int
+	dbDriver      string
+	dbDSN         string
+}
+
+// openStore returns the storage backend selected by cfg
+func openStore(cfg storeConfig) (repository.Store, error) {
+	switch cfg.backend {
+	case "memory":
+		return repository.NewInMemoryRepository(), nil
+	case "file":
+		return repository.OpenFileRepository(cfg.dataDir, repository.FileOptions{
+			SnapshotEvery: cfg.snapshotEvery,
+		})
+	case "sql":
+		db, err := sql.Open(cfg.dbDriver, cfg.dbDSN)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := repository.NewSQLRepository(db)
+		if err != nil {
+			db.Close()
+			if errors.Is(err, repository.ErrSchemaVersion) {
+				return nil, fmt.Errorf("%w (run the migrate subcommand)", err)
+			}
+			return nil, err
+		}
+		return repo, nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", cfg.backend)
+	}
+}
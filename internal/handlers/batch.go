@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/service"
+)
+
+var errUnknownOperation = errors.New("unknown batch operation")
+
+// batchOperation is one operation in a batch request. Data holds the entity
+// for create and update, the fields to change for patch, and the movement
+// for a movement. Version is the expected version, as If-Match would give
+// it for a single request.
+type batchOperation struct {
+	Op      string          `json:"op"`
+	Entity  string          `json:"entity"`
+	ID      string          `json:"id"`
+	Version int64           `json:"version"`
+	Cascade bool            `json:"cascade"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type batchRequest struct {
+	Operations []batchOperation `json:"operations"`
+}
+
+type batchResult struct {
+	Status int `json:"status"`
+	Data   any `json:"data,omitempty"`
+}
+
+// batchEntity maps the operations on one entity type to the service
+type batchEntity struct {
+	name   string
+	create func(svc *service.InventoryService, data json.RawMessage) (any, error)
+	update func(svc *service.InventoryService, id string, version int64, data json.RawMessage) (any, error)
+	patch  func(svc *service.InventoryService, id string, version int64, data json.RawMessage) (any, error)
+	delete func(svc *service.InventoryService, id string, version int64, cascade bool) error
+}
+
+var batchEntities = map[string]batchEntity{
+	"seller": {
+		name:   "Seller",
+		create: batchCreate((*service.InventoryService).CreateSeller),
+		update: batchUpdate((*service.InventoryService).UpdateSeller, func(s *models.Seller, id string, version int64) {
+			s.ID, s.Version = id, version
+		}),
+		patch: batchPatch((*service.InventoryService).PatchSeller),
+		delete: func(svc *service.InventoryService, id string, version int64, _ bool) error {
+			return svc.DeleteSeller(id, version)
+		},
+	},
+	"buyer": {
+		name:   "Buyer",
+		create: batchCreate((*service.InventoryService).CreateBuyer),
+		update: batchUpdate((*service.InventoryService).UpdateBuyer, func(b *models.Buyer, id string, version int64) {
+			b.ID, b.Version = id, version
+		}),
+		patch: batchPatch((*service.InventoryService).PatchBuyer),
+		delete: func(svc *service.InventoryService, id string, version int64, _ bool) error {
+			return svc.DeleteBuyer(id, version)
+		},
+	},
+	"vendor": {
+		name:   "Vendor",
+		create: batchCreate((*service.InventoryService).CreateVendor),
+		update: batchUpdate((*service.InventoryService).UpdateVendor, func(v *models.Vendor, id string, version int64) {
+			v.ID, v.Version = id, version
+		}),
+		patch:  batchPatch((*service.InventoryService).PatchVendor),
+		delete: (*service.InventoryService).DeleteVendor,
+	},
+	"product": {
+		name:   "Product",
+		create: batchCreate((*service.InventoryService).CreateProduct),
+		update: batchUpdate((*service.InventoryService).UpdateProduct, func(p *models.Product, id string, version int64) {
+			p.ID, p.Version = id, version
+		}),
+		patch:  batchPatch((*service.InventoryService).PatchProduct),
+		delete: (*service.InventoryService).DeleteProduct,
+	},
+	"inventory": {
+		name:   "Inventory item",
+		create: batchCreate((*service.InventoryService).CreateInventoryItem),
+		update: batchUpdate((*service.InventoryService).UpdateInventoryItem, func(i *models.InventoryItem, id string, version int64) {
+			i.ID, i.Version = id, version
+		}),
+		patch: batchPatch((*service.InventoryService).PatchInventoryItem),
+		delete: func(svc *service.InventoryService, id string, version int64, _ bool) error {
+			return svc.DeleteInventoryItem(id, version)
+		},
+	},
+}
+
+func batchCreate[T any](create func(*service.InventoryService, *T) error) func(*service.InventoryService, json.RawMessage) (any, error) {
+	return func(svc *service.InventoryService, data json.RawMessage) (any, error) {
+		var v T
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, errInvalidBody
+		}
+		if err := create(svc, &v); err != nil {
+			return nil, err
+		}
+		return &v, nil
+	}
+}
+
+func batchUpdate[T any](update func(*service.InventoryService, *T) error, setKey func(v *T, id string, version int64)) func(*service.InventoryService, string, int64, json.RawMessage) (any, error) {
+	return func(svc *service.InventoryService, id string, version int64, data json.RawMessage) (any, error) {
+		var v T
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, errInvalidBody
+		}
+		setKey(&v, id, version)
+		if err := update(svc, &v); err != nil {
+			return nil, err
+		}
+		return &v, nil
+	}
+}
+
+func batchPatch[T any](patch func(*service.InventoryService, string, int64, func(*T) error) (*T, error)) func(*service.InventoryService, string, int64, json.RawMessage) (any, error) {
+	return func(svc *service.InventoryService, id string, version int64, data json.RawMessage) (any, error) {
+		return patch(svc, id, version, func(v *T) error {
+			if err := json.Unmarshal(data, v); err != nil {
+				return errInvalidBody
+			}
+			return nil
+		})
+	}
+}
+
+// apply runs op against svc and returns the result to report for it
+func (op batchOperation) apply(svc *service.InventoryService) (batchResult, error) {
+	entity, ok := batchEntities[op.Entity]
+	if !ok {
+		return batchResult{}, errUnknownOperation
+	}
+
+	var data any
+	var err error
+	status := http.StatusOK
+	switch op.Op {
+	case "create":
+		status = http.StatusCreated
+		data, err = entity.create(svc, op.Data)
+	case "update":
+		data, err = entity.update(svc, op.ID, op.Version, op.Data)
+	case "patch":
+		data, err = entity.patch(svc, op.ID, op.Version, op.Data)
+	case "delete":
+		status = http.StatusNoContent
+		err = entity.delete(svc, op.ID, op.Version, op.Cascade)
+	case "movement":
+		if op.Entity != "inventory" {
+			return batchResult{}, errUnknownOperation
+		}
+		var movement models.StockMovement
+		if err := json.Unmarshal(op.Data, &movement); err != nil {
+			return batchResult{}, errInvalidBody
+		}
+		movement.ItemID = op.ID
+		status = http.StatusCreated
+		data, err = &movement, svc.PostMovement(&movement, op.Version)
+	default:
+		return batchResult{}, errUnknownOperation
+	}
+	if err != nil {
+		return batchResult{}, err
+	}
+	return batchResult{Status: status, Data: data}, nil
+}
+
+// batchError maps the error from a failed operation to a status and message
+func batchError(op batchOperation, err error) (int, string) {
+	name := batchEntities[op.Entity].name
+	switch err {
+	case errUnknownOperation:
+		return http.StatusBadRequest, fmt.Sprintf("Unknown operation %q on %q", op.Op, op.Entity)
+	case errInvalidBody:
+		return http.StatusBadRequest, "Invalid data"
+	case repository.ErrNotFound:
+		if op.Op == "create" {
+			return http.StatusBadRequest, "Referenced entity not found"
+		}
+		return http.StatusNotFound, name + " not found"
+	case repository.ErrAlreadyExists:
+		return http.StatusConflict, name + " already exists"
+	case repository.ErrInvalidReference:
+		return http.StatusBadRequest, "Referenced entity not found"
+	case repository.ErrInUse:
+		return http.StatusConflict, name + " is still referenced; set cascade to delete its dependents too"
+	case repository.ErrVersionMismatch:
+		return http.StatusPreconditionFailed, name + " has been modified"
+	case repository.ErrInsufficientStock:
+		return http.StatusConflict, "Insufficient stock"
+	case service.ErrInvalidMovement:
+		return http.StatusBadRequest, "Invalid movement"
+	case service.ErrLocationUnavailable:
+		return http.StatusBadRequest, "Location not found or inactive"
+	case service.ErrInvalidLot:
+		return http.StatusBadRequest, "Invalid lot"
+	case service.ErrLotExpired:
+		return http.StatusConflict, "Lot has expired"
+	case service.ErrSerializedStock:
+		return http.StatusConflict, "Stock of a serialized product changes only through its serials"
+	case service.ErrInvalidUnit, service.ErrFractionalQuantity:
+		return http.StatusBadRequest, unitErrorMessage(err)
+	case service.ErrBaseUnitInUse:
+		return http.StatusConflict, "A product with inventory items or orders cannot change its base unit"
+	case service.ErrInvalidPrice:
+		return http.StatusBadRequest, invalidPriceMessage
+	case service.ErrInvalidVariant:
+		return http.StatusBadRequest, "Invalid variant"
+	case service.ErrVariantExists:
+		return http.StatusConflict, variantExistsMessage
+	case service.ErrInvalidKit:
+		return http.StatusBadRequest, "Invalid kit"
+	}
+	return http.StatusInternalServerError, "Failed to apply operation"
+}
+
+// Batch applies a list of operations all-or-nothing. On success it returns
+// a result per operation; otherwise nothing is applied and the response
+// describes the first operation that failed.
+func (h *Handler) Batch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.Operations) == 0 {
+		respondError(w, http.StatusBadRequest, "Batch has no operations")
+		return
+	}
+
+	results := make([]batchResult, 0, len(req.Operations))
+	failed := -1
+	err := h.service.Atomically(func(svc *service.InventoryService) error {
+		for i, op := range req.Operations {
+			result, err := op.apply(svc)
+			if err != nil {
+				failed = i
+				return err
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		if failed < 0 {
+			respondError(w, http.StatusInternalServerError, "Failed to commit batch")
+			return
+		}
+		status, message := batchError(req.Operations[failed], err)
+		respondJSON(w, status, map[string]any{
+			"error": fmt.Sprintf("Operation %d failed: %s; no operations were applied", failed, message),
+			"index": failed,
+		})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{"results": results})
+}
@@ -2,7 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
 	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
@@ -31,6 +36,101 @@ func respondError(w http.ResponseWriter, status int, message string) {
 	respondJSON(w, status, map[string]string{"error": message})
 }
 
+var errInvalidBody = errors.New("invalid request body")
+
+// decodeInto returns a patch function that decodes the request body over an
+// existing entity, so only the fields present in the body change
+func decodeInto[T any](r *http.Request) func(*T) error {
+	return func(v *T) error {
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			return errInvalidBody
+		}
+		return nil
+	}
+}
+
+// queryBool reports whether the named query parameter is set to a true value
+func queryBool(r *http.Request, name string) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get(name))
+	return v
+}
+
+// queryInt returns the named query parameter as a non-negative integer, def
+// if it is absent, and false if it is malformed
+func queryInt(r *http.Request, name string, def int64) (int64, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// queryTime returns the named query parameter as an RFC 3339 time, whether
+// it is present, and false if it is malformed
+func queryTime(r *http.Request, name string) (t time.Time, present, ok bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return time.Time{}, false, true
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, true, err == nil
+}
+
+// queryAttributes returns the variant attributes a request filters by,
+// given as attr.<axis>=<value> query parameters, or nil if there are none
+func queryAttributes(r *http.Request) map[string]string {
+	var attributes map[string]string
+	for key, values := range r.URL.Query() {
+		axis, ok := strings.CutPrefix(key, "attr.")
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if attributes == nil {
+			attributes = make(map[string]string)
+		}
+		attributes[axis] = values[0]
+	}
+	return attributes
+}
+
+// setETag sets the ETag header to an entity version
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(version, 10)))
+}
+
+// ifMatch returns the version required by the request's If-Match header,
+// which is zero when the header is absent or "*". An If-Match that cannot
+// name a version (a weak or malformed tag, or a list) can never match, so
+// ifMatch responds with 412 and returns false.
+func ifMatch(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	if unquoted, err := strconv.Unquote(header); err == nil {
+		if version, err := strconv.ParseInt(unquoted, 10, 64); err == nil && version > 0 {
+			return version, true
+		}
+	}
+	respondError(w, http.StatusPreconditionFailed, "Precondition failed: If-Match does not match the current version")
+	return 0, false
+}
+
+// respondVersionMismatch reports a failed compare-and-swap: 412 when the
+// client sent If-Match, otherwise 409 because the entity changed while the
+// request was being handled
+func respondVersionMismatch(w http.ResponseWriter, r *http.Request, entity string) {
+	if r.Header.Get("If-Match") != "" {
+		respondError(w, http.StatusPreconditionFailed, "Precondition failed: "+entity+" has been modified")
+	} else {
+		respondError(w, http.StatusConflict, entity+" was modified concurrently; retry the request")
+	}
+}
+
 // Seller handlers
 
 func (h *Handler) CreateSeller(w http.ResponseWriter, r *http.Request) {
@@ -49,6 +149,7 @@ func (h *Handler) CreateSeller(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setETag(w, seller.Version)
 	respondJSON(w, http.StatusCreated, seller)
 }
 
@@ -61,6 +162,91 @@ func (h *Handler) ListSellers(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, sellers)
 }
 
+func (h *Handler) GetSeller(w http.ResponseWriter, r *http.Request) {
+	seller, err := h.service.GetSeller(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Seller not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get seller")
+		}
+		return
+	}
+	setETag(w, seller.Version)
+	respondJSON(w, http.StatusOK, seller)
+}
+
+func (h *Handler) UpdateSeller(w http.ResponseWriter, r *http.Request) {
+	var seller models.Seller
+	if err := json.NewDecoder(r.Body).Decode(&seller); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	seller.ID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	seller.Version = version
+
+	if err := h.service.UpdateSeller(&seller); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Seller not found")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Seller")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update seller")
+		}
+		return
+	}
+
+	setETag(w, seller.Version)
+	respondJSON(w, http.StatusOK, seller)
+}
+
+func (h *Handler) PatchSeller(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	seller, err := h.service.PatchSeller(r.PathValue("id"), version, decodeInto[models.Seller](r))
+	if err != nil {
+		if err == errInvalidBody {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Seller not found")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Seller")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update seller")
+		}
+		return
+	}
+
+	setETag(w, seller.Version)
+	respondJSON(w, http.StatusOK, seller)
+}
+
+func (h *Handler) DeleteSeller(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteSeller(r.PathValue("id"), version); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Seller not found")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Seller")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to delete seller")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // Buyer handlers
 
 func (h *Handler) CreateBuyer(w http.ResponseWriter, r *http.Request) {
@@ -73,12 +259,15 @@ func (h *Handler) CreateBuyer(w http.ResponseWriter, r *http.Request) {
 	if err := h.service.CreateBuyer(&buyer); err != nil {
 		if err == repository.ErrAlreadyExists {
 			respondError(w, http.StatusConflict, "Buyer already exists")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Price list not found")
 		} else {
 			respondError(w, http.StatusInternalServerError, "Failed to create buyer")
 		}
 		return
 	}
 
+	setETag(w, buyer.Version)
 	respondJSON(w, http.StatusCreated, buyer)
 }
 
@@ -91,6 +280,97 @@ func (h *Handler) ListBuyers(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, buyers)
 }
 
+func (h *Handler) GetBuyer(w http.ResponseWriter, r *http.Request) {
+	buyer, err := h.service.GetBuyer(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Buyer not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get buyer")
+		}
+		return
+	}
+	setETag(w, buyer.Version)
+	respondJSON(w, http.StatusOK, buyer)
+}
+
+func (h *Handler) UpdateBuyer(w http.ResponseWriter, r *http.Request) {
+	var buyer models.Buyer
+	if err := json.NewDecoder(r.Body).Decode(&buyer); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	buyer.ID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	buyer.Version = version
+
+	if err := h.service.UpdateBuyer(&buyer); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Buyer not found")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Price list not found")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Buyer")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update buyer")
+		}
+		return
+	}
+
+	setETag(w, buyer.Version)
+	respondJSON(w, http.StatusOK, buyer)
+}
+
+func (h *Handler) PatchBuyer(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	buyer, err := h.service.PatchBuyer(r.PathValue("id"), version, decodeInto[models.Buyer](r))
+	if err != nil {
+		if err == errInvalidBody {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Buyer not found")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Price list not found")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Buyer")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update buyer")
+		}
+		return
+	}
+
+	setETag(w, buyer.Version)
+	respondJSON(w, http.StatusOK, buyer)
+}
+
+func (h *Handler) DeleteBuyer(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteBuyer(r.PathValue("id"), version); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Buyer not found")
+		} else if err == repository.ErrInUse {
+			respondError(w, http.StatusConflict, "Buyer still has sales orders or serials")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Buyer")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to delete buyer")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // Vendor handlers
 
 func (h *Handler) CreateVendor(w http.ResponseWriter, r *http.Request) {
@@ -109,6 +389,7 @@ func (h *Handler) CreateVendor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setETag(w, vendor.Version)
 	respondJSON(w, http.StatusCreated, vendor)
 }
 
@@ -121,6 +402,95 @@ func (h *Handler) ListVendors(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, vendors)
 }
 
+func (h *Handler) GetVendor(w http.ResponseWriter, r *http.Request) {
+	vendor, err := h.service.GetVendor(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Vendor not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get vendor")
+		}
+		return
+	}
+	setETag(w, vendor.Version)
+	respondJSON(w, http.StatusOK, vendor)
+}
+
+func (h *Handler) UpdateVendor(w http.ResponseWriter, r *http.Request) {
+	var vendor models.Vendor
+	if err := json.NewDecoder(r.Body).Decode(&vendor); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	vendor.ID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	vendor.Version = version
+
+	if err := h.service.UpdateVendor(&vendor); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Vendor not found")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Vendor")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update vendor")
+		}
+		return
+	}
+
+	setETag(w, vendor.Version)
+	respondJSON(w, http.StatusOK, vendor)
+}
+
+func (h *Handler) PatchVendor(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	vendor, err := h.service.PatchVendor(r.PathValue("id"), version, decodeInto[models.Vendor](r))
+	if err != nil {
+		if err == errInvalidBody {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Vendor not found")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Vendor")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update vendor")
+		}
+		return
+	}
+
+	setETag(w, vendor.Version)
+	respondJSON(w, http.StatusOK, vendor)
+}
+
+func (h *Handler) DeleteVendor(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteVendor(r.PathValue("id"), version, queryBool(r, "cascade")); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Vendor not found")
+		} else if err == repository.ErrInUse && queryBool(r, "cascade") {
+			respondError(w, http.StatusConflict, "Vendor or its products are still on orders, price lists or promotions")
+		} else if err == repository.ErrInUse {
+			respondError(w, http.StatusConflict, "Vendor still has products or purchase orders; ?cascade=true deletes its products too")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Vendor")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to delete vendor")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // Product handlers
 
 func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
@@ -133,19 +503,54 @@ func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	if err := h.service.CreateProduct(&product); err != nil {
 		if err == repository.ErrAlreadyExists {
 			respondError(w, http.StatusConflict, "Product already exists")
-		} else if err == repository.ErrNotFound {
-			respondError(w, http.StatusBadRequest, "Vendor not found")
+		} else if err == repository.ErrNotFound || err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Vendor or parent product not found")
+		} else if err == service.ErrInvalidPrice {
+			respondError(w, http.StatusBadRequest, invalidPriceMessage)
+		} else if err == service.ErrInvalidUnit {
+			respondError(w, http.StatusBadRequest, productUnitsMessage)
+		} else if err == service.ErrFractionalQuantity {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == service.ErrInvalidVariant {
+			respondError(w, http.StatusBadRequest, invalidVariantMessage)
+		} else if err == service.ErrVariantExists {
+			respondError(w, http.StatusConflict, variantExistsMessage)
+		} else if err == service.ErrInvalidKit {
+			respondError(w, http.StatusBadRequest, invalidKitMessage)
 		} else {
 			respondError(w, http.StatusInternalServerError, "Failed to create product")
 		}
 		return
 	}
 
+	setETag(w, product.Version)
 	respondJSON(w, http.StatusCreated, product)
 }
 
+// ListProducts lists products, filtered by the vendor_id, category and
+// parent_id query parameters and by attributes when given, as they are now
+// or as they were at the as_of time
 func (h *Handler) ListProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := h.service.ListProducts()
+	query := r.URL.Query()
+	filter := repository.ProductFilter{
+		VendorID:   query.Get("vendor_id"),
+		Category:   query.Get("category"),
+		ParentID:   query.Get("parent_id"),
+		Attributes: queryAttributes(r),
+	}
+	asOf, past, ok := queryTime(r, "as_of")
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Invalid as_of time; use RFC 3339")
+		return
+	}
+
+	var products []*models.Product
+	var err error
+	if past {
+		products, err = h.service.ProductsAsOf(asOf, filter)
+	} else {
+		products, err = h.service.FindProducts(filter)
+	}
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to list products")
 		return
@@ -153,8 +558,221 @@ func (h *Handler) ListProducts(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, products)
 }
 
+func (h *Handler) ListVendorProducts(w http.ResponseWriter, r *http.Request) {
+	products, err := h.service.ListVendorProducts(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Vendor not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to list products")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, products)
+}
+
+func (h *Handler) GetProduct(w http.ResponseWriter, r *http.Request) {
+	product, err := h.service.GetProduct(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Product not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get product")
+		}
+		return
+	}
+	setETag(w, product.Version)
+	respondJSON(w, http.StatusOK, product)
+}
+
+func (h *Handler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	var product models.Product
+	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	product.ID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	product.Version = version
+
+	if err := h.service.UpdateProduct(&product); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Product not found")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Vendor or parent product not found")
+		} else if err == service.ErrSerializedStock {
+			respondError(w, http.StatusConflict, serializedChangeMessage)
+		} else if err == service.ErrInvalidPrice {
+			respondError(w, http.StatusBadRequest, invalidPriceMessage)
+		} else if err == service.ErrInvalidUnit {
+			respondError(w, http.StatusBadRequest, productUnitsMessage)
+		} else if err == service.ErrFractionalQuantity {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == service.ErrInvalidVariant {
+			respondError(w, http.StatusBadRequest, invalidVariantMessage)
+		} else if err == service.ErrVariantExists {
+			respondError(w, http.StatusConflict, variantExistsMessage)
+		} else if err == service.ErrInvalidKit {
+			respondError(w, http.StatusBadRequest, invalidKitMessage)
+		} else if err == service.ErrBaseUnitInUse {
+			respondError(w, http.StatusConflict, "A product with inventory items or orders cannot change its base unit")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Product")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update product")
+		}
+		return
+	}
+
+	setETag(w, product.Version)
+	respondJSON(w, http.StatusOK, product)
+}
+
+func (h *Handler) PatchProduct(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	product, err := h.service.PatchProduct(r.PathValue("id"), version, decodeInto[models.Product](r))
+	if err != nil {
+		if err == errInvalidBody {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Product not found")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Vendor or parent product not found")
+		} else if err == service.ErrSerializedStock {
+			respondError(w, http.StatusConflict, serializedChangeMessage)
+		} else if err == service.ErrInvalidPrice {
+			respondError(w, http.StatusBadRequest, invalidPriceMessage)
+		} else if err == service.ErrInvalidUnit {
+			respondError(w, http.StatusBadRequest, productUnitsMessage)
+		} else if err == service.ErrFractionalQuantity {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == service.ErrInvalidVariant {
+			respondError(w, http.StatusBadRequest, invalidVariantMessage)
+		} else if err == service.ErrVariantExists {
+			respondError(w, http.StatusConflict, variantExistsMessage)
+		} else if err == service.ErrInvalidKit {
+			respondError(w, http.StatusBadRequest, invalidKitMessage)
+		} else if err == service.ErrBaseUnitInUse {
+			respondError(w, http.StatusConflict, "A product with inventory items or orders cannot change its base unit")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Product")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update product")
+		}
+		return
+	}
+
+	setETag(w, product.Version)
+	respondJSON(w, http.StatusOK, product)
+}
+
+func (h *Handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteProduct(r.PathValue("id"), version, queryBool(r, "cascade")); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Product not found")
+		} else if err == repository.ErrInUse && queryBool(r, "cascade") {
+			respondError(w, http.StatusConflict,
+				"Product is still on orders, price lists or promotions, has serials or variants, or is a component of a kit")
+		} else if err == repository.ErrInUse {
+			respondError(w, http.StatusConflict, "Product still has inventory items or variants, or is a component of a kit "+
+				"or on a price list or promotion; delete its variants and kits and take it off price lists and promotions first, "+
+				"or retry with ?cascade=true to delete its inventory too")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Product")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to delete product")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const invalidPriceMessage = "Invalid price: it must not be negative and needs a currency that is an " +
+	"ISO 4217 code, such as {\"amount\": \"24.99\", \"currency\": \"USD\"}"
+
+const invalidLinePriceMessage = "Invalid price: line prices must not be negative and all need the same currency, " +
+	"an ISO 4217 code, such as {\"amount\": \"24.99\", \"currency\": \"USD\"}"
+
+const invalidVariantMessage = "Invalid variant: each axis needs a new name and distinct values, and a variant " +
+	"has no axes of its own and one attribute for each axis of its parent, which cannot itself be a variant"
+
+const variantExistsMessage = "The parent product already has a variant with these attributes"
+
+// ListProductVariants lists a product's variants, filtered by attributes
+// when given
+func (h *Handler) ListProductVariants(w http.ResponseWriter, r *http.Request) {
+	variants, err := h.service.ListVariants(r.PathValue("id"), queryAttributes(r))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Product not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to list variants")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, variants)
+}
+
+// GenerateProductVariants creates the variants a product is missing, one
+// for each combination of the values of its axes, and returns them
+func (h *Handler) GenerateProductVariants(w http.ResponseWriter, r *http.Request) {
+	variants, err := h.service.GenerateVariants(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Product not found")
+		} else if err == service.ErrInvalidVariant {
+			respondError(w, http.StatusBadRequest, "Only a product with axes that is not itself a variant has variants")
+		} else if err == repository.ErrAlreadyExists {
+			respondError(w, http.StatusConflict, "A product with a generated variant's ID already exists")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to generate variants")
+		}
+		return
+	}
+	if variants == nil {
+		variants = []*models.Product{}
+	}
+	respondJSON(w, http.StatusCreated, variants)
+}
+
+const serializedChangeMessage = "A product with stock or serials cannot start or stop being serialized"
+
+const productUnitsMessage = "Invalid units: base_unit must be a standard unit, and each pack a new unit " +
+	"holding a positive quantity, whole unless fractional, of the base unit or another unit the product knows"
+
+// isUnitError reports whether err rejects a quantity given in a unit it
+// cannot be counted in
+func isUnitError(err error) bool {
+	return err == service.ErrInvalidUnit || err == service.ErrFractionalQuantity
+}
+
+// unitErrorMessage describes a unit error
+func unitErrorMessage(err error) string {
+	if err == service.ErrFractionalQuantity {
+		return "Quantity must be a whole number of its unit"
+	}
+	return "Unknown unit, or one the product is not counted in"
+}
+
 // Inventory handlers
 
+const serializedStockMessage = "Stock of a serialized product changes only through its serials"
+
+const invalidLotMessage = "Invalid lot: items of lot-controlled products need a lot_number " +
+	"and a manufactured_at no later than expires_at, and items of other products take no lot"
+
 func (h *Handler) CreateInventoryItem(w http.ResponseWriter, r *http.Request) {
 	var item models.InventoryItem
 	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
@@ -165,19 +783,50 @@ func (h *Handler) CreateInventoryItem(w http.ResponseWriter, r *http.Request) {
 	if err := h.service.CreateInventoryItem(&item); err != nil {
 		if err == repository.ErrAlreadyExists {
 			respondError(w, http.StatusConflict, "Inventory item already exists")
-		} else if err == repository.ErrNotFound {
+		} else if err == repository.ErrNotFound || err == repository.ErrInvalidReference {
 			respondError(w, http.StatusBadRequest, "Product not found")
+		} else if err == service.ErrLocationUnavailable {
+			respondError(w, http.StatusBadRequest, "Location not found or inactive")
+		} else if err == service.ErrInvalidLot {
+			respondError(w, http.StatusBadRequest, invalidLotMessage)
+		} else if err == service.ErrSerializedStock {
+			respondError(w, http.StatusBadRequest, serializedStockMessage)
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == repository.ErrInsufficientStock {
+			respondError(w, http.StatusBadRequest, "Quantity cannot be negative")
 		} else {
 			respondError(w, http.StatusInternalServerError, "Failed to create inventory item")
 		}
 		return
 	}
 
+	setETag(w, item.Version)
 	respondJSON(w, http.StatusCreated, item)
 }
 
+// ListInventoryItems lists inventory items, filtered by the product_id and
+// location query parameters when given, as they are now or as they were at
+// the as_of time
 func (h *Handler) ListInventoryItems(w http.ResponseWriter, r *http.Request) {
-	items, err := h.service.ListInventoryItems()
+	query := r.URL.Query()
+	filter := repository.InventoryFilter{
+		ProductID: query.Get("product_id"),
+		Location:  query.Get("location"),
+	}
+	asOf, past, ok := queryTime(r, "as_of")
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Invalid as_of time; use RFC 3339")
+		return
+	}
+
+	var items []*models.InventoryItem
+	var err error
+	if past {
+		items, err = h.service.InventoryItemsAsOf(asOf, filter)
+	} else {
+		items, err = h.service.FindInventoryItems(filter)
+	}
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to list inventory items")
 		return
@@ -185,24 +834,410 @@ func (h *Handler) ListInventoryItems(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, items)
 }
 
+// ListProductInventory lists a product's inventory items, filtered by the
+// location query parameter when given
+func (h *Handler) ListProductInventory(w http.ResponseWriter, r *http.Request) {
+	items, err := h.service.ListProductInventory(r.PathValue("id"), r.URL.Query().Get("location"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Product not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to list inventory items")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, items)
+}
+
+// ListExpiringLots lists the lots holding stock that expire within the
+// number of days given by the days query parameter, 30 by default, including
+// those already expired, soonest first. The product_id query parameter
+// narrows them to one product.
+func (h *Handler) ListExpiringLots(w http.ResponseWriter, r *http.Request) {
+	days, ok := queryInt(r, "days", 30)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Invalid days; use a non-negative integer")
+		return
+	}
+	before := time.Now().AddDate(0, 0, int(days))
+	items, err := h.service.ExpiringLots(r.URL.Query().Get("product_id"), before)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list expiring lots")
+		return
+	}
+	respondJSON(w, http.StatusOK, items)
+}
+
+func (h *Handler) GetInventoryItem(w http.ResponseWriter, r *http.Request) {
+	item, err := h.service.GetInventoryItem(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Inventory item not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get inventory item")
+		}
+		return
+	}
+	setETag(w, item.Version)
+	respondJSON(w, http.StatusOK, item)
+}
+
+func (h *Handler) UpdateInventoryItem(w http.ResponseWriter, r *http.Request) {
+	var item models.InventoryItem
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	item.ID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	item.Version = version
+
+	if err := h.service.UpdateInventoryItem(&item); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Inventory item not found")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Product not found")
+		} else if err == service.ErrLocationUnavailable {
+			respondError(w, http.StatusBadRequest, "Location not found or inactive")
+		} else if err == service.ErrInvalidLot {
+			respondError(w, http.StatusBadRequest, invalidLotMessage)
+		} else if err == service.ErrSerializedStock {
+			respondError(w, http.StatusConflict, "The item holds serials, which would be left behind")
+		} else if err == service.ErrInvalidUnit {
+			respondError(w, http.StatusConflict, "An item holding stock cannot move to a product with another base unit")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Inventory item")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update inventory item")
+		}
+		return
+	}
+
+	setETag(w, item.Version)
+	respondJSON(w, http.StatusOK, item)
+}
+
+func (h *Handler) PatchInventoryItem(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	item, err := h.service.PatchInventoryItem(r.PathValue("id"), version, decodeInto[models.InventoryItem](r))
+	if err != nil {
+		if err == errInvalidBody {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Inventory item not found")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Product not found")
+		} else if err == service.ErrLocationUnavailable {
+			respondError(w, http.StatusBadRequest, "Location not found or inactive")
+		} else if err == service.ErrInvalidLot {
+			respondError(w, http.StatusBadRequest, invalidLotMessage)
+		} else if err == service.ErrSerializedStock {
+			respondError(w, http.StatusConflict, "The item holds serials, which would be left behind")
+		} else if err == service.ErrInvalidUnit {
+			respondError(w, http.StatusConflict, "An item holding stock cannot move to a product with another base unit")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Inventory item")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update inventory item")
+		}
+		return
+	}
+
+	setETag(w, item.Version)
+	respondJSON(w, http.StatusOK, item)
+}
+
+func (h *Handler) DeleteInventoryItem(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteInventoryItem(r.PathValue("id"), version); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Inventory item not found")
+		} else if err == repository.ErrInUse {
+			respondError(w, http.StatusConflict, "Inventory item still has serials")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Inventory item")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to delete inventory item")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *Handler) UpdateInventoryQuantity(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		ID       string `json:"id"`
-		Quantity int    `json:"quantity"`
+		ID       string  `json:"id"`
+		Quantity float64 `json:"quantity"`
+		Unit     string  `json:"unit"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	if err := h.service.UpdateInventoryQuantity(req.ID, req.Quantity); err != nil {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	released, err := h.service.UpdateInventoryQuantity(req.ID, req.Quantity, req.Unit, version)
+	if err != nil {
 		if err == repository.ErrNotFound {
 			respondError(w, http.StatusNotFound, "Inventory item not found")
+		} else if err == service.ErrInvalidMovement {
+			respondError(w, http.StatusBadRequest, "Quantity cannot be negative")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == service.ErrSerializedStock {
+			respondError(w, http.StatusConflict, serializedStockMessage)
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Inventory item")
 		} else {
 			respondError(w, http.StatusInternalServerError, "Failed to update inventory quantity")
 		}
 		return
 	}
 
-	respondJSON(w, http.StatusOK, map[string]string{"message": "Quantity updated successfully"})
+	respondJSON(w, http.StatusOK, map[string]any{
+		"message":               "Quantity updated successfully",
+		"released_reservations": released,
+	})
+}
+
+// Stock movement handlers
+
+func (h *Handler) PostMovement(w http.ResponseWriter, r *http.Request) {
+	var movement models.StockMovement
+	if err := json.NewDecoder(r.Body).Decode(&movement); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	movement.ItemID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.PostMovement(&movement, version); err != nil {
+		if err == service.ErrInvalidMovement {
+			respondError(w, http.StatusBadRequest,
+				"Invalid movement: it needs a known type, a non-zero delta with the type's sign, a reason and an actor, "+
+					"and only an outbound movement can consume a reservation")
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Inventory item not found")
+		} else if err == repository.ErrInsufficientStock {
+			respondError(w, http.StatusConflict, "Insufficient stock available; reserved stock can only be taken by consuming its reservation")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Reservation not found for this inventory item")
+		} else if err == service.ErrLotExpired {
+			respondError(w, http.StatusConflict, "The item's lot has expired and cannot be shipped")
+		} else if err == service.ErrSerializedStock {
+			respondError(w, http.StatusConflict, serializedStockMessage)
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Inventory item")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to post movement")
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, movement)
+}
+
+func (h *Handler) ListMovements(w http.ResponseWriter, r *http.Request) {
+	movements, err := h.service.ListMovements(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Inventory item not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to list movements")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, movements)
+}
+
+// Reservation handlers
+
+func (h *Handler) CreateReservation(w http.ResponseWriter, r *http.Request) {
+	var reservation models.Reservation
+	if err := json.NewDecoder(r.Body).Decode(&reservation); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	reservation.ItemID = r.PathValue("id")
+
+	if err := h.service.CreateReservation(&reservation); err != nil {
+		if err == service.ErrInvalidReservation {
+			respondError(w, http.StatusBadRequest,
+				"Invalid reservation: it needs a positive quantity, an owner and an expiry in the future")
+		} else if err == repository.ErrAlreadyExists {
+			respondError(w, http.StatusConflict, "Reservation already exists")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusNotFound, "Inventory item not found")
+		} else if err == repository.ErrInsufficientStock {
+			respondError(w, http.StatusConflict, "Insufficient available stock")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to create reservation")
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, reservation)
+}
+
+func (h *Handler) ListReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := h.service.ListReservations(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Inventory item not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to list reservations")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, reservations)
+}
+
+func (h *Handler) GetReservation(w http.ResponseWriter, r *http.Request) {
+	reservation, err := h.service.GetReservation(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Reservation not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get reservation")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, reservation)
+}
+
+func (h *Handler) ReleaseReservation(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.ReleaseReservation(r.PathValue("id")); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Reservation not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to release reservation")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Snapshot handlers
+
+// GetSnapshot returns every seller, buyer, vendor, product and inventory
+// item as of a single instant
+func (h *Handler) GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	takenAt := time.Now()
+	snapshot, err := h.service.Snapshot()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to take snapshot")
+		return
+	}
+	defer snapshot.Close()
+
+	sellers, sellersErr := snapshot.ListSellers()
+	buyers, buyersErr := snapshot.ListBuyers()
+	vendors, vendorsErr := snapshot.ListVendors()
+	products, productsErr := snapshot.ListProducts()
+	inventory, inventoryErr := snapshot.ListInventoryItems()
+	if err := errors.Join(sellersErr, buyersErr, vendorsErr, productsErr, inventoryErr); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to read snapshot")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"taken_at":  takenAt,
+		"sellers":   sellers,
+		"buyers":    buyers,
+		"vendors":   vendors,
+		"products":  products,
+		"inventory": inventory,
+	})
+}
+
+// History handlers
+
+func (h *Handler) GetProductHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := h.service.ProductHistory(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Product not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get product history")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, history)
+}
+
+func (h *Handler) GetInventoryItemHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := h.service.InventoryItemHistory(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Inventory item not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get inventory item history")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, history)
+}
+
+func (h *Handler) GetSerialHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := h.service.SerialHistory(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Serial not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get serial history")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, history)
+}
+
+// Change log handlers
+
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+)
+
+// ListChanges returns the change events after the seq given by the after
+// query parameter, oldest first, at most limit of them
+func (h *Handler) ListChanges(w http.ResponseWriter, r *http.Request) {
+	after, ok := queryInt(r, "after", 0)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Invalid after parameter")
+		return
+	}
+	limit, ok := queryInt(r, "limit", defaultChangesLimit)
+	if !ok || limit == 0 || limit > maxChangesLimit {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Limit must be between 1 and %d", maxChangesLimit))
+		return
+	}
+
+	changes, err := h.service.Changes(after, int(limit))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to read changes")
+		return
+	}
+	respondJSON(w, http.StatusOK, changes)
 }
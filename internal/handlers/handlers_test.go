@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/router"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/service"
+)
+
+// newTestRouter serves the API over an in-memory store holding seller s1 at
+// version 1
+func newTestRouter(t *testing.T) *router.Router {
+	svc := service.NewInventoryService(repository.NewInMemoryRepository())
+	if err := svc.CreateSeller(&models.Seller{ID: "s1", Name: "Acme"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	rt := router.New()
+	NewHandler(svc).RegisterRoutes(rt)
+	return rt
+}
+
+func serve(rt *router.Router, method, target, ifMatch, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+		etag    string
+	}{
+		{"absent", "", http.StatusOK, `"2"`},
+		{"any", "*", http.StatusOK, `"2"`},
+		{"matching", `"1"`, http.StatusOK, `"2"`},
+		{"stale", `"7"`, http.StatusPreconditionFailed, ""},
+		{"weak", `W/"1"`, http.StatusPreconditionFailed, ""},
+		{"list", `"1", "2"`, http.StatusPreconditionFailed, ""},
+		{"unquoted", "1", http.StatusPreconditionFailed, ""},
+		{"zero", `"0"`, http.StatusPreconditionFailed, ""},
+	}
+
+	for _, tt := range tests {
+		for _, method := range []string{http.MethodPut, http.MethodPatch} {
+			t.Run(tt.name+" "+method, func(t *testing.T) {
+				rec := serve(newTestRouter(t), method, "/api/v1/sellers/s1", tt.ifMatch, `{"name":"Acme Ltd"}`)
+
+				if rec.Code != tt.status {
+					t.Fatalf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+				}
+				if got := rec.Header().Get("ETag"); got != tt.etag {
+					t.Errorf("Expected ETag %q, got %q", tt.etag, got)
+				}
+			})
+		}
+	}
+}
+
+func TestIfMatchLeavesEntityUnchanged(t *testing.T) {
+	rt := newTestRouter(t)
+	if rec := serve(rt, http.MethodPut, "/api/v1/sellers/s1", `W/"1"`, `{"name":"Acme Ltd"}`); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected status 412, got %d", rec.Code)
+	}
+
+	rec := serve(rt, http.MethodGet, "/api/v1/sellers/s1", "", "")
+	if got := rec.Header().Get("ETag"); got != `"1"` {
+		t.Errorf("Expected ETag \"1\" after a rejected update, got %q", got)
+	}
+	if strings.Contains(rec.Body.String(), "Acme Ltd") {
+		t.Error("Expected a rejected update to leave the seller unchanged")
+	}
+}
+
+func TestRespondVersionMismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+		message string
+	}{
+		{"with If-Match", `"3"`, http.StatusPreconditionFailed, "Precondition failed: Seller has been modified"},
+		{"with If-Match *", "*", http.StatusPreconditionFailed, "Precondition failed: Seller has been modified"},
+		{"without If-Match", "", http.StatusConflict, "Seller was modified concurrently; retry the request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/sellers/s1", nil)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			respondVersionMismatch(rec, req, "Seller")
+
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.message) {
+				t.Errorf("Expected error %q, got %s", tt.message, rec.Body.String())
+			}
+		})
+	}
+}
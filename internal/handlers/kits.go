@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/service"
+)
+
+// Kit handlers
+
+const invalidKitMessage = "Invalid kit: each component needs another product, named once, that is neither a kit " +
+	"nor serialized, and a positive quantity; a kit is counted whole, is neither serialized nor lot-controlled, " +
+	"and cannot itself be a component"
+
+// GetKitAvailability returns how many of a kit are available, assembled
+// and buildable from its components, at the location query parameter or
+// anywhere if it is absent
+func (h *Handler) GetKitAvailability(w http.ResponseWriter, r *http.Request) {
+	availability, err := h.service.KitAvailability(r.PathValue("id"), r.URL.Query().Get("location"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Product not found")
+		} else if err == service.ErrNotKit {
+			respondError(w, http.StatusBadRequest, "Product is not a kit")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to compute kit availability")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, availability)
+}
+
+// AssembleKit builds kits into an inventory item from their components
+func (h *Handler) AssembleKit(w http.ResponseWriter, r *http.Request) {
+	h.assemble(w, r, h.service.AssembleKit, "Failed to assemble kits")
+}
+
+// DisassembleKit breaks kits held by an inventory item into their
+// components
+func (h *Handler) DisassembleKit(w http.ResponseWriter, r *http.Request) {
+	h.assemble(w, r, h.service.DisassembleKit, "Failed to disassemble kits")
+}
+
+// assemble decodes an assembly, applies it to the inventory item named by
+// the path with apply and responds with the item and the movements posted
+func (h *Handler) assemble(w http.ResponseWriter, r *http.Request,
+	apply func(string, service.Assembly) (*models.InventoryItem, []*models.StockMovement, error), failure string) {
+	var assembly service.Assembly
+	if err := json.NewDecoder(r.Body).Decode(&assembly); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	item, movements, err := apply(r.PathValue("id"), assembly)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Inventory item not found")
+		} else if err == service.ErrInvalidAssembly {
+			respondError(w, http.StatusBadRequest, "Invalid assembly: it needs a positive quantity and an actor")
+		} else if err == service.ErrNotKit {
+			respondError(w, http.StatusBadRequest, "Inventory item does not hold a kit")
+		} else if err == service.ErrLocationUnavailable {
+			respondError(w, http.StatusConflict, "Inventory item is at no location; map it to one first")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == repository.ErrInsufficientStock {
+			respondError(w, http.StatusConflict, "Insufficient stock")
+		} else if err == service.ErrNoComponentItem {
+			respondError(w, http.StatusConflict, "A component has no inventory item at the kit's location to return to")
+		} else {
+			respondError(w, http.StatusInternalServerError, failure)
+		}
+		return
+	}
+
+	setETag(w, item.Version)
+	respondJSON(w, http.StatusOK, map[string]any{
+		"item":      item,
+		"movements": movements,
+	})
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/service"
+)
+
+// Location handlers
+
+const invalidLocationMessage = "Invalid location: it needs a type of site, warehouse, zone, aisle or bin " +
+	"below its parent's and above its children's, a parent outside its own subtree, and a capacity that is not negative"
+
+// decodeLocation decodes a location from the request body. Locations are
+// active unless the body says otherwise.
+func decodeLocation(r *http.Request) (*models.Location, error) {
+	location := &models.Location{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(location); err != nil {
+		return nil, err
+	}
+	return location, nil
+}
+
+func (h *Handler) CreateLocation(w http.ResponseWriter, r *http.Request) {
+	location, err := decodeLocation(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.service.CreateLocation(location); err != nil {
+		if err == service.ErrInvalidLocation {
+			respondError(w, http.StatusBadRequest, invalidLocationMessage)
+		} else if err == repository.ErrAlreadyExists {
+			respondError(w, http.StatusConflict, "Location already exists")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Parent location not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to create location")
+		}
+		return
+	}
+
+	setETag(w, location.Version)
+	respondJSON(w, http.StatusCreated, location)
+}
+
+// ListLocations lists locations, filtered by the parent_id and type query
+// parameters when given
+func (h *Handler) ListLocations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	locations, err := h.service.FindLocations(repository.LocationFilter{
+		ParentID: query.Get("parent_id"),
+		Type:     models.LocationType(query.Get("type")),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list locations")
+		return
+	}
+	respondJSON(w, http.StatusOK, locations)
+}
+
+func (h *Handler) GetLocation(w http.ResponseWriter, r *http.Request) {
+	location, err := h.service.GetLocation(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Location not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get location")
+		}
+		return
+	}
+	setETag(w, location.Version)
+	respondJSON(w, http.StatusOK, location)
+}
+
+func (h *Handler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
+	location, err := decodeLocation(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	location.ID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	location.Version = version
+
+	if err := h.service.UpdateLocation(location); err != nil {
+		if err == service.ErrInvalidLocation {
+			respondError(w, http.StatusBadRequest, invalidLocationMessage)
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Location not found")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Parent location not found")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Location")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update location")
+		}
+		return
+	}
+
+	setETag(w, location.Version)
+	respondJSON(w, http.StatusOK, location)
+}
+
+func (h *Handler) DeleteLocation(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteLocation(r.PathValue("id"), version); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Location not found")
+		} else if err == repository.ErrInUse {
+			respondError(w, http.StatusConflict, "Location still has child locations, inventory items or transfer orders")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Location")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to delete location")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetLocationStock totals the stock at a location and every location below
+// it, only of the product_id query parameter's product when given
+func (h *Handler) GetLocationStock(w http.ResponseWriter, r *http.Request) {
+	stock, err := h.service.LocationStock(r.PathValue("id"), r.URL.Query().Get("product_id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Location not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to total stock")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, stock)
+}
+
+// ListUnmatchedLocations lists the locations inventory items name that do
+// not exist, with the items naming each
+func (h *Handler) ListUnmatchedLocations(w http.ResponseWriter, r *http.Request) {
+	unmatched, err := h.service.UnmatchedLocations()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list unmatched locations")
+		return
+	}
+	respondJSON(w, http.StatusOK, unmatched)
+}
+
+// MapLocations moves the items at unmatched locations to the locations the
+// body's mappings name for them
+func (h *Handler) MapLocations(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Mappings map[string]string `json:"mappings"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Mappings) == 0 {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	moved, err := h.service.MapLocations(req.Mappings)
+	if err != nil {
+		if err == service.ErrInvalidLocation {
+			respondError(w, http.StatusBadRequest, "Only locations that do not exist can be mapped")
+		} else if err == service.ErrLocationUnavailable {
+			respondError(w, http.StatusBadRequest, "Locations can only be mapped to active locations")
+		} else if err == repository.ErrVersionMismatch {
+			respondError(w, http.StatusConflict, "Inventory items were modified concurrently; retry the request")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to map locations")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]int{"moved": moved})
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/service"
+)
+
+// Price list handlers
+
+const invalidPriceListMessage = "Invalid price list: it needs a name, and each price a product, a min_quantity " +
+	"that is not negative, a price that is not negative in a supported currency and a valid_to after its valid_from; " +
+	"two prices of a product for the same min_quantity cannot be valid at the same time"
+
+func (h *Handler) CreatePriceList(w http.ResponseWriter, r *http.Request) {
+	var list models.PriceList
+	if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.service.CreatePriceList(&list); err != nil {
+		if err == service.ErrInvalidPriceList {
+			respondError(w, http.StatusBadRequest, invalidPriceListMessage)
+		} else if err == repository.ErrAlreadyExists {
+			respondError(w, http.StatusConflict, "Price list already exists")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Product not found")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to create price list")
+		}
+		return
+	}
+
+	setETag(w, list.Version)
+	respondJSON(w, http.StatusCreated, list)
+}
+
+func (h *Handler) ListPriceLists(w http.ResponseWriter, r *http.Request) {
+	lists, err := h.service.ListPriceLists()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list price lists")
+		return
+	}
+	respondJSON(w, http.StatusOK, lists)
+}
+
+func (h *Handler) GetPriceList(w http.ResponseWriter, r *http.Request) {
+	list, err := h.service.GetPriceList(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Price list not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get price list")
+		}
+		return
+	}
+	setETag(w, list.Version)
+	respondJSON(w, http.StatusOK, list)
+}
+
+func (h *Handler) UpdatePriceList(w http.ResponseWriter, r *http.Request) {
+	var list models.PriceList
+	if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	list.ID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	list.Version = version
+
+	if err := h.service.UpdatePriceList(&list); err != nil {
+		if err == service.ErrInvalidPriceList {
+			respondError(w, http.StatusBadRequest, invalidPriceListMessage)
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Price list not found")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Product not found")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Price list")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update price list")
+		}
+		return
+	}
+
+	setETag(w, list.Version)
+	respondJSON(w, http.StatusOK, list)
+}
+
+func (h *Handler) DeletePriceList(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeletePriceList(r.PathValue("id"), version); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Price list not found")
+		} else if err == repository.ErrInUse {
+			respondError(w, http.StatusConflict, "Price list is still assigned to buyers")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Price list")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to delete price list")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetProductPrice resolves the unit price of a product for the buyer_id,
+// quantity, unit and at query parameters, explaining which price won
+func (h *Handler) GetProductPrice(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	request := service.PriceRequest{
+		BuyerID: query.Get("buyer_id"),
+		Unit:    query.Get("unit"),
+	}
+	if v := query.Get("quantity"); v != "" {
+		quantity, err := strconv.ParseFloat(v, 64)
+		if err != nil || quantity <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid quantity; it must be a positive number")
+			return
+		}
+		request.Quantity = quantity
+	}
+	at, _, ok := queryTime(r, "at")
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Invalid at time; use RFC 3339")
+		return
+	}
+	request.At = at
+
+	resolution, err := h.service.ResolvePrice(r.PathValue("id"), request)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Product not found")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Buyer not found")
+		} else if err == service.ErrInvalidPriceRequest {
+			respondError(w, http.StatusBadRequest, "Invalid quantity; it must be a positive number")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to resolve price")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, resolution)
+}
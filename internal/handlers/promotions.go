@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/service"
+)
+
+// Promotion handlers
+
+const invalidPromotionMessage = "Invalid promotion: it needs a name and a type with its settings only: " +
+	"percent_off a percent over 0 and at most 100, amount_off a positive amount, buy_get_free a positive " +
+	"buy_quantity and free_quantity; a min_subtotal and limit_per_buyer cannot be negative and valid_to must " +
+	"come after valid_from"
+
+const invalidCartMessage = "Invalid cart: it needs at least one line, each with a positive quantity"
+
+func (h *Handler) CreatePromotion(w http.ResponseWriter, r *http.Request) {
+	var promotion models.Promotion
+	if err := json.NewDecoder(r.Body).Decode(&promotion); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.service.CreatePromotion(&promotion); err != nil {
+		if err == service.ErrInvalidPromotion {
+			respondError(w, http.StatusBadRequest, invalidPromotionMessage)
+		} else if err == repository.ErrAlreadyExists {
+			respondError(w, http.StatusConflict, "Promotion already exists")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Product not found")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to create promotion")
+		}
+		return
+	}
+
+	setETag(w, promotion.Version)
+	respondJSON(w, http.StatusCreated, promotion)
+}
+
+func (h *Handler) ListPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions, err := h.service.ListPromotions()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list promotions")
+		return
+	}
+	respondJSON(w, http.StatusOK, promotions)
+}
+
+func (h *Handler) GetPromotion(w http.ResponseWriter, r *http.Request) {
+	promotion, err := h.service.GetPromotion(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Promotion not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get promotion")
+		}
+		return
+	}
+	setETag(w, promotion.Version)
+	respondJSON(w, http.StatusOK, promotion)
+}
+
+func (h *Handler) UpdatePromotion(w http.ResponseWriter, r *http.Request) {
+	var promotion models.Promotion
+	if err := json.NewDecoder(r.Body).Decode(&promotion); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	promotion.ID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	promotion.Version = version
+
+	if err := h.service.UpdatePromotion(&promotion); err != nil {
+		if err == service.ErrInvalidPromotion {
+			respondError(w, http.StatusBadRequest, invalidPromotionMessage)
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Promotion not found")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Product not found")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Promotion")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update promotion")
+		}
+		return
+	}
+
+	setETag(w, promotion.Version)
+	respondJSON(w, http.StatusOK, promotion)
+}
+
+func (h *Handler) DeletePromotion(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeletePromotion(r.PathValue("id"), version); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Promotion not found")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Promotion")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to delete promotion")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// QuoteCart prices a cart and applies the promotions to it without
+// recording anything
+func (h *Handler) QuoteCart(w http.ResponseWriter, r *http.Request) {
+	h.quote(w, r, h.service.QuoteCart)
+}
+
+// RedeemCart quotes a cart and records a use of each promotion applied
+// by its buyer
+func (h *Handler) RedeemCart(w http.ResponseWriter, r *http.Request) {
+	h.quote(w, r, h.service.RedeemCart)
+}
+
+// quote decodes a cart and responds with the quote that price makes of it
+func (h *Handler) quote(w http.ResponseWriter, r *http.Request, price func(service.Cart) (*service.Quote, error)) {
+	var cart service.Cart
+	if err := json.NewDecoder(r.Body).Decode(&cart); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	quote, err := price(cart)
+	if err != nil {
+		if err == service.ErrInvalidCart {
+			respondError(w, http.StatusBadRequest, invalidCartMessage)
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Product or buyer not found")
+		} else if err == models.ErrCurrencyMismatch {
+			respondError(w, http.StatusBadRequest, "The cart's products are priced in different currencies")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to quote cart")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, quote)
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/service"
+)
+
+// Purchase order handlers
+
+const invalidPurchaseOrderMessage = "Invalid purchase order: tolerances must be between 0 and 100, " +
+	"and it needs at least one line, each with a product of the vendor, a positive quantity and an expected date"
+
+func (h *Handler) CreatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	var order models.PurchaseOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.service.CreatePurchaseOrder(&order); err != nil {
+		if err == service.ErrInvalidPurchaseOrder {
+			respondError(w, http.StatusBadRequest, invalidPurchaseOrderMessage)
+		} else if err == repository.ErrAlreadyExists {
+			respondError(w, http.StatusConflict, "Purchase order already exists")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Vendor or product not found")
+		} else if err == service.ErrInvalidPrice {
+			respondError(w, http.StatusBadRequest, invalidLinePriceMessage)
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to create purchase order")
+		}
+		return
+	}
+
+	setETag(w, order.Version)
+	respondJSON(w, http.StatusCreated, order)
+}
+
+// ListPurchaseOrders lists purchase orders, filtered by the vendor_id,
+// product_id and status query parameters when given
+func (h *Handler) ListPurchaseOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	orders, err := h.service.FindPurchaseOrders(repository.PurchaseOrderFilter{
+		VendorID:  query.Get("vendor_id"),
+		ProductID: query.Get("product_id"),
+		Status:    models.PurchaseOrderStatus(query.Get("status")),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list purchase orders")
+		return
+	}
+	respondJSON(w, http.StatusOK, orders)
+}
+
+func (h *Handler) GetPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	order, err := h.service.GetPurchaseOrder(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Purchase order not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get purchase order")
+		}
+		return
+	}
+	setETag(w, order.Version)
+	respondJSON(w, http.StatusOK, order)
+}
+
+func (h *Handler) UpdatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	var order models.PurchaseOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	order.ID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	order.Version = version
+
+	if err := h.service.UpdatePurchaseOrder(&order); err != nil {
+		if err == service.ErrInvalidPurchaseOrder {
+			respondError(w, http.StatusBadRequest, invalidPurchaseOrderMessage)
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Purchase order not found")
+		} else if err == service.ErrInvalidTransition {
+			respondError(w, http.StatusConflict, "Only draft purchase orders can be changed")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Vendor or product not found")
+		} else if err == service.ErrInvalidPrice {
+			respondError(w, http.StatusBadRequest, invalidLinePriceMessage)
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Purchase order")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update purchase order")
+		}
+		return
+	}
+
+	setETag(w, order.Version)
+	respondJSON(w, http.StatusOK, order)
+}
+
+func (h *Handler) DeletePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeletePurchaseOrder(r.PathValue("id"), version); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Purchase order not found")
+		} else if err == service.ErrInvalidTransition {
+			respondError(w, http.StatusConflict, "Only draft and cancelled purchase orders can be deleted")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Purchase order")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to delete purchase order")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// transitionPurchaseOrder returns a handler that moves the purchase order
+// named by the path to another status with transition, honouring If-Match
+func (h *Handler) transitionPurchaseOrder(transition func(svc *service.InventoryService, id string, version int64) (*models.PurchaseOrder, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version, ok := ifMatch(w, r)
+		if !ok {
+			return
+		}
+
+		order, err := transition(h.service, r.PathValue("id"), version)
+		if err != nil {
+			if err == repository.ErrNotFound {
+				respondError(w, http.StatusNotFound, "Purchase order not found")
+			} else if err == service.ErrInvalidTransition {
+				respondError(w, http.StatusConflict, "The purchase order's status does not allow this")
+			} else if err == repository.ErrVersionMismatch {
+				respondVersionMismatch(w, r, "Purchase order")
+			} else {
+				respondError(w, http.StatusInternalServerError, "Failed to update purchase order")
+			}
+			return
+		}
+
+		setETag(w, order.Version)
+		respondJSON(w, http.StatusOK, order)
+	}
+}
+
+// ReceivePurchaseOrder receives stock against a line of an open purchase
+// order and returns the order with the movement it posted
+func (h *Handler) ReceivePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	var receipt service.Receipt
+	if err := json.NewDecoder(r.Body).Decode(&receipt); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	order, movement, err := h.service.ReceivePurchaseOrder(r.PathValue("id"), version, receipt)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Purchase order not found")
+		} else if err == service.ErrInvalidTransition {
+			respondError(w, http.StatusConflict, "Only open purchase orders can be received")
+		} else if err == service.ErrInvalidReceipt {
+			respondError(w, http.StatusBadRequest,
+				"Invalid receipt: it needs a line of the order, a positive quantity, an item_id or location holding the line's product, "+
+					"and a serial for each unit of a serialized product")
+		} else if err == service.ErrOverReceipt {
+			respondError(w, http.StatusConflict, "Receipt exceeds the ordered quantity and its over tolerance")
+		} else if err == service.ErrInvalidLot {
+			respondError(w, http.StatusBadRequest,
+				"Invalid lot: a lot-controlled product is received as a lot_number its item holds, with matching dates, "+
+					"and any other product without one")
+		} else if err == service.ErrLotExpired {
+			respondError(w, http.StatusConflict, "The lot has expired and cannot be received")
+		} else if err == service.ErrLocationUnavailable {
+			respondError(w, http.StatusBadRequest, "Location not found or inactive")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == repository.ErrAlreadyExists {
+			respondError(w, http.StatusConflict, "Serial already exists")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Purchase order")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to receive purchase order")
+		}
+		return
+	}
+
+	setETag(w, order.Version)
+	respondJSON(w, http.StatusCreated, map[string]any{
+		"purchase_order": order,
+		"movement":       movement,
+	})
+}
+
+// GetProductOnOrder returns the quantity of a product on open purchase
+// orders and not yet received
+func (h *Handler) GetProductOnOrder(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	onOrder, unit, err := h.service.OnOrder(id)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Product not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get quantity on order")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"product_id": id,
+		"on_order":   onOrder,
+		"unit":       unit,
+	})
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/router"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/service"
+)
+
+// APIPrefix is the path under which the current API version is served
+const APIPrefix = "/api/v1"
+
+// RegisterRoutes registers the API on rt. The current version is served
+// under APIPrefix; the unversioned /api paths remain as deprecated aliases.
+func (h *Handler) RegisterRoutes(rt *router.Router) {
+	h.routes(rt.Group(APIPrefix))
+
+	legacy := rt.Group("/api")
+	h.routes(legacy.Deprecated(APIPrefix))
+
+	legacy.SupersededBy(func(r *http.Request) string {
+		return APIPrefix + "/inventory/{id}/movements"
+	}).HandleFunc("POST /inventory/update", "Update inventory quantity", h.UpdateInventoryQuantity)
+}
+
+func (h *Handler) routes(rt *router.Router) {
+	rt.HandleFunc("POST /sellers", "Create a seller", h.CreateSeller)
+	rt.HandleFunc("GET /sellers", "List all sellers", h.ListSellers)
+	rt.HandleFunc("GET /sellers/{id}", "Get a seller", h.GetSeller)
+	rt.HandleFunc("PUT /sellers/{id}", "Replace a seller", h.UpdateSeller)
+	rt.HandleFunc("PATCH /sellers/{id}", "Update some fields of a seller", h.PatchSeller)
+	rt.HandleFunc("DELETE /sellers/{id}", "Delete a seller", h.DeleteSeller)
+
+	rt.HandleFunc("POST /buyers", "Create a buyer", h.CreateBuyer)
+	rt.HandleFunc("GET /buyers", "List all buyers", h.ListBuyers)
+	rt.HandleFunc("GET /buyers/{id}", "Get a buyer", h.GetBuyer)
+	rt.HandleFunc("PUT /buyers/{id}", "Replace a buyer", h.UpdateBuyer)
+	rt.HandleFunc("PATCH /buyers/{id}", "Update some fields of a buyer", h.PatchBuyer)
+	rt.HandleFunc("DELETE /buyers/{id}", "Delete a buyer", h.DeleteBuyer)
+
+	rt.HandleFunc("POST /vendors", "Create a vendor", h.CreateVendor)
+	rt.HandleFunc("GET /vendors", "List all vendors", h.ListVendors)
+	rt.HandleFunc("GET /vendors/{id}", "Get a vendor", h.GetVendor)
+	rt.HandleFunc("GET /vendors/{id}/products", "List a vendor's products", h.ListVendorProducts)
+	rt.HandleFunc("PUT /vendors/{id}", "Replace a vendor", h.UpdateVendor)
+	rt.HandleFunc("PATCH /vendors/{id}", "Update some fields of a vendor", h.PatchVendor)
+	rt.HandleFunc("DELETE /vendors/{id}", "Delete a vendor (?cascade=true also deletes its products)", h.DeleteVendor)
+
+	rt.HandleFunc("GET /units", "List the standard units of measure", h.ListUnits)
+
+	rt.HandleFunc("POST /products", "Create a product", h.CreateProduct)
+	rt.HandleFunc("GET /products", "List products (?vendor_id=, ?category=, ?parent_id= and ?attr.<axis>= filter them, ?as_of= lists them as they were)", h.ListProducts)
+	rt.HandleFunc("GET /products/{id}", "Get a product", h.GetProduct)
+	rt.HandleFunc("GET /products/{id}/variants", "List a product's variants (?attr.<axis>= filters them)", h.ListProductVariants)
+	rt.HandleFunc("POST /products/{id}/variants", "Create a variant for each combination of a product's axes that has none", h.GenerateProductVariants)
+	rt.HandleFunc("GET /products/{id}/availability", "Get how many of a kit are available, assembled or buildable from its components (?location= narrows it)", h.GetKitAvailability)
+	rt.HandleFunc("GET /products/{id}/price",
+		"Resolve a product's unit price, explaining which price won (?buyer_id=, ?quantity=, ?unit= and ?at= describe the sale)", h.GetProductPrice)
+	rt.HandleFunc("GET /products/{id}/history", "List every revision of a product", h.GetProductHistory)
+	rt.HandleFunc("GET /products/{id}/inventory", "List a product's inventory items (?location= filters them)", h.ListProductInventory)
+	rt.HandleFunc("GET /products/{id}/on-order", "Get the quantity of a product on open purchase orders", h.GetProductOnOrder)
+	rt.HandleFunc("GET /products/{id}/in-transit", "Get the quantity of a product dispatched on transfer orders", h.GetProductInTransit)
+	rt.HandleFunc("PUT /products/{id}", "Replace a product", h.UpdateProduct)
+	rt.HandleFunc("PATCH /products/{id}", "Update some fields of a product", h.PatchProduct)
+	rt.HandleFunc("DELETE /products/{id}", "Delete a product (?cascade=true also deletes its inventory)", h.DeleteProduct)
+
+	rt.HandleFunc("POST /price-lists", "Create a price list", h.CreatePriceList)
+	rt.HandleFunc("GET /price-lists", "List all price lists", h.ListPriceLists)
+	rt.HandleFunc("GET /price-lists/{id}", "Get a price list", h.GetPriceList)
+	rt.HandleFunc("PUT /price-lists/{id}", "Replace a price list", h.UpdatePriceList)
+	rt.HandleFunc("DELETE /price-lists/{id}", "Delete a price list no buyer is assigned", h.DeletePriceList)
+
+	rt.HandleFunc("POST /promotions", "Create a promotion", h.CreatePromotion)
+	rt.HandleFunc("GET /promotions", "List all promotions", h.ListPromotions)
+	rt.HandleFunc("GET /promotions/{id}", "Get a promotion", h.GetPromotion)
+	rt.HandleFunc("PUT /promotions/{id}", "Replace a promotion's rules", h.UpdatePromotion)
+	rt.HandleFunc("DELETE /promotions/{id}", "Delete a promotion", h.DeletePromotion)
+	rt.HandleFunc("POST /quotes", "Price a cart and apply the promotions to it", h.QuoteCart)
+	rt.HandleFunc("POST /quotes/redeem", "Price a cart and record the buyer's use of the promotions applied", h.RedeemCart)
+
+	rt.HandleFunc("POST /locations", "Create a location", h.CreateLocation)
+	rt.HandleFunc("GET /locations", "List locations (?parent_id= and ?type= filter them)", h.ListLocations)
+	rt.HandleFunc("GET /locations/unmatched", "List locations inventory items name that do not exist", h.ListUnmatchedLocations)
+	rt.HandleFunc("POST /locations/map", "Move the items at locations that do not exist to existing ones", h.MapLocations)
+	rt.HandleFunc("GET /locations/{id}", "Get a location", h.GetLocation)
+	rt.HandleFunc("GET /locations/{id}/stock", "Total the stock at a location and below it (?product_id= filters it)", h.GetLocationStock)
+	rt.HandleFunc("PUT /locations/{id}", "Replace a location", h.UpdateLocation)
+	rt.HandleFunc("DELETE /locations/{id}", "Delete a location", h.DeleteLocation)
+
+	rt.HandleFunc("POST /inventory", "Create an inventory item", h.CreateInventoryItem)
+	rt.HandleFunc("GET /inventory", "List inventory items (?product_id= and ?location= filter them, ?as_of= lists them as they were)", h.ListInventoryItems)
+	rt.HandleFunc("GET /inventory/expiring", "List lots expiring within ?days= (default 30), soonest first (?product_id= filters them)", h.ListExpiringLots)
+	rt.HandleFunc("GET /inventory/{id}", "Get an inventory item", h.GetInventoryItem)
+	rt.HandleFunc("GET /inventory/{id}/history", "List every revision of an inventory item", h.GetInventoryItemHistory)
+	rt.HandleFunc("PUT /inventory/{id}", "Replace an inventory item", h.UpdateInventoryItem)
+	rt.HandleFunc("PATCH /inventory/{id}", "Update some fields of an inventory item", h.PatchInventoryItem)
+	rt.HandleFunc("DELETE /inventory/{id}", "Delete an inventory item", h.DeleteInventoryItem)
+	rt.HandleFunc("POST /inventory/{id}/movements", "Post a stock movement for an inventory item", h.PostMovement)
+	rt.HandleFunc("GET /inventory/{id}/movements", "List an inventory item's stock movements", h.ListMovements)
+	rt.HandleFunc("POST /inventory/{id}/reservations", "Reserve stock of an inventory item", h.CreateReservation)
+	rt.HandleFunc("GET /inventory/{id}/reservations", "List an inventory item's reservations", h.ListReservations)
+	rt.HandleFunc("POST /inventory/{id}/assemble", "Assemble kits into an inventory item from their components", h.AssembleKit)
+	rt.HandleFunc("POST /inventory/{id}/disassemble", "Disassemble kits held by an inventory item into their components", h.DisassembleKit)
+
+	rt.HandleFunc("GET /reservations/{id}", "Get a reservation", h.GetReservation)
+	rt.HandleFunc("DELETE /reservations/{id}", "Release a reservation", h.ReleaseReservation)
+
+	rt.HandleFunc("POST /sales-orders", "Create a draft sales order", h.CreateSalesOrder)
+	rt.HandleFunc("GET /sales-orders", "List sales orders (?buyer_id= and ?status= filter them)", h.ListSalesOrders)
+	rt.HandleFunc("GET /sales-orders/{id}", "Get a sales order", h.GetSalesOrder)
+	rt.HandleFunc("PUT /sales-orders/{id}", "Replace a draft sales order", h.UpdateSalesOrder)
+	rt.HandleFunc("DELETE /sales-orders/{id}", "Delete a draft or cancelled sales order", h.DeleteSalesOrder)
+	rt.HandleFunc("POST /sales-orders/{id}/confirm", "Confirm a sales order and reserve its stock",
+		h.transitionSalesOrder((*service.InventoryService).ConfirmSalesOrder))
+	rt.HandleFunc("POST /sales-orders/{id}/allocate", "Mark a sales order's stock allocated",
+		h.transitionSalesOrder((*service.InventoryService).AllocateSalesOrder))
+	rt.HandleFunc("POST /sales-orders/{id}/pick", "Mark a sales order picked",
+		h.transitionSalesOrder((*service.InventoryService).PickSalesOrder))
+	rt.HandleFunc("POST /sales-orders/{id}/ship", "Ship a sales order, taking its stock",
+		h.transitionSalesOrder((*service.InventoryService).ShipSalesOrder))
+	rt.HandleFunc("POST /sales-orders/{id}/invoice", "Mark a sales order invoiced",
+		h.transitionSalesOrder((*service.InventoryService).InvoiceSalesOrder))
+	rt.HandleFunc("POST /sales-orders/{id}/cancel", "Cancel a sales order and release its stock",
+		h.transitionSalesOrder((*service.InventoryService).CancelSalesOrder))
+
+	rt.HandleFunc("POST /purchase-orders", "Create a draft purchase order", h.CreatePurchaseOrder)
+	rt.HandleFunc("GET /purchase-orders", "List purchase orders (?vendor_id=, ?product_id= and ?status= filter them)", h.ListPurchaseOrders)
+	rt.HandleFunc("GET /purchase-orders/{id}", "Get a purchase order", h.GetPurchaseOrder)
+	rt.HandleFunc("PUT /purchase-orders/{id}", "Replace a draft purchase order", h.UpdatePurchaseOrder)
+	rt.HandleFunc("DELETE /purchase-orders/{id}", "Delete a draft or cancelled purchase order", h.DeletePurchaseOrder)
+	rt.HandleFunc("POST /purchase-orders/{id}/issue", "Issue a purchase order, opening it for receiving",
+		h.transitionPurchaseOrder((*service.InventoryService).IssuePurchaseOrder))
+	rt.HandleFunc("POST /purchase-orders/{id}/close", "Close a purchase order",
+		h.transitionPurchaseOrder((*service.InventoryService).ClosePurchaseOrder))
+	rt.HandleFunc("POST /purchase-orders/{id}/cancel", "Cancel a purchase order that has received nothing",
+		h.transitionPurchaseOrder((*service.InventoryService).CancelPurchaseOrder))
+	rt.HandleFunc("POST /purchase-orders/{id}/receipts", "Receive stock against a purchase order line", h.ReceivePurchaseOrder)
+
+	rt.HandleFunc("POST /transfer-orders", "Create a draft transfer order", h.CreateTransferOrder)
+	rt.HandleFunc("GET /transfer-orders",
+		"List transfer orders (?from_location=, ?to_location=, ?product_id= and ?status= filter them)", h.ListTransferOrders)
+	rt.HandleFunc("GET /transfer-orders/{id}", "Get a transfer order", h.GetTransferOrder)
+	rt.HandleFunc("PUT /transfer-orders/{id}", "Replace a draft transfer order", h.UpdateTransferOrder)
+	rt.HandleFunc("DELETE /transfer-orders/{id}", "Delete a draft or cancelled transfer order", h.DeleteTransferOrder)
+	rt.HandleFunc("POST /transfer-orders/{id}/dispatch", "Dispatch a transfer order, taking its stock from the source",
+		h.transitionTransferOrder((*service.InventoryService).DispatchTransferOrder))
+	rt.HandleFunc("POST /transfer-orders/{id}/cancel", "Cancel a draft transfer order",
+		h.transitionTransferOrder((*service.InventoryService).CancelTransferOrder))
+	rt.HandleFunc("POST /transfer-orders/{id}/receive", "Receive a transfer order in transit at its destination", h.ReceiveTransferOrder)
+
+	rt.HandleFunc("POST /serials", "Register a unit of a serialized product", h.CreateSerial)
+	rt.HandleFunc("GET /serials", "List serials (?product_id=, ?item_id=, ?status= and ?buyer_id= filter them)", h.ListSerials)
+	rt.HandleFunc("GET /serials/{id}", "Get a serial", h.GetSerial)
+	rt.HandleFunc("GET /serials/{id}/history", "List every revision of a serial", h.GetSerialHistory)
+	rt.HandleFunc("PUT /serials/{id}", "Replace a serial, moving its unit's stock to match", h.UpdateSerial)
+	rt.HandleFunc("DELETE /serials/{id}", "Delete a serial registered in error", h.DeleteSerial)
+
+	rt.HandleFunc("POST /batch", "Apply a list of operations all-or-nothing", h.Batch)
+	rt.HandleFunc("GET /snapshot", "Get all entities as of a single instant", h.GetSnapshot)
+	rt.HandleFunc("GET /changes", "List change events (?after=seq resumes, ?limit= caps the page)", h.ListChanges)
+}
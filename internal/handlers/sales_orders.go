@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/service"
+)
+
+// Sales order handlers
+
+const invalidSalesOrderMessage = "Invalid sales order: it needs at least one line, each with a product and a positive quantity"
+
+func (h *Handler) CreateSalesOrder(w http.ResponseWriter, r *http.Request) {
+	var order models.SalesOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.service.CreateSalesOrder(&order); err != nil {
+		if err == service.ErrInvalidSalesOrder {
+			respondError(w, http.StatusBadRequest, invalidSalesOrderMessage)
+		} else if err == repository.ErrAlreadyExists {
+			respondError(w, http.StatusConflict, "Sales order already exists")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Buyer or product not found")
+		} else if err == service.ErrInvalidPrice {
+			respondError(w, http.StatusBadRequest, invalidLinePriceMessage)
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to create sales order")
+		}
+		return
+	}
+
+	setETag(w, order.Version)
+	respondJSON(w, http.StatusCreated, order)
+}
+
+// ListSalesOrders lists sales orders, filtered by the buyer_id and status
+// query parameters when given
+func (h *Handler) ListSalesOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	orders, err := h.service.FindSalesOrders(repository.SalesOrderFilter{
+		BuyerID: query.Get("buyer_id"),
+		Status:  models.SalesOrderStatus(query.Get("status")),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list sales orders")
+		return
+	}
+	respondJSON(w, http.StatusOK, orders)
+}
+
+func (h *Handler) GetSalesOrder(w http.ResponseWriter, r *http.Request) {
+	order, err := h.service.GetSalesOrder(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Sales order not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get sales order")
+		}
+		return
+	}
+	setETag(w, order.Version)
+	respondJSON(w, http.StatusOK, order)
+}
+
+func (h *Handler) UpdateSalesOrder(w http.ResponseWriter, r *http.Request) {
+	var order models.SalesOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	order.ID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	order.Version = version
+
+	if err := h.service.UpdateSalesOrder(&order); err != nil {
+		if err == service.ErrInvalidSalesOrder {
+			respondError(w, http.StatusBadRequest, invalidSalesOrderMessage)
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Sales order not found")
+		} else if err == service.ErrInvalidTransition {
+			respondError(w, http.StatusConflict, "Only draft sales orders can be changed")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Buyer or product not found")
+		} else if err == service.ErrInvalidPrice {
+			respondError(w, http.StatusBadRequest, invalidLinePriceMessage)
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Sales order")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update sales order")
+		}
+		return
+	}
+
+	setETag(w, order.Version)
+	respondJSON(w, http.StatusOK, order)
+}
+
+func (h *Handler) DeleteSalesOrder(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteSalesOrder(r.PathValue("id"), version); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Sales order not found")
+		} else if err == service.ErrInvalidTransition {
+			respondError(w, http.StatusConflict, "Only draft and cancelled sales orders can be deleted")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Sales order")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to delete sales order")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// transitionSalesOrder returns a handler that moves the sales order named
+// by the path to its next status with transition, honouring If-Match
+func (h *Handler) transitionSalesOrder(transition func(svc *service.InventoryService, id string, version int64) (*models.SalesOrder, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version, ok := ifMatch(w, r)
+		if !ok {
+			return
+		}
+
+		order, err := transition(h.service, r.PathValue("id"), version)
+		if err != nil {
+			if err == repository.ErrNotFound {
+				respondError(w, http.StatusNotFound, "Sales order not found")
+			} else if err == service.ErrInvalidTransition {
+				respondError(w, http.StatusConflict, "The sales order's status does not allow this")
+			} else if err == repository.ErrInsufficientStock {
+				respondError(w, http.StatusConflict, "Insufficient available stock")
+			} else if err == service.ErrLotExpired {
+				respondError(w, http.StatusConflict, "An allocated lot has expired and cannot be shipped")
+			} else if err == repository.ErrVersionMismatch {
+				respondVersionMismatch(w, r, "Sales order")
+			} else {
+				respondError(w, http.StatusInternalServerError, "Failed to update sales order")
+			}
+			return
+		}
+
+		setETag(w, order.Version)
+		respondJSON(w, http.StatusOK, order)
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/service"
+)
+
+// Serial handlers
+
+const invalidSerialMessage = "Invalid serial: it needs an id, a known status, a serialized product " +
+	"and an item_id holding that product"
+
+func (h *Handler) CreateSerial(w http.ResponseWriter, r *http.Request) {
+	var serial models.Serial
+	if err := json.NewDecoder(r.Body).Decode(&serial); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.service.CreateSerial(&serial); err != nil {
+		if err == service.ErrInvalidSerial {
+			respondError(w, http.StatusBadRequest, invalidSerialMessage)
+		} else if err == repository.ErrAlreadyExists {
+			respondError(w, http.StatusConflict, "Serial already exists")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Product or buyer not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to create serial")
+		}
+		return
+	}
+
+	setETag(w, serial.Version)
+	respondJSON(w, http.StatusCreated, serial)
+}
+
+// ListSerials lists serials, filtered by the product_id, item_id, status
+// and buyer_id query parameters when given
+func (h *Handler) ListSerials(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	serials, err := h.service.FindSerials(repository.SerialFilter{
+		ProductID: query.Get("product_id"),
+		ItemID:    query.Get("item_id"),
+		Status:    models.SerialStatus(query.Get("status")),
+		BuyerID:   query.Get("buyer_id"),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list serials")
+		return
+	}
+	respondJSON(w, http.StatusOK, serials)
+}
+
+func (h *Handler) GetSerial(w http.ResponseWriter, r *http.Request) {
+	serial, err := h.service.GetSerial(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Serial not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get serial")
+		}
+		return
+	}
+	setETag(w, serial.Version)
+	respondJSON(w, http.StatusOK, serial)
+}
+
+func (h *Handler) UpdateSerial(w http.ResponseWriter, r *http.Request) {
+	var serial models.Serial
+	if err := json.NewDecoder(r.Body).Decode(&serial); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	serial.ID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	serial.Version = version
+
+	if err := h.service.UpdateSerial(&serial); err != nil {
+		if err == service.ErrInvalidSerial {
+			respondError(w, http.StatusBadRequest, invalidSerialMessage+"; its product cannot change")
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Serial not found")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Buyer not found")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Serial")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update serial")
+		}
+		return
+	}
+
+	setETag(w, serial.Version)
+	respondJSON(w, http.StatusOK, serial)
+}
+
+func (h *Handler) DeleteSerial(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteSerial(r.PathValue("id"), version); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Serial not found")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Serial")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to delete serial")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/service"
+)
+
+// Transfer order handlers
+
+const invalidTransferOrderMessage = "Invalid transfer order: it needs two different locations " +
+	"and at least one line, each with a positive quantity of a product that is not serialized"
+
+func (h *Handler) CreateTransferOrder(w http.ResponseWriter, r *http.Request) {
+	var order models.TransferOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.service.CreateTransferOrder(&order); err != nil {
+		if err == service.ErrInvalidTransferOrder {
+			respondError(w, http.StatusBadRequest, invalidTransferOrderMessage)
+		} else if err == service.ErrLocationUnavailable {
+			respondError(w, http.StatusBadRequest, "Location not found or inactive")
+		} else if err == repository.ErrAlreadyExists {
+			respondError(w, http.StatusConflict, "Transfer order already exists")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Product not found")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to create transfer order")
+		}
+		return
+	}
+
+	setETag(w, order.Version)
+	respondJSON(w, http.StatusCreated, order)
+}
+
+// ListTransferOrders lists transfer orders, filtered by the from_location,
+// to_location, product_id and status query parameters when given
+func (h *Handler) ListTransferOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	orders, err := h.service.FindTransferOrders(repository.TransferOrderFilter{
+		FromLocation: query.Get("from_location"),
+		ToLocation:   query.Get("to_location"),
+		ProductID:    query.Get("product_id"),
+		Status:       models.TransferOrderStatus(query.Get("status")),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list transfer orders")
+		return
+	}
+	respondJSON(w, http.StatusOK, orders)
+}
+
+func (h *Handler) GetTransferOrder(w http.ResponseWriter, r *http.Request) {
+	order, err := h.service.GetTransferOrder(r.PathValue("id"))
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Transfer order not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get transfer order")
+		}
+		return
+	}
+	setETag(w, order.Version)
+	respondJSON(w, http.StatusOK, order)
+}
+
+func (h *Handler) UpdateTransferOrder(w http.ResponseWriter, r *http.Request) {
+	var order models.TransferOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	order.ID = r.PathValue("id")
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	order.Version = version
+
+	if err := h.service.UpdateTransferOrder(&order); err != nil {
+		if err == service.ErrInvalidTransferOrder {
+			respondError(w, http.StatusBadRequest, invalidTransferOrderMessage)
+		} else if err == service.ErrLocationUnavailable {
+			respondError(w, http.StatusBadRequest, "Location not found or inactive")
+		} else if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Transfer order not found")
+		} else if err == service.ErrInvalidTransition {
+			respondError(w, http.StatusConflict, "Only draft transfer orders can be changed")
+		} else if err == repository.ErrInvalidReference {
+			respondError(w, http.StatusBadRequest, "Product not found")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Transfer order")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to update transfer order")
+		}
+		return
+	}
+
+	setETag(w, order.Version)
+	respondJSON(w, http.StatusOK, order)
+}
+
+func (h *Handler) DeleteTransferOrder(w http.ResponseWriter, r *http.Request) {
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteTransferOrder(r.PathValue("id"), version); err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Transfer order not found")
+		} else if err == service.ErrInvalidTransition {
+			respondError(w, http.StatusConflict, "Only draft and cancelled transfer orders can be deleted")
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Transfer order")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to delete transfer order")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// transitionTransferOrder returns a handler that moves the transfer order
+// named by the path to another status with transition, honouring If-Match
+func (h *Handler) transitionTransferOrder(transition func(svc *service.InventoryService, id string, version int64) (*models.TransferOrder, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version, ok := ifMatch(w, r)
+		if !ok {
+			return
+		}
+
+		order, err := transition(h.service, r.PathValue("id"), version)
+		if err != nil {
+			if err == repository.ErrNotFound {
+				respondError(w, http.StatusNotFound, "Transfer order not found")
+			} else if err == service.ErrInvalidTransition {
+				respondError(w, http.StatusConflict, "The transfer order's status does not allow this")
+			} else if err == service.ErrInvalidTransferOrder {
+				respondError(w, http.StatusBadRequest,
+					"A line's from_item_id is missing or does not hold the line's product at the source location")
+			} else if err == repository.ErrInsufficientStock {
+				respondError(w, http.StatusConflict, "Insufficient stock at the source location")
+			} else if err == repository.ErrVersionMismatch {
+				respondVersionMismatch(w, r, "Transfer order")
+			} else {
+				respondError(w, http.StatusInternalServerError, "Failed to update transfer order")
+			}
+			return
+		}
+
+		setETag(w, order.Version)
+		respondJSON(w, http.StatusOK, order)
+	}
+}
+
+// ReceiveTransferOrder receives a transfer order in transit, recording what
+// arrived for each line
+func (h *Handler) ReceiveTransferOrder(w http.ResponseWriter, r *http.Request) {
+	var receipt service.TransferReceipt
+	if err := json.NewDecoder(r.Body).Decode(&receipt); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	order, err := h.service.ReceiveTransferOrder(r.PathValue("id"), version, receipt)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Transfer order not found")
+		} else if err == service.ErrInvalidTransition {
+			respondError(w, http.StatusConflict, "Only transfer orders in transit can be received")
+		} else if err == service.ErrInvalidReceipt {
+			respondError(w, http.StatusBadRequest,
+				"Invalid receipt: received needs a quantity that is not negative for every line, or none at all")
+		} else if err == service.ErrInvalidTransferOrder {
+			respondError(w, http.StatusBadRequest,
+				"A line's to_item_id is missing or does not hold the line's product and lot at the destination")
+		} else if err == service.ErrLocationUnavailable {
+			respondError(w, http.StatusConflict, "The destination location no longer exists or is inactive")
+		} else if isUnitError(err) {
+			respondError(w, http.StatusBadRequest, unitErrorMessage(err))
+		} else if err == repository.ErrVersionMismatch {
+			respondVersionMismatch(w, r, "Transfer order")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to receive transfer order")
+		}
+		return
+	}
+
+	setETag(w, order.Version)
+	respondJSON(w, http.StatusOK, order)
+}
+
+// GetProductInTransit returns the quantity of a product dispatched on
+// transfer orders and not yet received
+func (h *Handler) GetProductInTransit(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	inTransit, unit, err := h.service.InTransit(id)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Product not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to get quantity in transit")
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"product_id": id,
+		"in_transit": inTransit,
+		"unit":       unit,
+	})
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+)
+
+// Unit handlers
+
+// ListUnits lists the standard units of measure by name. Products count in
+// one of them and may define packs of their own on top.
+func (h *Handler) ListUnits(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, models.Units)
+}
@@ -1,6 +1,15 @@
+// Package models defines the entities stored by the repository. Every
+// mutable entity carries a Version that starts at 1 when it is created and
+// is incremented by each update.
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"maps"
+	"math"
+	"slices"
+	"time"
+)
 
 // Seller represents a seller entity in the system
 type Seller struct {
@@ -9,16 +18,20 @@ type Seller struct {
 	Email     string    `json:"email"`
 	Phone     string    `json:"phone"`
 	CreatedAt time.Time `json:"created_at"`
+	Version   int64     `json:"version"`
 }
 
-// Buyer represents a buyer entity in the system
+// Buyer represents a buyer entity in the system. A buyer assigned a price
+// list by PriceListID pays its prices where it has one for a product.
 type Buyer struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Phone     string    `json:"phone"`
-	Address   string    `json:"address"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	Phone       string    `json:"phone"`
+	Address     string    `json:"address"`
+	PriceListID string    `json:"price_list_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Version     int64     `json:"version"`
 }
 
 // Vendor represents a vendor entity in the system
@@ -29,24 +42,631 @@ type Vendor struct {
 	Phone     string    `json:"phone"`
 	Address   string    `json:"address"`
 	CreatedAt time.Time `json:"created_at"`
+	Version   int64     `json:"version"`
 }
 
-// Product represents a product in the inventory
+// Product represents a product in the inventory. The stock of a
+// LotControlled product is held per lot, each inventory item being one lot.
+// Each unit of a Serialized product is tracked by a Serial, and its
+// inventory items count the units in stock. Every quantity of the product
+// is stored in its BaseUnit, one of Units; Packs are the product's own
+// units, such as cases and pallets, that it may also be counted in.
+//
+// A product with Axes is the parent of variants: products whose ParentID
+// names it and whose Attributes pick one of the values of each axis, such
+// as the 50 ft length of a hose. Variants have their own price and
+// inventory and inherit their parent's VendorID and Category.
+//
+// Price is what one BaseUnit of the product sells for.
+//
+// A product with Components is a kit, its bill of materials naming the
+// products each kit is built from. A kit may be held as assembled stock,
+// like any product, or built from its components as it is sold.
 type Product struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Category    string    `json:"category"`
-	Price       float64   `json:"price"`
-	VendorID    string    `json:"vendor_id"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Description   string            `json:"description"`
+	Category      string            `json:"category"`
+	Price         Money             `json:"price"`
+	VendorID      string            `json:"vendor_id"`
+	LotControlled bool              `json:"lot_controlled"`
+	Serialized    bool              `json:"serialized"`
+	BaseUnit      string            `json:"base_unit"`
+	Packs         []Pack            `json:"packs,omitempty"`
+	ParentID      string            `json:"parent_id,omitempty"`
+	Axes          []Axis            `json:"axes,omitempty"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
+	Components    []Component       `json:"components,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Version       int64             `json:"version"`
+}
+
+// Pack is a unit of one product that holds Quantity of another unit, Of:
+// a standard unit or another of the product's packs, the base unit when
+// empty. A pallet may hold 40 cases, each holding 12 each, and a bag of
+// mulch 2 cubic feet. Packs are counted in whole numbers.
+type Pack struct {
+	Unit     string  `json:"unit"`
+	Quantity float64 `json:"quantity"`
+	Of       string  `json:"of"`
+}
+
+// Axis is a way the variants of a product differ, such as size or colour,
+// and the values it takes
+type Axis struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// Component is Quantity of a product, in Unit, that goes into each kit
+type Component struct {
+	ProductID string  `json:"product_id"`
+	Quantity  float64 `json:"quantity"`
+	Unit      string  `json:"unit"`
+}
+
+// Clone returns a copy of the product that shares none of its packs, axes,
+// attributes or components
+func (p *Product) Clone() *Product {
+	c := *p
+	c.Packs = slices.Clone(p.Packs)
+	c.Components = slices.Clone(p.Components)
+	c.Axes = slices.Clone(p.Axes)
+	for i := range c.Axes {
+		c.Axes[i].Values = slices.Clone(c.Axes[i].Values)
+	}
+	c.Attributes = maps.Clone(p.Attributes)
+	return &c
+}
+
+// Dimension is what a unit of measure measures
+type Dimension string
+
+const (
+	DimensionCount  Dimension = "count"
+	DimensionMass   Dimension = "mass"
+	DimensionVolume Dimension = "volume"
+)
+
+// Unit is a standard unit of measure. Size is the unit's size in the
+// dimension's reference unit: one each, kilograms or cubic meters.
+// Quantities may be fractional only in Fractional units.
+type Unit struct {
+	Dimension  Dimension `json:"dimension"`
+	Size       float64   `json:"size"`
+	Fractional bool      `json:"fractional"`
+}
+
+// DefaultUnit is the base unit of products that do not name one
+const DefaultUnit = "each"
+
+// Units are the standard units of measure, by name
+var Units = map[string]Unit{
+	"each":       {DimensionCount, 1, false},
+	"kg":         {DimensionMass, 1, true},
+	"g":          {DimensionMass, 0.001, true},
+	"lb":         {DimensionMass, 0.45359237, true},
+	"oz":         {DimensionMass, 0.028349523125, true},
+	"liter":      {DimensionVolume, 0.001, true},
+	"gallon":     {DimensionVolume, 0.003785411784, true},
+	"cubic_foot": {DimensionVolume, 0.028316846592, true},
+	"cubic_yard": {DimensionVolume, 0.764554857984, true},
 }
 
-// InventoryItem represents an inventory item with quantity tracking
+// quantityPrecision is the number of decimal places quantities are kept to
+const quantityPrecision = 1e6
+
+// RoundQuantity rounds q to the precision quantities are kept to, so that
+// sums and differences of quantities carry no floating-point residue
+func RoundQuantity(q float64) float64 {
+	return math.Round(q*quantityPrecision) / quantityPrecision
+}
+
+// LocationType is a level of the location hierarchy
+type LocationType string
+
+const (
+	LocationSite      LocationType = "site"
+	LocationWarehouse LocationType = "warehouse"
+	LocationZone      LocationType = "zone"
+	LocationAisle     LocationType = "aisle"
+	LocationBin       LocationType = "bin"
+)
+
+// Location is a place stock is kept. Locations form a tree through
+// ParentID, from sites at the top down through warehouses, zones and aisles
+// to bins; a location with no parent is a root. Capacity is the stock the
+// location is meant to hold, zero when unlimited. Inactive locations keep
+// their stock but take no new inventory items.
+type Location struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Type      LocationType `json:"type"`
+	ParentID  string       `json:"parent_id"`
+	Capacity  int          `json:"capacity"`
+	Active    bool         `json:"active"`
+	CreatedAt time.Time    `json:"created_at"`
+	Version   int64        `json:"version"`
+}
+
+// InventoryItem represents an inventory item with quantity tracking.
+// Quantity is the stock on hand, the balance of the item's stock movements;
+// it is set by posting movements, not by updating the item. Reserved is the
+// stock held by reservations and Available is what remains to promise,
+// Quantity less Reserved. Both are maintained by the repository. Location is
+// the ID of the Location holding the item. Items of lot-controlled products
+// hold a single lot, named by LotNumber, with the dates it was made and
+// expires, if known. Unit is the product's base unit, which the item's
+// quantities are in.
 type InventoryItem struct {
+	ID             string     `json:"id"`
+	ProductID      string     `json:"product_id"`
+	Quantity       float64    `json:"quantity"`
+	Reserved       float64    `json:"reserved"`
+	Available      float64    `json:"available"`
+	Unit           string     `json:"unit"`
+	Location       string     `json:"location"`
+	LotNumber      string     `json:"lot_number,omitempty"`
+	ManufacturedAt *time.Time `json:"manufactured_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	Version        int64      `json:"version"`
+}
+
+// Clone returns a copy of the item that shares none of its times
+func (i *InventoryItem) Clone() *InventoryItem {
+	c := *i
+	c.ManufacturedAt = cloneTime(i.ManufacturedAt)
+	c.ExpiresAt = cloneTime(i.ExpiresAt)
+	return &c
+}
+
+// Expired reports whether the item's lot has expired by now
+func (i *InventoryItem) Expired(now time.Time) bool {
+	return i.ExpiresAt != nil && !i.ExpiresAt.After(now)
+}
+
+// cloneTime returns a copy of t, or nil if t is nil
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+// MovementType classifies a stock movement
+type MovementType string
+
+const (
+	MovementReceipt     MovementType = "receipt"
+	MovementShipment    MovementType = "shipment"
+	MovementAdjustment  MovementType = "adjustment"
+	MovementTransferIn  MovementType = "transfer_in"
+	MovementTransferOut MovementType = "transfer_out"
+	MovementReturn      MovementType = "return"
+	MovementWriteOff    MovementType = "write_off"
+	MovementConsume     MovementType = "consume"
+	MovementProduce     MovementType = "produce"
+)
+
+// Reason codes and actor used for movements the system records itself
+const (
+	ReasonOpeningBalance = "opening_balance"
+	ReasonStockCount     = "stock_count"
+	ReasonSale           = "sale"
+	ReasonPurchase       = "purchase"
+	ReasonTransfer       = "transfer"
+	ReasonReturn         = "return"
+	ReasonRepair         = "repair"
+	ReasonAssembly       = "assembly"
+	ReasonDisassembly    = "disassembly"
+	SystemActor          = "system"
+)
+
+// StockMovement is an entry in an inventory item's append-only ledger.
+// Delta is signed: positive movements add stock and negative ones remove
+// it. ID, Balance, the item's quantity after the movement, and Unit, the
+// item's unit, are assigned by the repository. An outbound movement may
+// consume ReservationID, a reservation of the item, taking the stock it
+// holds.
+type StockMovement struct {
+	ID            int64        `json:"id"`
+	ItemID        string       `json:"item_id"`
+	Type          MovementType `json:"type"`
+	Delta         float64      `json:"delta"`
+	Balance       float64      `json:"balance"`
+	Unit          string       `json:"unit"`
+	Reason        string       `json:"reason"`
+	Reference     string       `json:"reference"`
+	Actor         string       `json:"actor"`
+	ReservationID string       `json:"reservation_id,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+// Reservation holds Quantity of an inventory item's stock for Owner, a
+// reference such as an order or buyer ID, until it is released or
+// ExpiresAt passes. Reservations are never updated; a changed hold is a new
+// reservation. Unit is the item's unit, assigned by the repository.
+type Reservation struct {
 	ID        string    `json:"id"`
-	ProductID string    `json:"product_id"`
-	Quantity  int       `json:"quantity"`
-	Location  string    `json:"location"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ItemID    string    `json:"item_id"`
+	Quantity  float64   `json:"quantity"`
+	Unit      string    `json:"unit"`
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Expired reports whether the reservation no longer holds stock at t
+func (r *Reservation) Expired(t time.Time) bool {
+	return !t.Before(r.ExpiresAt)
+}
+
+// SalesOrderStatus is a stage in a sales order's lifecycle
+type SalesOrderStatus string
+
+const (
+	SalesOrderDraft     SalesOrderStatus = "draft"
+	SalesOrderConfirmed SalesOrderStatus = "confirmed"
+	SalesOrderAllocated SalesOrderStatus = "allocated"
+	SalesOrderPicked    SalesOrderStatus = "picked"
+	SalesOrderShipped   SalesOrderStatus = "shipped"
+	SalesOrderInvoiced  SalesOrderStatus = "invoiced"
+	SalesOrderCancelled SalesOrderStatus = "cancelled"
+)
+
+// SalesOrder is an order placed by a buyer. Its lines can be changed only
+// while it is a draft; after that it moves through the statuses above.
+type SalesOrder struct {
+	ID        string           `json:"id"`
+	BuyerID   string           `json:"buyer_id"`
+	Status    SalesOrderStatus `json:"status"`
+	Lines     []SalesOrderLine `json:"lines"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	Version   int64            `json:"version"`
+}
+
+// SalesOrderLine orders Quantity of a product, in Unit, at UnitPrice for
+// each PriceUnit of it, which together make Total. Allocations record the
+// inventory items whose stock was reserved for the line when the order was
+// confirmed, in the same unit as Quantity.
+type SalesOrderLine struct {
+	ProductID   string       `json:"product_id"`
+	Quantity    float64      `json:"quantity"`
+	Unit        string       `json:"unit"`
+	UnitPrice   Money        `json:"unit_price"`
+	PriceUnit   string       `json:"price_unit"`
+	Total       Money        `json:"total"`
+	Allocations []Allocation `json:"allocations,omitempty"`
+}
+
+// Allocation is the part of an order line reserved from one inventory item.
+// When a line for a kit is built from its components, ProductID is the
+// component the item holds; it is empty for stock of the line's product.
+type Allocation struct {
+	ItemID        string  `json:"item_id"`
+	ReservationID string  `json:"reservation_id"`
+	Quantity      float64 `json:"quantity"`
+	ProductID     string  `json:"product_id,omitempty"`
+}
+
+// Clone returns a copy of the order that shares none of its lines
+func (o *SalesOrder) Clone() *SalesOrder {
+	c := *o
+	if o.Lines != nil {
+		c.Lines = make([]SalesOrderLine, len(o.Lines))
+		for i, line := range o.Lines {
+			line.Allocations = slices.Clone(line.Allocations)
+			c.Lines[i] = line
+		}
+	}
+	return &c
+}
+
+// PurchaseOrderStatus is a stage in a purchase order's lifecycle
+type PurchaseOrderStatus string
+
+const (
+	PurchaseOrderDraft     PurchaseOrderStatus = "draft"
+	PurchaseOrderOpen      PurchaseOrderStatus = "open"
+	PurchaseOrderClosed    PurchaseOrderStatus = "closed"
+	PurchaseOrderCancelled PurchaseOrderStatus = "cancelled"
+)
+
+// PurchaseOrder is an order placed with a vendor. Its lines can be changed
+// only while it is a draft. Once issued it is open for receiving until it
+// is closed, which happens by itself when every line has been received.
+//
+// OverTolerance and UnderTolerance are percentages of a line's quantity: a
+// line may receive up to OverTolerance percent more than was ordered, and
+// counts as fully received once it is within UnderTolerance percent of it.
+type PurchaseOrder struct {
+	ID             string              `json:"id"`
+	VendorID       string              `json:"vendor_id"`
+	Status         PurchaseOrderStatus `json:"status"`
+	OverTolerance  float64             `json:"over_tolerance"`
+	UnderTolerance float64             `json:"under_tolerance"`
+	Lines          []PurchaseOrderLine `json:"lines"`
+	CreatedAt      time.Time           `json:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at"`
+	Version        int64               `json:"version"`
+}
+
+// PurchaseOrderLine orders Quantity of a product, in Unit, at Cost for each
+// CostUnit of it, which together make Total, for delivery by ExpectedAt.
+// Received is the quantity received against it so far.
+type PurchaseOrderLine struct {
+	ProductID  string    `json:"product_id"`
+	Quantity   float64   `json:"quantity"`
+	Unit       string    `json:"unit"`
+	Cost       Money     `json:"cost"`
+	CostUnit   string    `json:"cost_unit"`
+	Total      Money     `json:"total"`
+	Received   float64   `json:"received"`
+	ExpectedAt time.Time `json:"expected_at"`
+}
+
+// Clone returns a copy of the order that shares none of its lines
+func (o *PurchaseOrder) Clone() *PurchaseOrder {
+	c := *o
+	c.Lines = slices.Clone(o.Lines)
+	return &c
+}
+
+// TransferOrderStatus is a stage in a transfer order's lifecycle
+type TransferOrderStatus string
+
+const (
+	TransferOrderDraft     TransferOrderStatus = "draft"
+	TransferOrderInTransit TransferOrderStatus = "in_transit"
+	TransferOrderReceived  TransferOrderStatus = "received"
+	TransferOrderCancelled TransferOrderStatus = "cancelled"
+)
+
+// TransferOrder moves stock from one location to another. Dispatching it
+// takes each line's stock out of an item at FromLocation, after which the
+// stock is in transit, counted by the order rather than by any item, until
+// receiving it puts what arrived into an item at ToLocation. Note records
+// anything said about the transfer when it was received.
+type TransferOrder struct {
+	ID           string              `json:"id"`
+	FromLocation string              `json:"from_location"`
+	ToLocation   string              `json:"to_location"`
+	Status       TransferOrderStatus `json:"status"`
+	Lines        []TransferOrderLine `json:"lines"`
+	Note         string              `json:"note,omitempty"`
+	DispatchedAt *time.Time          `json:"dispatched_at,omitempty"`
+	ReceivedAt   *time.Time          `json:"received_at,omitempty"`
+	CreatedAt    time.Time           `json:"created_at"`
+	UpdatedAt    time.Time           `json:"updated_at"`
+	Version      int64               `json:"version"`
+}
+
+// TransferOrderLine transfers Quantity of a product from the inventory item
+// FromItemID to the item ToItemID. Either item may be left empty to be
+// chosen when the order is dispatched or received. Received is the
+// quantity that arrived, and Discrepancy is Received less Quantity: negative
+// when stock was lost on the way and positive when more arrived than was
+// sent. All three are in Unit. LotNumber, ManufacturedAt and ExpiresAt are
+// the lot of FromItemID, recorded when the order is dispatched, which the
+// stock keeps at its destination.
+type TransferOrderLine struct {
+	ProductID      string     `json:"product_id"`
+	Quantity       float64    `json:"quantity"`
+	Unit           string     `json:"unit"`
+	FromItemID     string     `json:"from_item_id,omitempty"`
+	ToItemID       string     `json:"to_item_id,omitempty"`
+	LotNumber      string     `json:"lot_number,omitempty"`
+	ManufacturedAt *time.Time `json:"manufactured_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	Received       float64    `json:"received"`
+	Discrepancy    float64    `json:"discrepancy"`
+}
+
+// Clone returns a copy of the order that shares none of its lines or times
+func (o *TransferOrder) Clone() *TransferOrder {
+	c := *o
+	c.Lines = slices.Clone(o.Lines)
+	for i := range c.Lines {
+		c.Lines[i].ManufacturedAt = cloneTime(o.Lines[i].ManufacturedAt)
+		c.Lines[i].ExpiresAt = cloneTime(o.Lines[i].ExpiresAt)
+	}
+	c.DispatchedAt = cloneTime(o.DispatchedAt)
+	c.ReceivedAt = cloneTime(o.ReceivedAt)
+	return &c
+}
+
+// SerialStatus is the state of one unit of a serialized product
+type SerialStatus string
+
+const (
+	SerialInStock  SerialStatus = "in_stock"
+	SerialSold     SerialStatus = "sold"
+	SerialReturned SerialStatus = "returned"
+	SerialInRepair SerialStatus = "in_repair"
+)
+
+// Serial is one unit of a serialized product, identified by its serial
+// number. ItemID is the inventory item that counts the unit while it is in
+// stock or returned, and that last counted it otherwise; Location is that
+// item's location. BuyerID is the buyer the unit was sold to, and Reference
+// the document, such as a sales order, that last changed its status.
+type Serial struct {
+	ID        string       `json:"id"`
+	ProductID string       `json:"product_id"`
+	ItemID    string       `json:"item_id"`
+	Location  string       `json:"location"`
+	Status    SerialStatus `json:"status"`
+	BuyerID   string       `json:"buyer_id,omitempty"`
+	Reference string       `json:"reference,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Version   int64        `json:"version"`
+}
+
+// PriceList is a named set of prices, such as those paid by landscape
+// contractors or wholesale nurseries, that the buyers assigned to it pay in
+// place of products' own prices
+type PriceList struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"`
+	Prices    []ListPrice `json:"prices"`
+	CreatedAt time.Time   `json:"created_at"`
+	Version   int64       `json:"version"`
+}
+
+// ListPrice is the Price of one base unit of a product on a price list. It
+// applies to purchases of at least MinQuantity, in Unit, made from
+// ValidFrom until ValidTo; a window without one of them is open at that
+// end. Several prices of one product with different minimum quantities
+// form quantity breaks.
+type ListPrice struct {
+	ProductID   string     `json:"product_id"`
+	MinQuantity float64    `json:"min_quantity"`
+	Unit        string     `json:"unit"`
+	Price       Money      `json:"price"`
+	ValidFrom   *time.Time `json:"valid_from,omitempty"`
+	ValidTo     *time.Time `json:"valid_to,omitempty"`
+}
+
+// Applies reports whether the price applies to a purchase of quantity, in
+// its unit, at t
+func (p *ListPrice) Applies(quantity float64, t time.Time) bool {
+	return quantity >= p.MinQuantity && p.Current(t)
+}
+
+// Current reports whether t falls within the price's validity window
+func (p *ListPrice) Current(t time.Time) bool {
+	return (p.ValidFrom == nil || !t.Before(*p.ValidFrom)) && (p.ValidTo == nil || t.Before(*p.ValidTo))
+}
+
+// Clone returns a copy of the price list that shares none of its prices
+func (l *PriceList) Clone() *PriceList {
+	c := *l
+	c.Prices = slices.Clone(l.Prices)
+	for i := range c.Prices {
+		c.Prices[i].ValidFrom = cloneTime(l.Prices[i].ValidFrom)
+		c.Prices[i].ValidTo = cloneTime(l.Prices[i].ValidTo)
+	}
+	return &c
+}
+
+// PromotionType is the kind of discount a promotion gives
+type PromotionType string
+
+const (
+	// PromotionPercentOff takes Percent off the lines it covers
+	PromotionPercentOff PromotionType = "percent_off"
+	// PromotionAmountOff takes Amount off the lines it covers, together
+	PromotionAmountOff PromotionType = "amount_off"
+	// PromotionBuyGetFree makes FreeQuantity of each line it covers free
+	// for every BuyQuantity bought
+	PromotionBuyGetFree PromotionType = "buy_get_free"
+)
+
+// Promotion is a discount rule, such as "buy 3 bags of mulch get 1 free",
+// "15% off Soil Amendments" or "$10 off orders over $100". It covers the
+// cart lines for ProductIDs or of Categories, or every line if it names
+// neither, and only once those lines add up to MinSubtotal. It is valid
+// from ValidFrom until ValidTo, either open when nil. BuyQuantity and
+// FreeQuantity are in Unit of each product.
+//
+// Promotions stack, in order of descending Priority, each applying to
+// what earlier ones left. An Exclusive promotion never combines with
+// another: it applies alone if it saves more than the others together. Of
+// promotions sharing a Group only the first applies. LimitPerBuyer, when
+// not zero, is how many times a buyer may redeem the promotion, and Uses
+// counts the redemptions of each buyer.
+type Promotion struct {
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	Type          PromotionType  `json:"type"`
+	Percent       BasisPoints    `json:"percent,omitempty"`
+	Amount        Money          `json:"amount"`
+	BuyQuantity   float64        `json:"buy_quantity,omitempty"`
+	FreeQuantity  float64        `json:"free_quantity,omitempty"`
+	Unit          string         `json:"unit,omitempty"`
+	ProductIDs    []string       `json:"product_ids,omitempty"`
+	Categories    []string       `json:"categories,omitempty"`
+	MinSubtotal   Money          `json:"min_subtotal"`
+	Exclusive     bool           `json:"exclusive"`
+	Group         string         `json:"group,omitempty"`
+	Priority      int            `json:"priority"`
+	ValidFrom     *time.Time     `json:"valid_from,omitempty"`
+	ValidTo       *time.Time     `json:"valid_to,omitempty"`
+	LimitPerBuyer int            `json:"limit_per_buyer,omitempty"`
+	Uses          map[string]int `json:"uses,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Version       int64          `json:"version"`
+}
+
+// Current reports whether t falls within the promotion's validity window
+func (p *Promotion) Current(t time.Time) bool {
+	return (p.ValidFrom == nil || !t.Before(*p.ValidFrom)) && (p.ValidTo == nil || t.Before(*p.ValidTo))
+}
+
+// Covers reports whether the promotion covers a cart line of product
+func (p *Promotion) Covers(product *Product) bool {
+	if len(p.ProductIDs) == 0 && len(p.Categories) == 0 {
+		return true
+	}
+	return slices.Contains(p.ProductIDs, product.ID) || slices.Contains(p.Categories, product.Category)
+}
+
+// Clone returns a copy of the promotion that shares none of its products,
+// categories, window or uses
+func (p *Promotion) Clone() *Promotion {
+	c := *p
+	c.ProductIDs = slices.Clone(p.ProductIDs)
+	c.Categories = slices.Clone(p.Categories)
+	c.ValidFrom = cloneTime(p.ValidFrom)
+	c.ValidTo = cloneTime(p.ValidTo)
+	c.Uses = maps.Clone(p.Uses)
+	return &c
+}
+
+// Entity types, as named by change events
+const (
+	EntitySeller        = "seller"
+	EntityBuyer         = "buyer"
+	EntityVendor        = "vendor"
+	EntityProduct       = "product"
+	EntityInventoryItem = "inventory_item"
+	EntityStockMovement = "stock_movement"
+	EntityReservation   = "reservation"
+	EntitySalesOrder    = "sales_order"
+	EntityPurchaseOrder = "purchase_order"
+	EntityLocation      = "location"
+	EntityTransferOrder = "transfer_order"
+	EntitySerial        = "serial"
+	EntityPriceList     = "price_list"
+	EntityPromotion     = "promotion"
+)
+
+// ChangeOp is the kind of change a change event records
+type ChangeOp string
+
+const (
+	ChangeCreate ChangeOp = "create"
+	ChangeUpdate ChangeOp = "update"
+	ChangeDelete ChangeOp = "delete"
+)
+
+// ChangeEvent records one committed change to one entity. Seq numbers
+// increase in commit order and are never reused. Before and After are the
+// entity's JSON before and after the change, absent for a create and a
+// delete respectively. Version is the entity's version after the change, or
+// before it for a delete, and zero for stock movements and reservations,
+// which have no version.
+type ChangeEvent struct {
+	Seq         int64           `json:"seq"`
+	Entity      string          `json:"entity"`
+	ID          string          `json:"id"`
+	Op          ChangeOp        `json:"op"`
+	Version     int64           `json:"version"`
+	Before      json.RawMessage `json:"before,omitempty"`
+	After       json.RawMessage `json:"after,omitempty"`
+	CommittedAt time.Time       `json:"committed_at"`
 }
@@ -0,0 +1,344 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidMoney     = errors.New("invalid money")
+	ErrCurrencyMismatch = errors.New("currencies differ")
+	ErrMoneyOverflow    = errors.New("money amount out of range")
+	ErrInvalidPercent   = errors.New("invalid percent")
+)
+
+// DefaultCurrency is the currency of prices stored before prices had one
+const DefaultCurrency = "USD"
+
+// Currencies are the ISO 4217 currencies money may be in, by code, with the
+// number of decimal digits of each one's minor unit
+var Currencies = map[string]int{
+	"AUD": 2,
+	"BHD": 3,
+	"BRL": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CLP": 0,
+	"CNY": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"INR": 2,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MXN": 2,
+	"NOK": 2,
+	"NZD": 2,
+	"OMR": 3,
+	"SEK": 2,
+	"SGD": 2,
+	"USD": 2,
+	"ZAR": 2,
+}
+
+// Money is an exact amount of a currency, held as a whole number of the
+// currency's minor unit, such as cents. Arithmetic on money is exact;
+// where a result falls between two minor units it is rounded to the
+// nearer, ties to even. Money is encoded in JSON as an object with the
+// amount as a decimal string, {"amount": "24.99", "currency": "USD"}, and
+// decoded strictly: the amount must be a string with no more decimal
+// places than the currency has and the currency one of Currencies. The
+// zero Money, nothing in no currency, is encoded with an amount of "0" and
+// an empty currency.
+type Money struct {
+	Minor    int64
+	Currency string
+}
+
+// ParseMoney returns the money with the given decimal amount, such as
+// "24.99" or "-3", in currency. It fails with ErrInvalidMoney unless the
+// amount is a plain decimal with no more decimal places than the
+// currency's minor unit and the currency is one of Currencies.
+func ParseMoney(amount, currency string) (Money, error) {
+	digits, ok := Currencies[currency]
+	if !ok {
+		return Money{}, ErrInvalidMoney
+	}
+	r, ok := parseDecimal(amount)
+	if !ok {
+		return Money{}, ErrInvalidMoney
+	}
+	if _, fraction, found := strings.Cut(amount, "."); found && len(fraction) > digits {
+		return Money{}, ErrInvalidMoney
+	}
+	minor, err := roundHalfEven(r.Mul(r, scale(digits)))
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// MoneyFromFloat returns the money nearest to the floating-point amount f
+// in currency, taking f to be the shortest decimal that it represents and
+// rounding that to the currency's minor unit, ties to even. It is meant
+// for amounts stored as floats before money was exact.
+func MoneyFromFloat(f float64, currency string) (Money, error) {
+	digits, ok := Currencies[currency]
+	if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+		return Money{}, ErrInvalidMoney
+	}
+	r, _ := parseDecimal(strconv.FormatFloat(f, 'f', -1, 64))
+	minor, err := roundHalfEven(r.Mul(r, scale(digits)))
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// parseDecimal parses a plain decimal: an optional minus sign, digits and
+// optionally a point followed by more digits
+func parseDecimal(s string) (*big.Rat, bool) {
+	whole, fraction, found := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if whole == "" || (found && fraction == "") || !allDigits(whole) || !allDigits(fraction) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+func allDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// scale returns ten to the power of digits
+func scale(digits int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil))
+}
+
+// roundHalfEven rounds r to the nearest whole number, ties to even,
+// failing with ErrMoneyOverflow if that does not fit in an int64
+func roundHalfEven(r *big.Rat) (int64, error) {
+	quotient, remainder := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	twice := new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2))
+	if c := twice.Cmp(r.Denom()); c > 0 || (c == 0 && quotient.Bit(0) == 1) {
+		quotient.Add(quotient, big.NewInt(int64(r.Sign())))
+	}
+	if !quotient.IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+	return quotient.Int64(), nil
+}
+
+// Valid reports whether m is in one of Currencies
+func (m Money) Valid() bool {
+	_, ok := Currencies[m.Currency]
+	return ok
+}
+
+// IsZero reports whether m is an amount of nothing
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+// Sign returns -1, 0 or +1 as m is negative, zero or positive
+func (m Money) Sign() int {
+	switch {
+	case m.Minor < 0:
+		return -1
+	case m.Minor > 0:
+		return 1
+	}
+	return 0
+}
+
+// Amount returns m's amount as a decimal with as many decimal places as
+// its currency's minor unit, such as "24.99"
+func (m Money) Amount() string {
+	digits := Currencies[m.Currency]
+	s := strconv.FormatUint(absMinor(m.Minor), 10)
+	if digits > 0 {
+		if len(s) <= digits {
+			s = strings.Repeat("0", digits-len(s)+1) + s
+		}
+		s = s[:len(s)-digits] + "." + s[len(s)-digits:]
+	}
+	if m.Minor < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+func absMinor(minor int64) uint64 {
+	if minor < 0 {
+		return uint64(-(minor + 1)) + 1
+	}
+	return uint64(minor)
+}
+
+// String returns m as its amount and currency, such as "24.99 USD"
+func (m Money) String() string {
+	return m.Amount() + " " + m.Currency
+}
+
+// Float64 returns m's amount as the nearest float. It is not exact and is
+// meant only for storage that predates exact money.
+func (m Money) Float64() float64 {
+	f, _ := new(big.Rat).Quo(new(big.Rat).SetInt64(m.Minor), scale(Currencies[m.Currency])).Float64()
+	return f
+}
+
+// Add returns m plus o. It fails with ErrCurrencyMismatch if they are in
+// different currencies and with ErrMoneyOverflow if the sum is too large.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	sum := m.Minor + o.Minor
+	if (o.Minor > 0 && sum < m.Minor) || (o.Minor < 0 && sum > m.Minor) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Minor: sum, Currency: m.Currency}, nil
+}
+
+// Sub returns m less o, failing as described for Add
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Minor == math.MinInt64 {
+		return Money{}, ErrMoneyOverflow
+	}
+	return m.Add(o.Neg())
+}
+
+// Neg returns m with its sign reversed
+func (m Money) Neg() Money {
+	return Money{Minor: -m.Minor, Currency: m.Currency}
+}
+
+// Cmp returns -1, 0 or +1 as m is less than, equal to or greater than o.
+// It fails with ErrCurrencyMismatch if they are in different currencies.
+func (m Money) Cmp(o Money) (int, error) {
+	if m.Currency != o.Currency {
+		return 0, ErrCurrencyMismatch
+	}
+	switch {
+	case m.Minor < o.Minor:
+		return -1, nil
+	case m.Minor > o.Minor:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Mul returns m times factor, such as a quantity or a fraction of a price,
+// rounded to the minor unit, ties to even. The factor is taken to be the
+// shortest decimal that it represents, so 2.5 bags at 3.99 is exactly
+// 9.975 before rounding. It fails with ErrMoneyOverflow if the product is
+// too large.
+func (m Money) Mul(factor float64) (Money, error) {
+	if math.IsNaN(factor) || math.IsInf(factor, 0) {
+		return Money{}, ErrInvalidMoney
+	}
+	r, _ := parseDecimal(strconv.FormatFloat(factor, 'f', -1, 64))
+	return m.MulRat(r)
+}
+
+// MulRat returns m times the exact fraction r, rounded and failing as
+// described for Mul
+func (m Money) MulRat(r *big.Rat) (Money, error) {
+	minor, err := roundHalfEven(new(big.Rat).Mul(new(big.Rat).SetInt64(m.Minor), r))
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: minor, Currency: m.Currency}, nil
+}
+
+// moneyJSON is the JSON encoding of Money
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON encodes m with its amount as a decimal string
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Amount(), Currency: m.Currency})
+}
+
+// UnmarshalJSON decodes money encoded as by MarshalJSON, failing with
+// ErrInvalidMoney for anything else, a number or an unknown field among
+// them. Null leaves m unchanged.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var v moneyJSON
+	if err := decoder.Decode(&v); err != nil {
+		return ErrInvalidMoney
+	}
+	if v == (moneyJSON{Amount: "0"}) {
+		*m = Money{}
+		return nil
+	}
+	money, err := ParseMoney(v.Amount, v.Currency)
+	if err != nil {
+		return err
+	}
+	*m = money
+	return nil
+}
+
+// BasisPoints is an exact fraction in hundredths of a percent, so 1250 is
+// 12.5%. It is encoded in JSON as a number of percent, such as 12.5, and
+// decoded strictly: the number must be a plain decimal with at most two
+// decimal places.
+type BasisPoints int64
+
+// OnePercent is one percent in basis points
+const OnePercent BasisPoints = 100
+
+// Of returns b of m, rounded to the minor unit, ties to even, failing as
+// described for Mul
+func (b BasisPoints) Of(m Money) (Money, error) {
+	return m.MulRat(big.NewRat(int64(b), 100*int64(OnePercent)))
+}
+
+// MarshalJSON encodes b as a number of percent
+func (b BasisPoints) MarshalJSON() ([]byte, error) {
+	r := big.NewRat(int64(b), int64(OnePercent))
+	if r.IsInt() {
+		return []byte(r.Num().String()), nil
+	}
+	return []byte(r.FloatString(2)), nil
+}
+
+// UnmarshalJSON decodes a number of percent, failing with
+// ErrInvalidPercent unless it is a plain decimal with at most two decimal
+// places. Null leaves b unchanged.
+func (b *BasisPoints) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	r, ok := parseDecimal(string(data))
+	if !ok {
+		return ErrInvalidPercent
+	}
+	r.Mul(r, big.NewRat(int64(OnePercent), 1))
+	if !r.IsInt() || !r.Num().IsInt64() {
+		return ErrInvalidPercent
+	}
+	*b = BasisPoints(r.Num().Int64())
+	return nil
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		amount, currency string
+		want             Money
+		err              error
+	}{
+		{"24.99", "USD", Money{2499, "USD"}, nil},
+		{"24.9", "USD", Money{2490, "USD"}, nil},
+		{"-3", "USD", Money{-300, "USD"}, nil},
+		{"1500", "JPY", Money{1500, "JPY"}, nil},
+		{"1.250", "KWD", Money{1250, "KWD"}, nil},
+		{"24.999", "USD", Money{}, ErrInvalidMoney},
+		{"1.5", "JPY", Money{}, ErrInvalidMoney},
+		{"24.99", "XYZ", Money{}, ErrInvalidMoney},
+		{"24.99", "", Money{}, ErrInvalidMoney},
+		{"1e3", "USD", Money{}, ErrInvalidMoney},
+		{"+5", "USD", Money{}, ErrInvalidMoney},
+		{"5.", "USD", Money{}, ErrInvalidMoney},
+		{".5", "USD", Money{}, ErrInvalidMoney},
+		{"", "USD", Money{}, ErrInvalidMoney},
+		{"99999999999999999999", "USD", Money{}, ErrMoneyOverflow},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.amount, tt.currency)
+		if got != tt.want || err != tt.err {
+			t.Errorf("ParseMoney(%q, %q): expected %v, %v, got %v, %v", tt.amount, tt.currency, tt.want, tt.err, got, err)
+		}
+	}
+}
+
+func TestMoneyAmount(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{Money{2499, "USD"}, "24.99"},
+		{Money{5, "USD"}, "0.05"},
+		{Money{-5, "USD"}, "-0.05"},
+		{Money{1500, "JPY"}, "1500"},
+		{Money{1250, "KWD"}, "1.250"},
+		{Money{math.MinInt64, "JPY"}, "-9223372036854775808"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.Amount(); got != tt.want {
+			t.Errorf("Expected %v to have amount %q, got %q", tt.money, tt.want, got)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	price := Money{399, "USD"}
+
+	sum, err := price.Add(Money{1, "USD"})
+	if err != nil || sum != (Money{400, "USD"}) {
+		t.Errorf("Expected 4.00 USD, got %v, %v", sum, err)
+	}
+	difference, err := price.Sub(Money{500, "USD"})
+	if err != nil || difference != (Money{-101, "USD"}) {
+		t.Errorf("Expected -1.01 USD, got %v, %v", difference, err)
+	}
+	if _, err := price.Add(Money{1, "EUR"}); err != ErrCurrencyMismatch {
+		t.Errorf("Expected ErrCurrencyMismatch adding euros to dollars, got %v", err)
+	}
+	if _, err := (Money{math.MaxInt64, "USD"}).Add(Money{1, "USD"}); err != ErrMoneyOverflow {
+		t.Errorf("Expected ErrMoneyOverflow, got %v", err)
+	}
+	if c, err := price.Cmp(Money{400, "USD"}); c != -1 || err != nil {
+		t.Errorf("Expected 3.99 USD to be less than 4.00 USD, got %d, %v", c, err)
+	}
+
+	// Results between two cents round to the nearer, ties to the even cent
+	tests := []struct {
+		money  Money
+		factor float64
+		want   int64
+	}{
+		{Money{399, "USD"}, 2.5, 998},   // 9.975
+		{Money{397, "USD"}, 2.5, 992},   // 9.925
+		{Money{1000, "USD"}, 0.85, 850}, // 15% off
+		{Money{1, "USD"}, 0.5, 0},       // 0.005
+		{Money{3, "USD"}, 0.5, 2},       // 0.015
+		{Money{-3, "USD"}, 0.5, -2},     // -0.015
+		{Money{100, "USD"}, 1.0 / 3, 33},
+	}
+	for _, tt := range tests {
+		got, err := tt.money.Mul(tt.factor)
+		if err != nil || got != (Money{tt.want, tt.money.Currency}) {
+			t.Errorf("Expected %v times %v to be %d cents, got %v, %v", tt.money, tt.factor, tt.want, got, err)
+		}
+	}
+}
+
+func TestMoneyFromFloat(t *testing.T) {
+	tests := []struct {
+		f    float64
+		want int64
+	}{
+		{24.99, 2499},
+		{0.1 + 0.2, 30},
+		{19.125, 1912},
+		{19.135, 1914},
+		{-0.005, 0},
+	}
+	for _, tt := range tests {
+		got, err := MoneyFromFloat(tt.f, "USD")
+		if err != nil || got != (Money{tt.want, "USD"}) {
+			t.Errorf("Expected %v to become %d cents, got %v, %v", tt.f, tt.want, got, err)
+		}
+	}
+	if _, err := MoneyFromFloat(math.NaN(), "USD"); err != ErrInvalidMoney {
+		t.Errorf("Expected ErrInvalidMoney for NaN, got %v", err)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	data, err := json.Marshal(Money{2499, "USD"})
+	if err != nil {
+		t.Fatalf("Failed to encode money: %v", err)
+	}
+	if string(data) != `{"amount":"24.99","currency":"USD"}` {
+		t.Errorf("Expected the amount as a string, got %s", data)
+	}
+
+	var decoded Money
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != (Money{2499, "USD"}) {
+		t.Errorf("Expected 24.99 USD back, got %v, %v", decoded, err)
+	}
+	var zero Money
+	data, _ = json.Marshal(zero)
+	if err := json.Unmarshal(data, &zero); err != nil || zero != (Money{}) {
+		t.Errorf("Expected the zero Money to round-trip, got %v, %v", zero, err)
+	}
+
+	for _, body := range []string{
+		`24.99`,
+		`"24.99"`,
+		`{"amount": 24.99, "currency": "USD"}`,
+		`{"amount": "24.999", "currency": "USD"}`,
+		`{"amount": "24.99"}`,
+		`{"amount": "24.99", "currency": "USD", "rate": 1}`,
+	} {
+		var m Money
+		if err := json.Unmarshal([]byte(body), &m); err == nil {
+			t.Errorf("Expected %s to be rejected, got %v", body, m)
+		}
+	}
+}
+
+func TestBasisPoints(t *testing.T) {
+	for _, tc := range []struct {
+		percent  BasisPoints
+		of       Money
+		expected Money
+	}{
+		{15 * OnePercent, Money{1999, "USD"}, Money{300, "USD"}},
+		{1250, Money{1000, "JPY"}, Money{125, "JPY"}},
+		{1, Money{50, "USD"}, Money{0, "USD"}},
+		{3333, Money{3, "USD"}, Money{1, "USD"}},
+		{100 * OnePercent, Money{12345, "KWD"}, Money{12345, "KWD"}},
+	} {
+		got, err := tc.percent.Of(tc.of)
+		if err != nil || got != tc.expected {
+			t.Errorf("Expected %d basis points of %v to be %v, got %v, %v", tc.percent, tc.of, tc.expected, got, err)
+		}
+	}
+}
+
+func TestBasisPointsJSON(t *testing.T) {
+	for _, tc := range []struct {
+		percent BasisPoints
+		json    string
+	}{
+		{15 * OnePercent, "15"},
+		{1250, "12.50"},
+		{1, "0.01"},
+	} {
+		data, err := json.Marshal(tc.percent)
+		if err != nil || string(data) != tc.json {
+			t.Errorf("Expected %d basis points to encode as %s, got %s, %v", tc.percent, tc.json, data, err)
+		}
+		var decoded BasisPoints
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded != tc.percent {
+			t.Errorf("Expected %s to decode as %d basis points, got %d, %v", data, tc.percent, decoded, err)
+		}
+	}
+	for _, body := range []string{`12.345`, `"15"`, `1e1`, `true`} {
+		var b BasisPoints
+		if err := json.Unmarshal([]byte(body), &b); err == nil {
+			t.Errorf("Expected %s to be rejected, got %d", body, b)
+		}
+	}
+}
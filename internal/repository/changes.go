@@ -0,0 +1,418 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+)
+
+// subscriptionBatch is the number of events a subscription reads from the
+// change log at a time
+const subscriptionBatch = 100
+
+// newChangeEvent returns the event for a change of an entity of the given
+// kind from before to after, either of which may be nil
+func newChangeEvent(kind, id string, before, after any) (*models.ChangeEvent, error) {
+	event := &models.ChangeEvent{Entity: kind, ID: id, CommittedAt: time.Now()}
+	var err error
+	switch {
+	case isNil(before):
+		event.Op = models.ChangeCreate
+		event.Version = entityVersion(after)
+	case isNil(after):
+		event.Op = models.ChangeDelete
+		event.Version = entityVersion(before)
+	default:
+		event.Op = models.ChangeUpdate
+		event.Version = entityVersion(after)
+	}
+	if !isNil(before) {
+		if event.Before, err = json.Marshal(before); err != nil {
+			return nil, err
+		}
+	}
+	if !isNil(after) {
+		if event.After, err = json.Marshal(after); err != nil {
+			return nil, err
+		}
+	}
+	return event, nil
+}
+
+// isNil reports whether v is nil or a nil entity pointer
+func isNil(v any) bool {
+	switch e := v.(type) {
+	case nil:
+		return true
+	case *models.Seller:
+		return e == nil
+	case *models.Buyer:
+		return e == nil
+	case *models.Vendor:
+		return e == nil
+	case *models.Product:
+		return e == nil
+	case *models.InventoryItem:
+		return e == nil
+	case *models.StockMovement:
+		return e == nil
+	case *models.Reservation:
+		return e == nil
+	case *models.SalesOrder:
+		return e == nil
+	case *models.PurchaseOrder:
+		return e == nil
+	case *models.Location:
+		return e == nil
+	case *models.TransferOrder:
+		return e == nil
+	case *models.Serial:
+		return e == nil
+	case *models.PriceList:
+		return e == nil
+	case *models.Promotion:
+		return e == nil
+	}
+	return false
+}
+
+// entityVersion returns the Version of an entity, or zero if it has none
+func entityVersion(v any) int64 {
+	switch e := v.(type) {
+	case *models.Seller:
+		return e.Version
+	case *models.Buyer:
+		return e.Version
+	case *models.Vendor:
+		return e.Version
+	case *models.Product:
+		return e.Version
+	case *models.InventoryItem:
+		return e.Version
+	case *models.SalesOrder:
+		return e.Version
+	case *models.PurchaseOrder:
+		return e.Version
+	case *models.Location:
+		return e.Version
+	case *models.TransferOrder:
+		return e.Version
+	case *models.Serial:
+		return e.Version
+	case *models.PriceList:
+		return e.Version
+	case *models.Promotion:
+		return e.Version
+	}
+	return 0
+}
+
+// cloneChange copies an event, including its images
+func cloneChange(e *models.ChangeEvent) *models.ChangeEvent {
+	c := *e
+	c.Before = bytes.Clone(e.Before)
+	c.After = bytes.Clone(e.After)
+	return &c
+}
+
+// broadcaster wakes every waiter when the change log grows. A nil
+// broadcaster never wakes anyone, which suits views such as a unit of work
+// whose changes are not yet committed.
+type broadcaster struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{ch: make(chan struct{})}
+}
+
+// wait returns a channel that is closed at the next broadcast
+func (b *broadcaster) wait() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ch
+}
+
+func (b *broadcaster) broadcast() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(b.ch)
+	b.ch = make(chan struct{})
+}
+
+// changeLog reads change events with Seq greater than after, oldest first
+type changeLog func(after int64, limit int) ([]*models.ChangeEvent, error)
+
+// Subscription delivers change events in Seq order as they are committed.
+// Events delivers them until the subscription is closed or reading the
+// change log fails, when it is closed; Err then reports the failure.
+type Subscription struct {
+	events chan models.ChangeEvent
+	done   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+// subscribe starts delivering the events after seq from log, waking on
+// changed when it has caught up
+func subscribe(log changeLog, changed *broadcaster, after int64) *Subscription {
+	s := &Subscription{
+		events: make(chan models.ChangeEvent),
+		done:   make(chan struct{}),
+	}
+	go s.run(log, changed, after)
+	return s
+}
+
+func (s *Subscription) run(log changeLog, changed *broadcaster, after int64) {
+	defer close(s.events)
+	for {
+		// Take the wake-up channel before reading so that an event committed
+		// after the read is not missed
+		wake := changed.wait()
+		events, err := log(after, subscriptionBatch)
+		if err != nil {
+			s.err = err
+			return
+		}
+		for _, e := range events {
+			select {
+			case s.events <- *e:
+				after = e.Seq
+			case <-s.done:
+				return
+			}
+		}
+		if len(events) > 0 {
+			continue
+		}
+		select {
+		case <-wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Events returns the channel the events are delivered on
+func (s *Subscription) Events() <-chan models.ChangeEvent {
+	return s.events
+}
+
+// Err returns the error that ended the subscription, once Events is closed
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Close stops the subscription and waits for Events to be closed
+func (s *Subscription) Close() error {
+	s.once.Do(func() { close(s.done) })
+	for range s.events {
+	}
+	return nil
+}
+
+// Change log methods
+
+// changeKey is the mutation ID of a change event
+func changeKey(seq int64) string {
+	return strconv.FormatInt(seq, 10)
+}
+
+// lastChangeSeq returns the Seq of the newest event. The caller must hold
+// r.mu.
+func (r *InMemoryRepository) lastChangeSeq() int64 {
+	if n := len(r.changes); n > 0 {
+		return r.changes[n-1].Seq
+	}
+	return 0
+}
+
+// withChanges returns muts followed by the change events that record them.
+// The caller must hold r.mu for writing.
+func (r *InMemoryRepository) withChanges(muts []mutation) ([]mutation, error) {
+	seq := r.lastChangeSeq()
+	all := make([]mutation, 0, 2*len(muts))
+	all = append(all, muts...)
+	for _, m := range muts {
+		event, err := newChangeEvent(m.Kind, m.ID, m.Before, m.After)
+		if err != nil {
+			return nil, err
+		}
+		seq++
+		event.Seq = seq
+		all = append(all, mutation{Kind: kindChange, ID: changeKey(seq), After: event})
+	}
+	return all, nil
+}
+
+// setChange appends event v to the change log, or removes the event with
+// Seq id when v is nil. Only the newest events are ever removed, by undo.
+// The log's backing array may be shared with snapshots, so elements already
+// in it are never overwritten.
+func (r *InMemoryRepository) setChange(id string, v any) {
+	seq, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		panic("repository: bad change event key " + id)
+	}
+	i := sort.Search(len(r.changes), func(i int) bool { return r.changes[i].Seq >= seq })
+	present := i < len(r.changes) && r.changes[i].Seq == seq
+	if present {
+		old := r.changes[i]
+		r.changesByEntity.remove(old.Entity, id)
+		r.changesByID.remove(entityKey(old.Entity, old.ID), id)
+	}
+
+	event, _ := v.(*models.ChangeEvent)
+	switch {
+	case event == nil && present:
+		r.changes = slices.Concat(r.changes[:i], r.changes[i+1:])
+	case event == nil:
+	case i == len(r.changes):
+		r.changes = append(r.changes, cloneChange(event))
+	default:
+		tail := r.changes[i:]
+		if present {
+			tail = tail[1:]
+		}
+		r.changes = slices.Concat(r.changes[:i], []*models.ChangeEvent{cloneChange(event)}, tail)
+	}
+	if event != nil {
+		r.changesByEntity.add(event.Entity, id)
+		r.changesByID.add(entityKey(event.Entity, event.ID), id)
+	}
+}
+
+// entityKey is the changesByID index key of an entity
+func entityKey(kind, id string) string {
+	return kind + "/" + id
+}
+
+// Changes returns up to limit change events with Seq greater than after,
+// oldest first. A limit of zero or less means no limit.
+func (r *InMemoryRepository) Changes(after int64, limit int) ([]*models.ChangeEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := sort.Search(len(r.changes), func(i int) bool { return r.changes[i].Seq > after })
+	end := len(r.changes)
+	if limit > 0 && i+limit < end {
+		end = i + limit
+	}
+	events := make([]*models.ChangeEvent, 0, end-i)
+	for _, event := range r.changes[i:end] {
+		events = append(events, cloneChange(event))
+	}
+	return events, nil
+}
+
+// FindChanges returns the change events matching filter, oldest first
+func (r *InMemoryRepository) FindChanges(filter ChangeFilter) ([]*models.ChangeEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	byID := ""
+	if filter.Entity != "" && filter.ID != "" {
+		byID = entityKey(filter.Entity, filter.ID)
+	}
+	keys, indexed := narrowest(
+		indexLookup{r.changesByID, byID},
+		indexLookup{r.changesByEntity, filter.Entity},
+	)
+
+	matching := make([]*models.ChangeEvent, 0, len(keys))
+	if !indexed {
+		for _, event := range r.changes {
+			if filter.matches(event) {
+				matching = append(matching, event)
+			}
+		}
+	} else {
+		for key := range keys {
+			seq, _ := strconv.ParseInt(key, 10, 64)
+			i := sort.Search(len(r.changes), func(i int) bool { return r.changes[i].Seq >= seq })
+			if event := r.changes[i]; filter.matches(event) {
+				matching = append(matching, event)
+			}
+		}
+		sort.Slice(matching, func(i, j int) bool { return matching[i].Seq < matching[j].Seq })
+	}
+
+	kept := filter.keep(matching)
+	events := make([]*models.ChangeEvent, 0, len(kept))
+	for _, event := range kept {
+		events = append(events, cloneChange(event))
+	}
+	return events, nil
+}
+
+// compactable returns the events of log, which is in Seq order, that
+// CompactChanges removes for the cutoff before. The newest event of the
+// whole log is always kept, because the next Seq follows it.
+func compactable(log []*models.ChangeEvent, before time.Time) []*models.ChangeEvent {
+	newest := make(map[string]bool)
+	var removed []*models.ChangeEvent
+	for i := len(log) - 1; i >= 0; i-- {
+		event := log[i]
+		if !event.CommittedAt.Before(before) {
+			continue
+		}
+		key := entityKey(event.Entity, event.ID)
+		if i < len(log)-1 && (newest[key] || event.Op == models.ChangeDelete) {
+			removed = append(removed, event)
+		}
+		newest[key] = true
+	}
+	slices.Reverse(removed)
+	return removed
+}
+
+// CompactChanges removes the events before the cutoff that are not needed
+// to reconstruct states at or after it, as described for ChangeFeed
+func (r *InMemoryRepository) CompactChanges(before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.compactChanges(before), nil
+}
+
+// compactChanges removes the events CompactChanges does and returns how
+// many it removed. The caller must hold r.mu for writing.
+func (r *InMemoryRepository) compactChanges(before time.Time) int {
+	removed := compactable(r.changes, before)
+	if len(removed) == 0 {
+		return 0
+	}
+	drop := make(map[int64]bool, len(removed))
+	for _, event := range removed {
+		drop[event.Seq] = true
+		id := changeKey(event.Seq)
+		r.changesByEntity.remove(event.Entity, id)
+		r.changesByID.remove(entityKey(event.Entity, event.ID), id)
+	}
+	// A new slice, since the old backing array may be shared
+	changes := make([]*models.ChangeEvent, 0, len(r.changes)-len(removed))
+	for _, event := range r.changes {
+		if !drop[event.Seq] {
+			changes = append(changes, event)
+		}
+	}
+	r.changes = changes
+	return len(removed)
+}
+
+// Subscribe delivers the change events after seq after, then each event as
+// it is committed
+func (r *InMemoryRepository) Subscribe(after int64) (*Subscription, error) {
+	return subscribe(r.Changes, r.changed, after), nil
+}
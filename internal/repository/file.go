@@ -0,0 +1,561 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+)
+
+const (
+	walFileName       = "wal.log"
+	snapshotFileName  = "snapshot.json"
+	changeLogFileName = "changes.log"
+
+	// DefaultSnapshotEvery is the number of log records written between
+	// automatic snapshots when FileOptions.SnapshotEvery is zero
+	DefaultSnapshotEvery = 1000
+
+	// walHeaderSize is the size of the length and checksum that precede
+	// every log record
+	walHeaderSize = 8
+
+	// maxRecordSize bounds the payload of a log record. A torn record is
+	// only recognised by a length running past the end of the log, so a
+	// longer length is always damage rather than a crash mid-write.
+	maxRecordSize = 1 << 30
+)
+
+var (
+	ErrCorruptLog = errors.New("write-ahead log is corrupt")
+	ErrClosed     = errors.New("repository is closed")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// FileOptions configures a FileRepository
+type FileOptions struct {
+	// SnapshotEvery is the number of log records after which the log is
+	// compacted into a snapshot. Zero means DefaultSnapshotEvery.
+	SnapshotEvery int
+}
+
+// FileRepository is a durable repository. Entities are served from memory,
+// every mutation is appended to an fsync'd write-ahead log before it is
+// acknowledged, and the log is periodically compacted into a snapshot.
+// Opening the repository loads the latest snapshot and replays the log on
+// top of it. Snapshots hold entity state only; change events go to a
+// separate change log that each snapshot appends the new ones to, so a
+// snapshot's size does not grow with the length of the history.
+type FileRepository struct {
+	*InMemoryRepository
+
+	dir           string
+	wal           *os.File
+	walSize       int64
+	seq           uint64 // sequence number of the last logged record
+	sinceSnapshot int    // records logged since the last snapshot
+	snapshotEvery int
+	changeLog     *os.File
+	changeLogSize int64
+	savedChange   int64 // Seq of the newest event in the change log
+	err           error // sticky error; set once the log can't be trusted
+}
+
+var _ Store = (*FileRepository)(nil)
+
+// walRecord is one atomic batch of mutations in the log
+type walRecord struct {
+	Seq     uint64     `json:"seq"`
+	Entries []walEntry `json:"entries"`
+}
+
+// walEntry is the state of one entity after a mutation. Data is null when
+// the entity was deleted.
+type walEntry struct {
+	Kind string          `json:"kind"`
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// snapshotState is the on-disk form of a snapshot
+type snapshotState struct {
+	Seq      uint64     `json:"seq"`
+	Entities []walEntry `json:"entities"`
+}
+
+// OpenFileRepository opens or creates a file-backed repository in dir.
+// A torn record at the end of the log or the change log, as left by a crash
+// mid-write, is discarded; damage anywhere else returns ErrCorruptLog.
+func OpenFileRepository(dir string, opts FileOptions) (*FileRepository, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f := &FileRepository{
+		InMemoryRepository: NewInMemoryRepository(),
+		dir:                dir,
+		snapshotEvery:      opts.SnapshotEvery,
+	}
+	if f.snapshotEvery <= 0 {
+		f.snapshotEvery = DefaultSnapshotEvery
+	}
+
+	if err := f.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	changeLog, err := os.OpenFile(filepath.Join(dir, changeLogFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f.changeLog = changeLog
+	if err := f.loadChanges(); err != nil {
+		changeLog.Close()
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		changeLog.Close()
+		return nil, err
+	}
+	f.wal = wal
+	if err := f.replay(); err != nil {
+		wal.Close()
+		changeLog.Close()
+		return nil, err
+	}
+
+	f.journal = f.append
+	return f, nil
+}
+
+// Compact writes a snapshot of the current state and truncates the log
+func (f *FileRepository) Compact() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+	return f.snapshot()
+}
+
+// CompactChanges removes the events before the cutoff that are not needed
+// to reconstruct states at or after it, as described for ChangeFeed. The
+// change log is rewritten without them and a snapshot taken, so that
+// neither log brings them back on the next open.
+func (f *FileRepository) CompactChanges(before time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return 0, f.err
+	}
+	n := f.compactChanges(before)
+	if n == 0 {
+		return 0, nil
+	}
+	if err := f.rewriteChanges(); err != nil {
+		return n, err
+	}
+	return n, f.snapshot()
+}
+
+// Close closes the logs. Further mutations fail with ErrClosed.
+func (f *FileRepository) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err == ErrClosed {
+		return nil
+	}
+	f.err = ErrClosed
+	if err := f.wal.Close(); err != nil {
+		f.changeLog.Close()
+		return err
+	}
+	return f.changeLog.Close()
+}
+
+func (f *FileRepository) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(f.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state snapshotState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	if err := f.apply(state.Entities); err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	f.seq = state.Seq
+	return nil
+}
+
+// loadChanges reads the change log. Events in an older snapshot were
+// already loaded with it and are saved to the change log by the next
+// snapshot.
+func (f *FileRepository) loadChanges() error {
+	size, err := readLog(f.changeLog, func(payload []byte) error {
+		var event models.ChangeEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return err
+		}
+		f.set(kindChange, changeKey(event.Seq), &event)
+		f.savedChange = event.Seq
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	f.changeLogSize = size
+	return nil
+}
+
+// replay applies every log record newer than the snapshot and leaves the
+// file positioned for appending
+func (f *FileRepository) replay() error {
+	size, err := readLog(f.wal, func(payload []byte) error {
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return err
+		}
+		if rec.Seq > f.seq {
+			if err := f.apply(rec.Entries); err != nil {
+				return err
+			}
+			f.seq = rec.Seq
+			f.sinceSnapshot++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	f.walSize = size
+	return nil
+}
+
+// readLog calls fn with the payload of each record in file, oldest first,
+// and returns the offset after the last one, leaving the file positioned
+// there. A torn final record, as left by a crash mid-write, is truncated;
+// any other damage, or an error from fn, returns ErrCorruptLog.
+func readLog(file *os.File, fn func(payload []byte) error) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(file)
+	var offset int64
+	for offset < size {
+		payload, err := readFrame(r, size-offset)
+		if err != nil {
+			// Only the last record can be torn, and only by being cut short
+			if offset+walHeaderSize+int64(len(payload)) < size && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return 0, fmt.Errorf("%w at offset %d: %v", ErrCorruptLog, offset, err)
+			}
+			// A torn final record: drop it
+			if err := file.Truncate(offset); err != nil {
+				return 0, err
+			}
+			break
+		}
+		if err := fn(payload); err != nil {
+			return 0, fmt.Errorf("%w at offset %d: %v", ErrCorruptLog, offset, err)
+		}
+		offset += walHeaderSize + int64(len(payload))
+	}
+
+	_, err = file.Seek(offset, io.SeekStart)
+	return offset, err
+}
+
+// readFrame reads one length-prefixed, checksummed record from at most
+// remaining bytes. It fails with io.ErrUnexpectedEOF if the record runs
+// past them. On a checksum mismatch the payload is returned along with the
+// error so the caller can tell how far the damage extends.
+func readFrame(r io.Reader, remaining int64) ([]byte, error) {
+	var header [walHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+	if length > maxRecordSize {
+		return nil, fmt.Errorf("record length %d exceeds the limit", length)
+	}
+	if int64(length) > remaining-walHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != sum {
+		return payload, errors.New("checksum mismatch")
+	}
+	return payload, nil
+}
+
+// appendFrame appends payload to buf as a length-prefixed, checksummed
+// record
+func appendFrame(buf, payload []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(payload, crcTable))
+	return append(buf, payload...)
+}
+
+// apply loads logged entity states straight into the maps, bypassing the
+// journal and preserving logged timestamps. States logged in an older
+// encoding are upgraded as described for upgradeImage.
+func (f *FileRepository) apply(entries []walEntry) error {
+	for _, e := range entries {
+		if len(e.Data) == 0 || string(e.Data) == "null" {
+			f.set(e.Kind, e.ID, nil)
+			continue
+		}
+		v, ok := newEntity(e.Kind)
+		if !ok {
+			return fmt.Errorf("unknown entity kind %q", e.Kind)
+		}
+		data, err := upgradeImage(e.Kind, e.Data)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+		f.set(e.Kind, e.ID, v)
+	}
+	return nil
+}
+
+// append is the journal hook. It logs muts as a single record and fsyncs
+// before returning. It runs with f.mu held.
+func (f *FileRepository) append(muts []mutation) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	rec := walRecord{Seq: f.seq + 1, Entries: make([]walEntry, len(muts))}
+	for i, m := range muts {
+		data, err := json.Marshal(m.After)
+		if err != nil {
+			return err
+		}
+		rec.Entries[i] = walEntry{Kind: m.Kind, ID: m.ID, Data: data}
+	}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("log record of %d bytes exceeds the limit", len(payload))
+	}
+
+	frame := appendFrame(nil, payload)
+	if _, err := f.wal.Write(frame); err != nil {
+		f.rewind(f.wal, f.walSize)
+		return err
+	}
+	if err := f.wal.Sync(); err != nil {
+		f.rewind(f.wal, f.walSize)
+		return err
+	}
+
+	f.walSize += int64(len(frame))
+	f.seq = rec.Seq
+	f.sinceSnapshot++
+
+	if f.sinceSnapshot >= f.snapshotEvery {
+		// The record is already durable, so a failed snapshot is not
+		// reported to the caller; it is retried after the next write.
+		f.snapshot()
+	}
+	return nil
+}
+
+// rewind discards records partially written to file after its first size
+// bytes. If that fails the file can no longer be appended to safely and the
+// repository refuses further writes.
+func (f *FileRepository) rewind(file *os.File, size int64) {
+	if err := file.Truncate(size); err != nil {
+		f.err = fmt.Errorf("%w: %v", ErrCorruptLog, err)
+		return
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		f.err = fmt.Errorf("%w: %v", ErrCorruptLog, err)
+	}
+}
+
+// saveChanges appends the change events not yet in the change log to it and
+// fsyncs it. It runs with f.mu held.
+func (f *FileRepository) saveChanges() error {
+	i := sort.Search(len(f.changes), func(i int) bool { return f.changes[i].Seq > f.savedChange })
+	if i == len(f.changes) {
+		return nil
+	}
+	var frames []byte
+	for _, event := range f.changes[i:] {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		frames = appendFrame(frames, payload)
+	}
+
+	if _, err := f.changeLog.Write(frames); err != nil {
+		f.rewind(f.changeLog, f.changeLogSize)
+		return err
+	}
+	if err := f.changeLog.Sync(); err != nil {
+		f.rewind(f.changeLog, f.changeLogSize)
+		return err
+	}
+	f.changeLogSize += int64(len(frames))
+	f.savedChange = f.changes[len(f.changes)-1].Seq
+	return nil
+}
+
+// rewriteChanges atomically replaces the change log with every event in
+// memory. A crash before the snapshot that follows only brings back events
+// still in the write-ahead log. It runs with f.mu held.
+func (f *FileRepository) rewriteChanges() error {
+	var frames []byte
+	for _, event := range f.changes {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		frames = appendFrame(frames, payload)
+	}
+	name := filepath.Join(f.dir, changeLogFileName)
+	if err := writeFileSync(name, frames); err != nil {
+		return err
+	}
+
+	changeLog, err := os.OpenFile(name, os.O_RDWR, 0o644)
+	if err != nil {
+		f.err = fmt.Errorf("%w: %v", ErrCorruptLog, err)
+		return err
+	}
+	if _, err := changeLog.Seek(0, io.SeekEnd); err != nil {
+		changeLog.Close()
+		f.err = fmt.Errorf("%w: %v", ErrCorruptLog, err)
+		return err
+	}
+	f.changeLog.Close()
+	f.changeLog = changeLog
+	f.changeLogSize = int64(len(frames))
+	if n := len(f.changes); n > 0 {
+		f.savedChange = f.changes[n-1].Seq
+	}
+	return nil
+}
+
+// snapshot saves new change events to the change log, atomically replaces
+// the snapshot file with the current entity state and then truncates the
+// log. A crash between the steps is harmless because replay skips records
+// already covered by the snapshot, and events it replays replace their
+// copies from the change log. It runs with f.mu held.
+func (f *FileRepository) snapshot() error {
+	if err := f.saveChanges(); err != nil {
+		return err
+	}
+
+	state := snapshotState{Seq: f.seq}
+	var encodeErr error
+	f.each(func(kind, id string, v any) {
+		if kind == kindChange {
+			return
+		}
+		data, err := json.Marshal(v)
+		if err != nil && encodeErr == nil {
+			encodeErr = err
+		}
+		state.Entities = append(state.Entities, walEntry{Kind: kind, ID: id, Data: data})
+	})
+	if encodeErr != nil {
+		return encodeErr
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileSync(filepath.Join(f.dir, snapshotFileName), data); err != nil {
+		return err
+	}
+
+	if err := f.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := f.wal.Sync(); err != nil {
+		return err
+	}
+	f.walSize = 0
+	f.sinceSnapshot = 0
+	return nil
+}
+
+// writeFileSync durably replaces name with data via a temporary file and
+// rename
+func writeFileSync(name string, data []byte) error {
+	tmp := name + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
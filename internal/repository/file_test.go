@@ -0,0 +1,386 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+)
+
+func openTestFileRepository(t *testing.T, dir string, snapshotEvery int) *FileRepository {
+	t.Helper()
+
+	repo, err := OpenFileRepository(dir, FileOptions{SnapshotEvery: snapshotEvery})
+	if err != nil {
+		t.Fatalf("Failed to open file repository: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func seedFileRepository(t *testing.T, repo *FileRepository) {
+	t.Helper()
+
+	if err := repo.CreateVendor(&models.Vendor{ID: "v1", Name: "Garden Supplies Co"}); err != nil {
+		t.Fatalf("Failed to create vendor: %v", err)
+	}
+	if err := repo.CreateProduct(&models.Product{ID: "p1", Name: "Fertilizer", VendorID: "v1"}); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	if err := repo.CreateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 100}); err != nil {
+		t.Fatalf("Failed to create inventory item: %v", err)
+	}
+	shipment := &models.StockMovement{ItemID: "i1", Type: models.MovementShipment, Delta: -25, Reason: "sale", Actor: "alice"}
+	if err := repo.PostMovement(shipment, 0); err != nil {
+		t.Fatalf("Failed to post movement: %v", err)
+	}
+}
+
+func TestFileRepositoryReplaysLog(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestFileRepository(t, dir, 1000)
+	seedFileRepository(t, repo)
+	created, _ := repo.GetVendor("v1")
+	repo.Close()
+
+	reopened := openTestFileRepository(t, dir, 1000)
+
+	item, err := reopened.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Quantity != 75 {
+		t.Errorf("Expected quantity 75, got %v", item.Quantity)
+	}
+
+	vendor, err := reopened.GetVendor("v1")
+	if err != nil {
+		t.Fatalf("Failed to get vendor: %v", err)
+	}
+	if !vendor.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("Expected CreatedAt %v, got %v", created.CreatedAt, vendor.CreatedAt)
+	}
+}
+
+func TestFileRepositoryRecoversFromSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestFileRepository(t, dir, 2)
+	seedFileRepository(t, repo)
+	if err := repo.CreateSeller(&models.Seller{ID: "s1", Name: "John Doe"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	repo.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Fatalf("Expected a snapshot to be written: %v", err)
+	}
+
+	reopened := openTestFileRepository(t, dir, 2)
+
+	item, err := reopened.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Quantity != 75 {
+		t.Errorf("Expected quantity 75, got %v", item.Quantity)
+	}
+	if _, err := reopened.GetSeller("s1"); err != nil {
+		t.Errorf("Failed to get seller logged after snapshot: %v", err)
+	}
+
+	// Movement IDs continue from the restored ledger
+	receipt := &models.StockMovement{ItemID: "i1", Type: models.MovementReceipt, Delta: 5, Reason: "purchase", Actor: "bob"}
+	if err := reopened.PostMovement(receipt, 0); err != nil {
+		t.Fatalf("Failed to post movement: %v", err)
+	}
+	if receipt.ID != 3 {
+		t.Errorf("Expected movement ID 3 after the opening balance and shipment, got %d", receipt.ID)
+	}
+}
+
+func TestFileRepositoryDiscardsTornRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestFileRepository(t, dir, 1000)
+	seedFileRepository(t, repo)
+	repo.Close()
+
+	// Simulate a crash part way through appending a record
+	walPath := filepath.Join(dir, walFileName)
+	info, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatalf("Failed to stat log: %v", err)
+	}
+	wal, err := os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	wal.Write([]byte{0, 0, 1, 0, 0xde, 0xad, '{', '"', 's'})
+	wal.Close()
+
+	reopened := openTestFileRepository(t, dir, 1000)
+
+	item, err := reopened.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Quantity != 75 {
+		t.Errorf("Expected quantity 75, got %v", item.Quantity)
+	}
+
+	after, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatalf("Failed to stat log: %v", err)
+	}
+	if after.Size() != info.Size() {
+		t.Errorf("Expected torn record to be truncated to %d bytes, got %d", info.Size(), after.Size())
+	}
+
+	// New writes must land after the last good record and survive a reopen
+	if err := reopened.CreateSeller(&models.Seller{ID: "s1", Name: "John Doe"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	reopened.Close()
+
+	again := openTestFileRepository(t, dir, 1000)
+	if _, err := again.GetSeller("s1"); err != nil {
+		t.Errorf("Failed to get seller: %v", err)
+	}
+}
+
+func TestFileRepositoryRejectsCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestFileRepository(t, dir, 1000)
+	seedFileRepository(t, repo)
+	repo.Close()
+
+	// Flip a byte inside the first record; later records are intact
+	walPath := filepath.Join(dir, walFileName)
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	data[walHeaderSize+2] ^= 0xff
+	if err := os.WriteFile(walPath, data, 0o644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	if _, err := OpenFileRepository(dir, FileOptions{}); !errors.Is(err, ErrCorruptLog) {
+		t.Fatalf("Expected ErrCorruptLog, got %v", err)
+	}
+}
+
+func TestFileRepositoryRejectsCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestFileRepository(t, dir, 1000)
+	seedFileRepository(t, repo)
+	repo.Close()
+
+	// A length in the first record that runs past the end of the log looks
+	// like a torn record, but no record is ever that long
+	walPath := filepath.Join(dir, walFileName)
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	data[0] = 0x7f
+	if err := os.WriteFile(walPath, data, 0o644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	if _, err := OpenFileRepository(dir, FileOptions{}); !errors.Is(err, ErrCorruptLog) {
+		t.Fatalf("Expected ErrCorruptLog, got %v", err)
+	}
+	if info, err := os.Stat(walPath); err != nil || info.Size() != int64(len(data)) {
+		t.Errorf("Expected the corrupt log to be left as it was, got %v and %v", info, err)
+	}
+}
+
+func TestFileRepositoryLogsUnitOfWorkAsOneRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestFileRepository(t, dir, 1000)
+	seedFileRepository(t, repo)
+	seq := repo.seq
+
+	tx, err := repo.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	if err := tx.CreateProduct(&models.Product{ID: "p2", Name: "Mulch", VendorID: "v1"}); err != nil {
+		t.Fatalf("Failed to stage product: %v", err)
+	}
+	if err := tx.CreateInventoryItem(&models.InventoryItem{ID: "i2", ProductID: "p2", Quantity: 30}); err != nil {
+		t.Fatalf("Failed to stage inventory item: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if repo.seq != seq+1 {
+		t.Errorf("Expected the unit of work to be logged as one record, got %d records", repo.seq-seq)
+	}
+
+	// A rolled back unit of work is not logged
+	tx, err = repo.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	if err := tx.CreateSeller(&models.Seller{ID: "s1"}); err != nil {
+		t.Fatalf("Failed to stage seller: %v", err)
+	}
+	tx.Rollback()
+	repo.Close()
+
+	reopened := openTestFileRepository(t, dir, 1000)
+	item, err := reopened.GetInventoryItem("i2")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Quantity != 30 {
+		t.Errorf("Expected quantity 30, got %v", item.Quantity)
+	}
+	if _, err := reopened.GetSeller("s1"); err != ErrNotFound {
+		t.Errorf("Expected rolled back seller to be absent, got %v", err)
+	}
+}
+
+func TestFileRepositoryKeepsChangeLog(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestFileRepository(t, dir, 3)
+	seedFileRepository(t, repo)
+	events, err := repo.Changes(0, 0)
+	if err != nil {
+		t.Fatalf("Failed to read changes: %v", err)
+	}
+	if len(events) == 0 {
+		t.Fatal("Expected change events from seeding")
+	}
+	repo.Close()
+
+	// Reopening, after the log has been compacted into a snapshot, restores
+	// the change log and continues its sequence
+	reopened := openTestFileRepository(t, dir, 3)
+	restored, err := reopened.Changes(0, 0)
+	if err != nil {
+		t.Fatalf("Failed to read changes: %v", err)
+	}
+	if len(restored) != len(events) {
+		t.Fatalf("Expected %d events after reopening, got %d", len(events), len(restored))
+	}
+	for i := range events {
+		if restored[i].Seq != events[i].Seq || restored[i].ID != events[i].ID || restored[i].Op != events[i].Op {
+			t.Errorf("Expected event %+v, got %+v", events[i], restored[i])
+		}
+	}
+
+	// The snapshot holds entity state only; the events are in the change log
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFileName))
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	var state snapshotState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("Failed to decode snapshot: %v", err)
+	}
+	for _, e := range state.Entities {
+		if e.Kind == kindChange {
+			t.Fatalf("Expected no change events in the snapshot, got %s", e.Data)
+		}
+	}
+	if reopened.savedChange == 0 {
+		t.Error("Expected the snapshot to save events to the change log")
+	}
+
+	if err := reopened.CreateSeller(&models.Seller{ID: "s9"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	next, err := reopened.Changes(events[len(events)-1].Seq, 0)
+	if err != nil {
+		t.Fatalf("Failed to read changes: %v", err)
+	}
+	if len(next) != 1 || next[0].Seq != events[len(events)-1].Seq+1 || next[0].ID != "s9" {
+		t.Errorf("Expected one event for s9 continuing the sequence, got %+v", next)
+	}
+}
+
+func TestFileRepositoryCompactsChangeLog(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestFileRepository(t, dir, 1000)
+	seedFileRepository(t, repo)
+	for _, name := range []string{"Mulch", "Compost"} {
+		product, _ := repo.GetProduct("p1")
+		product.Name = name
+		if err := repo.UpdateProduct(product); err != nil {
+			t.Fatalf("Failed to update product: %v", err)
+		}
+	}
+	removed, err := repo.CompactChanges(time.Now())
+	if err != nil {
+		t.Fatalf("Failed to compact changes: %v", err)
+	}
+	if removed == 0 {
+		t.Fatal("Expected superseded events to be removed")
+	}
+	events, err := repo.Changes(0, 0)
+	if err != nil {
+		t.Fatalf("Failed to read changes: %v", err)
+	}
+	repo.Close()
+
+	// Neither the change log nor the write-ahead log brings the removed
+	// events back
+	reopened := openTestFileRepository(t, dir, 1000)
+	restored, err := reopened.Changes(0, 0)
+	if err != nil {
+		t.Fatalf("Failed to read changes: %v", err)
+	}
+	if len(restored) != len(events) {
+		t.Fatalf("Expected %d events after reopening, got %d", len(events), len(restored))
+	}
+	for i := range events {
+		if restored[i].Seq != events[i].Seq {
+			t.Errorf("Expected event %d to have seq %d, got %d", i, events[i].Seq, restored[i].Seq)
+		}
+	}
+
+	if err := reopened.CreateSeller(&models.Seller{ID: "s9"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	next, err := reopened.Changes(events[len(events)-1].Seq, 0)
+	if err != nil {
+		t.Fatalf("Failed to read changes: %v", err)
+	}
+	if len(next) != 1 || next[0].Seq != events[len(events)-1].Seq+1 {
+		t.Errorf("Expected one event continuing the sequence, got %+v", next)
+	}
+}
+
+func TestFileRepositoryUpgradesLegacyPrices(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := `{"seq": 2, "entities": [
+		{"kind": "vendor", "id": "v1", "data": {"id": "v1", "name": "Garden Supplies Co", "version": 1}},
+		{"kind": "product", "id": "p1", "data": {"id": "p1", "name": "Fertilizer", "price": 24.99, "vendor_id": "v1", "version": 1}}
+	]}`
+	if err := os.WriteFile(filepath.Join(dir, snapshotFileName), []byte(snapshot), 0o644); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	repo := openTestFileRepository(t, dir, 1000)
+	product, err := repo.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if product.Price != usd(2499) {
+		t.Errorf("Expected the float price to become 24.99 USD, got %v", product.Price)
+	}
+}
@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+)
+
+// HistoryReader reads entities and the change log, from which past states
+// of entities are reconstructed
+type HistoryReader interface {
+	Reader
+	FindChanges(filter ChangeFilter) ([]*models.ChangeEvent, error)
+}
+
+// Revision is an entity as it was after one change. Data is nil after a
+// delete. An entity that predates the change log starts with a revision
+// that has no Seq or Op and is dated by the entity's own timestamp.
+type Revision[T any] struct {
+	Seq         int64           `json:"seq,omitempty"`
+	Op          models.ChangeOp `json:"op,omitempty"`
+	CommittedAt time.Time       `json:"committed_at"`
+	Data        *T              `json:"data"`
+}
+
+// historyKind describes how to reconstruct the history of one entity type
+type historyKind[T any] struct {
+	kind string
+	id   func(*T) string
+
+	// since returns the time from which an entity that has not changed
+	// since has been as it is
+	since func(*T) time.Time
+}
+
+var (
+	productHistory = historyKind[models.Product]{
+		kind:  kindProduct,
+		id:    func(p *models.Product) string { return p.ID },
+		since: func(p *models.Product) time.Time { return p.CreatedAt },
+	}
+	inventoryItemHistory = historyKind[models.InventoryItem]{
+		kind:  kindInventoryItem,
+		id:    func(i *models.InventoryItem) string { return i.ID },
+		since: func(i *models.InventoryItem) time.Time { return i.UpdatedAt },
+	}
+	serialHistory = historyKind[models.Serial]{
+		kind:  kindSerial,
+		id:    func(s *models.Serial) string { return s.ID },
+		since: func(s *models.Serial) time.Time { return s.UpdatedAt },
+	}
+)
+
+// decodeImage decodes a change event image of an entity of the given kind,
+// which is nil for a deleted entity
+func decodeImage[T any](kind string, image json.RawMessage) (*T, error) {
+	if image == nil {
+		return nil, nil
+	}
+	image, err := upgradeImage(kind, image)
+	if err != nil {
+		return nil, err
+	}
+	var v T
+	if err := json.Unmarshal(image, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// upgradeImage rewrites the stored JSON of an entity of the given kind
+// that predates a change to how the kind is encoded. A product stored
+// before prices were exact has a price that is a bare number, which
+// becomes money in models.DefaultCurrency.
+func upgradeImage(kind string, image json.RawMessage) (json.RawMessage, error) {
+	if kind != kindProduct {
+		return image, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(image, &fields); err != nil {
+		return nil, err
+	}
+	var price float64
+	if err := json.Unmarshal(fields["price"], &price); err != nil {
+		return image, nil
+	}
+	money, err := models.MoneyFromFloat(price, models.DefaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+	if fields["price"], err = json.Marshal(money); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// revisions returns the revisions of one entity from its change events,
+// oldest first. current is its state now, or nil, and must have been read
+// before the events so that no change falls between the two.
+func (k historyKind[T]) revisions(events []*models.ChangeEvent, current *T) ([]Revision[T], error) {
+	revs := make([]Revision[T], 0, len(events)+1)
+	if len(events) == 0 {
+		if current != nil {
+			revs = append(revs, Revision[T]{CommittedAt: k.since(current), Data: current})
+		}
+		return revs, nil
+	}
+
+	if first := events[0]; first.Op != models.ChangeCreate {
+		before, err := decodeImage[T](k.kind, first.Before)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, Revision[T]{CommittedAt: k.since(before), Data: before})
+	}
+	for _, event := range events {
+		after, err := decodeImage[T](k.kind, event.After)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, Revision[T]{Seq: event.Seq, Op: event.Op, CommittedAt: event.CommittedAt, Data: after})
+	}
+	return revs, nil
+}
+
+// history returns the revisions of entity id, or ErrNotFound if it has
+// never existed
+func (k historyKind[T]) history(store HistoryReader, id string, get func(string) (*T, error)) ([]Revision[T], error) {
+	current, err := get(id)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	events, err := store.FindChanges(ChangeFilter{Entity: k.kind, ID: id})
+	if err != nil {
+		return nil, err
+	}
+	revs, err := k.revisions(events, current)
+	if err != nil {
+		return nil, err
+	}
+	if len(revs) == 0 {
+		return nil, ErrNotFound
+	}
+	return revs, nil
+}
+
+// asOf returns every entity of the kind that existed at t and satisfies
+// match, ordered by ID. The store finds the last change of each entity up
+// to t, whose image is its state then, and the first change after t of
+// the entities with none, whose before image is their state then unless
+// they were created after t. An entity with no changes at all predates the
+// change log and is as it is now. At most one image per entity is decoded.
+func (k historyKind[T]) asOf(store HistoryReader, t time.Time, list func() ([]*T, error), match func(*T) bool) ([]*T, error) {
+	current, err := list()
+	if err != nil {
+		return nil, err
+	}
+	last, err := store.FindChanges(ChangeFilter{Entity: k.kind, Until: t, Keep: KeepLast})
+	if err != nil {
+		return nil, err
+	}
+	next, err := store.FindChanges(ChangeFilter{Entity: k.kind, After: t, Keep: KeepFirst})
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]*T)
+	for _, v := range current {
+		if !k.since(v).After(t) {
+			states[k.id(v)] = v
+		}
+	}
+	for _, event := range next {
+		state, err := decodeImage[T](k.kind, event.Before)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil && k.since(state).After(t) {
+			state = nil
+		}
+		states[event.ID] = state
+	}
+	for _, event := range last {
+		state, err := decodeImage[T](k.kind, event.After)
+		if err != nil {
+			return nil, err
+		}
+		states[event.ID] = state
+	}
+
+	result := make([]*T, 0)
+	for _, state := range states {
+		if state != nil && match(state) {
+			result = append(result, state)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return k.id(result[i]) < k.id(result[j]) })
+	return result, nil
+}
+
+// ProductHistory returns every revision of a product, oldest first, or
+// ErrNotFound if it has never existed
+func ProductHistory(store HistoryReader, id string) ([]Revision[models.Product], error) {
+	return productHistory.history(store, id, store.GetProduct)
+}
+
+// InventoryItemHistory returns every revision of an inventory item, oldest
+// first, or ErrNotFound if it has never existed
+func InventoryItemHistory(store HistoryReader, id string) ([]Revision[models.InventoryItem], error) {
+	return inventoryItemHistory.history(store, id, store.GetInventoryItem)
+}
+
+// SerialHistory returns every revision of a serial, oldest first, or
+// ErrNotFound if it has never existed
+func SerialHistory(store HistoryReader, id string) ([]Revision[models.Serial], error) {
+	return serialHistory.history(store, id, store.GetSerial)
+}
+
+// ProductsAsOf returns the products matching filter as they were at t,
+// ordered by ID
+func ProductsAsOf(store HistoryReader, t time.Time, filter ProductFilter) ([]*models.Product, error) {
+	return productHistory.asOf(store, t, store.ListProducts, filter.matches)
+}
+
+// InventoryItemsAsOf returns the inventory items matching filter as they
+// were at t, ordered by ID
+func InventoryItemsAsOf(store HistoryReader, t time.Time, filter InventoryFilter) ([]*models.InventoryItem, error) {
+	return inventoryItemHistory.asOf(store, t, store.ListInventoryItems, filter.matches)
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+)
+
+func TestHistoryOfEntityPredatingChangeLog(t *testing.T) {
+	created := time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC)
+	updated := time.Date(2026, 3, 15, 9, 0, 0, 0, time.UTC)
+
+	v1 := &models.Product{ID: "p1", Price: usd(2999), VendorID: "v1", CreatedAt: created, Version: 1}
+	v2 := *v1
+	v2.Price = usd(3499)
+	v2.Version = 2
+	event, err := newChangeEvent(kindProduct, "p1", v1, &v2)
+	if err != nil {
+		t.Fatalf("Failed to build change event: %v", err)
+	}
+	event.Seq = 7
+	event.CommittedAt = updated
+
+	revs, err := productHistory.revisions([]*models.ChangeEvent{event}, &v2)
+	if err != nil {
+		t.Fatalf("Failed to build revisions: %v", err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("Expected 2 revisions, got %d", len(revs))
+	}
+	if revs[0].Seq != 0 || revs[0].Op != "" || !revs[0].CommittedAt.Equal(created) {
+		t.Errorf("Expected an initial revision dated by CreatedAt, got %+v", revs[0])
+	}
+
+	tests := []struct {
+		at   time.Time
+		want models.Money // zero when the product did not exist
+	}{
+		{created.Add(-time.Hour), models.Money{}},
+		{created, usd(2999)},
+		{updated.Add(-time.Second), usd(2999)},
+		{updated, usd(3499)},
+	}
+	repo := NewInMemoryRepository()
+	repo.set(kindProduct, "p1", &v2)
+	repo.set(kindChange, changeKey(event.Seq), event)
+	for _, tt := range tests {
+		products, err := productHistory.asOf(repo, tt.at, repo.ListProducts, func(*models.Product) bool { return true })
+		if err != nil {
+			t.Fatalf("Failed to list products as of %v: %v", tt.at, err)
+		}
+		var got *models.Product
+		if len(products) == 1 {
+			got = products[0]
+		}
+		switch {
+		case tt.want.IsZero() && got != nil:
+			t.Errorf("Expected no product at %v, got %+v", tt.at, got)
+		case !tt.want.IsZero() && (got == nil || got.Price != tt.want):
+			t.Errorf("Expected price %v at %v, got %+v", tt.want, tt.at, got)
+		}
+	}
+
+	// With no events at all the current state is all there is
+	revs, err = productHistory.revisions(nil, &v2)
+	if err != nil {
+		t.Fatalf("Failed to build revisions: %v", err)
+	}
+	if len(revs) != 1 || revs[0].Data.Price != usd(3499) {
+		data, _ := json.Marshal(revs)
+		t.Errorf("Expected only the current state, got %s", data)
+	}
+}
+
+func TestRevisionsUpgradeLegacyPrices(t *testing.T) {
+	event := &models.ChangeEvent{
+		Seq:    1,
+		Entity: kindProduct,
+		ID:     "p1",
+		Op:     models.ChangeCreate,
+		After:  json.RawMessage(`{"id": "p1", "name": "Fertilizer", "price": 29.995, "vendor_id": "v1", "version": 1}`),
+	}
+	revs, err := productHistory.revisions([]*models.ChangeEvent{event}, nil)
+	if err != nil {
+		t.Fatalf("Failed to build revisions: %v", err)
+	}
+	// 29.995 is a tie between two cents and rounds to the even one
+	if len(revs) != 1 || revs[0].Data.Price != usd(3000) || revs[0].Data.Name != "Fertilizer" {
+		t.Errorf("Expected the float price to become 30.00 USD, got %+v", revs)
+	}
+}
@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+)
+
+// ProductFilter selects products by field. Empty fields match any value.
+type ProductFilter struct {
+	VendorID string
+	Category string
+	ParentID string
+	// Attributes matches variants with each of the attributes
+	Attributes map[string]string
+	// ComponentID matches kits with a component of the product
+	ComponentID string
+}
+
+// InventoryFilter selects inventory items by field. Empty fields match any
+// value.
+type InventoryFilter struct {
+	ProductID string
+	Location  string
+}
+
+// SalesOrderFilter selects sales orders by field. Empty fields match any
+// value.
+type SalesOrderFilter struct {
+	BuyerID string
+	Status  models.SalesOrderStatus
+}
+
+// PurchaseOrderFilter selects purchase orders by field. ProductID matches
+// orders with a line for the product. Empty fields match any value.
+type PurchaseOrderFilter struct {
+	VendorID  string
+	ProductID string
+	Status    models.PurchaseOrderStatus
+}
+
+// LocationFilter selects locations by field. Empty fields match any value,
+// so a ParentID of "" does not select the roots.
+type LocationFilter struct {
+	ParentID string
+	Type     models.LocationType
+}
+
+// TransferOrderFilter selects transfer orders by field. ProductID matches
+// orders with a line for the product. Empty fields match any value.
+type TransferOrderFilter struct {
+	FromLocation string
+	ToLocation   string
+	ProductID    string
+	Status       models.TransferOrderStatus
+}
+
+// SerialFilter selects serials by field. Empty fields match any value.
+type SerialFilter struct {
+	ProductID string
+	ItemID    string
+	Status    models.SerialStatus
+	BuyerID   string
+}
+
+// ChangeFilter selects change events by entity type and ID and by when
+// they were committed. Empty fields match any value.
+type ChangeFilter struct {
+	Entity string
+	ID     string
+	After  time.Time // matches events committed after it
+	Until  time.Time // matches events committed at or before it
+	// Keep, if not KeepAll, keeps only one matching event per entity
+	Keep ChangeKeep
+}
+
+// ChangeKeep selects which of an entity's matching change events a
+// ChangeFilter keeps
+type ChangeKeep int
+
+const (
+	KeepAll   ChangeKeep = iota
+	KeepFirst            // the oldest event of each entity
+	KeepLast             // the newest event of each entity
+)
+
+// index is a secondary index from a field value to the IDs of the entities
+// with that value
+type index map[string]map[string]struct{}
+
+func (ix index) add(value, id string) {
+	ids, ok := ix[value]
+	if !ok {
+		ids = make(map[string]struct{})
+		ix[value] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+func (ix index) remove(value, id string) {
+	ids := ix[value]
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(ix, value)
+	}
+}
+
+// lookup returns the IDs with value. The caller must not modify the result.
+func (ix index) lookup(value string) map[string]struct{} {
+	return ix[value]
+}
+
+// clone returns a deep copy, for a repository that stops sharing its
+// indexes with a snapshot
+func (ix index) clone() index {
+	c := make(index, len(ix))
+	for value, ids := range ix {
+		c[value] = maps.Clone(ids)
+	}
+	return c
+}
+
+// keySet returns the keys of m as a set, for a query no index applies to
+func keySet[T any](m map[string]*T) map[string]struct{} {
+	ids := make(map[string]struct{}, len(m))
+	for id := range m {
+		ids[id] = struct{}{}
+	}
+	return ids
+}
+
+// indexLookup is a lookup of value in ix, skipped when value is empty
+type indexLookup struct {
+	ix    index
+	value string
+}
+
+// narrowest returns the smallest of the ID sets selected by lookups, and
+// false if every lookup is skipped. Entities in the result may still fail to
+// match the other lookups.
+func narrowest(lookups ...indexLookup) (map[string]struct{}, bool) {
+	var ids map[string]struct{}
+	found := false
+	for _, l := range lookups {
+		if l.value == "" {
+			continue
+		}
+		if set := l.ix.lookup(l.value); !found || len(set) < len(ids) {
+			ids, found = set, true
+		}
+	}
+	return ids, found
+}
+
+// matches reports whether event satisfies f, apart from Keep
+func (f ChangeFilter) matches(event *models.ChangeEvent) bool {
+	return (f.Entity == "" || event.Entity == f.Entity) &&
+		(f.ID == "" || event.ID == f.ID) &&
+		(f.After.IsZero() || event.CommittedAt.After(f.After)) &&
+		(f.Until.IsZero() || !event.CommittedAt.After(f.Until))
+}
+
+// keep returns the events of matching, which is in Seq order, that f.Keep
+// keeps, in the same order
+func (f ChangeFilter) keep(matching []*models.ChangeEvent) []*models.ChangeEvent {
+	if f.Keep == KeepAll {
+		return matching
+	}
+	seen := make(map[string]bool)
+	kept := make([]*models.ChangeEvent, 0)
+	pick := func(event *models.ChangeEvent) {
+		if key := entityKey(event.Entity, event.ID); !seen[key] {
+			seen[key] = true
+			kept = append(kept, event)
+		}
+	}
+	if f.Keep == KeepFirst {
+		for _, event := range matching {
+			pick(event)
+		}
+		return kept
+	}
+	for i := len(matching) - 1; i >= 0; i-- {
+		pick(matching[i])
+	}
+	slices.Reverse(kept)
+	return kept
+}
+
+// matches reports whether product satisfies f
+func (f ProductFilter) matches(product *models.Product) bool {
+	for name, value := range f.Attributes {
+		if product.Attributes[name] != value {
+			return false
+		}
+	}
+	if f.ComponentID != "" && !slices.ContainsFunc(product.Components, func(c models.Component) bool {
+		return c.ProductID == f.ComponentID
+	}) {
+		return false
+	}
+	return (f.VendorID == "" || product.VendorID == f.VendorID) &&
+		(f.Category == "" || product.Category == f.Category) &&
+		(f.ParentID == "" || product.ParentID == f.ParentID)
+}
+
+// matches reports whether item satisfies f
+func (f InventoryFilter) matches(item *models.InventoryItem) bool {
+	return (f.ProductID == "" || item.ProductID == f.ProductID) &&
+		(f.Location == "" || item.Location == f.Location)
+}
+
+// matches reports whether order satisfies f
+func (f SalesOrderFilter) matches(order *models.SalesOrder) bool {
+	return (f.BuyerID == "" || order.BuyerID == f.BuyerID) &&
+		(f.Status == "" || order.Status == f.Status)
+}
+
+// matches reports whether order satisfies f
+func (f TransferOrderFilter) matches(order *models.TransferOrder) bool {
+	if f.ProductID != "" && !slices.ContainsFunc(order.Lines, func(line models.TransferOrderLine) bool {
+		return line.ProductID == f.ProductID
+	}) {
+		return false
+	}
+	return (f.FromLocation == "" || order.FromLocation == f.FromLocation) &&
+		(f.ToLocation == "" || order.ToLocation == f.ToLocation) &&
+		(f.Status == "" || order.Status == f.Status)
+}
+
+// matches reports whether serial satisfies f
+func (f SerialFilter) matches(serial *models.Serial) bool {
+	return (f.ProductID == "" || serial.ProductID == f.ProductID) &&
+		(f.ItemID == "" || serial.ItemID == f.ItemID) &&
+		(f.Status == "" || serial.Status == f.Status) &&
+		(f.BuyerID == "" || serial.BuyerID == f.BuyerID)
+}
+
+// matches reports whether location satisfies f
+func (f LocationFilter) matches(location *models.Location) bool {
+	return (f.ParentID == "" || location.ParentID == f.ParentID) &&
+		(f.Type == "" || location.Type == f.Type)
+}
+
+// matches reports whether order satisfies f
+func (f PurchaseOrderFilter) matches(order *models.PurchaseOrder) bool {
+	if f.ProductID != "" && !slices.ContainsFunc(order.Lines, func(line models.PurchaseOrderLine) bool {
+		return line.ProductID == f.ProductID
+	}) {
+		return false
+	}
+	return (f.VendorID == "" || order.VendorID == f.VendorID) &&
+		(f.Status == "" || order.Status == f.Status)
+}
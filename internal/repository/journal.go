@@ -0,0 +1,345 @@
+package repository
+
+import (
+	"strconv"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+)
+
+// Entity kinds used to tag mutations
+const (
+	kindSeller        = models.EntitySeller
+	kindBuyer         = models.EntityBuyer
+	kindVendor        = models.EntityVendor
+	kindProduct       = models.EntityProduct
+	kindInventoryItem = models.EntityInventoryItem
+	kindStockMovement = models.EntityStockMovement
+	kindReservation   = models.EntityReservation
+	kindSalesOrder    = models.EntitySalesOrder
+	kindPurchaseOrder = models.EntityPurchaseOrder
+	kindLocation      = models.EntityLocation
+	kindTransferOrder = models.EntityTransferOrder
+	kindSerial        = models.EntitySerial
+	kindPriceList     = models.EntityPriceList
+	kindPromotion     = models.EntityPromotion
+	kindChange        = "change"
+)
+
+// mutation is a change to a single entity. Before is nil for a create and
+// After is nil for a delete.
+type mutation struct {
+	Kind   string
+	ID     string
+	Before any
+	After  any
+}
+
+// commit applies muts along with the change events that record them and
+// hands them to the journal, if any. When the journal rejects them the
+// mutations are undone so memory never runs ahead of durable state. The
+// caller must hold r.mu for writing.
+func (r *InMemoryRepository) commit(muts ...mutation) error {
+	muts, err := r.withChanges(muts)
+	if err != nil {
+		return err
+	}
+	for _, m := range muts {
+		r.set(m.Kind, m.ID, m.After)
+	}
+	if r.journal != nil {
+		if err := r.journal(muts); err != nil {
+			for i := len(muts) - 1; i >= 0; i-- {
+				r.set(muts[i].Kind, muts[i].ID, muts[i].Before)
+			}
+			return err
+		}
+	}
+	r.changed.broadcast()
+	return nil
+}
+
+// set stores entity v of the given kind under id, or removes id when v is
+// nil, and updates the secondary indexes to match. Every change to stored
+// state goes through set, including undo and replay, so the indexes never
+// drift from the maps.
+func (r *InMemoryRepository) set(kind, id string, v any) {
+	r.unshare()
+	switch kind {
+	case kindSeller:
+		setEntity(r.sellers, id, v)
+	case kindBuyer:
+		if old, ok := r.buyers[id]; ok {
+			r.buyersByPriceList.remove(old.PriceListID, id)
+		}
+		setEntity(r.buyers, id, v)
+		if buyer, ok := r.buyers[id]; ok {
+			r.buyersByPriceList.add(buyer.PriceListID, id)
+		}
+	case kindVendor:
+		setEntity(r.vendors, id, v)
+	case kindProduct:
+		if old, ok := r.products[id]; ok {
+			r.productsByVendor.remove(old.VendorID, id)
+			r.productsByCategory.remove(old.Category, id)
+			r.productsByParent.remove(old.ParentID, id)
+			for _, c := range old.Components {
+				r.productsByComponent.remove(c.ProductID, id)
+			}
+		}
+		setEntity(r.products, id, v)
+		if p, ok := r.products[id]; ok {
+			r.productsByVendor.add(p.VendorID, id)
+			r.productsByCategory.add(p.Category, id)
+			r.productsByParent.add(p.ParentID, id)
+			for _, c := range p.Components {
+				r.productsByComponent.add(c.ProductID, id)
+			}
+		}
+	case kindInventoryItem:
+		if old, ok := r.inventory[id]; ok {
+			r.itemsByProduct.remove(old.ProductID, id)
+			r.itemsByLocation.remove(old.Location, id)
+		}
+		if item, ok := v.(*models.InventoryItem); ok && item != nil {
+			// Items logged before reservations existed have no Available
+			derived := *item
+			setAvailable(&derived)
+			v = &derived
+		}
+		setEntity(r.inventory, id, v)
+		if item, ok := r.inventory[id]; ok {
+			r.itemsByProduct.add(item.ProductID, id)
+			r.itemsByLocation.add(item.Location, id)
+		}
+	case kindStockMovement:
+		if old, ok := r.movements[id]; ok {
+			r.movementsByItem.remove(old.ItemID, id)
+		}
+		setEntity(r.movements, id, v)
+		if m, ok := r.movements[id]; ok {
+			r.movementsByItem.add(m.ItemID, id)
+		}
+		if m, ok := v.(*models.StockMovement); ok && m != nil && m.ID > r.lastMovementID {
+			r.lastMovementID = m.ID
+		}
+	case kindReservation:
+		if old, ok := r.reservations[id]; ok {
+			r.reservationsByItem.remove(old.ItemID, id)
+		}
+		setEntity(r.reservations, id, v)
+		if reservation, ok := r.reservations[id]; ok {
+			r.reservationsByItem.add(reservation.ItemID, id)
+		}
+	case kindSalesOrder:
+		if old, ok := r.salesOrders[id]; ok {
+			r.salesOrdersByBuyer.remove(old.BuyerID, id)
+			for _, line := range old.Lines {
+				r.salesOrdersByProduct.remove(line.ProductID, id)
+			}
+		}
+		setEntity(r.salesOrders, id, v)
+		if order, ok := r.salesOrders[id]; ok {
+			r.salesOrdersByBuyer.add(order.BuyerID, id)
+			for _, line := range order.Lines {
+				r.salesOrdersByProduct.add(line.ProductID, id)
+			}
+		}
+	case kindPurchaseOrder:
+		if old, ok := r.purchaseOrders[id]; ok {
+			r.purchaseOrdersByVendor.remove(old.VendorID, id)
+			for _, line := range old.Lines {
+				r.purchaseOrdersByProduct.remove(line.ProductID, id)
+			}
+		}
+		setEntity(r.purchaseOrders, id, v)
+		if order, ok := r.purchaseOrders[id]; ok {
+			r.purchaseOrdersByVendor.add(order.VendorID, id)
+			for _, line := range order.Lines {
+				r.purchaseOrdersByProduct.add(line.ProductID, id)
+			}
+		}
+	case kindLocation:
+		if old, ok := r.locations[id]; ok {
+			r.locationsByParent.remove(old.ParentID, id)
+		}
+		setEntity(r.locations, id, v)
+		if location, ok := r.locations[id]; ok {
+			r.locationsByParent.add(location.ParentID, id)
+		}
+	case kindTransferOrder:
+		if old, ok := r.transferOrders[id]; ok {
+			r.transferOrdersByLocation.remove(old.FromLocation, id)
+			r.transferOrdersByLocation.remove(old.ToLocation, id)
+			for _, line := range old.Lines {
+				r.transferOrdersByProduct.remove(line.ProductID, id)
+			}
+		}
+		setEntity(r.transferOrders, id, v)
+		if order, ok := r.transferOrders[id]; ok {
+			r.transferOrdersByLocation.add(order.FromLocation, id)
+			r.transferOrdersByLocation.add(order.ToLocation, id)
+			for _, line := range order.Lines {
+				r.transferOrdersByProduct.add(line.ProductID, id)
+			}
+		}
+	case kindSerial:
+		if old, ok := r.serials[id]; ok {
+			r.serialsByProduct.remove(old.ProductID, id)
+			r.serialsByItem.remove(old.ItemID, id)
+			r.serialsByBuyer.remove(old.BuyerID, id)
+		}
+		setEntity(r.serials, id, v)
+		if serial, ok := r.serials[id]; ok {
+			r.serialsByProduct.add(serial.ProductID, id)
+			r.serialsByItem.add(serial.ItemID, id)
+			r.serialsByBuyer.add(serial.BuyerID, id)
+		}
+	case kindPriceList:
+		if old, ok := r.priceLists[id]; ok {
+			for _, price := range old.Prices {
+				r.priceListsByProduct.remove(price.ProductID, id)
+			}
+		}
+		setEntity(r.priceLists, id, v)
+		if list, ok := r.priceLists[id]; ok {
+			for _, price := range list.Prices {
+				r.priceListsByProduct.add(price.ProductID, id)
+			}
+		}
+	case kindPromotion:
+		if old, ok := r.promotions[id]; ok {
+			for _, productID := range old.ProductIDs {
+				r.promotionsByProduct.remove(productID, id)
+			}
+		}
+		setEntity(r.promotions, id, v)
+		if promotion, ok := r.promotions[id]; ok {
+			for _, productID := range promotion.ProductIDs {
+				r.promotionsByProduct.add(productID, id)
+			}
+		}
+	case kindChange:
+		r.setChange(id, v)
+	default:
+		panic("repository: unknown entity kind " + kind)
+	}
+}
+
+// setEntity stores a copy of v, so that the caller's pointer does not
+// alias stored state. Stored entities are never modified in place, which
+// lets snapshots share them.
+func setEntity[T any](m map[string]*T, id string, v any) {
+	if v == nil {
+		delete(m, id)
+		return
+	}
+	e := v.(*T)
+	if e == nil {
+		delete(m, id)
+		return
+	}
+	m[id] = clone(e)
+}
+
+// clone returns a copy of a stored entity for a caller to own. Most
+// entities are flat structs, so a shallow copy is enough; those that are not
+// copy themselves.
+func clone[T any](e *T) *T {
+	if c, ok := any(e).(interface{ Clone() *T }); ok {
+		return c.Clone()
+	}
+	c := *e
+	return &c
+}
+
+// each calls fn for every stored entity. The caller must hold r.mu.
+func (r *InMemoryRepository) each(fn func(kind, id string, v any)) {
+	for id, e := range r.sellers {
+		fn(kindSeller, id, e)
+	}
+	for id, e := range r.buyers {
+		fn(kindBuyer, id, e)
+	}
+	for id, e := range r.vendors {
+		fn(kindVendor, id, e)
+	}
+	for id, e := range r.products {
+		fn(kindProduct, id, e)
+	}
+	for id, e := range r.inventory {
+		fn(kindInventoryItem, id, e)
+	}
+	for id, e := range r.movements {
+		fn(kindStockMovement, id, e)
+	}
+	for id, e := range r.reservations {
+		fn(kindReservation, id, e)
+	}
+	for id, e := range r.salesOrders {
+		fn(kindSalesOrder, id, e)
+	}
+	for id, e := range r.purchaseOrders {
+		fn(kindPurchaseOrder, id, e)
+	}
+	for id, e := range r.locations {
+		fn(kindLocation, id, e)
+	}
+	for id, e := range r.transferOrders {
+		fn(kindTransferOrder, id, e)
+	}
+	for id, e := range r.serials {
+		fn(kindSerial, id, e)
+	}
+	for id, e := range r.priceLists {
+		fn(kindPriceList, id, e)
+	}
+	for id, e := range r.promotions {
+		fn(kindPromotion, id, e)
+	}
+	for _, e := range r.changes {
+		fn(kindChange, changeKey(e.Seq), e)
+	}
+}
+
+// newEntity returns a pointer to a zero value of the given kind, suitable
+// for decoding into
+func newEntity(kind string) (any, bool) {
+	switch kind {
+	case kindSeller:
+		return &models.Seller{}, true
+	case kindBuyer:
+		return &models.Buyer{}, true
+	case kindVendor:
+		return &models.Vendor{}, true
+	case kindProduct:
+		return &models.Product{}, true
+	case kindInventoryItem:
+		return &models.InventoryItem{}, true
+	case kindStockMovement:
+		return &models.StockMovement{}, true
+	case kindReservation:
+		return &models.Reservation{}, true
+	case kindSalesOrder:
+		return &models.SalesOrder{}, true
+	case kindPurchaseOrder:
+		return &models.PurchaseOrder{}, true
+	case kindLocation:
+		return &models.Location{}, true
+	case kindTransferOrder:
+		return &models.TransferOrder{}, true
+	case kindSerial:
+		return &models.Serial{}, true
+	case kindPriceList:
+		return &models.PriceList{}, true
+	case kindPromotion:
+		return &models.Promotion{}, true
+	case kindChange:
+		return &models.ChangeEvent{}, true
+	}
+	return nil, false
+}
+
+// movementKey is the mutation ID of a stock movement
+func movementKey(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
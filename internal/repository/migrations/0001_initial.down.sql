@@ -0,0 +1,9 @@
+DROP TABLE inventory_items;
+
+DROP TABLE products;
+
+DROP TABLE vendors;
+
+DROP TABLE buyers;
+
+DROP TABLE sellers;
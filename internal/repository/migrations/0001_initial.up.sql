@@ -0,0 +1,43 @@
+CREATE TABLE sellers (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    email      TEXT NOT NULL,
+    phone      TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE buyers (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    email      TEXT NOT NULL,
+    phone      TEXT NOT NULL,
+    address    TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE vendors (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    email      TEXT NOT NULL,
+    phone      TEXT NOT NULL,
+    address    TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE products (
+    id          TEXT PRIMARY KEY,
+    name        TEXT NOT NULL,
+    description TEXT NOT NULL,
+    category    TEXT NOT NULL,
+    price       REAL NOT NULL,
+    vendor_id   TEXT NOT NULL REFERENCES vendors (id),
+    created_at  TIMESTAMP NOT NULL
+);
+
+CREATE TABLE inventory_items (
+    id         TEXT PRIMARY KEY,
+    product_id TEXT NOT NULL REFERENCES products (id),
+    quantity   INTEGER NOT NULL,
+    location   TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
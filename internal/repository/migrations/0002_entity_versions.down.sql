@@ -0,0 +1,9 @@
+ALTER TABLE inventory_items DROP COLUMN version;
+
+ALTER TABLE products DROP COLUMN version;
+
+ALTER TABLE vendors DROP COLUMN version;
+
+ALTER TABLE buyers DROP COLUMN version;
+
+ALTER TABLE sellers DROP COLUMN version;
@@ -0,0 +1,9 @@
+ALTER TABLE sellers ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE buyers ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE vendors ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE products ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE inventory_items ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
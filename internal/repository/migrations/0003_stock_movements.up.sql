@@ -0,0 +1,19 @@
+CREATE TABLE stock_movements (
+    id         INTEGER PRIMARY KEY,
+    item_id    TEXT NOT NULL REFERENCES inventory_items (id),
+    type       TEXT NOT NULL,
+    delta      INTEGER NOT NULL,
+    balance    INTEGER NOT NULL,
+    reason     TEXT NOT NULL,
+    reference  TEXT NOT NULL,
+    actor      TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX stock_movements_item_id ON stock_movements (item_id, id);
+
+INSERT INTO stock_movements (item_id, type, delta, balance, reason, reference, actor, created_at)
+SELECT id, 'adjustment', quantity, quantity, 'opening_balance', '', 'system', updated_at
+FROM inventory_items
+WHERE quantity <> 0
+ORDER BY id;
@@ -0,0 +1,4 @@
+DROP INDEX inventory_items_location;
+DROP INDEX inventory_items_product_id;
+DROP INDEX products_category;
+DROP INDEX products_vendor_id;
@@ -0,0 +1,4 @@
+CREATE INDEX products_vendor_id ON products (vendor_id);
+CREATE INDEX products_category ON products (category);
+CREATE INDEX inventory_items_product_id ON inventory_items (product_id);
+CREATE INDEX inventory_items_location ON inventory_items (location);
@@ -0,0 +1,10 @@
+CREATE TABLE change_events (
+    seq          INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity       TEXT NOT NULL,
+    entity_id    TEXT NOT NULL,
+    op           TEXT NOT NULL,
+    version      INTEGER NOT NULL,
+    before_image TEXT,
+    after_image  TEXT,
+    committed_at TIMESTAMP NOT NULL
+);
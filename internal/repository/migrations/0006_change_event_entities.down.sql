@@ -0,0 +1 @@
+DROP INDEX change_events_entity;
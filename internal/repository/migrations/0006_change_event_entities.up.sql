@@ -0,0 +1 @@
+CREATE INDEX change_events_entity ON change_events (entity, entity_id, seq);
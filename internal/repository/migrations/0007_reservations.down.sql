@@ -0,0 +1,5 @@
+ALTER TABLE stock_movements DROP COLUMN reservation_id;
+
+DROP TABLE reservations;
+
+ALTER TABLE inventory_items DROP COLUMN reserved;
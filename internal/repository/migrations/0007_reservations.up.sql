@@ -0,0 +1,14 @@
+ALTER TABLE inventory_items ADD COLUMN reserved INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE reservations (
+    id         TEXT PRIMARY KEY,
+    item_id    TEXT NOT NULL REFERENCES inventory_items (id),
+    quantity   INTEGER NOT NULL,
+    owner      TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX reservations_item_id ON reservations (item_id);
+
+ALTER TABLE stock_movements ADD COLUMN reservation_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+DROP TABLE sales_order_allocations;
+
+DROP TABLE sales_order_lines;
+
+DROP TABLE sales_orders;
@@ -0,0 +1,31 @@
+CREATE TABLE sales_orders (
+    id         TEXT PRIMARY KEY,
+    buyer_id   TEXT NOT NULL REFERENCES buyers (id),
+    status     TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    version    INTEGER NOT NULL
+);
+
+CREATE INDEX sales_orders_buyer_id ON sales_orders (buyer_id);
+
+CREATE TABLE sales_order_lines (
+    order_id   TEXT NOT NULL REFERENCES sales_orders (id),
+    line       INTEGER NOT NULL,
+    product_id TEXT NOT NULL REFERENCES products (id),
+    quantity   INTEGER NOT NULL,
+    PRIMARY KEY (order_id, line)
+);
+
+CREATE INDEX sales_order_lines_product_id ON sales_order_lines (product_id);
+
+CREATE TABLE sales_order_allocations (
+    order_id       TEXT NOT NULL,
+    line           INTEGER NOT NULL,
+    seq            INTEGER NOT NULL,
+    item_id        TEXT NOT NULL,
+    reservation_id TEXT NOT NULL,
+    quantity       INTEGER NOT NULL,
+    PRIMARY KEY (order_id, line, seq),
+    FOREIGN KEY (order_id, line) REFERENCES sales_order_lines (order_id, line)
+);
@@ -0,0 +1,24 @@
+CREATE TABLE purchase_orders (
+    id              TEXT PRIMARY KEY,
+    vendor_id       TEXT NOT NULL REFERENCES vendors (id),
+    status          TEXT NOT NULL,
+    over_tolerance  REAL NOT NULL,
+    under_tolerance REAL NOT NULL,
+    created_at      TIMESTAMP NOT NULL,
+    updated_at      TIMESTAMP NOT NULL,
+    version         INTEGER NOT NULL
+);
+
+CREATE INDEX purchase_orders_vendor_id ON purchase_orders (vendor_id);
+
+CREATE TABLE purchase_order_lines (
+    order_id    TEXT NOT NULL REFERENCES purchase_orders (id),
+    line        INTEGER NOT NULL,
+    product_id  TEXT NOT NULL REFERENCES products (id),
+    quantity    INTEGER NOT NULL,
+    received    INTEGER NOT NULL,
+    expected_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (order_id, line)
+);
+
+CREATE INDEX purchase_order_lines_product_id ON purchase_order_lines (product_id);
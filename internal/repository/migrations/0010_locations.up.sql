@@ -0,0 +1,12 @@
+CREATE TABLE locations (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    type       TEXT NOT NULL,
+    parent_id  TEXT NOT NULL,
+    capacity   INTEGER NOT NULL,
+    active     BOOLEAN NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    version    INTEGER NOT NULL
+);
+
+CREATE INDEX locations_parent_id ON locations (parent_id);
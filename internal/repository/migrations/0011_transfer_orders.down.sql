@@ -0,0 +1,3 @@
+DROP TABLE transfer_order_lines;
+
+DROP TABLE transfer_orders;
@@ -0,0 +1,29 @@
+CREATE TABLE transfer_orders (
+    id            TEXT PRIMARY KEY,
+    from_location TEXT NOT NULL REFERENCES locations (id),
+    to_location   TEXT NOT NULL REFERENCES locations (id),
+    status        TEXT NOT NULL,
+    note          TEXT NOT NULL,
+    dispatched_at TIMESTAMP,
+    received_at   TIMESTAMP,
+    created_at    TIMESTAMP NOT NULL,
+    updated_at    TIMESTAMP NOT NULL,
+    version       INTEGER NOT NULL
+);
+
+CREATE INDEX transfer_orders_from_location ON transfer_orders (from_location);
+CREATE INDEX transfer_orders_to_location ON transfer_orders (to_location);
+
+CREATE TABLE transfer_order_lines (
+    order_id     TEXT NOT NULL REFERENCES transfer_orders (id),
+    line         INTEGER NOT NULL,
+    product_id   TEXT NOT NULL REFERENCES products (id),
+    quantity     INTEGER NOT NULL,
+    from_item_id TEXT NOT NULL,
+    to_item_id   TEXT NOT NULL,
+    received     INTEGER NOT NULL,
+    discrepancy  INTEGER NOT NULL,
+    PRIMARY KEY (order_id, line)
+);
+
+CREATE INDEX transfer_order_lines_product_id ON transfer_order_lines (product_id);
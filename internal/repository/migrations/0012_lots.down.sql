@@ -0,0 +1,15 @@
+ALTER TABLE transfer_order_lines DROP COLUMN expires_at;
+
+ALTER TABLE transfer_order_lines DROP COLUMN manufactured_at;
+
+ALTER TABLE transfer_order_lines DROP COLUMN lot_number;
+
+DROP INDEX inventory_items_expires_at;
+
+ALTER TABLE inventory_items DROP COLUMN expires_at;
+
+ALTER TABLE inventory_items DROP COLUMN manufactured_at;
+
+ALTER TABLE inventory_items DROP COLUMN lot_number;
+
+ALTER TABLE products DROP COLUMN lot_controlled;
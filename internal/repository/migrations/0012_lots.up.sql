@@ -0,0 +1,15 @@
+ALTER TABLE products ADD COLUMN lot_controlled BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE inventory_items ADD COLUMN lot_number TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE inventory_items ADD COLUMN manufactured_at TIMESTAMP;
+
+ALTER TABLE inventory_items ADD COLUMN expires_at TIMESTAMP;
+
+CREATE INDEX inventory_items_expires_at ON inventory_items (expires_at);
+
+ALTER TABLE transfer_order_lines ADD COLUMN lot_number TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE transfer_order_lines ADD COLUMN manufactured_at TIMESTAMP;
+
+ALTER TABLE transfer_order_lines ADD COLUMN expires_at TIMESTAMP;
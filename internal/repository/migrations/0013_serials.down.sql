@@ -0,0 +1,3 @@
+DROP TABLE serials;
+
+ALTER TABLE products DROP COLUMN serialized;
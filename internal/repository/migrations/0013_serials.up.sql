@@ -0,0 +1,18 @@
+ALTER TABLE products ADD COLUMN serialized BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE serials (
+    id         TEXT PRIMARY KEY,
+    product_id TEXT NOT NULL REFERENCES products (id),
+    item_id    TEXT NOT NULL REFERENCES inventory_items (id),
+    location   TEXT NOT NULL,
+    status     TEXT NOT NULL,
+    buyer_id   TEXT NOT NULL,
+    reference  TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    version    INTEGER NOT NULL
+);
+
+CREATE INDEX serials_product_id ON serials (product_id);
+CREATE INDEX serials_item_id ON serials (item_id);
+CREATE INDEX serials_buyer_id ON serials (buyer_id);
@@ -0,0 +1,10 @@
+ALTER TABLE transfer_order_lines DROP COLUMN unit;
+ALTER TABLE purchase_order_lines DROP COLUMN unit;
+ALTER TABLE sales_order_lines DROP COLUMN unit;
+ALTER TABLE reservations DROP COLUMN unit;
+ALTER TABLE stock_movements DROP COLUMN unit;
+ALTER TABLE inventory_items DROP COLUMN unit;
+
+DROP TABLE product_packs;
+
+ALTER TABLE products DROP COLUMN base_unit;
@@ -0,0 +1,17 @@
+ALTER TABLE products ADD COLUMN base_unit TEXT NOT NULL DEFAULT 'each';
+
+CREATE TABLE product_packs (
+    product_id TEXT NOT NULL REFERENCES products (id),
+    seq        INTEGER NOT NULL,
+    unit       TEXT NOT NULL,
+    quantity   REAL NOT NULL,
+    of_unit    TEXT NOT NULL,
+    PRIMARY KEY (product_id, seq)
+);
+
+ALTER TABLE inventory_items ADD COLUMN unit TEXT NOT NULL DEFAULT 'each';
+ALTER TABLE stock_movements ADD COLUMN unit TEXT NOT NULL DEFAULT 'each';
+ALTER TABLE reservations ADD COLUMN unit TEXT NOT NULL DEFAULT 'each';
+ALTER TABLE sales_order_lines ADD COLUMN unit TEXT NOT NULL DEFAULT 'each';
+ALTER TABLE purchase_order_lines ADD COLUMN unit TEXT NOT NULL DEFAULT 'each';
+ALTER TABLE transfer_order_lines ADD COLUMN unit TEXT NOT NULL DEFAULT 'each';
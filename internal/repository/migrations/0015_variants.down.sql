@@ -0,0 +1,5 @@
+DROP TABLE product_attributes;
+DROP TABLE product_axes;
+
+DROP INDEX products_parent_id;
+ALTER TABLE products DROP COLUMN parent_id;
@@ -0,0 +1,20 @@
+ALTER TABLE products ADD COLUMN parent_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX products_parent_id ON products (parent_id);
+
+CREATE TABLE product_axes (
+    product_id TEXT NOT NULL REFERENCES products (id),
+    seq        INTEGER NOT NULL,
+    axis       TEXT NOT NULL,
+    value      TEXT NOT NULL,
+    PRIMARY KEY (product_id, seq)
+);
+
+CREATE TABLE product_attributes (
+    product_id TEXT NOT NULL REFERENCES products (id),
+    axis       TEXT NOT NULL,
+    value      TEXT NOT NULL,
+    PRIMARY KEY (product_id, axis)
+);
+
+CREATE INDEX product_attributes_axis_value ON product_attributes (axis, value);
@@ -0,0 +1,4 @@
+ALTER TABLE sales_order_allocations DROP COLUMN product_id;
+
+DROP INDEX product_components_component_id;
+DROP TABLE product_components;
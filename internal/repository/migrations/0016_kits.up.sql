@@ -0,0 +1,12 @@
+CREATE TABLE product_components (
+    product_id   TEXT NOT NULL REFERENCES products (id),
+    seq          INTEGER NOT NULL,
+    component_id TEXT NOT NULL REFERENCES products (id),
+    quantity     REAL NOT NULL,
+    unit         TEXT NOT NULL,
+    PRIMARY KEY (product_id, seq)
+);
+
+CREATE INDEX product_components_component_id ON product_components (component_id);
+
+ALTER TABLE sales_order_allocations ADD COLUMN product_id TEXT NOT NULL DEFAULT '';
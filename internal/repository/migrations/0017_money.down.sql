@@ -0,0 +1,6 @@
+ALTER TABLE purchase_order_lines DROP COLUMN cost_currency;
+ALTER TABLE purchase_order_lines DROP COLUMN cost_minor;
+ALTER TABLE sales_order_lines DROP COLUMN unit_price_currency;
+ALTER TABLE sales_order_lines DROP COLUMN unit_price_minor;
+ALTER TABLE products DROP COLUMN price_currency;
+ALTER TABLE products DROP COLUMN price_minor;
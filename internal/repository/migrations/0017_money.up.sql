@@ -0,0 +1,6 @@
+ALTER TABLE products ADD COLUMN price_minor INTEGER;
+ALTER TABLE products ADD COLUMN price_currency TEXT NOT NULL DEFAULT '';
+ALTER TABLE sales_order_lines ADD COLUMN unit_price_minor INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sales_order_lines ADD COLUMN unit_price_currency TEXT NOT NULL DEFAULT '';
+ALTER TABLE purchase_order_lines ADD COLUMN cost_minor INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE purchase_order_lines ADD COLUMN cost_currency TEXT NOT NULL DEFAULT '';
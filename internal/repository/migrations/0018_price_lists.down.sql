@@ -0,0 +1,7 @@
+DROP INDEX buyers_price_list_id;
+ALTER TABLE buyers DROP COLUMN price_list_id;
+
+DROP INDEX price_list_prices_product_id;
+DROP TABLE price_list_prices;
+
+DROP TABLE price_lists;
@@ -0,0 +1,25 @@
+CREATE TABLE price_lists (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    version    INTEGER NOT NULL
+);
+
+CREATE TABLE price_list_prices (
+    price_list_id  TEXT NOT NULL REFERENCES price_lists (id),
+    seq            INTEGER NOT NULL,
+    product_id     TEXT NOT NULL REFERENCES products (id),
+    min_quantity   REAL NOT NULL,
+    unit           TEXT NOT NULL,
+    price_minor    INTEGER NOT NULL,
+    price_currency TEXT NOT NULL,
+    valid_from     TIMESTAMP,
+    valid_to       TIMESTAMP,
+    PRIMARY KEY (price_list_id, seq)
+);
+
+CREATE INDEX price_list_prices_product_id ON price_list_prices (product_id);
+
+ALTER TABLE buyers ADD COLUMN price_list_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX buyers_price_list_id ON buyers (price_list_id);
@@ -0,0 +1,7 @@
+DROP TABLE promotion_uses;
+DROP TABLE promotion_categories;
+
+DROP INDEX promotion_products_product_id;
+DROP TABLE promotion_products;
+
+DROP TABLE promotions;
@@ -0,0 +1,44 @@
+CREATE TABLE promotions (
+    id                    TEXT PRIMARY KEY,
+    name                  TEXT NOT NULL,
+    type                  TEXT NOT NULL,
+    percent_basis_points  INTEGER NOT NULL,
+    amount_minor          INTEGER NOT NULL,
+    amount_currency       TEXT NOT NULL,
+    buy_quantity          REAL NOT NULL,
+    free_quantity         REAL NOT NULL,
+    unit                  TEXT NOT NULL,
+    min_subtotal_minor    INTEGER NOT NULL,
+    min_subtotal_currency TEXT NOT NULL,
+    exclusive             INTEGER NOT NULL,
+    group_name            TEXT NOT NULL,
+    priority              INTEGER NOT NULL,
+    valid_from            TIMESTAMP,
+    valid_to              TIMESTAMP,
+    limit_per_buyer       INTEGER NOT NULL,
+    created_at            TIMESTAMP NOT NULL,
+    version               INTEGER NOT NULL
+);
+
+CREATE TABLE promotion_products (
+    promotion_id TEXT NOT NULL REFERENCES promotions (id),
+    seq          INTEGER NOT NULL,
+    product_id   TEXT NOT NULL REFERENCES products (id),
+    PRIMARY KEY (promotion_id, seq)
+);
+
+CREATE INDEX promotion_products_product_id ON promotion_products (product_id);
+
+CREATE TABLE promotion_categories (
+    promotion_id TEXT NOT NULL REFERENCES promotions (id),
+    seq          INTEGER NOT NULL,
+    category     TEXT NOT NULL,
+    PRIMARY KEY (promotion_id, seq)
+);
+
+CREATE TABLE promotion_uses (
+    promotion_id TEXT NOT NULL REFERENCES promotions (id),
+    buyer_id     TEXT NOT NULL,
+    uses         INTEGER NOT NULL,
+    PRIMARY KEY (promotion_id, buyer_id)
+);
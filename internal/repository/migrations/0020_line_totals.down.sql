@@ -0,0 +1,6 @@
+ALTER TABLE purchase_order_lines DROP COLUMN total_currency;
+ALTER TABLE purchase_order_lines DROP COLUMN total_minor;
+ALTER TABLE purchase_order_lines DROP COLUMN cost_unit;
+ALTER TABLE sales_order_lines DROP COLUMN total_currency;
+ALTER TABLE sales_order_lines DROP COLUMN total_minor;
+ALTER TABLE sales_order_lines DROP COLUMN price_unit;
@@ -0,0 +1,10 @@
+ALTER TABLE sales_order_lines ADD COLUMN price_unit TEXT NOT NULL DEFAULT '';
+ALTER TABLE sales_order_lines ADD COLUMN total_minor INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sales_order_lines ADD COLUMN total_currency TEXT NOT NULL DEFAULT '';
+UPDATE sales_order_lines SET price_unit = unit, total_minor = CAST(ROUND(quantity * unit_price_minor) AS INTEGER),
+    total_currency = unit_price_currency;
+ALTER TABLE purchase_order_lines ADD COLUMN cost_unit TEXT NOT NULL DEFAULT '';
+ALTER TABLE purchase_order_lines ADD COLUMN total_minor INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE purchase_order_lines ADD COLUMN total_currency TEXT NOT NULL DEFAULT '';
+UPDATE purchase_order_lines SET cost_unit = unit, total_minor = CAST(ROUND(quantity * cost_minor) AS INTEGER),
+    total_currency = cost_currency;
@@ -0,0 +1,228 @@
+// Package migrations holds the versioned schema for the SQL repository and
+// applies it. Each migration is a pair of embedded files named
+// NNNN_name.up.sql and NNNN_name.down.sql. Statements within a file are
+// separated by a semicolon at the end of a line.
+//
+// Applied migrations are recorded in the schema_version table, one row per
+// version, so the current version is the highest recorded one.
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var ErrUnknownVersion = errors.New("unknown schema version")
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// All returns the embedded migrations in version order
+func All() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := files.ReadFile(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Latest returns the version of the newest embedded migration
+func Latest() int {
+	migrations, err := All()
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// Version returns the schema version of db, or 0 if no migrations have been
+// applied
+func Version(db *sql.DB) (int, error) {
+	if err := ensureVersionTable(db); err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// Up applies all pending migrations
+func Up(db *sql.DB) error {
+	return Migrate(db, Latest())
+}
+
+// Down rolls back the most recently applied migration
+func Down(db *sql.DB) error {
+	current, err := Version(db)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return nil
+	}
+
+	migrations, err := All()
+	if err != nil {
+		return err
+	}
+	target := 0
+	for _, m := range migrations {
+		if m.Version < current {
+			target = m.Version
+		}
+	}
+	return Migrate(db, target)
+}
+
+// Migrate moves db up or down to the target version, applying each
+// migration in its own transaction
+func Migrate(db *sql.DB, target int) error {
+	migrations, err := All()
+	if err != nil {
+		return err
+	}
+	if target != 0 && !hasVersion(migrations, target) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+
+	current, err := Version(db)
+	if err != nil {
+		return err
+	}
+
+	if target >= current {
+		for _, m := range migrations {
+			if m.Version > current && m.Version <= target {
+				if err := apply(db, m, true); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= current && m.Version > target {
+			if err := apply(db, m, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func hasVersion(migrations []Migration, version int) bool {
+	for _, m := range migrations {
+		if m.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+func ensureVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`)
+	return err
+}
+
+func apply(db *sql.DB, m Migration, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script := m.Down
+	if up {
+		script = m.Up
+	}
+	for _, stmt := range statements(script) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	if up {
+		_, err = tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+			m.Version, m.Name, time.Now())
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_version WHERE version = ?`, m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// statements splits a script on semicolons that end a line
+func statements(script string) []string {
+	var stmts []string
+	for _, stmt := range strings.Split(script, ";\n") {
+		stmt = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+		if stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
@@ -0,0 +1,114 @@
+package migrations
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n)
+	if err != nil {
+		t.Fatalf("Failed to query schema: %v", err)
+	}
+	return n == 1
+}
+
+func TestAllMigrationsAreComplete(t *testing.T) {
+	migrations, err := All()
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("Expected at least one migration")
+	}
+	for i, m := range migrations {
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			t.Errorf("Migration %d is out of order", m.Version)
+		}
+	}
+}
+
+func TestUpAndDown(t *testing.T) {
+	db := openTestDB(t)
+
+	version, err := Version(db)
+	if err != nil {
+		t.Fatalf("Failed to read version: %v", err)
+	}
+	if version != 0 {
+		t.Errorf("Expected version 0 on an empty database, got %d", version)
+	}
+
+	if err := Up(db); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	version, _ = Version(db)
+	if version != Latest() {
+		t.Errorf("Expected version %d, got %d", Latest(), version)
+	}
+	if !tableExists(t, db, "inventory_items") {
+		t.Error("Expected inventory_items table to exist")
+	}
+
+	// Up is idempotent
+	if err := Up(db); err != nil {
+		t.Fatalf("Failed to re-run up: %v", err)
+	}
+
+	if err := Migrate(db, 0); err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+	version, _ = Version(db)
+	if version != 0 {
+		t.Errorf("Expected version 0 after rolling back, got %d", version)
+	}
+	if tableExists(t, db, "inventory_items") {
+		t.Error("Expected inventory_items table to be dropped")
+	}
+}
+
+func TestDownRollsBackOneVersion(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Up(db); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	if err := Down(db); err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+
+	migrations, _ := All()
+	want := 0
+	if len(migrations) > 1 {
+		want = migrations[len(migrations)-2].Version
+	}
+	version, _ := Version(db)
+	if version != want {
+		t.Errorf("Expected version %d, got %d", want, version)
+	}
+}
+
+func TestMigrateToUnknownVersion(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Migrate(db, 9999); err == nil {
+		t.Error("Expected an error migrating to an unknown version")
+	}
+}
@@ -2,6 +2,9 @@ package repository
 
 import (
 	"errors"
+	"maps"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
@@ -9,10 +12,25 @@ import (
 )
 
 var (
-	ErrNotFound      = errors.New("entity not found")
-	ErrAlreadyExists = errors.New("entity already exists")
+	ErrNotFound          = errors.New("entity not found")
+	ErrAlreadyExists     = errors.New("entity already exists")
+	ErrInvalidReference  = errors.New("referenced entity not found")
+	ErrInUse             = errors.New("entity is still referenced")
+	ErrVersionMismatch   = errors.New("entity version does not match")
+	ErrInsufficientStock = errors.New("insufficient stock")
 )
 
+// CheckVersion implements compare-and-swap for updates and deletes: it
+// returns ErrVersionMismatch unless expected is zero (unconditional) or
+// equal to current. The service checks a caller's version against the one
+// it read with it too.
+func CheckVersion(current, expected int64) error {
+	if expected != 0 && expected != current {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
 // InMemoryRepository provides in-memory storage for all entities
 type InMemoryRepository struct {
 	sellers   map[string]*models.Seller
@@ -20,7 +38,75 @@ type InMemoryRepository struct {
 	vendors   map[string]*models.Vendor
 	products  map[string]*models.Product
 	inventory map[string]*models.InventoryItem
-	mu        sync.RWMutex
+	movements map[string]*models.StockMovement
+	// reservations holds the reservations of inventory items
+	reservations map[string]*models.Reservation
+	salesOrders  map[string]*models.SalesOrder
+
+	purchaseOrders map[string]*models.PurchaseOrder
+	locations      map[string]*models.Location
+	transferOrders map[string]*models.TransferOrder
+	serials        map[string]*models.Serial
+	priceLists     map[string]*models.PriceList
+	promotions     map[string]*models.Promotion
+	mu             sync.RWMutex
+
+	// Secondary indexes, maintained by set
+	productsByVendor   index
+	productsByCategory index
+	productsByParent   index
+	itemsByProduct     index
+	itemsByLocation    index
+	movementsByItem    index
+	reservationsByItem index
+
+	// productsByComponent indexes kits by the product of every component
+	productsByComponent index
+
+	// salesOrdersByProduct indexes orders by the product of every line
+	salesOrdersByBuyer   index
+	salesOrdersByProduct index
+
+	// purchaseOrdersByProduct indexes orders by the product of every line
+	purchaseOrdersByVendor  index
+	purchaseOrdersByProduct index
+
+	locationsByParent index
+
+	// transferOrdersByLocation indexes orders by both of their locations,
+	// and transferOrdersByProduct by the product of every line
+	transferOrdersByLocation index
+	transferOrdersByProduct  index
+
+	serialsByProduct index
+	serialsByItem    index
+	serialsByBuyer   index
+
+	// priceListsByProduct indexes price lists by the product of every price
+	priceListsByProduct index
+	buyersByPriceList   index
+
+	// promotionsByProduct indexes promotions by every product they name
+	promotionsByProduct index
+
+	// lastMovementID is the highest stock movement ID assigned so far
+	lastMovementID int64
+
+	// shared is set while the maps above are shared with a snapshot, and
+	// cleared by unshare before the next write copies them
+	shared bool
+
+	// changes is the change log, oldest first. changed is broadcast when it
+	// grows; it is nil in a unit of work's view, whose changes are not yet
+	// committed.
+	changes         []*models.ChangeEvent
+	changesByEntity index
+	changesByID     index
+	changed         *broadcaster
+
+	// journal, when set, receives every committed batch of mutations
+	// while mu is held. Durable backends use it to log changes.
+	journal func([]mutation) error
 }
 
 // NewInMemoryRepository creates a new in-memory repository
@@ -31,6 +117,51 @@ func NewInMemoryRepository() *InMemoryRepository {
 		vendors:   make(map[string]*models.Vendor),
 		products:  make(map[string]*models.Product),
 		inventory: make(map[string]*models.InventoryItem),
+		movements: make(map[string]*models.StockMovement),
+
+		reservations: make(map[string]*models.Reservation),
+		salesOrders:  make(map[string]*models.SalesOrder),
+
+		purchaseOrders: make(map[string]*models.PurchaseOrder),
+		locations:      make(map[string]*models.Location),
+		transferOrders: make(map[string]*models.TransferOrder),
+		serials:        make(map[string]*models.Serial),
+		priceLists:     make(map[string]*models.PriceList),
+		promotions:     make(map[string]*models.Promotion),
+
+		productsByVendor:   make(index),
+		productsByCategory: make(index),
+		productsByParent:   make(index),
+		itemsByProduct:     make(index),
+		itemsByLocation:    make(index),
+		movementsByItem:    make(index),
+		reservationsByItem: make(index),
+
+		productsByComponent: make(index),
+
+		salesOrdersByBuyer:   make(index),
+		salesOrdersByProduct: make(index),
+
+		purchaseOrdersByVendor:  make(index),
+		purchaseOrdersByProduct: make(index),
+
+		locationsByParent: make(index),
+
+		transferOrdersByLocation: make(index),
+		transferOrdersByProduct:  make(index),
+
+		serialsByProduct: make(index),
+		serialsByItem:    make(index),
+		serialsByBuyer:   make(index),
+
+		priceListsByProduct: make(index),
+		buyersByPriceList:   make(index),
+
+		promotionsByProduct: make(index),
+
+		changesByEntity: make(index),
+		changesByID:     make(index),
+		changed:         newBroadcaster(),
 	}
 }
 
@@ -44,8 +175,8 @@ func (r *InMemoryRepository) CreateSeller(seller *models.Seller) error {
 		return ErrAlreadyExists
 	}
 	seller.CreatedAt = time.Now()
-	r.sellers[seller.ID] = seller
-	return nil
+	seller.Version = 1
+	return r.commit(mutation{Kind: kindSeller, ID: seller.ID, After: seller})
 }
 
 func (r *InMemoryRepository) GetSeller(id string) (*models.Seller, error) {
@@ -56,7 +187,7 @@ func (r *InMemoryRepository) GetSeller(id string) (*models.Seller, error) {
 	if !exists {
 		return nil, ErrNotFound
 	}
-	return seller, nil
+	return clone(seller), nil
 }
 
 func (r *InMemoryRepository) ListSellers() ([]*models.Seller, error) {
@@ -65,13 +196,55 @@ func (r *InMemoryRepository) ListSellers() ([]*models.Seller, error) {
 
 	sellers := make([]*models.Seller, 0, len(r.sellers))
 	for _, seller := range r.sellers {
-		sellers = append(sellers, seller)
+		sellers = append(sellers, clone(seller))
 	}
 	return sellers, nil
 }
 
+func (r *InMemoryRepository) UpdateSeller(seller *models.Seller) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.sellers[seller.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, seller.Version); err != nil {
+		return err
+	}
+	seller.CreatedAt = existing.CreatedAt
+	seller.Version = existing.Version + 1
+	return r.commit(mutation{Kind: kindSeller, ID: seller.ID, Before: existing, After: seller})
+}
+
+func (r *InMemoryRepository) DeleteSeller(id string, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.sellers[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	return r.commit(mutation{Kind: kindSeller, ID: id, Before: existing})
+}
+
 // Buyer methods
 
+// checkBuyerPriceList returns ErrInvalidReference if buyer is assigned a
+// price list that does not exist. The caller must hold r.mu.
+func (r *InMemoryRepository) checkBuyerPriceList(buyer *models.Buyer) error {
+	if buyer.PriceListID == "" {
+		return nil
+	}
+	if _, exists := r.priceLists[buyer.PriceListID]; !exists {
+		return ErrInvalidReference
+	}
+	return nil
+}
+
 func (r *InMemoryRepository) CreateBuyer(buyer *models.Buyer) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -79,9 +252,12 @@ func (r *InMemoryRepository) CreateBuyer(buyer *models.Buyer) error {
 	if _, exists := r.buyers[buyer.ID]; exists {
 		return ErrAlreadyExists
 	}
+	if err := r.checkBuyerPriceList(buyer); err != nil {
+		return err
+	}
 	buyer.CreatedAt = time.Now()
-	r.buyers[buyer.ID] = buyer
-	return nil
+	buyer.Version = 1
+	return r.commit(mutation{Kind: kindBuyer, ID: buyer.ID, After: buyer})
 }
 
 func (r *InMemoryRepository) GetBuyer(id string) (*models.Buyer, error) {
@@ -92,7 +268,7 @@ func (r *InMemoryRepository) GetBuyer(id string) (*models.Buyer, error) {
 	if !exists {
 		return nil, ErrNotFound
 	}
-	return buyer, nil
+	return clone(buyer), nil
 }
 
 func (r *InMemoryRepository) ListBuyers() ([]*models.Buyer, error) {
@@ -101,11 +277,47 @@ func (r *InMemoryRepository) ListBuyers() ([]*models.Buyer, error) {
 
 	buyers := make([]*models.Buyer, 0, len(r.buyers))
 	for _, buyer := range r.buyers {
-		buyers = append(buyers, buyer)
+		buyers = append(buyers, clone(buyer))
 	}
 	return buyers, nil
 }
 
+func (r *InMemoryRepository) UpdateBuyer(buyer *models.Buyer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.buyers[buyer.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := r.checkBuyerPriceList(buyer); err != nil {
+		return err
+	}
+	if err := CheckVersion(existing.Version, buyer.Version); err != nil {
+		return err
+	}
+	buyer.CreatedAt = existing.CreatedAt
+	buyer.Version = existing.Version + 1
+	return r.commit(mutation{Kind: kindBuyer, ID: buyer.ID, Before: existing, After: buyer})
+}
+
+func (r *InMemoryRepository) DeleteBuyer(id string, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.buyers[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	if len(r.salesOrdersByBuyer.lookup(id)) > 0 || len(r.serialsByBuyer.lookup(id)) > 0 {
+		return ErrInUse
+	}
+	return r.commit(mutation{Kind: kindBuyer, ID: id, Before: existing})
+}
+
 // Vendor methods
 
 func (r *InMemoryRepository) CreateVendor(vendor *models.Vendor) error {
@@ -116,8 +328,8 @@ func (r *InMemoryRepository) CreateVendor(vendor *models.Vendor) error {
 		return ErrAlreadyExists
 	}
 	vendor.CreatedAt = time.Now()
-	r.vendors[vendor.ID] = vendor
-	return nil
+	vendor.Version = 1
+	return r.commit(mutation{Kind: kindVendor, ID: vendor.ID, After: vendor})
 }
 
 func (r *InMemoryRepository) GetVendor(id string) (*models.Vendor, error) {
@@ -128,7 +340,7 @@ func (r *InMemoryRepository) GetVendor(id string) (*models.Vendor, error) {
 	if !exists {
 		return nil, ErrNotFound
 	}
-	return vendor, nil
+	return clone(vendor), nil
 }
 
 func (r *InMemoryRepository) ListVendors() ([]*models.Vendor, error) {
@@ -137,13 +349,93 @@ func (r *InMemoryRepository) ListVendors() ([]*models.Vendor, error) {
 
 	vendors := make([]*models.Vendor, 0, len(r.vendors))
 	for _, vendor := range r.vendors {
-		vendors = append(vendors, vendor)
+		vendors = append(vendors, clone(vendor))
 	}
 	return vendors, nil
 }
 
+func (r *InMemoryRepository) UpdateVendor(vendor *models.Vendor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.vendors[vendor.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, vendor.Version); err != nil {
+		return err
+	}
+	vendor.CreatedAt = existing.CreatedAt
+	vendor.Version = existing.Version + 1
+	return r.commit(mutation{Kind: kindVendor, ID: vendor.ID, Before: existing, After: vendor})
+}
+
+// DeleteVendor deletes a vendor. If the vendor still has products it fails
+// with ErrInUse, unless cascade is set, in which case the products and their
+// inventory items are deleted too, provided no other vendor's product is a
+// variant of them or a kit with one of them as a component.
+func (r *InMemoryRepository) DeleteVendor(id string, version int64, cascade bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.vendors[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+
+	if len(r.purchaseOrdersByVendor.lookup(id)) > 0 {
+		return ErrInUse
+	}
+	products := r.productsByVendor.lookup(id)
+	if len(products) > 0 && !cascade {
+		return ErrInUse
+	}
+	var muts []mutation
+	for productID := range products {
+		if r.productReferenced(productID) {
+			return ErrInUse
+		}
+		for variantID := range r.productsByParent.lookup(productID) {
+			if _, ok := products[variantID]; !ok {
+				return ErrInUse
+			}
+		}
+		for kitID := range r.productsByComponent.lookup(productID) {
+			if _, ok := products[kitID]; !ok {
+				return ErrInUse
+			}
+		}
+		muts = append(muts, r.productDeletions(r.products[productID])...)
+	}
+	muts = append(muts, mutation{Kind: kindVendor, ID: id, Before: existing})
+	return r.commit(muts...)
+}
+
 // Product methods
 
+// checkProductReferences returns ErrInvalidReference unless the product's
+// vendor exists and so do its parent, if it has one, and its components,
+// none of them the product itself. The caller must hold r.mu.
+func (r *InMemoryRepository) checkProductReferences(product *models.Product) error {
+	if _, exists := r.vendors[product.VendorID]; !exists {
+		return ErrInvalidReference
+	}
+	if product.ParentID != "" {
+		if _, exists := r.products[product.ParentID]; !exists || product.ParentID == product.ID {
+			return ErrInvalidReference
+		}
+	}
+	for _, c := range product.Components {
+		if _, exists := r.products[c.ProductID]; !exists || c.ProductID == product.ID {
+			return ErrInvalidReference
+		}
+	}
+	return nil
+}
+
 func (r *InMemoryRepository) CreateProduct(product *models.Product) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -151,9 +443,12 @@ func (r *InMemoryRepository) CreateProduct(product *models.Product) error {
 	if _, exists := r.products[product.ID]; exists {
 		return ErrAlreadyExists
 	}
+	if err := r.checkProductReferences(product); err != nil {
+		return err
+	}
 	product.CreatedAt = time.Now()
-	r.products[product.ID] = product
-	return nil
+	product.Version = 1
+	return r.commit(mutation{Kind: kindProduct, ID: product.ID, After: product})
 }
 
 func (r *InMemoryRepository) GetProduct(id string) (*models.Product, error) {
@@ -164,7 +459,7 @@ func (r *InMemoryRepository) GetProduct(id string) (*models.Product, error) {
 	if !exists {
 		return nil, ErrNotFound
 	}
-	return product, nil
+	return clone(product), nil
 }
 
 func (r *InMemoryRepository) ListProducts() ([]*models.Product, error) {
@@ -173,11 +468,110 @@ func (r *InMemoryRepository) ListProducts() ([]*models.Product, error) {
 
 	products := make([]*models.Product, 0, len(r.products))
 	for _, product := range r.products {
-		products = append(products, product)
+		products = append(products, clone(product))
+	}
+	return products, nil
+}
+
+// FindProducts returns the products matching filter, ordered by ID
+func (r *InMemoryRepository) FindProducts(filter ProductFilter) ([]*models.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids, indexed := narrowest(
+		indexLookup{r.productsByVendor, filter.VendorID},
+		indexLookup{r.productsByCategory, filter.Category},
+		indexLookup{r.productsByParent, filter.ParentID},
+		indexLookup{r.productsByComponent, filter.ComponentID},
+	)
+	if !indexed {
+		ids = keySet(r.products)
 	}
+	products := make([]*models.Product, 0, len(ids))
+	for id := range ids {
+		if product := r.products[id]; filter.matches(product) {
+			products = append(products, clone(product))
+		}
+	}
+	sort.Slice(products, func(i, j int) bool { return products[i].ID < products[j].ID })
 	return products, nil
 }
 
+func (r *InMemoryRepository) UpdateProduct(product *models.Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.products[product.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := r.checkProductReferences(product); err != nil {
+		return err
+	}
+	if err := CheckVersion(existing.Version, product.Version); err != nil {
+		return err
+	}
+	product.CreatedAt = existing.CreatedAt
+	product.Version = existing.Version + 1
+	return r.commit(mutation{Kind: kindProduct, ID: product.ID, Before: existing, After: product})
+}
+
+// DeleteProduct deletes a product. If the product still has inventory items
+// it fails with ErrInUse, unless cascade is set, in which case the items are
+// deleted too. A product with variants or that is a component of a kit
+// cannot be deleted.
+func (r *InMemoryRepository) DeleteProduct(id string, version int64, cascade bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.products[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+
+	muts := r.productDeletions(existing)
+	if len(muts) > 1 && !cascade || r.productReferenced(id) || len(r.productsByParent.lookup(id)) > 0 ||
+		len(r.productsByComponent.lookup(id)) > 0 {
+		return ErrInUse
+	}
+	return r.commit(muts...)
+}
+
+// productReferenced reports whether a sales, purchase or transfer order has a
+// line for the product, a serial is of the product, a price list prices it
+// or a promotion names it. The caller must hold r.mu.
+func (r *InMemoryRepository) productReferenced(id string) bool {
+	return len(r.salesOrdersByProduct.lookup(id)) > 0 || len(r.purchaseOrdersByProduct.lookup(id)) > 0 ||
+		len(r.transferOrdersByProduct.lookup(id)) > 0 || len(r.serialsByProduct.lookup(id)) > 0 ||
+		len(r.priceListsByProduct.lookup(id)) > 0 || len(r.promotionsByProduct.lookup(id)) > 0
+}
+
+// productDeletions returns the mutations that delete product and its
+// inventory items. The caller must hold r.mu.
+func (r *InMemoryRepository) productDeletions(product *models.Product) []mutation {
+	var muts []mutation
+	for itemID := range r.itemsByProduct.lookup(product.ID) {
+		muts = append(muts, r.itemDeletions(r.inventory[itemID])...)
+	}
+	return append(muts, mutation{Kind: kindProduct, ID: product.ID, Before: product})
+}
+
+// itemDeletions returns the mutations that delete item, its ledger and its
+// reservations. The caller must hold r.mu.
+func (r *InMemoryRepository) itemDeletions(item *models.InventoryItem) []mutation {
+	var muts []mutation
+	for id := range r.reservationsByItem.lookup(item.ID) {
+		muts = append(muts, mutation{Kind: kindReservation, ID: id, Before: r.reservations[id]})
+	}
+	for key := range r.movementsByItem.lookup(item.ID) {
+		muts = append(muts, mutation{Kind: kindStockMovement, ID: key, Before: r.movements[key]})
+	}
+	return append(muts, mutation{Kind: kindInventoryItem, ID: item.ID, Before: item})
+}
+
 // Inventory methods
 
 func (r *InMemoryRepository) CreateInventoryItem(item *models.InventoryItem) error {
@@ -187,9 +581,23 @@ func (r *InMemoryRepository) CreateInventoryItem(item *models.InventoryItem) err
 	if _, exists := r.inventory[item.ID]; exists {
 		return ErrAlreadyExists
 	}
+	if _, exists := r.products[item.ProductID]; !exists {
+		return ErrInvalidReference
+	}
+	if item.Quantity < 0 {
+		return ErrInsufficientStock
+	}
+	item.Reserved = 0
+	setAvailable(item)
 	item.UpdatedAt = time.Now()
-	r.inventory[item.ID] = item
-	return nil
+	item.Version = 1
+	muts := []mutation{{Kind: kindInventoryItem, ID: item.ID, After: item}}
+	if item.Quantity != 0 {
+		opening := openingBalance(item)
+		opening.ID = r.lastMovementID + 1
+		muts = append(muts, mutation{Kind: kindStockMovement, ID: movementKey(opening.ID), After: opening})
+	}
+	return r.commit(muts...)
 }
 
 func (r *InMemoryRepository) GetInventoryItem(id string) (*models.InventoryItem, error) {
@@ -200,20 +608,46 @@ func (r *InMemoryRepository) GetInventoryItem(id string) (*models.InventoryItem,
 	if !exists {
 		return nil, ErrNotFound
 	}
-	return item, nil
+	return clone(item), nil
 }
 
-func (r *InMemoryRepository) UpdateInventoryQuantity(id string, quantity int) error {
+func (r *InMemoryRepository) UpdateInventoryItem(item *models.InventoryItem) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	item, exists := r.inventory[id]
+	existing, exists := r.inventory[item.ID]
 	if !exists {
 		return ErrNotFound
 	}
-	item.Quantity = quantity
+	if err := CheckVersion(existing.Version, item.Version); err != nil {
+		return err
+	}
+	if _, exists := r.products[item.ProductID]; !exists {
+		return ErrInvalidReference
+	}
+	item.Quantity = existing.Quantity
+	item.Reserved = existing.Reserved
+	setAvailable(item)
 	item.UpdatedAt = time.Now()
-	return nil
+	item.Version = existing.Version + 1
+	return r.commit(mutation{Kind: kindInventoryItem, ID: item.ID, Before: existing, After: item})
+}
+
+func (r *InMemoryRepository) DeleteInventoryItem(id string, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.inventory[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	if len(r.serialsByItem.lookup(id)) > 0 {
+		return ErrInUse
+	}
+	return r.commit(r.itemDeletions(existing)...)
 }
 
 func (r *InMemoryRepository) ListInventoryItems() ([]*models.InventoryItem, error) {
@@ -222,7 +656,1075 @@ func (r *InMemoryRepository) ListInventoryItems() ([]*models.InventoryItem, erro
 
 	items := make([]*models.InventoryItem, 0, len(r.inventory))
 	for _, item := range r.inventory {
-		items = append(items, item)
+		items = append(items, clone(item))
+	}
+	return items, nil
+}
+
+// FindInventoryItems returns the inventory items matching filter, ordered
+// by ID
+func (r *InMemoryRepository) FindInventoryItems(filter InventoryFilter) ([]*models.InventoryItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids, indexed := narrowest(
+		indexLookup{r.itemsByProduct, filter.ProductID},
+		indexLookup{r.itemsByLocation, filter.Location},
+	)
+	if !indexed {
+		ids = keySet(r.inventory)
 	}
+	items := make([]*models.InventoryItem, 0, len(ids))
+	for id := range ids {
+		if item := r.inventory[id]; filter.matches(item) {
+			items = append(items, clone(item))
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
 	return items, nil
 }
+
+// Stock movement methods
+
+// openingBalance returns the movement that records the quantity a new item
+// was created with
+func openingBalance(item *models.InventoryItem) *models.StockMovement {
+	return &models.StockMovement{
+		ItemID:    item.ID,
+		Type:      models.MovementAdjustment,
+		Delta:     item.Quantity,
+		Balance:   item.Quantity,
+		Unit:      item.Unit,
+		Reason:    models.ReasonOpeningBalance,
+		Actor:     models.SystemActor,
+		CreatedAt: item.UpdatedAt,
+	}
+}
+
+// PostMovement appends movement to its item's ledger and applies the delta
+// to the item's quantity, first releasing the reservation it consumes, if
+// any. It fails with ErrInsufficientStock if the quantity would become
+// negative or the movement removes more than is available.
+func (r *InMemoryRepository) PostMovement(movement *models.StockMovement, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, exists := r.inventory[movement.ItemID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(item.Version, version); err != nil {
+		return err
+	}
+	now := time.Now()
+	var consumed []*models.Reservation
+	if movement.ReservationID != "" {
+		reservation, exists := r.reservations[movement.ReservationID]
+		if !exists || reservation.ItemID != item.ID {
+			return ErrInvalidReference
+		}
+		consumed = append(consumed, reservation)
+	}
+	muts, updated := releases(item, consumed, now)
+	if err := checkOutbound(updated, movement.Delta); err != nil {
+		return err
+	}
+	updated.Quantity = models.RoundQuantity(item.Quantity + movement.Delta)
+	setAvailable(updated)
+
+	movement.ID = r.lastMovementID + 1
+	movement.Balance = updated.Quantity
+	movement.Unit = item.Unit
+	movement.CreatedAt = now
+	return r.commit(append(muts,
+		mutation{Kind: kindInventoryItem, ID: item.ID, Before: item, After: updated},
+		mutation{Kind: kindStockMovement, ID: movementKey(movement.ID), After: movement},
+	)...)
+}
+
+// checkOutbound fails with ErrInsufficientStock if delta would make item's
+// quantity negative or removes more than it has available
+func checkOutbound(item *models.InventoryItem, delta float64) error {
+	if models.RoundQuantity(item.Quantity+delta) < 0 {
+		return ErrInsufficientStock
+	}
+	if delta < 0 && models.RoundQuantity(item.Available+delta) < 0 {
+		return ErrInsufficientStock
+	}
+	return nil
+}
+
+// ListMovements returns an item's ledger, oldest first
+func (r *InMemoryRepository) ListMovements(itemID string) ([]*models.StockMovement, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, exists := r.inventory[itemID]; !exists {
+		return nil, ErrNotFound
+	}
+	keys := r.movementsByItem.lookup(itemID)
+	movements := make([]*models.StockMovement, 0, len(keys))
+	for key := range keys {
+		movements = append(movements, clone(r.movements[key]))
+	}
+	sort.Slice(movements, func(i, j int) bool { return movements[i].ID < movements[j].ID })
+	return movements, nil
+}
+
+// Reservation methods
+
+// setAvailable derives an item's available quantity from its on-hand and
+// reserved quantities
+func setAvailable(item *models.InventoryItem) {
+	item.Available = models.RoundQuantity(item.Quantity - item.Reserved)
+}
+
+// CreateReservation holds stock of the reservation's item. Expired holds
+// on the item are released first. It fails with ErrInvalidReference if the
+// item does not exist and with ErrInsufficientStock if the item has less
+// available than the reservation's quantity.
+func (r *InMemoryRepository) CreateReservation(reservation *models.Reservation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.reservations[reservation.ID]; exists {
+		return ErrAlreadyExists
+	}
+	item, exists := r.inventory[reservation.ItemID]
+	if !exists {
+		return ErrInvalidReference
+	}
+	reservation.CreatedAt = time.Now()
+
+	var expired []*models.Reservation
+	for id := range r.reservationsByItem.lookup(item.ID) {
+		if held := r.reservations[id]; held.Expired(reservation.CreatedAt) {
+			expired = append(expired, held)
+		}
+	}
+	muts, updated := releases(item, expired, reservation.CreatedAt)
+	if updated.Available < reservation.Quantity {
+		return ErrInsufficientStock
+	}
+	updated.Reserved = models.RoundQuantity(updated.Reserved + reservation.Quantity)
+	reservation.Unit = item.Unit
+	setAvailable(updated)
+	muts = append(muts,
+		mutation{Kind: kindInventoryItem, ID: item.ID, Before: item, After: updated},
+		mutation{Kind: kindReservation, ID: reservation.ID, After: reservation},
+	)
+	return r.commit(muts...)
+}
+
+// releases returns the mutations that delete the held reservations of item
+// and a new version of item with their stock returned to it. The caller
+// adds the item's update.
+func releases(item *models.InventoryItem, held []*models.Reservation, now time.Time) ([]mutation, *models.InventoryItem) {
+	updated := *item
+	var muts []mutation
+	for _, reservation := range held {
+		updated.Reserved = models.RoundQuantity(updated.Reserved - reservation.Quantity)
+		muts = append(muts, mutation{Kind: kindReservation, ID: reservation.ID, Before: reservation})
+	}
+	setAvailable(&updated)
+	updated.UpdatedAt = now
+	updated.Version = item.Version + 1
+	return muts, &updated
+}
+
+func (r *InMemoryRepository) GetReservation(id string) (*models.Reservation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reservation, exists := r.reservations[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(reservation), nil
+}
+
+// ListReservations returns an item's reservations, including expired ones
+// not yet released, ordered by ID
+func (r *InMemoryRepository) ListReservations(itemID string) ([]*models.Reservation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, exists := r.inventory[itemID]; !exists {
+		return nil, ErrNotFound
+	}
+	ids := r.reservationsByItem.lookup(itemID)
+	reservations := make([]*models.Reservation, 0, len(ids))
+	for id := range ids {
+		reservations = append(reservations, clone(r.reservations[id]))
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].ID < reservations[j].ID })
+	return reservations, nil
+}
+
+// ReleaseReservation deletes a reservation and returns its stock to its
+// item's available quantity
+func (r *InMemoryRepository) ReleaseReservation(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reservation, exists := r.reservations[id]
+	if !exists {
+		return ErrNotFound
+	}
+	item := r.inventory[reservation.ItemID]
+	muts, updated := releases(item, []*models.Reservation{reservation}, time.Now())
+	return r.commit(append(muts, mutation{Kind: kindInventoryItem, ID: item.ID, Before: item, After: updated})...)
+}
+
+// ReleaseExpiredReservations releases every reservation that has expired
+// at now and returns how many it released
+func (r *InMemoryRepository) ReleaseExpiredReservations(now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expired := make(map[string][]*models.Reservation)
+	count := 0
+	for _, reservation := range r.reservations {
+		if reservation.Expired(now) {
+			expired[reservation.ItemID] = append(expired[reservation.ItemID], reservation)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	var muts []mutation
+	for _, itemID := range slices.Sorted(maps.Keys(expired)) {
+		held := expired[itemID]
+		item := r.inventory[itemID]
+		released, updated := releases(item, held, now)
+		muts = append(muts, released...)
+		muts = append(muts, mutation{Kind: kindInventoryItem, ID: item.ID, Before: item, After: updated})
+	}
+	if err := r.commit(muts...); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Sales order methods
+
+// checkOrderReferences returns ErrInvalidReference unless the order's buyer
+// and the products of its lines exist. The caller must hold r.mu.
+func (r *InMemoryRepository) checkOrderReferences(order *models.SalesOrder) error {
+	if _, exists := r.buyers[order.BuyerID]; !exists {
+		return ErrInvalidReference
+	}
+	for _, line := range order.Lines {
+		if _, exists := r.products[line.ProductID]; !exists {
+			return ErrInvalidReference
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) CreateSalesOrder(order *models.SalesOrder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.salesOrders[order.ID]; exists {
+		return ErrAlreadyExists
+	}
+	if err := r.checkOrderReferences(order); err != nil {
+		return err
+	}
+	order.CreatedAt = time.Now()
+	order.UpdatedAt = order.CreatedAt
+	order.Version = 1
+	return r.commit(mutation{Kind: kindSalesOrder, ID: order.ID, After: order})
+}
+
+func (r *InMemoryRepository) GetSalesOrder(id string) (*models.SalesOrder, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	order, exists := r.salesOrders[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(order), nil
+}
+
+// FindSalesOrders returns the sales orders matching filter, ordered by ID
+func (r *InMemoryRepository) FindSalesOrders(filter SalesOrderFilter) ([]*models.SalesOrder, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids, indexed := narrowest(indexLookup{r.salesOrdersByBuyer, filter.BuyerID})
+	if !indexed {
+		ids = keySet(r.salesOrders)
+	}
+	orders := make([]*models.SalesOrder, 0, len(ids))
+	for id := range ids {
+		if order := r.salesOrders[id]; filter.matches(order) {
+			orders = append(orders, clone(order))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+	return orders, nil
+}
+
+// UpdateSalesOrder replaces an order's buyer, status and lines
+func (r *InMemoryRepository) UpdateSalesOrder(order *models.SalesOrder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.salesOrders[order.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := r.checkOrderReferences(order); err != nil {
+		return err
+	}
+	if err := CheckVersion(existing.Version, order.Version); err != nil {
+		return err
+	}
+	order.CreatedAt = existing.CreatedAt
+	order.UpdatedAt = time.Now()
+	order.Version = existing.Version + 1
+	return r.commit(mutation{Kind: kindSalesOrder, ID: order.ID, Before: existing, After: order})
+}
+
+func (r *InMemoryRepository) DeleteSalesOrder(id string, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.salesOrders[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	return r.commit(mutation{Kind: kindSalesOrder, ID: id, Before: existing})
+}
+
+// Purchase order methods
+
+// checkPurchaseOrderReferences returns ErrInvalidReference unless the
+// order's vendor and the products of its lines exist. The caller must hold
+// r.mu.
+func (r *InMemoryRepository) checkPurchaseOrderReferences(order *models.PurchaseOrder) error {
+	if _, exists := r.vendors[order.VendorID]; !exists {
+		return ErrInvalidReference
+	}
+	for _, line := range order.Lines {
+		if _, exists := r.products[line.ProductID]; !exists {
+			return ErrInvalidReference
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) CreatePurchaseOrder(order *models.PurchaseOrder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.purchaseOrders[order.ID]; exists {
+		return ErrAlreadyExists
+	}
+	if err := r.checkPurchaseOrderReferences(order); err != nil {
+		return err
+	}
+	order.CreatedAt = time.Now()
+	order.UpdatedAt = order.CreatedAt
+	order.Version = 1
+	return r.commit(mutation{Kind: kindPurchaseOrder, ID: order.ID, After: order})
+}
+
+func (r *InMemoryRepository) GetPurchaseOrder(id string) (*models.PurchaseOrder, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	order, exists := r.purchaseOrders[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(order), nil
+}
+
+// FindPurchaseOrders returns the purchase orders matching filter, ordered
+// by ID
+func (r *InMemoryRepository) FindPurchaseOrders(filter PurchaseOrderFilter) ([]*models.PurchaseOrder, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids, indexed := narrowest(
+		indexLookup{r.purchaseOrdersByVendor, filter.VendorID},
+		indexLookup{r.purchaseOrdersByProduct, filter.ProductID},
+	)
+	if !indexed {
+		ids = keySet(r.purchaseOrders)
+	}
+	orders := make([]*models.PurchaseOrder, 0, len(ids))
+	for id := range ids {
+		if order := r.purchaseOrders[id]; filter.matches(order) {
+			orders = append(orders, clone(order))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+	return orders, nil
+}
+
+// UpdatePurchaseOrder replaces every field of an order except ID and
+// CreatedAt
+func (r *InMemoryRepository) UpdatePurchaseOrder(order *models.PurchaseOrder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.purchaseOrders[order.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := r.checkPurchaseOrderReferences(order); err != nil {
+		return err
+	}
+	if err := CheckVersion(existing.Version, order.Version); err != nil {
+		return err
+	}
+	order.CreatedAt = existing.CreatedAt
+	order.UpdatedAt = time.Now()
+	order.Version = existing.Version + 1
+	return r.commit(mutation{Kind: kindPurchaseOrder, ID: order.ID, Before: existing, After: order})
+}
+
+func (r *InMemoryRepository) DeletePurchaseOrder(id string, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.purchaseOrders[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	return r.commit(mutation{Kind: kindPurchaseOrder, ID: id, Before: existing})
+}
+
+// Location methods
+
+// checkLocationParent returns ErrInvalidReference if location has a parent
+// that does not exist
+func (r *InMemoryRepository) checkLocationParent(location *models.Location) error {
+	if location.ParentID == "" {
+		return nil
+	}
+	if _, exists := r.locations[location.ParentID]; !exists {
+		return ErrInvalidReference
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) CreateLocation(location *models.Location) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.locations[location.ID]; exists {
+		return ErrAlreadyExists
+	}
+	if err := r.checkLocationParent(location); err != nil {
+		return err
+	}
+	location.CreatedAt = time.Now()
+	location.Version = 1
+	return r.commit(mutation{Kind: kindLocation, ID: location.ID, After: location})
+}
+
+func (r *InMemoryRepository) GetLocation(id string) (*models.Location, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	location, exists := r.locations[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(location), nil
+}
+
+// FindLocations returns the locations matching filter, ordered by ID
+func (r *InMemoryRepository) FindLocations(filter LocationFilter) ([]*models.Location, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids, indexed := narrowest(indexLookup{r.locationsByParent, filter.ParentID})
+	if !indexed {
+		ids = keySet(r.locations)
+	}
+	locations := make([]*models.Location, 0, len(ids))
+	for id := range ids {
+		if location := r.locations[id]; filter.matches(location) {
+			locations = append(locations, clone(location))
+		}
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i].ID < locations[j].ID })
+	return locations, nil
+}
+
+func (r *InMemoryRepository) UpdateLocation(location *models.Location) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.locations[location.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := r.checkLocationParent(location); err != nil {
+		return err
+	}
+	if err := CheckVersion(existing.Version, location.Version); err != nil {
+		return err
+	}
+	location.CreatedAt = existing.CreatedAt
+	location.Version = existing.Version + 1
+	return r.commit(mutation{Kind: kindLocation, ID: location.ID, Before: existing, After: location})
+}
+
+// DeleteLocation deletes a location. It fails with ErrInUse if the location
+// has child locations, holds inventory items or is on a transfer order.
+func (r *InMemoryRepository) DeleteLocation(id string, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.locations[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	if len(r.locationsByParent.lookup(id)) > 0 || len(r.itemsByLocation.lookup(id)) > 0 ||
+		len(r.transferOrdersByLocation.lookup(id)) > 0 {
+		return ErrInUse
+	}
+	return r.commit(mutation{Kind: kindLocation, ID: id, Before: existing})
+}
+
+// Transfer order methods
+
+// checkTransferOrderReferences returns ErrInvalidReference unless the
+// order's locations and the products of its lines exist. The caller must
+// hold r.mu.
+func (r *InMemoryRepository) checkTransferOrderReferences(order *models.TransferOrder) error {
+	for _, id := range []string{order.FromLocation, order.ToLocation} {
+		if _, exists := r.locations[id]; !exists {
+			return ErrInvalidReference
+		}
+	}
+	for _, line := range order.Lines {
+		if _, exists := r.products[line.ProductID]; !exists {
+			return ErrInvalidReference
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) CreateTransferOrder(order *models.TransferOrder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.transferOrders[order.ID]; exists {
+		return ErrAlreadyExists
+	}
+	if err := r.checkTransferOrderReferences(order); err != nil {
+		return err
+	}
+	order.CreatedAt = time.Now()
+	order.UpdatedAt = order.CreatedAt
+	order.Version = 1
+	return r.commit(mutation{Kind: kindTransferOrder, ID: order.ID, After: order})
+}
+
+func (r *InMemoryRepository) GetTransferOrder(id string) (*models.TransferOrder, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	order, exists := r.transferOrders[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(order), nil
+}
+
+// FindTransferOrders returns the transfer orders matching filter, ordered
+// by ID
+func (r *InMemoryRepository) FindTransferOrders(filter TransferOrderFilter) ([]*models.TransferOrder, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids, indexed := narrowest(
+		indexLookup{r.transferOrdersByLocation, filter.FromLocation},
+		indexLookup{r.transferOrdersByLocation, filter.ToLocation},
+		indexLookup{r.transferOrdersByProduct, filter.ProductID},
+	)
+	if !indexed {
+		ids = keySet(r.transferOrders)
+	}
+	orders := make([]*models.TransferOrder, 0, len(ids))
+	for id := range ids {
+		if order := r.transferOrders[id]; filter.matches(order) {
+			orders = append(orders, clone(order))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+	return orders, nil
+}
+
+// UpdateTransferOrder replaces every field of an order except ID and
+// CreatedAt
+func (r *InMemoryRepository) UpdateTransferOrder(order *models.TransferOrder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.transferOrders[order.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := r.checkTransferOrderReferences(order); err != nil {
+		return err
+	}
+	if err := CheckVersion(existing.Version, order.Version); err != nil {
+		return err
+	}
+	order.CreatedAt = existing.CreatedAt
+	order.UpdatedAt = time.Now()
+	order.Version = existing.Version + 1
+	return r.commit(mutation{Kind: kindTransferOrder, ID: order.ID, Before: existing, After: order})
+}
+
+func (r *InMemoryRepository) DeleteTransferOrder(id string, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.transferOrders[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	return r.commit(mutation{Kind: kindTransferOrder, ID: id, Before: existing})
+}
+
+// Serial methods
+
+// checkSerialReferences returns ErrInvalidReference unless the serial's
+// product and item exist, and its buyer if it has one. The caller must hold
+// r.mu.
+func (r *InMemoryRepository) checkSerialReferences(serial *models.Serial) error {
+	if _, exists := r.products[serial.ProductID]; !exists {
+		return ErrInvalidReference
+	}
+	if _, exists := r.inventory[serial.ItemID]; !exists {
+		return ErrInvalidReference
+	}
+	if serial.BuyerID != "" {
+		if _, exists := r.buyers[serial.BuyerID]; !exists {
+			return ErrInvalidReference
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) CreateSerial(serial *models.Serial) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.serials[serial.ID]; exists {
+		return ErrAlreadyExists
+	}
+	if err := r.checkSerialReferences(serial); err != nil {
+		return err
+	}
+	serial.CreatedAt = time.Now()
+	serial.UpdatedAt = serial.CreatedAt
+	serial.Version = 1
+	return r.commit(mutation{Kind: kindSerial, ID: serial.ID, After: serial})
+}
+
+func (r *InMemoryRepository) GetSerial(id string) (*models.Serial, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	serial, exists := r.serials[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(serial), nil
+}
+
+// FindSerials returns the serials matching filter, ordered by ID
+func (r *InMemoryRepository) FindSerials(filter SerialFilter) ([]*models.Serial, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids, indexed := narrowest(
+		indexLookup{r.serialsByProduct, filter.ProductID},
+		indexLookup{r.serialsByItem, filter.ItemID},
+		indexLookup{r.serialsByBuyer, filter.BuyerID},
+	)
+	if !indexed {
+		ids = keySet(r.serials)
+	}
+	serials := make([]*models.Serial, 0, len(ids))
+	for id := range ids {
+		if serial := r.serials[id]; filter.matches(serial) {
+			serials = append(serials, clone(serial))
+		}
+	}
+	sort.Slice(serials, func(i, j int) bool { return serials[i].ID < serials[j].ID })
+	return serials, nil
+}
+
+// UpdateSerial replaces every field of a serial except ID and CreatedAt
+func (r *InMemoryRepository) UpdateSerial(serial *models.Serial) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.serials[serial.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := r.checkSerialReferences(serial); err != nil {
+		return err
+	}
+	if err := CheckVersion(existing.Version, serial.Version); err != nil {
+		return err
+	}
+	serial.CreatedAt = existing.CreatedAt
+	serial.UpdatedAt = time.Now()
+	serial.Version = existing.Version + 1
+	return r.commit(mutation{Kind: kindSerial, ID: serial.ID, Before: existing, After: serial})
+}
+
+func (r *InMemoryRepository) DeleteSerial(id string, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.serials[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	return r.commit(mutation{Kind: kindSerial, ID: id, Before: existing})
+}
+
+// Price list methods
+
+// checkPriceListProducts returns ErrInvalidReference unless the product of
+// every price on the list exists. The caller must hold r.mu.
+func (r *InMemoryRepository) checkPriceListProducts(list *models.PriceList) error {
+	for _, price := range list.Prices {
+		if _, exists := r.products[price.ProductID]; !exists {
+			return ErrInvalidReference
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) CreatePriceList(list *models.PriceList) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.priceLists[list.ID]; exists {
+		return ErrAlreadyExists
+	}
+	if err := r.checkPriceListProducts(list); err != nil {
+		return err
+	}
+	list.CreatedAt = time.Now()
+	list.Version = 1
+	return r.commit(mutation{Kind: kindPriceList, ID: list.ID, After: list})
+}
+
+func (r *InMemoryRepository) GetPriceList(id string) (*models.PriceList, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list, exists := r.priceLists[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(list), nil
+}
+
+// ListPriceLists returns every price list, ordered by ID
+func (r *InMemoryRepository) ListPriceLists() ([]*models.PriceList, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	lists := make([]*models.PriceList, 0, len(r.priceLists))
+	for _, list := range r.priceLists {
+		lists = append(lists, clone(list))
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].ID < lists[j].ID })
+	return lists, nil
+}
+
+func (r *InMemoryRepository) UpdatePriceList(list *models.PriceList) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.priceLists[list.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := r.checkPriceListProducts(list); err != nil {
+		return err
+	}
+	if err := CheckVersion(existing.Version, list.Version); err != nil {
+		return err
+	}
+	list.CreatedAt = existing.CreatedAt
+	list.Version = existing.Version + 1
+	return r.commit(mutation{Kind: kindPriceList, ID: list.ID, Before: existing, After: list})
+}
+
+// DeletePriceList deletes a price list. It fails with ErrInUse if a buyer
+// is assigned the list.
+func (r *InMemoryRepository) DeletePriceList(id string, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.priceLists[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	if len(r.buyersByPriceList.lookup(id)) > 0 {
+		return ErrInUse
+	}
+	return r.commit(mutation{Kind: kindPriceList, ID: id, Before: existing})
+}
+
+// Promotion methods
+
+// checkPromotionProducts returns ErrInvalidReference unless every product
+// the promotion names exists. The caller must hold r.mu.
+func (r *InMemoryRepository) checkPromotionProducts(promotion *models.Promotion) error {
+	for _, id := range promotion.ProductIDs {
+		if _, exists := r.products[id]; !exists {
+			return ErrInvalidReference
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) CreatePromotion(promotion *models.Promotion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.promotions[promotion.ID]; exists {
+		return ErrAlreadyExists
+	}
+	if err := r.checkPromotionProducts(promotion); err != nil {
+		return err
+	}
+	promotion.CreatedAt = time.Now()
+	promotion.Version = 1
+	return r.commit(mutation{Kind: kindPromotion, ID: promotion.ID, After: promotion})
+}
+
+func (r *InMemoryRepository) GetPromotion(id string) (*models.Promotion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	promotion, exists := r.promotions[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(promotion), nil
+}
+
+// ListPromotions returns every promotion, ordered by ID
+func (r *InMemoryRepository) ListPromotions() ([]*models.Promotion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	promotions := make([]*models.Promotion, 0, len(r.promotions))
+	for _, promotion := range r.promotions {
+		promotions = append(promotions, clone(promotion))
+	}
+	sort.Slice(promotions, func(i, j int) bool { return promotions[i].ID < promotions[j].ID })
+	return promotions, nil
+}
+
+func (r *InMemoryRepository) UpdatePromotion(promotion *models.Promotion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.promotions[promotion.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := r.checkPromotionProducts(promotion); err != nil {
+		return err
+	}
+	if err := CheckVersion(existing.Version, promotion.Version); err != nil {
+		return err
+	}
+	promotion.CreatedAt = existing.CreatedAt
+	promotion.Version = existing.Version + 1
+	return r.commit(mutation{Kind: kindPromotion, ID: promotion.ID, Before: existing, After: promotion})
+}
+
+func (r *InMemoryRepository) DeletePromotion(id string, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.promotions[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	return r.commit(mutation{Kind: kindPromotion, ID: id, Before: existing})
+}
+
+// Snapshots
+
+// snapshotReader names the Reader a memorySnapshot embeds, so that the
+// embedded field is unexported
+type snapshotReader = Reader
+
+// memorySnapshot reads a repository that shares the maps another held at
+// one instant. The repository copies its maps before its next write, so the
+// shared ones never change; entity pointers are shared too, which is safe
+// because stored entities are never modified in place. The change log,
+// which a Snapshot does not read, is not shared. Only the read methods are
+// exposed: a write to the shared maps would change the live store without
+// its lock or journal.
+type memorySnapshot struct {
+	snapshotReader
+}
+
+// Snapshot shares the repository's maps with the snapshot and marks them
+// copy-on-write. Taking a snapshot copies nothing, but the first write after
+// it clones every entity map and index under the write lock, in time
+// proportional to the number of stored entities.
+func (r *InMemoryRepository) Snapshot() (Snapshot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.shared = true
+	return memorySnapshot{&InMemoryRepository{
+		sellers:   r.sellers,
+		buyers:    r.buyers,
+		vendors:   r.vendors,
+		products:  r.products,
+		inventory: r.inventory,
+		movements: r.movements,
+
+		reservations: r.reservations,
+		salesOrders:  r.salesOrders,
+
+		purchaseOrders: r.purchaseOrders,
+		locations:      r.locations,
+		transferOrders: r.transferOrders,
+		serials:        r.serials,
+		priceLists:     r.priceLists,
+		promotions:     r.promotions,
+
+		productsByVendor:   r.productsByVendor,
+		productsByCategory: r.productsByCategory,
+		productsByParent:   r.productsByParent,
+		itemsByProduct:     r.itemsByProduct,
+		itemsByLocation:    r.itemsByLocation,
+		movementsByItem:    r.movementsByItem,
+		reservationsByItem: r.reservationsByItem,
+
+		productsByComponent: r.productsByComponent,
+
+		salesOrdersByBuyer:   r.salesOrdersByBuyer,
+		salesOrdersByProduct: r.salesOrdersByProduct,
+
+		purchaseOrdersByVendor:  r.purchaseOrdersByVendor,
+		purchaseOrdersByProduct: r.purchaseOrdersByProduct,
+
+		locationsByParent: r.locationsByParent,
+
+		transferOrdersByLocation: r.transferOrdersByLocation,
+		transferOrdersByProduct:  r.transferOrdersByProduct,
+
+		serialsByProduct: r.serialsByProduct,
+		serialsByItem:    r.serialsByItem,
+		serialsByBuyer:   r.serialsByBuyer,
+
+		priceListsByProduct: r.priceListsByProduct,
+		buyersByPriceList:   r.buyersByPriceList,
+
+		promotionsByProduct: r.promotionsByProduct,
+
+		lastMovementID: r.lastMovementID,
+	}}, nil
+}
+
+// unshare gives the repository its own copies of maps it shares with a
+// snapshot. mu must be held for writing.
+func (r *InMemoryRepository) unshare() {
+	if !r.shared {
+		return
+	}
+	r.shared = false
+
+	r.sellers = maps.Clone(r.sellers)
+	r.buyers = maps.Clone(r.buyers)
+	r.vendors = maps.Clone(r.vendors)
+	r.products = maps.Clone(r.products)
+	r.inventory = maps.Clone(r.inventory)
+	r.movements = maps.Clone(r.movements)
+
+	r.reservations = maps.Clone(r.reservations)
+	r.salesOrders = maps.Clone(r.salesOrders)
+
+	r.purchaseOrders = maps.Clone(r.purchaseOrders)
+	r.locations = maps.Clone(r.locations)
+	r.transferOrders = maps.Clone(r.transferOrders)
+	r.serials = maps.Clone(r.serials)
+	r.priceLists = maps.Clone(r.priceLists)
+	r.promotions = maps.Clone(r.promotions)
+
+	r.productsByVendor = r.productsByVendor.clone()
+	r.productsByCategory = r.productsByCategory.clone()
+	r.productsByParent = r.productsByParent.clone()
+	r.itemsByProduct = r.itemsByProduct.clone()
+	r.itemsByLocation = r.itemsByLocation.clone()
+	r.movementsByItem = r.movementsByItem.clone()
+	r.reservationsByItem = r.reservationsByItem.clone()
+
+	r.productsByComponent = r.productsByComponent.clone()
+
+	r.salesOrdersByBuyer = r.salesOrdersByBuyer.clone()
+	r.salesOrdersByProduct = r.salesOrdersByProduct.clone()
+
+	r.purchaseOrdersByVendor = r.purchaseOrdersByVendor.clone()
+	r.purchaseOrdersByProduct = r.purchaseOrdersByProduct.clone()
+
+	r.locationsByParent = r.locationsByParent.clone()
+
+	r.transferOrdersByLocation = r.transferOrdersByLocation.clone()
+	r.transferOrdersByProduct = r.transferOrdersByProduct.clone()
+
+	r.serialsByProduct = r.serialsByProduct.clone()
+	r.serialsByItem = r.serialsByItem.clone()
+	r.serialsByBuyer = r.serialsByBuyer.clone()
+
+	r.priceListsByProduct = r.priceListsByProduct.clone()
+	r.buyersByPriceList = r.buyersByPriceList.clone()
+
+	r.promotionsByProduct = r.promotionsByProduct.clone()
+}
+
+func (memorySnapshot) Close() error {
+	return nil
+}
@@ -1,11 +1,18 @@
 package repository
 
 import (
+	"encoding/json"
+	"sync"
 	"testing"
 
 	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
 )
 
+// usd returns minor cents of US dollars
+func usd(minor int64) models.Money {
+	return models.Money{Minor: minor, Currency: "USD"}
+}
+
 func TestCreateAndGetSeller(t *testing.T) {
 	repo := NewInMemoryRepository()
 
@@ -122,7 +129,7 @@ func TestCreateProductAndInventory(t *testing.T) {
 		Name:        "Fertilizer",
 		Description: "Organic fertilizer",
 		Category:    "Soil Amendments",
-		Price:       29.99,
+		Price:       usd(2999),
 		VendorID:    "v1",
 	}
 	if err := repo.CreateProduct(product); err != nil {
@@ -147,11 +154,11 @@ func TestCreateProductAndInventory(t *testing.T) {
 	}
 
 	if retrieved.Quantity != 100 {
-		t.Errorf("Expected quantity 100, got %d", retrieved.Quantity)
+		t.Errorf("Expected quantity 100, got %v", retrieved.Quantity)
 	}
 }
 
-func TestUpdateInventoryQuantity(t *testing.T) {
+func TestPostMovementUpdatesQuantity(t *testing.T) {
 	repo := NewInMemoryRepository()
 
 	// Create vendor first
@@ -185,10 +192,10 @@ func TestUpdateInventoryQuantity(t *testing.T) {
 		t.Fatalf("Failed to create inventory item: %v", err)
 	}
 
-	// Update quantity
-	err := repo.UpdateInventoryQuantity("i1", 50)
-	if err != nil {
-		t.Fatalf("Failed to update quantity: %v", err)
+	// Ship 50 units
+	shipment := &models.StockMovement{ItemID: "i1", Type: models.MovementShipment, Delta: -50, Reason: "sale", Actor: "alice"}
+	if err := repo.PostMovement(shipment, 0); err != nil {
+		t.Fatalf("Failed to post movement: %v", err)
 	}
 
 	// Verify update
@@ -198,7 +205,7 @@ func TestUpdateInventoryQuantity(t *testing.T) {
 	}
 
 	if updated.Quantity != 50 {
-		t.Errorf("Expected quantity 50, got %d", updated.Quantity)
+		t.Errorf("Expected quantity 50, got %v", updated.Quantity)
 	}
 }
 
@@ -230,3 +237,52 @@ func TestGetNonExistentEntity(t *testing.T) {
 		t.Errorf("Expected ErrNotFound, got %v", err)
 	}
 }
+
+// TestReadsDoNotAliasStoredState is meant to be run with -race: callers
+// encode and modify what they read while writers update the same entity
+func TestReadsDoNotAliasStoredState(t *testing.T) {
+	repo := NewInMemoryRepository()
+	if err := repo.CreateSeller(&models.Seller{ID: "s1", Name: "Alice"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			repo.UpdateSeller(&models.Seller{ID: "s1", Name: "Bob"})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			seller, err := repo.GetSeller("s1")
+			if err != nil {
+				t.Errorf("Failed to get seller: %v", err)
+				return
+			}
+			json.Marshal(seller)
+			seller.Name = "Changed"
+		}
+	}()
+	wg.Wait()
+
+	seller, _ := repo.GetSeller("s1")
+	if seller.Name != "Bob" {
+		t.Errorf("Expected Bob, got %s", seller.Name)
+	}
+}
+
+func TestSnapshotIsReadOnly(t *testing.T) {
+	repo := NewInMemoryRepository()
+	snap, err := repo.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	defer snap.Close()
+
+	if _, ok := snap.(SellerStore); ok {
+		t.Error("Expected a snapshot not to expose write methods")
+	}
+}
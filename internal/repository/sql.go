@@ -0,0 +1,2765 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository/migrations"
+)
+
+var ErrSchemaVersion = errors.New("database schema is not at the expected version")
+
+// SQLRepository stores entities in a relational database through
+// database/sql. Queries use ? placeholders. The schema is managed by
+// package migrations. Updates and deletes only apply to the version of a row
+// they read, so concurrent writers cannot lose each other's changes whatever
+// the isolation level.
+type SQLRepository struct {
+	db *sql.DB
+	tx *sql.Tx // set when the repository is a unit of work
+
+	// changed is broadcast when a commit adds to the change log
+	changed *broadcaster
+}
+
+var _ Store = (*SQLRepository)(nil)
+
+// NewSQLRepository creates a repository on db. It returns ErrSchemaVersion
+// unless every migration has been applied.
+func NewSQLRepository(db *sql.DB) (*SQLRepository, error) {
+	version, err := migrations.Version(db)
+	if err != nil {
+		return nil, err
+	}
+	if latest := migrations.Latest(); version != latest {
+		return nil, fmt.Errorf("%w: have %d, want %d", ErrSchemaVersion, version, latest)
+	}
+	return &SQLRepository{db: db, changed: newBroadcaster()}, nil
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// conn returns the transaction of a unit of work, or the database
+func (r *SQLRepository) conn() querier {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// sqlTx is a unit of work on an SQLRepository, backed by a database
+// transaction
+type sqlTx struct {
+	*SQLRepository
+}
+
+// Begin starts a unit of work in a database transaction
+func (r *SQLRepository) Begin() (Tx, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return sqlTx{&SQLRepository{db: r.db, tx: tx, changed: r.changed}}, nil
+}
+
+func (tx sqlTx) Commit() error {
+	if err := tx.tx.Commit(); err != nil {
+		return translateTxDone(err)
+	}
+	tx.changed.broadcast()
+	return nil
+}
+
+func (tx sqlTx) Rollback() error {
+	return translateTxDone(tx.tx.Rollback())
+}
+
+// sqlSnapshot is a read-only database transaction
+type sqlSnapshot struct {
+	*SQLRepository
+}
+
+// Snapshot starts a read-only transaction. Depending on the database and
+// its isolation level, writers may wait for it to be closed.
+func (r *SQLRepository) Snapshot() (Snapshot, error) {
+	tx, err := r.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelSerializable})
+	if err != nil {
+		return nil, err
+	}
+	// Databases take the snapshot at the first read, not at BEGIN
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM schema_version`).Scan(&n); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return sqlSnapshot{&SQLRepository{db: r.db, tx: tx}}, nil
+}
+
+func (s sqlSnapshot) Close() error {
+	return translateTxDone(s.tx.Rollback())
+}
+
+// translateTxDone maps sql.ErrTxDone to ErrTxDone
+func translateTxDone(err error) error {
+	if err == sql.ErrTxDone {
+		return ErrTxDone
+	}
+	return err
+}
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// inTx runs fn in a transaction, committing if it returns nil. In a unit of
+// work fn runs in a savepoint of its transaction instead, so that a failed
+// operation leaves nothing behind.
+func (r *SQLRepository) inTx(fn func(tx *sql.Tx) error) error {
+	if r.tx != nil {
+		if _, err := r.tx.Exec(`SAVEPOINT operation`); err != nil {
+			return err
+		}
+		if err := fn(r.tx); err != nil {
+			if _, rbErr := r.tx.Exec(`ROLLBACK TO SAVEPOINT operation`); rbErr != nil {
+				return rbErr
+			}
+			return err
+		}
+		_, err := r.tx.Exec(`RELEASE SAVEPOINT operation`)
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.changed.broadcast()
+	return nil
+}
+
+// exists reports whether table has a row with the given id
+func exists(tx *sql.Tx, table, id string) (bool, error) {
+	var one int
+	err := tx.QueryRow(`SELECT 1 FROM `+table+` WHERE id = ?`, id).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// requireReference returns ErrInvalidReference unless table has a row with
+// the given id
+func requireReference(tx *sql.Tx, table, id string) error {
+	ok, err := exists(tx, table, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidReference
+	}
+	return nil
+}
+
+// requireAbsent returns ErrAlreadyExists if table has a row with the given
+// id
+func requireAbsent(tx *sql.Tx, table, id string) error {
+	ok, err := exists(tx, table, id)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+// insert runs an INSERT after checking that id is not already in table
+func insert(tx *sql.Tx, table, id, query string, args ...any) error {
+	if err := requireAbsent(tx, table, id); err != nil {
+		return err
+	}
+	_, err := tx.Exec(query, args...)
+	return err
+}
+
+// execOne runs a statement that must affect exactly one row, returning
+// ErrNotFound when it affects none
+func execOne(tx *sql.Tx, query string, args ...any) error {
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// execVersioned runs an UPDATE or DELETE of one row whose last condition is
+// `version = ?`, given the version the row was read at, returning
+// ErrVersionMismatch when it affects none because another writer changed
+// the row since
+func execVersioned(tx *sql.Tx, query string, args ...any) error {
+	if err := execOne(tx, query, args...); err != nil {
+		if err == ErrNotFound {
+			return ErrVersionMismatch
+		}
+		return err
+	}
+	return nil
+}
+
+// requireUnreferenced returns ErrInUse if table has a row whose column
+// holds id
+func requireUnreferenced(tx *sql.Tx, table, column, id string) error {
+	var one int
+	err := tx.QueryRow(`SELECT 1 FROM `+table+` WHERE `+column+` = ? LIMIT 1`, id).Scan(&one)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ErrInUse
+}
+
+// notFound maps sql.ErrNoRows to ErrNotFound
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// selectRows runs query and scans every row it returns with scan
+func selectRows[T any](q querier, scan func(scanner) (*T, error), query string, args ...any) ([]*T, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]*T, 0)
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// Seller methods
+
+const sellerColumns = `id, name, email, phone, created_at, version`
+
+func scanSeller(row scanner) (*models.Seller, error) {
+	var seller models.Seller
+	err := row.Scan(&seller.ID, &seller.Name, &seller.Email, &seller.Phone, &seller.CreatedAt, &seller.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &seller, nil
+}
+
+func getSeller(q querier, id string) (*models.Seller, error) {
+	return scanSeller(q.QueryRow(`SELECT `+sellerColumns+` FROM sellers WHERE id = ?`, id))
+}
+
+func (r *SQLRepository) CreateSeller(seller *models.Seller) error {
+	seller.CreatedAt = time.Now()
+	seller.Version = 1
+	return r.inTx(func(tx *sql.Tx) error {
+		err := insert(tx, "sellers", seller.ID,
+			`INSERT INTO sellers (`+sellerColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+			seller.ID, seller.Name, seller.Email, seller.Phone, seller.CreatedAt, seller.Version)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, kindSeller, seller.ID, nil, seller)
+	})
+}
+
+func (r *SQLRepository) GetSeller(id string) (*models.Seller, error) {
+	return getSeller(r.conn(), id)
+}
+
+func (r *SQLRepository) ListSellers() ([]*models.Seller, error) {
+	return selectRows(r.conn(), scanSeller, `SELECT `+sellerColumns+` FROM sellers ORDER BY id`)
+}
+
+func (r *SQLRepository) UpdateSeller(seller *models.Seller) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getSeller(tx, seller.ID)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, seller.Version); err != nil {
+			return err
+		}
+		seller.CreatedAt = existing.CreatedAt
+		seller.Version = existing.Version + 1
+		err = execVersioned(tx, `UPDATE sellers SET name = ?, email = ?, phone = ?, version = ?
+			WHERE id = ? AND version = ?`,
+			seller.Name, seller.Email, seller.Phone, seller.Version, seller.ID, existing.Version)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, kindSeller, seller.ID, existing, seller)
+	})
+}
+
+func (r *SQLRepository) DeleteSeller(id string, version int64) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getSeller(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		if err := execVersioned(tx, `DELETE FROM sellers WHERE id = ? AND version = ?`, id, existing.Version); err != nil {
+			return err
+		}
+		return recordChange(tx, kindSeller, id, existing, nil)
+	})
+}
+
+// Buyer methods
+
+const buyerColumns = `id, name, email, phone, address, price_list_id, created_at, version`
+
+func scanBuyer(row scanner) (*models.Buyer, error) {
+	var buyer models.Buyer
+	err := row.Scan(&buyer.ID, &buyer.Name, &buyer.Email, &buyer.Phone, &buyer.Address, &buyer.PriceListID,
+		&buyer.CreatedAt, &buyer.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &buyer, nil
+}
+
+func getBuyer(q querier, id string) (*models.Buyer, error) {
+	return scanBuyer(q.QueryRow(`SELECT `+buyerColumns+` FROM buyers WHERE id = ?`, id))
+}
+
+// requireBuyerPriceList returns ErrInvalidReference if buyer is assigned
+// a price list that does not exist
+func requireBuyerPriceList(tx *sql.Tx, buyer *models.Buyer) error {
+	if buyer.PriceListID == "" {
+		return nil
+	}
+	return requireReference(tx, "price_lists", buyer.PriceListID)
+}
+
+func (r *SQLRepository) CreateBuyer(buyer *models.Buyer) error {
+	buyer.CreatedAt = time.Now()
+	buyer.Version = 1
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := requireAbsent(tx, "buyers", buyer.ID); err != nil {
+			return err
+		}
+		if err := requireBuyerPriceList(tx, buyer); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO buyers (`+buyerColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			buyer.ID, buyer.Name, buyer.Email, buyer.Phone, buyer.Address, buyer.PriceListID,
+			buyer.CreatedAt, buyer.Version)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, kindBuyer, buyer.ID, nil, buyer)
+	})
+}
+
+func (r *SQLRepository) GetBuyer(id string) (*models.Buyer, error) {
+	return getBuyer(r.conn(), id)
+}
+
+func (r *SQLRepository) ListBuyers() ([]*models.Buyer, error) {
+	return selectRows(r.conn(), scanBuyer, `SELECT `+buyerColumns+` FROM buyers ORDER BY id`)
+}
+
+func (r *SQLRepository) UpdateBuyer(buyer *models.Buyer) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getBuyer(tx, buyer.ID)
+		if err != nil {
+			return err
+		}
+		if err := requireBuyerPriceList(tx, buyer); err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, buyer.Version); err != nil {
+			return err
+		}
+		buyer.CreatedAt = existing.CreatedAt
+		buyer.Version = existing.Version + 1
+		err = execVersioned(tx, `UPDATE buyers SET name = ?, email = ?, phone = ?, address = ?, price_list_id = ?,
+			version = ? WHERE id = ? AND version = ?`,
+			buyer.Name, buyer.Email, buyer.Phone, buyer.Address, buyer.PriceListID, buyer.Version, buyer.ID,
+			existing.Version)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, kindBuyer, buyer.ID, existing, buyer)
+	})
+}
+
+func (r *SQLRepository) DeleteBuyer(id string, version int64) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getBuyer(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		if err := requireUnreferenced(tx, "sales_orders", "buyer_id", id); err != nil {
+			return err
+		}
+		if err := requireUnreferenced(tx, "serials", "buyer_id", id); err != nil {
+			return err
+		}
+		if err := execVersioned(tx, `DELETE FROM buyers WHERE id = ? AND version = ?`, id, existing.Version); err != nil {
+			return err
+		}
+		return recordChange(tx, kindBuyer, id, existing, nil)
+	})
+}
+
+// Vendor methods
+
+const vendorColumns = `id, name, email, phone, address, created_at, version`
+
+func scanVendor(row scanner) (*models.Vendor, error) {
+	var vendor models.Vendor
+	err := row.Scan(&vendor.ID, &vendor.Name, &vendor.Email, &vendor.Phone, &vendor.Address, &vendor.CreatedAt, &vendor.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &vendor, nil
+}
+
+func getVendor(q querier, id string) (*models.Vendor, error) {
+	return scanVendor(q.QueryRow(`SELECT `+vendorColumns+` FROM vendors WHERE id = ?`, id))
+}
+
+func (r *SQLRepository) CreateVendor(vendor *models.Vendor) error {
+	vendor.CreatedAt = time.Now()
+	vendor.Version = 1
+	return r.inTx(func(tx *sql.Tx) error {
+		err := insert(tx, "vendors", vendor.ID,
+			`INSERT INTO vendors (`+vendorColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			vendor.ID, vendor.Name, vendor.Email, vendor.Phone, vendor.Address, vendor.CreatedAt, vendor.Version)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, kindVendor, vendor.ID, nil, vendor)
+	})
+}
+
+func (r *SQLRepository) GetVendor(id string) (*models.Vendor, error) {
+	return getVendor(r.conn(), id)
+}
+
+func (r *SQLRepository) ListVendors() ([]*models.Vendor, error) {
+	return selectRows(r.conn(), scanVendor, `SELECT `+vendorColumns+` FROM vendors ORDER BY id`)
+}
+
+func (r *SQLRepository) UpdateVendor(vendor *models.Vendor) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getVendor(tx, vendor.ID)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, vendor.Version); err != nil {
+			return err
+		}
+		vendor.CreatedAt = existing.CreatedAt
+		vendor.Version = existing.Version + 1
+		err = execVersioned(tx, `UPDATE vendors SET name = ?, email = ?, phone = ?, address = ?, version = ?
+			WHERE id = ? AND version = ?`,
+			vendor.Name, vendor.Email, vendor.Phone, vendor.Address, vendor.Version, vendor.ID, existing.Version)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, kindVendor, vendor.ID, existing, vendor)
+	})
+}
+
+// DeleteVendor deletes a vendor. If the vendor still has products it fails
+// with ErrInUse, unless cascade is set, in which case the products and their
+// inventory items are deleted too, provided no other vendor's product is a
+// variant of them or a kit with one of them as a component.
+func (r *SQLRepository) DeleteVendor(id string, version int64, cascade bool) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getVendor(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+
+		if err := requireUnreferenced(tx, "purchase_orders", "vendor_id", id); err != nil {
+			return err
+		}
+		// Kits go first, before their components, and variants before their
+		// parents
+		products, err := selectProducts(tx, `SELECT `+productColumns+` FROM products WHERE vendor_id = ?
+			ORDER BY NOT EXISTS (SELECT 1 FROM product_components c WHERE c.product_id = products.id),
+				parent_id = '', id`, id)
+		if err != nil {
+			return err
+		}
+		if len(products) > 0 && !cascade {
+			return ErrInUse
+		}
+		for _, product := range products {
+			if err := deleteProduct(tx, product); err != nil {
+				return err
+			}
+		}
+		if err := execVersioned(tx, `DELETE FROM vendors WHERE id = ? AND version = ?`, id, existing.Version); err != nil {
+			return err
+		}
+		return recordChange(tx, kindVendor, id, existing, nil)
+	})
+}
+
+// column is a column and the value a query filters it by, if not empty
+type column struct {
+	name  string
+	value string
+}
+
+// whereEqual returns a WHERE clause matching every column with a non-empty
+// value, and its arguments. Column names are trusted.
+func whereEqual(columns ...column) (string, []any) {
+	var conds []string
+	var args []any
+	for _, c := range columns {
+		if c.value == "" {
+			continue
+		}
+		conds = append(conds, c.name+" = ?")
+		args = append(args, c.value)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// Product methods
+
+const productColumns = `id, name, description, category, price, price_minor, price_currency, vendor_id,
+	lot_controlled, serialized, base_unit, parent_id, created_at, version`
+
+// scanProduct scans a product. A product written before prices were
+// exact has no price_minor, only the float price, which is taken to be in
+// models.DefaultCurrency.
+func scanProduct(row scanner) (*models.Product, error) {
+	var product models.Product
+	var price float64
+	var minor sql.NullInt64
+	err := row.Scan(&product.ID, &product.Name, &product.Description, &product.Category,
+		&price, &minor, &product.Price.Currency, &product.VendorID, &product.LotControlled, &product.Serialized,
+		&product.BaseUnit, &product.ParentID, &product.CreatedAt, &product.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if minor.Valid {
+		product.Price.Minor = minor.Int64
+	} else if product.Price, err = models.MoneyFromFloat(price, models.DefaultCurrency); err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func scanPack(row scanner) (*models.Pack, error) {
+	var pack models.Pack
+	if err := row.Scan(&pack.Unit, &pack.Quantity, &pack.Of); err != nil {
+		return nil, err
+	}
+	return &pack, nil
+}
+
+// loadPacks reads the packs of each product
+func loadPacks(q querier, products ...*models.Product) error {
+	for _, product := range products {
+		packs, err := selectRows(q, scanPack,
+			`SELECT unit, quantity, of_unit FROM product_packs WHERE product_id = ? ORDER BY seq`, product.ID)
+		if err != nil {
+			return err
+		}
+		product.Packs = nil
+		for _, pack := range packs {
+			product.Packs = append(product.Packs, *pack)
+		}
+	}
+	return nil
+}
+
+// attribute is an axis and a value, a row of product_axes or
+// product_attributes
+type attribute struct {
+	axis  string
+	value string
+}
+
+func scanAttribute(row scanner) (*attribute, error) {
+	var a attribute
+	if err := row.Scan(&a.axis, &a.value); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// loadVariants reads the axes and attributes of each product. Each value
+// of an axis is a row, in order.
+func loadVariants(q querier, products ...*models.Product) error {
+	for _, product := range products {
+		values, err := selectRows(q, scanAttribute,
+			`SELECT axis, value FROM product_axes WHERE product_id = ? ORDER BY seq`, product.ID)
+		if err != nil {
+			return err
+		}
+		product.Axes = nil
+		for _, v := range values {
+			if n := len(product.Axes); n == 0 || product.Axes[n-1].Name != v.axis {
+				product.Axes = append(product.Axes, models.Axis{Name: v.axis})
+			}
+			axis := &product.Axes[len(product.Axes)-1]
+			axis.Values = append(axis.Values, v.value)
+		}
+
+		attributes, err := selectRows(q, scanAttribute,
+			`SELECT axis, value FROM product_attributes WHERE product_id = ?`, product.ID)
+		if err != nil {
+			return err
+		}
+		product.Attributes = nil
+		for _, a := range attributes {
+			if product.Attributes == nil {
+				product.Attributes = make(map[string]string)
+			}
+			product.Attributes[a.axis] = a.value
+		}
+	}
+	return nil
+}
+
+func scanComponent(row scanner) (*models.Component, error) {
+	var component models.Component
+	if err := row.Scan(&component.ProductID, &component.Quantity, &component.Unit); err != nil {
+		return nil, err
+	}
+	return &component, nil
+}
+
+// loadComponents reads the components of each product
+func loadComponents(q querier, products ...*models.Product) error {
+	for _, product := range products {
+		components, err := selectRows(q, scanComponent,
+			`SELECT component_id, quantity, unit FROM product_components WHERE product_id = ? ORDER BY seq`, product.ID)
+		if err != nil {
+			return err
+		}
+		product.Components = nil
+		for _, component := range components {
+			product.Components = append(product.Components, *component)
+		}
+	}
+	return nil
+}
+
+// loadProductDetails reads the packs, axes, attributes and components of
+// each product
+func loadProductDetails(q querier, products ...*models.Product) error {
+	if err := loadPacks(q, products...); err != nil {
+		return err
+	}
+	if err := loadVariants(q, products...); err != nil {
+		return err
+	}
+	return loadComponents(q, products...)
+}
+
+// selectProducts returns the products query selects, with their packs,
+// axes, attributes and components
+func selectProducts(q querier, query string, args ...any) ([]*models.Product, error) {
+	products, err := selectRows(q, scanProduct, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return products, loadProductDetails(q, products...)
+}
+
+func getProduct(q querier, id string) (*models.Product, error) {
+	product, err := scanProduct(q.QueryRow(`SELECT `+productColumns+` FROM products WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return product, loadProductDetails(q, product)
+}
+
+// replacePacks replaces the stored packs of product with its current ones
+func replacePacks(tx *sql.Tx, product *models.Product) error {
+	if _, err := tx.Exec(`DELETE FROM product_packs WHERE product_id = ?`, product.ID); err != nil {
+		return err
+	}
+	for i, pack := range product.Packs {
+		_, err := tx.Exec(`INSERT INTO product_packs (product_id, seq, unit, quantity, of_unit) VALUES (?, ?, ?, ?, ?)`,
+			product.ID, i, pack.Unit, pack.Quantity, pack.Of)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceVariants replaces the stored axes and attributes of product with
+// its current ones
+func replaceVariants(tx *sql.Tx, product *models.Product) error {
+	if err := deleteVariants(tx, product.ID); err != nil {
+		return err
+	}
+	seq := 0
+	for _, axis := range product.Axes {
+		for _, value := range axis.Values {
+			_, err := tx.Exec(`INSERT INTO product_axes (product_id, seq, axis, value) VALUES (?, ?, ?, ?)`,
+				product.ID, seq, axis.Name, value)
+			if err != nil {
+				return err
+			}
+			seq++
+		}
+	}
+	for axis, value := range product.Attributes {
+		_, err := tx.Exec(`INSERT INTO product_attributes (product_id, axis, value) VALUES (?, ?, ?)`,
+			product.ID, axis, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteVariants deletes the stored axes and attributes of a product
+func deleteVariants(tx *sql.Tx, productID string) error {
+	if _, err := tx.Exec(`DELETE FROM product_axes WHERE product_id = ?`, productID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM product_attributes WHERE product_id = ?`, productID)
+	return err
+}
+
+// replaceComponents replaces the stored components of product with its
+// current ones
+func replaceComponents(tx *sql.Tx, product *models.Product) error {
+	if _, err := tx.Exec(`DELETE FROM product_components WHERE product_id = ?`, product.ID); err != nil {
+		return err
+	}
+	for i, component := range product.Components {
+		_, err := tx.Exec(`INSERT INTO product_components (product_id, seq, component_id, quantity, unit)
+			VALUES (?, ?, ?, ?, ?)`,
+			product.ID, i, component.ProductID, component.Quantity, component.Unit)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireProductReferences returns ErrInvalidReference unless the product's
+// vendor exists and so do its parent, if it has one, and its components,
+// none of them the product itself
+func requireProductReferences(tx *sql.Tx, product *models.Product) error {
+	if err := requireReference(tx, "vendors", product.VendorID); err != nil {
+		return err
+	}
+	var references []string
+	if product.ParentID != "" {
+		references = append(references, product.ParentID)
+	}
+	for _, c := range product.Components {
+		references = append(references, c.ProductID)
+	}
+	for _, id := range references {
+		if id == product.ID {
+			return ErrInvalidReference
+		}
+		if err := requireReference(tx, "products", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepository) CreateProduct(product *models.Product) error {
+	product.CreatedAt = time.Now()
+	product.Version = 1
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := requireAbsent(tx, "products", product.ID); err != nil {
+			return err
+		}
+		if err := requireProductReferences(tx, product); err != nil {
+			return err
+		}
+		err := insert(tx, "products", product.ID,
+			`INSERT INTO products (`+productColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			product.ID, product.Name, product.Description, product.Category,
+			product.Price.Float64(), product.Price.Minor, product.Price.Currency, product.VendorID,
+			product.LotControlled, product.Serialized, product.BaseUnit, product.ParentID, product.CreatedAt, product.Version)
+		if err != nil {
+			return err
+		}
+		if err := replacePacks(tx, product); err != nil {
+			return err
+		}
+		if err := replaceVariants(tx, product); err != nil {
+			return err
+		}
+		if err := replaceComponents(tx, product); err != nil {
+			return err
+		}
+		return recordChange(tx, kindProduct, product.ID, nil, product)
+	})
+}
+
+func (r *SQLRepository) GetProduct(id string) (*models.Product, error) {
+	return getProduct(r.conn(), id)
+}
+
+func (r *SQLRepository) ListProducts() ([]*models.Product, error) {
+	return selectProducts(r.conn(), `SELECT `+productColumns+` FROM products ORDER BY id`)
+}
+
+// FindProducts returns the products matching filter, ordered by ID
+func (r *SQLRepository) FindProducts(filter ProductFilter) ([]*models.Product, error) {
+	where, args := whereEqual(
+		column{"vendor_id", filter.VendorID},
+		column{"category", filter.Category},
+		column{"parent_id", filter.ParentID},
+	)
+	if filter.ComponentID != "" {
+		if where == "" {
+			where = " WHERE "
+		} else {
+			where += " AND "
+		}
+		where += `EXISTS (SELECT 1 FROM product_components c WHERE c.product_id = products.id AND c.component_id = ?)`
+		args = append(args, filter.ComponentID)
+	}
+	for axis, value := range filter.Attributes {
+		if where == "" {
+			where = " WHERE "
+		} else {
+			where += " AND "
+		}
+		where += `EXISTS (SELECT 1 FROM product_attributes a WHERE a.product_id = products.id AND a.axis = ? AND a.value = ?)`
+		args = append(args, axis, value)
+	}
+	return selectProducts(r.conn(), `SELECT `+productColumns+` FROM products`+where+` ORDER BY id`, args...)
+}
+
+func (r *SQLRepository) UpdateProduct(product *models.Product) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getProduct(tx, product.ID)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, product.Version); err != nil {
+			return err
+		}
+		if err := requireProductReferences(tx, product); err != nil {
+			return err
+		}
+		product.CreatedAt = existing.CreatedAt
+		product.Version = existing.Version + 1
+		err = execVersioned(tx, `UPDATE products SET name = ?, description = ?, category = ?, price = ?, price_minor = ?,
+			price_currency = ?, vendor_id = ?, lot_controlled = ?, serialized = ?, base_unit = ?, parent_id = ?,
+			version = ? WHERE id = ? AND version = ?`,
+			product.Name, product.Description, product.Category, product.Price.Float64(), product.Price.Minor,
+			product.Price.Currency, product.VendorID, product.LotControlled, product.Serialized, product.BaseUnit,
+			product.ParentID, product.Version, product.ID, existing.Version)
+		if err != nil {
+			return err
+		}
+		if err := replacePacks(tx, product); err != nil {
+			return err
+		}
+		if err := replaceVariants(tx, product); err != nil {
+			return err
+		}
+		if err := replaceComponents(tx, product); err != nil {
+			return err
+		}
+		return recordChange(tx, kindProduct, product.ID, existing, product)
+	})
+}
+
+// DeleteProduct deletes a product. If the product still has inventory items
+// it fails with ErrInUse, unless cascade is set, in which case the items are
+// deleted too. A product with variants or that is a component of a kit
+// cannot be deleted.
+func (r *SQLRepository) DeleteProduct(id string, version int64, cascade bool) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getProduct(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+
+		var items int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM inventory_items WHERE product_id = ?`, id).Scan(&items); err != nil {
+			return err
+		}
+		if items > 0 && !cascade {
+			return ErrInUse
+		}
+		return deleteProduct(tx, existing)
+	})
+}
+
+// deleteProduct deletes product and its inventory items, recording the
+// changes. It fails with ErrInUse if an order, a serial, a variant, a kit,
+// a price list or a promotion references the product.
+func deleteProduct(tx *sql.Tx, product *models.Product) error {
+	if err := requireUnreferenced(tx, "products", "parent_id", product.ID); err != nil {
+		return err
+	}
+	if err := requireUnreferenced(tx, "product_components", "component_id", product.ID); err != nil {
+		return err
+	}
+	if err := requireUnreferenced(tx, "serials", "product_id", product.ID); err != nil {
+		return err
+	}
+	if err := requireUnreferenced(tx, "sales_order_lines", "product_id", product.ID); err != nil {
+		return err
+	}
+	if err := requireUnreferenced(tx, "purchase_order_lines", "product_id", product.ID); err != nil {
+		return err
+	}
+	if err := requireUnreferenced(tx, "transfer_order_lines", "product_id", product.ID); err != nil {
+		return err
+	}
+	if err := requireUnreferenced(tx, "price_list_prices", "product_id", product.ID); err != nil {
+		return err
+	}
+	if err := requireUnreferenced(tx, "promotion_products", "product_id", product.ID); err != nil {
+		return err
+	}
+	items, err := selectRows(tx, scanInventoryItem,
+		`SELECT `+inventoryColumns+` FROM inventory_items WHERE product_id = ? ORDER BY id`, product.ID)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := deleteItem(tx, item); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM product_packs WHERE product_id = ?`, product.ID); err != nil {
+		return err
+	}
+	if err := deleteVariants(tx, product.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM product_components WHERE product_id = ?`, product.ID); err != nil {
+		return err
+	}
+	if err := execVersioned(tx, `DELETE FROM products WHERE id = ? AND version = ?`, product.ID, product.Version); err != nil {
+		return err
+	}
+	return recordChange(tx, kindProduct, product.ID, product, nil)
+}
+
+// deleteItem deletes item, its ledger and its reservations, recording the
+// changes
+func deleteItem(tx *sql.Tx, item *models.InventoryItem) error {
+	reservations, err := selectRows(tx, scanReservation,
+		`SELECT `+reservationColumns+` FROM reservations WHERE item_id = ? ORDER BY id`, item.ID)
+	if err != nil {
+		return err
+	}
+	for _, reservation := range reservations {
+		if err := deleteReservation(tx, reservation); err != nil {
+			return err
+		}
+	}
+	movements, err := selectRows(tx, scanMovement,
+		`SELECT `+movementColumns+` FROM stock_movements WHERE item_id = ? ORDER BY id`, item.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM stock_movements WHERE item_id = ?`, item.ID); err != nil {
+		return err
+	}
+	for _, movement := range movements {
+		if err := recordChange(tx, kindStockMovement, movementKey(movement.ID), movement, nil); err != nil {
+			return err
+		}
+	}
+	if err := execVersioned(tx, `DELETE FROM inventory_items WHERE id = ? AND version = ?`, item.ID, item.Version); err != nil {
+		return err
+	}
+	return recordChange(tx, kindInventoryItem, item.ID, item, nil)
+}
+
+// Inventory methods
+
+const inventoryColumns = `id, product_id, quantity, reserved, unit, location, lot_number, manufactured_at,
+	expires_at, updated_at, version`
+
+func scanInventoryItem(row scanner) (*models.InventoryItem, error) {
+	var item models.InventoryItem
+	err := row.Scan(&item.ID, &item.ProductID, &item.Quantity, &item.Reserved, &item.Unit, &item.Location,
+		&item.LotNumber, &item.ManufacturedAt, &item.ExpiresAt, &item.UpdatedAt, &item.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	setAvailable(&item)
+	return &item, nil
+}
+
+func getInventoryItem(q querier, id string) (*models.InventoryItem, error) {
+	return scanInventoryItem(q.QueryRow(`SELECT `+inventoryColumns+` FROM inventory_items WHERE id = ?`, id))
+}
+
+func (r *SQLRepository) CreateInventoryItem(item *models.InventoryItem) error {
+	if item.Quantity < 0 {
+		return ErrInsufficientStock
+	}
+	item.Reserved = 0
+	setAvailable(item)
+	item.UpdatedAt = time.Now()
+	item.Version = 1
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := requireAbsent(tx, "inventory_items", item.ID); err != nil {
+			return err
+		}
+		if err := requireReference(tx, "products", item.ProductID); err != nil {
+			return err
+		}
+		err := insert(tx, "inventory_items", item.ID,
+			`INSERT INTO inventory_items (`+inventoryColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			item.ID, item.ProductID, item.Quantity, item.Reserved, item.Unit, item.Location,
+			item.LotNumber, item.ManufacturedAt, item.ExpiresAt, item.UpdatedAt, item.Version)
+		if err != nil {
+			return err
+		}
+		if err := recordChange(tx, kindInventoryItem, item.ID, nil, item); err != nil || item.Quantity == 0 {
+			return err
+		}
+		opening := openingBalance(item)
+		if err := insertMovement(tx, opening); err != nil {
+			return err
+		}
+		return recordChange(tx, kindStockMovement, movementKey(opening.ID), nil, opening)
+	})
+}
+
+func (r *SQLRepository) GetInventoryItem(id string) (*models.InventoryItem, error) {
+	return getInventoryItem(r.conn(), id)
+}
+
+func (r *SQLRepository) ListInventoryItems() ([]*models.InventoryItem, error) {
+	return selectRows(r.conn(), scanInventoryItem, `SELECT `+inventoryColumns+` FROM inventory_items ORDER BY id`)
+}
+
+// FindInventoryItems returns the inventory items matching filter, ordered
+// by ID
+func (r *SQLRepository) FindInventoryItems(filter InventoryFilter) ([]*models.InventoryItem, error) {
+	where, args := whereEqual(
+		column{"product_id", filter.ProductID},
+		column{"location", filter.Location},
+	)
+	return selectRows(r.conn(), scanInventoryItem,
+		`SELECT `+inventoryColumns+` FROM inventory_items`+where+` ORDER BY id`, args...)
+}
+
+func (r *SQLRepository) UpdateInventoryItem(item *models.InventoryItem) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getInventoryItem(tx, item.ID)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, item.Version); err != nil {
+			return err
+		}
+		if err := requireReference(tx, "products", item.ProductID); err != nil {
+			return err
+		}
+		item.Quantity = existing.Quantity
+		item.Reserved = existing.Reserved
+		setAvailable(item)
+		item.UpdatedAt = time.Now()
+		item.Version = existing.Version + 1
+		err = execVersioned(tx, `UPDATE inventory_items SET product_id = ?, unit = ?, location = ?, lot_number = ?,
+			manufactured_at = ?, expires_at = ?, updated_at = ?, version = ? WHERE id = ? AND version = ?`,
+			item.ProductID, item.Unit, item.Location, item.LotNumber, item.ManufacturedAt,
+			item.ExpiresAt, item.UpdatedAt, item.Version, item.ID, existing.Version)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, kindInventoryItem, item.ID, existing, item)
+	})
+}
+
+func (r *SQLRepository) DeleteInventoryItem(id string, version int64) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getInventoryItem(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		if err := requireUnreferenced(tx, "serials", "item_id", id); err != nil {
+			return err
+		}
+		return deleteItem(tx, existing)
+	})
+}
+
+// Stock movement methods
+
+const movementColumns = `id, item_id, type, delta, balance, unit, reason, reference, actor, reservation_id,
+	created_at`
+
+func scanMovement(row scanner) (*models.StockMovement, error) {
+	var movement models.StockMovement
+	err := row.Scan(&movement.ID, &movement.ItemID, &movement.Type, &movement.Delta, &movement.Balance, &movement.Unit,
+		&movement.Reason, &movement.Reference, &movement.Actor, &movement.ReservationID, &movement.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &movement, nil
+}
+
+// insertMovement appends movement to the ledger and sets its ID
+func insertMovement(tx *sql.Tx, movement *models.StockMovement) error {
+	result, err := tx.Exec(`INSERT INTO stock_movements (item_id, type, delta, balance, unit, reason, reference, actor,
+		reservation_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		movement.ItemID, movement.Type, movement.Delta, movement.Balance, movement.Unit,
+		movement.Reason, movement.Reference, movement.Actor, movement.ReservationID, movement.CreatedAt)
+	if err != nil {
+		return err
+	}
+	movement.ID, err = result.LastInsertId()
+	return err
+}
+
+// PostMovement appends movement to its item's ledger and applies the delta
+// to the item's quantity, first releasing the reservation it consumes, if
+// any. It fails with ErrInsufficientStock if the quantity would become
+// negative or the movement removes more than is available.
+func (r *SQLRepository) PostMovement(movement *models.StockMovement, version int64) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		item, err := getInventoryItem(tx, movement.ItemID)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(item.Version, version); err != nil {
+			return err
+		}
+		now := time.Now()
+		var consumed []*models.Reservation
+		if movement.ReservationID != "" {
+			reservation, err := scanReservation(tx.QueryRow(
+				`SELECT `+reservationColumns+` FROM reservations WHERE id = ?`, movement.ReservationID))
+			if err == ErrNotFound {
+				return ErrInvalidReference
+			} else if err != nil {
+				return err
+			}
+			if reservation.ItemID != item.ID {
+				return ErrInvalidReference
+			}
+			consumed = append(consumed, reservation)
+		}
+		updated, err := releaseReservations(tx, item, consumed, now)
+		if err != nil {
+			return err
+		}
+		if err := checkOutbound(updated, movement.Delta); err != nil {
+			return err
+		}
+		updated.Quantity = models.RoundQuantity(item.Quantity + movement.Delta)
+		setAvailable(updated)
+
+		err = execVersioned(tx, `UPDATE inventory_items SET quantity = ?, reserved = ?, updated_at = ?, version = ?
+			WHERE id = ? AND version = ?`,
+			updated.Quantity, updated.Reserved, updated.UpdatedAt, updated.Version, updated.ID, item.Version)
+		if err != nil {
+			return err
+		}
+		if err := recordChange(tx, kindInventoryItem, item.ID, item, updated); err != nil {
+			return err
+		}
+
+		movement.Balance = updated.Quantity
+		movement.Unit = item.Unit
+		movement.CreatedAt = now
+		if err := insertMovement(tx, movement); err != nil {
+			return err
+		}
+		return recordChange(tx, kindStockMovement, movementKey(movement.ID), nil, movement)
+	})
+}
+
+// ListMovements returns an item's ledger, oldest first
+func (r *SQLRepository) ListMovements(itemID string) ([]*models.StockMovement, error) {
+	if _, err := r.GetInventoryItem(itemID); err != nil {
+		return nil, err
+	}
+	return selectRows(r.conn(), scanMovement,
+		`SELECT `+movementColumns+` FROM stock_movements WHERE item_id = ? ORDER BY id`, itemID)
+}
+
+// Reservation methods
+
+const reservationColumns = `id, item_id, quantity, unit, owner, expires_at, created_at`
+
+func scanReservation(row scanner) (*models.Reservation, error) {
+	var reservation models.Reservation
+	err := row.Scan(&reservation.ID, &reservation.ItemID, &reservation.Quantity, &reservation.Unit, &reservation.Owner,
+		&reservation.ExpiresAt, &reservation.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &reservation, nil
+}
+
+// deleteReservation deletes reservation, recording the change. It does not
+// update the reservation's item.
+func deleteReservation(tx *sql.Tx, reservation *models.Reservation) error {
+	if err := execOne(tx, `DELETE FROM reservations WHERE id = ?`, reservation.ID); err != nil {
+		return err
+	}
+	return recordChange(tx, kindReservation, reservation.ID, reservation, nil)
+}
+
+// releaseReservations deletes the held reservations of item and returns
+// their stock to it, recording the changes, and returns the updated item
+func releaseReservations(tx *sql.Tx, item *models.InventoryItem, held []*models.Reservation, now time.Time) (*models.InventoryItem, error) {
+	for _, reservation := range held {
+		if err := deleteReservation(tx, reservation); err != nil {
+			return nil, err
+		}
+	}
+	_, updated := releases(item, held, now)
+	return updated, nil
+}
+
+// updateReserved writes the reserved quantity of updated, which replaces
+// item, recording the change
+func updateReserved(tx *sql.Tx, item, updated *models.InventoryItem) error {
+	err := execVersioned(tx, `UPDATE inventory_items SET reserved = ?, updated_at = ?, version = ?
+		WHERE id = ? AND version = ?`,
+		updated.Reserved, updated.UpdatedAt, updated.Version, updated.ID, item.Version)
+	if err != nil {
+		return err
+	}
+	return recordChange(tx, kindInventoryItem, item.ID, item, updated)
+}
+
+// expiredReservations returns the reservations of item that have expired at
+// now, or of every item when itemID is empty. Expiry is compared here rather
+// than in SQL, where timestamps are compared as text.
+func expiredReservations(tx *sql.Tx, itemID string, now time.Time) ([]*models.Reservation, error) {
+	where, args := whereEqual(column{"item_id", itemID})
+	reservations, err := selectRows(tx, scanReservation,
+		`SELECT `+reservationColumns+` FROM reservations`+where+` ORDER BY item_id, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	expired := reservations[:0]
+	for _, reservation := range reservations {
+		if reservation.Expired(now) {
+			expired = append(expired, reservation)
+		}
+	}
+	return expired, nil
+}
+
+// CreateReservation holds stock of the reservation's item. Expired holds
+// on the item are released first. It fails with ErrInvalidReference if the
+// item does not exist and with ErrInsufficientStock if the item has less
+// available than the reservation's quantity.
+func (r *SQLRepository) CreateReservation(reservation *models.Reservation) error {
+	reservation.CreatedAt = time.Now()
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := requireAbsent(tx, "reservations", reservation.ID); err != nil {
+			return err
+		}
+		item, err := getInventoryItem(tx, reservation.ItemID)
+		if err == ErrNotFound {
+			return ErrInvalidReference
+		} else if err != nil {
+			return err
+		}
+		expired, err := expiredReservations(tx, item.ID, reservation.CreatedAt)
+		if err != nil {
+			return err
+		}
+		updated, err := releaseReservations(tx, item, expired, reservation.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if updated.Available < reservation.Quantity {
+			return ErrInsufficientStock
+		}
+		updated.Reserved = models.RoundQuantity(updated.Reserved + reservation.Quantity)
+		reservation.Unit = item.Unit
+		setAvailable(updated)
+		if err := updateReserved(tx, item, updated); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO reservations (`+reservationColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			reservation.ID, reservation.ItemID, reservation.Quantity, reservation.Unit, reservation.Owner,
+			reservation.ExpiresAt, reservation.CreatedAt)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, kindReservation, reservation.ID, nil, reservation)
+	})
+}
+
+func (r *SQLRepository) GetReservation(id string) (*models.Reservation, error) {
+	return scanReservation(r.conn().QueryRow(`SELECT `+reservationColumns+` FROM reservations WHERE id = ?`, id))
+}
+
+// ListReservations returns an item's reservations, including expired ones
+// not yet released, ordered by ID
+func (r *SQLRepository) ListReservations(itemID string) ([]*models.Reservation, error) {
+	if _, err := r.GetInventoryItem(itemID); err != nil {
+		return nil, err
+	}
+	return selectRows(r.conn(), scanReservation,
+		`SELECT `+reservationColumns+` FROM reservations WHERE item_id = ? ORDER BY id`, itemID)
+}
+
+// ReleaseReservation deletes a reservation and returns its stock to its
+// item's available quantity
+func (r *SQLRepository) ReleaseReservation(id string) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		reservation, err := scanReservation(tx.QueryRow(`SELECT `+reservationColumns+` FROM reservations WHERE id = ?`, id))
+		if err != nil {
+			return err
+		}
+		item, err := getInventoryItem(tx, reservation.ItemID)
+		if err != nil {
+			return err
+		}
+		updated, err := releaseReservations(tx, item, []*models.Reservation{reservation}, time.Now())
+		if err != nil {
+			return err
+		}
+		return updateReserved(tx, item, updated)
+	})
+}
+
+// ReleaseExpiredReservations releases every reservation that has expired
+// at now and returns how many it released
+func (r *SQLRepository) ReleaseExpiredReservations(now time.Time) (int, error) {
+	count := 0
+	err := r.inTx(func(tx *sql.Tx) error {
+		expired, err := expiredReservations(tx, "", now)
+		if err != nil {
+			return err
+		}
+		for start := 0; start < len(expired); {
+			end := start + 1
+			for end < len(expired) && expired[end].ItemID == expired[start].ItemID {
+				end++
+			}
+			item, err := getInventoryItem(tx, expired[start].ItemID)
+			if err != nil {
+				return err
+			}
+			updated, err := releaseReservations(tx, item, expired[start:end], now)
+			if err != nil {
+				return err
+			}
+			if err := updateReserved(tx, item, updated); err != nil {
+				return err
+			}
+			start = end
+		}
+		count = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Sales order methods
+
+const salesOrderColumns = `id, buyer_id, status, created_at, updated_at, version`
+
+func scanSalesOrder(row scanner) (*models.SalesOrder, error) {
+	var order models.SalesOrder
+	err := row.Scan(&order.ID, &order.BuyerID, &order.Status, &order.CreatedAt, &order.UpdatedAt, &order.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &order, nil
+}
+
+// loadLines reads the lines of order and their allocations
+func loadLines(q querier, order *models.SalesOrder) error {
+	rows, err := q.Query(`SELECT product_id, quantity, unit, unit_price_minor, unit_price_currency, price_unit,
+		total_minor, total_currency FROM sales_order_lines WHERE order_id = ? ORDER BY line`, order.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	order.Lines = make([]models.SalesOrderLine, 0)
+	for rows.Next() {
+		var line models.SalesOrderLine
+		err := rows.Scan(&line.ProductID, &line.Quantity, &line.Unit, &line.UnitPrice.Minor, &line.UnitPrice.Currency,
+			&line.PriceUnit, &line.Total.Minor, &line.Total.Currency)
+		if err != nil {
+			return err
+		}
+		order.Lines = append(order.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = q.Query(`SELECT line, item_id, reservation_id, quantity, product_id FROM sales_order_allocations
+		WHERE order_id = ? ORDER BY line, seq`, order.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var line int
+		var allocation models.Allocation
+		err := rows.Scan(&line, &allocation.ItemID, &allocation.ReservationID, &allocation.Quantity, &allocation.ProductID)
+		if err != nil {
+			return err
+		}
+		order.Lines[line].Allocations = append(order.Lines[line].Allocations, allocation)
+	}
+	return rows.Err()
+}
+
+func getSalesOrder(q querier, id string) (*models.SalesOrder, error) {
+	order, err := scanSalesOrder(q.QueryRow(`SELECT `+salesOrderColumns+` FROM sales_orders WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return order, loadLines(q, order)
+}
+
+// requireOrderReferences returns ErrInvalidReference unless the order's
+// buyer and the products of its lines exist
+func requireOrderReferences(tx *sql.Tx, order *models.SalesOrder) error {
+	if err := requireReference(tx, "buyers", order.BuyerID); err != nil {
+		return err
+	}
+	for _, line := range order.Lines {
+		if err := requireReference(tx, "products", line.ProductID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertLines writes the lines of order and their allocations
+func insertLines(tx *sql.Tx, order *models.SalesOrder) error {
+	for i, line := range order.Lines {
+		_, err := tx.Exec(`INSERT INTO sales_order_lines (order_id, line, product_id, quantity, unit, unit_price_minor,
+			unit_price_currency, price_unit, total_minor, total_currency) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			order.ID, i, line.ProductID, line.Quantity, line.Unit, line.UnitPrice.Minor, line.UnitPrice.Currency,
+			line.PriceUnit, line.Total.Minor, line.Total.Currency)
+		if err != nil {
+			return err
+		}
+		for j, allocation := range line.Allocations {
+			_, err := tx.Exec(`INSERT INTO sales_order_allocations (order_id, line, seq, item_id, reservation_id, quantity,
+				product_id) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				order.ID, i, j, allocation.ItemID, allocation.ReservationID, allocation.Quantity, allocation.ProductID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteLines deletes the lines of the order with the given ID and their
+// allocations
+func deleteLines(tx *sql.Tx, id string) error {
+	if _, err := tx.Exec(`DELETE FROM sales_order_allocations WHERE order_id = ?`, id); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM sales_order_lines WHERE order_id = ?`, id)
+	return err
+}
+
+func (r *SQLRepository) CreateSalesOrder(order *models.SalesOrder) error {
+	order.CreatedAt = time.Now()
+	order.UpdatedAt = order.CreatedAt
+	order.Version = 1
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := requireAbsent(tx, "sales_orders", order.ID); err != nil {
+			return err
+		}
+		if err := requireOrderReferences(tx, order); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO sales_orders (`+salesOrderColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+			order.ID, order.BuyerID, order.Status, order.CreatedAt, order.UpdatedAt, order.Version)
+		if err != nil {
+			return err
+		}
+		if err := insertLines(tx, order); err != nil {
+			return err
+		}
+		return recordChange(tx, kindSalesOrder, order.ID, nil, order)
+	})
+}
+
+func (r *SQLRepository) GetSalesOrder(id string) (*models.SalesOrder, error) {
+	return getSalesOrder(r.conn(), id)
+}
+
+// FindSalesOrders returns the sales orders matching filter, ordered by ID
+func (r *SQLRepository) FindSalesOrders(filter SalesOrderFilter) ([]*models.SalesOrder, error) {
+	where, args := whereEqual(
+		column{"buyer_id", filter.BuyerID},
+		column{"status", string(filter.Status)},
+	)
+	orders, err := selectRows(r.conn(), scanSalesOrder,
+		`SELECT `+salesOrderColumns+` FROM sales_orders`+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		if err := loadLines(r.conn(), order); err != nil {
+			return nil, err
+		}
+	}
+	return orders, nil
+}
+
+// UpdateSalesOrder replaces an order's buyer, status and lines
+func (r *SQLRepository) UpdateSalesOrder(order *models.SalesOrder) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getSalesOrder(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		if err := requireOrderReferences(tx, order); err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, order.Version); err != nil {
+			return err
+		}
+		order.CreatedAt = existing.CreatedAt
+		order.UpdatedAt = time.Now()
+		order.Version = existing.Version + 1
+		err = execVersioned(tx, `UPDATE sales_orders SET buyer_id = ?, status = ?, updated_at = ?, version = ?
+			WHERE id = ? AND version = ?`,
+			order.BuyerID, order.Status, order.UpdatedAt, order.Version, order.ID, existing.Version)
+		if err != nil {
+			return err
+		}
+		if err := deleteLines(tx, order.ID); err != nil {
+			return err
+		}
+		if err := insertLines(tx, order); err != nil {
+			return err
+		}
+		return recordChange(tx, kindSalesOrder, order.ID, existing, order)
+	})
+}
+
+func (r *SQLRepository) DeleteSalesOrder(id string, version int64) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getSalesOrder(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		if err := deleteLines(tx, id); err != nil {
+			return err
+		}
+		if err := execVersioned(tx, `DELETE FROM sales_orders WHERE id = ? AND version = ?`, id, existing.Version); err != nil {
+			return err
+		}
+		return recordChange(tx, kindSalesOrder, id, existing, nil)
+	})
+}
+
+// Purchase order methods
+
+const purchaseOrderColumns = `id, vendor_id, status, over_tolerance, under_tolerance, created_at, updated_at, version`
+
+func scanPurchaseOrder(row scanner) (*models.PurchaseOrder, error) {
+	var order models.PurchaseOrder
+	err := row.Scan(&order.ID, &order.VendorID, &order.Status, &order.OverTolerance, &order.UnderTolerance,
+		&order.CreatedAt, &order.UpdatedAt, &order.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &order, nil
+}
+
+func scanPurchaseOrderLine(row scanner) (*models.PurchaseOrderLine, error) {
+	var line models.PurchaseOrderLine
+	err := row.Scan(&line.ProductID, &line.Quantity, &line.Unit, &line.Cost.Minor, &line.Cost.Currency,
+		&line.CostUnit, &line.Total.Minor, &line.Total.Currency, &line.Received, &line.ExpectedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &line, nil
+}
+
+// loadPurchaseOrderLines reads the lines of order
+func loadPurchaseOrderLines(q querier, order *models.PurchaseOrder) error {
+	lines, err := selectRows(q, scanPurchaseOrderLine,
+		`SELECT product_id, quantity, unit, cost_minor, cost_currency, cost_unit, total_minor, total_currency, received,
+			expected_at FROM purchase_order_lines WHERE order_id = ? ORDER BY line`,
+		order.ID)
+	if err != nil {
+		return err
+	}
+	order.Lines = make([]models.PurchaseOrderLine, len(lines))
+	for i, line := range lines {
+		order.Lines[i] = *line
+	}
+	return nil
+}
+
+func getPurchaseOrder(q querier, id string) (*models.PurchaseOrder, error) {
+	order, err := scanPurchaseOrder(q.QueryRow(`SELECT `+purchaseOrderColumns+` FROM purchase_orders WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return order, loadPurchaseOrderLines(q, order)
+}
+
+// requirePurchaseOrderReferences returns ErrInvalidReference unless the
+// order's vendor and the products of its lines exist
+func requirePurchaseOrderReferences(tx *sql.Tx, order *models.PurchaseOrder) error {
+	if err := requireReference(tx, "vendors", order.VendorID); err != nil {
+		return err
+	}
+	for _, line := range order.Lines {
+		if err := requireReference(tx, "products", line.ProductID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replacePurchaseOrderLines replaces the stored lines of order with its
+// current ones
+func replacePurchaseOrderLines(tx *sql.Tx, order *models.PurchaseOrder) error {
+	if _, err := tx.Exec(`DELETE FROM purchase_order_lines WHERE order_id = ?`, order.ID); err != nil {
+		return err
+	}
+	for i, line := range order.Lines {
+		_, err := tx.Exec(`INSERT INTO purchase_order_lines (order_id, line, product_id, quantity, unit, cost_minor,
+			cost_currency, cost_unit, total_minor, total_currency, received, expected_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			order.ID, i, line.ProductID, line.Quantity, line.Unit, line.Cost.Minor, line.Cost.Currency, line.CostUnit,
+			line.Total.Minor, line.Total.Currency, line.Received, line.ExpectedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepository) CreatePurchaseOrder(order *models.PurchaseOrder) error {
+	order.CreatedAt = time.Now()
+	order.UpdatedAt = order.CreatedAt
+	order.Version = 1
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := requireAbsent(tx, "purchase_orders", order.ID); err != nil {
+			return err
+		}
+		if err := requirePurchaseOrderReferences(tx, order); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO purchase_orders (`+purchaseOrderColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			order.ID, order.VendorID, order.Status, order.OverTolerance, order.UnderTolerance,
+			order.CreatedAt, order.UpdatedAt, order.Version)
+		if err != nil {
+			return err
+		}
+		if err := replacePurchaseOrderLines(tx, order); err != nil {
+			return err
+		}
+		return recordChange(tx, kindPurchaseOrder, order.ID, nil, order)
+	})
+}
+
+func (r *SQLRepository) GetPurchaseOrder(id string) (*models.PurchaseOrder, error) {
+	return getPurchaseOrder(r.conn(), id)
+}
+
+// FindPurchaseOrders returns the purchase orders matching filter, ordered
+// by ID
+func (r *SQLRepository) FindPurchaseOrders(filter PurchaseOrderFilter) ([]*models.PurchaseOrder, error) {
+	where, args := whereEqual(
+		column{"vendor_id", filter.VendorID},
+		column{"status", string(filter.Status)},
+	)
+	if filter.ProductID != "" {
+		cond := `id IN (SELECT order_id FROM purchase_order_lines WHERE product_id = ?)`
+		if where == "" {
+			where = " WHERE " + cond
+		} else {
+			where += " AND " + cond
+		}
+		args = append(args, filter.ProductID)
+	}
+	orders, err := selectRows(r.conn(), scanPurchaseOrder,
+		`SELECT `+purchaseOrderColumns+` FROM purchase_orders`+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		if err := loadPurchaseOrderLines(r.conn(), order); err != nil {
+			return nil, err
+		}
+	}
+	return orders, nil
+}
+
+// UpdatePurchaseOrder replaces every field of an order except ID and
+// CreatedAt
+func (r *SQLRepository) UpdatePurchaseOrder(order *models.PurchaseOrder) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getPurchaseOrder(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		if err := requirePurchaseOrderReferences(tx, order); err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, order.Version); err != nil {
+			return err
+		}
+		order.CreatedAt = existing.CreatedAt
+		order.UpdatedAt = time.Now()
+		order.Version = existing.Version + 1
+		err = execVersioned(tx, `UPDATE purchase_orders SET vendor_id = ?, status = ?, over_tolerance = ?, under_tolerance = ?,
+			updated_at = ?, version = ? WHERE id = ? AND version = ?`,
+			order.VendorID, order.Status, order.OverTolerance, order.UnderTolerance,
+			order.UpdatedAt, order.Version, order.ID, existing.Version)
+		if err != nil {
+			return err
+		}
+		if err := replacePurchaseOrderLines(tx, order); err != nil {
+			return err
+		}
+		return recordChange(tx, kindPurchaseOrder, order.ID, existing, order)
+	})
+}
+
+func (r *SQLRepository) DeletePurchaseOrder(id string, version int64) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getPurchaseOrder(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM purchase_order_lines WHERE order_id = ?`, id); err != nil {
+			return err
+		}
+		if err := execVersioned(tx, `DELETE FROM purchase_orders WHERE id = ? AND version = ?`, id, existing.Version); err != nil {
+			return err
+		}
+		return recordChange(tx, kindPurchaseOrder, id, existing, nil)
+	})
+}
+
+// Location methods
+
+const locationColumns = `id, name, type, parent_id, capacity, active, created_at, version`
+
+func scanLocation(row scanner) (*models.Location, error) {
+	var location models.Location
+	err := row.Scan(&location.ID, &location.Name, &location.Type, &location.ParentID,
+		&location.Capacity, &location.Active, &location.CreatedAt, &location.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &location, nil
+}
+
+func getLocation(q querier, id string) (*models.Location, error) {
+	return scanLocation(q.QueryRow(`SELECT `+locationColumns+` FROM locations WHERE id = ?`, id))
+}
+
+// requireLocationParent returns ErrInvalidReference if location has a parent
+// that does not exist
+func requireLocationParent(tx *sql.Tx, location *models.Location) error {
+	if location.ParentID == "" {
+		return nil
+	}
+	return requireReference(tx, "locations", location.ParentID)
+}
+
+func (r *SQLRepository) CreateLocation(location *models.Location) error {
+	location.CreatedAt = time.Now()
+	location.Version = 1
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := requireAbsent(tx, "locations", location.ID); err != nil {
+			return err
+		}
+		if err := requireLocationParent(tx, location); err != nil {
+			return err
+		}
+		err := insert(tx, "locations", location.ID,
+			`INSERT INTO locations (`+locationColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			location.ID, location.Name, location.Type, location.ParentID,
+			location.Capacity, location.Active, location.CreatedAt, location.Version)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, kindLocation, location.ID, nil, location)
+	})
+}
+
+func (r *SQLRepository) GetLocation(id string) (*models.Location, error) {
+	return getLocation(r.conn(), id)
+}
+
+// FindLocations returns the locations matching filter, ordered by ID
+func (r *SQLRepository) FindLocations(filter LocationFilter) ([]*models.Location, error) {
+	where, args := whereEqual(
+		column{"parent_id", filter.ParentID},
+		column{"type", string(filter.Type)},
+	)
+	return selectRows(r.conn(), scanLocation, `SELECT `+locationColumns+` FROM locations`+where+` ORDER BY id`, args...)
+}
+
+func (r *SQLRepository) UpdateLocation(location *models.Location) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getLocation(tx, location.ID)
+		if err != nil {
+			return err
+		}
+		if err := requireLocationParent(tx, location); err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, location.Version); err != nil {
+			return err
+		}
+		location.CreatedAt = existing.CreatedAt
+		location.Version = existing.Version + 1
+		err = execVersioned(tx, `UPDATE locations SET name = ?, type = ?, parent_id = ?, capacity = ?, active = ?,
+			version = ? WHERE id = ? AND version = ?`,
+			location.Name, location.Type, location.ParentID, location.Capacity, location.Active,
+			location.Version, location.ID, existing.Version)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, kindLocation, location.ID, existing, location)
+	})
+}
+
+// DeleteLocation deletes a location. It fails with ErrInUse if the location
+// has child locations, holds inventory items or is on a transfer order.
+func (r *SQLRepository) DeleteLocation(id string, version int64) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getLocation(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		if err := requireUnreferenced(tx, "locations", "parent_id", id); err != nil {
+			return err
+		}
+		if err := requireUnreferenced(tx, "inventory_items", "location", id); err != nil {
+			return err
+		}
+		for _, column := range []string{"from_location", "to_location"} {
+			if err := requireUnreferenced(tx, "transfer_orders", column, id); err != nil {
+				return err
+			}
+		}
+		if err := execVersioned(tx, `DELETE FROM locations WHERE id = ? AND version = ?`, id, existing.Version); err != nil {
+			return err
+		}
+		return recordChange(tx, kindLocation, id, existing, nil)
+	})
+}
+
+// Transfer order methods
+
+const transferOrderColumns = `id, from_location, to_location, status, note, dispatched_at, received_at,
+	created_at, updated_at, version`
+
+func scanTransferOrder(row scanner) (*models.TransferOrder, error) {
+	var order models.TransferOrder
+	err := row.Scan(&order.ID, &order.FromLocation, &order.ToLocation, &order.Status, &order.Note,
+		&order.DispatchedAt, &order.ReceivedAt, &order.CreatedAt, &order.UpdatedAt, &order.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &order, nil
+}
+
+func scanTransferOrderLine(row scanner) (*models.TransferOrderLine, error) {
+	var line models.TransferOrderLine
+	err := row.Scan(&line.ProductID, &line.Quantity, &line.Unit, &line.FromItemID, &line.ToItemID,
+		&line.LotNumber, &line.ManufacturedAt, &line.ExpiresAt, &line.Received, &line.Discrepancy)
+	if err != nil {
+		return nil, err
+	}
+	return &line, nil
+}
+
+// loadTransferOrderLines reads the lines of order
+func loadTransferOrderLines(q querier, order *models.TransferOrder) error {
+	lines, err := selectRows(q, scanTransferOrderLine,
+		`SELECT product_id, quantity, unit, from_item_id, to_item_id, lot_number, manufactured_at, expires_at,
+			received, discrepancy FROM transfer_order_lines WHERE order_id = ? ORDER BY line`,
+		order.ID)
+	if err != nil {
+		return err
+	}
+	order.Lines = make([]models.TransferOrderLine, len(lines))
+	for i, line := range lines {
+		order.Lines[i] = *line
+	}
+	return nil
+}
+
+func getTransferOrder(q querier, id string) (*models.TransferOrder, error) {
+	order, err := scanTransferOrder(q.QueryRow(`SELECT `+transferOrderColumns+` FROM transfer_orders WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return order, loadTransferOrderLines(q, order)
+}
+
+// requireTransferOrderReferences returns ErrInvalidReference unless the
+// order's locations and the products of its lines exist
+func requireTransferOrderReferences(tx *sql.Tx, order *models.TransferOrder) error {
+	for _, id := range []string{order.FromLocation, order.ToLocation} {
+		if err := requireReference(tx, "locations", id); err != nil {
+			return err
+		}
+	}
+	for _, line := range order.Lines {
+		if err := requireReference(tx, "products", line.ProductID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceTransferOrderLines replaces the stored lines of order with its
+// current ones
+func replaceTransferOrderLines(tx *sql.Tx, order *models.TransferOrder) error {
+	if _, err := tx.Exec(`DELETE FROM transfer_order_lines WHERE order_id = ?`, order.ID); err != nil {
+		return err
+	}
+	for i, line := range order.Lines {
+		_, err := tx.Exec(`INSERT INTO transfer_order_lines
+			(order_id, line, product_id, quantity, unit, from_item_id, to_item_id, lot_number, manufactured_at,
+			expires_at, received, discrepancy) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			order.ID, i, line.ProductID, line.Quantity, line.Unit, line.FromItemID, line.ToItemID,
+			line.LotNumber, line.ManufacturedAt, line.ExpiresAt, line.Received, line.Discrepancy)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepository) CreateTransferOrder(order *models.TransferOrder) error {
+	order.CreatedAt = time.Now()
+	order.UpdatedAt = order.CreatedAt
+	order.Version = 1
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := requireAbsent(tx, "transfer_orders", order.ID); err != nil {
+			return err
+		}
+		if err := requireTransferOrderReferences(tx, order); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO transfer_orders (`+transferOrderColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			order.ID, order.FromLocation, order.ToLocation, order.Status, order.Note,
+			order.DispatchedAt, order.ReceivedAt, order.CreatedAt, order.UpdatedAt, order.Version)
+		if err != nil {
+			return err
+		}
+		if err := replaceTransferOrderLines(tx, order); err != nil {
+			return err
+		}
+		return recordChange(tx, kindTransferOrder, order.ID, nil, order)
+	})
+}
+
+func (r *SQLRepository) GetTransferOrder(id string) (*models.TransferOrder, error) {
+	return getTransferOrder(r.conn(), id)
+}
+
+// FindTransferOrders returns the transfer orders matching filter, ordered
+// by ID
+func (r *SQLRepository) FindTransferOrders(filter TransferOrderFilter) ([]*models.TransferOrder, error) {
+	where, args := whereEqual(
+		column{"from_location", filter.FromLocation},
+		column{"to_location", filter.ToLocation},
+		column{"status", string(filter.Status)},
+	)
+	if filter.ProductID != "" {
+		cond := `id IN (SELECT order_id FROM transfer_order_lines WHERE product_id = ?)`
+		if where == "" {
+			where = " WHERE " + cond
+		} else {
+			where += " AND " + cond
+		}
+		args = append(args, filter.ProductID)
+	}
+	orders, err := selectRows(r.conn(), scanTransferOrder,
+		`SELECT `+transferOrderColumns+` FROM transfer_orders`+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		if err := loadTransferOrderLines(r.conn(), order); err != nil {
+			return nil, err
+		}
+	}
+	return orders, nil
+}
+
+// UpdateTransferOrder replaces every field of an order except ID and
+// CreatedAt
+func (r *SQLRepository) UpdateTransferOrder(order *models.TransferOrder) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getTransferOrder(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		if err := requireTransferOrderReferences(tx, order); err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, order.Version); err != nil {
+			return err
+		}
+		order.CreatedAt = existing.CreatedAt
+		order.UpdatedAt = time.Now()
+		order.Version = existing.Version + 1
+		err = execVersioned(tx, `UPDATE transfer_orders SET from_location = ?, to_location = ?, status = ?, note = ?,
+			dispatched_at = ?, received_at = ?, updated_at = ?, version = ? WHERE id = ? AND version = ?`,
+			order.FromLocation, order.ToLocation, order.Status, order.Note,
+			order.DispatchedAt, order.ReceivedAt, order.UpdatedAt, order.Version, order.ID, existing.Version)
+		if err != nil {
+			return err
+		}
+		if err := replaceTransferOrderLines(tx, order); err != nil {
+			return err
+		}
+		return recordChange(tx, kindTransferOrder, order.ID, existing, order)
+	})
+}
+
+func (r *SQLRepository) DeleteTransferOrder(id string, version int64) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getTransferOrder(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM transfer_order_lines WHERE order_id = ?`, id); err != nil {
+			return err
+		}
+		if err := execVersioned(tx, `DELETE FROM transfer_orders WHERE id = ? AND version = ?`, id, existing.Version); err != nil {
+			return err
+		}
+		return recordChange(tx, kindTransferOrder, id, existing, nil)
+	})
+}
+
+// Price list methods
+
+const priceListColumns = `id, name, created_at, version`
+
+func scanPriceList(row scanner) (*models.PriceList, error) {
+	var list models.PriceList
+	err := row.Scan(&list.ID, &list.Name, &list.CreatedAt, &list.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &list, nil
+}
+
+func scanListPrice(row scanner) (*models.ListPrice, error) {
+	var price models.ListPrice
+	err := row.Scan(&price.ProductID, &price.MinQuantity, &price.Unit, &price.Price.Minor, &price.Price.Currency,
+		&price.ValidFrom, &price.ValidTo)
+	if err != nil {
+		return nil, err
+	}
+	return &price, nil
+}
+
+// loadListPrices reads the prices of list
+func loadListPrices(q querier, list *models.PriceList) error {
+	prices, err := selectRows(q, scanListPrice,
+		`SELECT product_id, min_quantity, unit, price_minor, price_currency, valid_from, valid_to
+			FROM price_list_prices WHERE price_list_id = ? ORDER BY seq`,
+		list.ID)
+	if err != nil {
+		return err
+	}
+	list.Prices = make([]models.ListPrice, len(prices))
+	for i, price := range prices {
+		list.Prices[i] = *price
+	}
+	return nil
+}
+
+func getPriceList(q querier, id string) (*models.PriceList, error) {
+	list, err := scanPriceList(q.QueryRow(`SELECT `+priceListColumns+` FROM price_lists WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return list, loadListPrices(q, list)
+}
+
+// requirePriceListReferences returns ErrInvalidReference unless the
+// product of every price on list exists
+func requirePriceListReferences(tx *sql.Tx, list *models.PriceList) error {
+	for _, price := range list.Prices {
+		if err := requireReference(tx, "products", price.ProductID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceListPrices replaces the stored prices of list with its current
+// ones
+func replaceListPrices(tx *sql.Tx, list *models.PriceList) error {
+	if _, err := tx.Exec(`DELETE FROM price_list_prices WHERE price_list_id = ?`, list.ID); err != nil {
+		return err
+	}
+	for i, price := range list.Prices {
+		_, err := tx.Exec(`INSERT INTO price_list_prices
+			(price_list_id, seq, product_id, min_quantity, unit, price_minor, price_currency, valid_from, valid_to)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			list.ID, i, price.ProductID, price.MinQuantity, price.Unit, price.Price.Minor, price.Price.Currency,
+			price.ValidFrom, price.ValidTo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepository) CreatePriceList(list *models.PriceList) error {
+	list.CreatedAt = time.Now()
+	list.Version = 1
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := requireAbsent(tx, "price_lists", list.ID); err != nil {
+			return err
+		}
+		if err := requirePriceListReferences(tx, list); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO price_lists (`+priceListColumns+`) VALUES (?, ?, ?, ?)`,
+			list.ID, list.Name, list.CreatedAt, list.Version)
+		if err != nil {
+			return err
+		}
+		if err := replaceListPrices(tx, list); err != nil {
+			return err
+		}
+		return recordChange(tx, kindPriceList, list.ID, nil, list)
+	})
+}
+
+func (r *SQLRepository) GetPriceList(id string) (*models.PriceList, error) {
+	return getPriceList(r.conn(), id)
+}
+
+// ListPriceLists returns every price list, ordered by ID
+func (r *SQLRepository) ListPriceLists() ([]*models.PriceList, error) {
+	lists, err := selectRows(r.conn(), scanPriceList, `SELECT `+priceListColumns+` FROM price_lists ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range lists {
+		if err := loadListPrices(r.conn(), list); err != nil {
+			return nil, err
+		}
+	}
+	return lists, nil
+}
+
+func (r *SQLRepository) UpdatePriceList(list *models.PriceList) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getPriceList(tx, list.ID)
+		if err != nil {
+			return err
+		}
+		if err := requirePriceListReferences(tx, list); err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, list.Version); err != nil {
+			return err
+		}
+		list.CreatedAt = existing.CreatedAt
+		list.Version = existing.Version + 1
+		err = execVersioned(tx, `UPDATE price_lists SET name = ?, version = ? WHERE id = ? AND version = ?`,
+			list.Name, list.Version, list.ID, existing.Version)
+		if err != nil {
+			return err
+		}
+		if err := replaceListPrices(tx, list); err != nil {
+			return err
+		}
+		return recordChange(tx, kindPriceList, list.ID, existing, list)
+	})
+}
+
+// DeletePriceList deletes a price list. It fails with ErrInUse if a buyer
+// is assigned the list.
+func (r *SQLRepository) DeletePriceList(id string, version int64) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getPriceList(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		if err := requireUnreferenced(tx, "buyers", "price_list_id", id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM price_list_prices WHERE price_list_id = ?`, id); err != nil {
+			return err
+		}
+		if err := execVersioned(tx, `DELETE FROM price_lists WHERE id = ? AND version = ?`, id, existing.Version); err != nil {
+			return err
+		}
+		return recordChange(tx, kindPriceList, id, existing, nil)
+	})
+}
+
+// Promotion methods
+
+const promotionColumns = `id, name, type, percent_basis_points, amount_minor, amount_currency, buy_quantity,
+	free_quantity, unit, min_subtotal_minor, min_subtotal_currency, exclusive, group_name, priority, valid_from,
+	valid_to, limit_per_buyer, created_at, version`
+
+func scanPromotion(row scanner) (*models.Promotion, error) {
+	var p models.Promotion
+	err := row.Scan(&p.ID, &p.Name, &p.Type, &p.Percent, &p.Amount.Minor, &p.Amount.Currency,
+		&p.BuyQuantity, &p.FreeQuantity, &p.Unit, &p.MinSubtotal.Minor, &p.MinSubtotal.Currency,
+		&p.Exclusive, &p.Group, &p.Priority, &p.ValidFrom, &p.ValidTo, &p.LimitPerBuyer, &p.CreatedAt, &p.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &p, nil
+}
+
+func scanText(row scanner) (*string, error) {
+	var s string
+	if err := row.Scan(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// promotionUse is a row of promotion_uses
+type promotionUse struct {
+	buyerID string
+	uses    int
+}
+
+func scanPromotionUse(row scanner) (*promotionUse, error) {
+	var u promotionUse
+	if err := row.Scan(&u.buyerID, &u.uses); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// loadPromotionDetails reads the products, categories and uses of each
+// promotion
+func loadPromotionDetails(q querier, promotions ...*models.Promotion) error {
+	for _, p := range promotions {
+		products, err := selectRows(q, scanText,
+			`SELECT product_id FROM promotion_products WHERE promotion_id = ? ORDER BY seq`, p.ID)
+		if err != nil {
+			return err
+		}
+		categories, err := selectRows(q, scanText,
+			`SELECT category FROM promotion_categories WHERE promotion_id = ? ORDER BY seq`, p.ID)
+		if err != nil {
+			return err
+		}
+		uses, err := selectRows(q, scanPromotionUse,
+			`SELECT buyer_id, uses FROM promotion_uses WHERE promotion_id = ?`, p.ID)
+		if err != nil {
+			return err
+		}
+		p.ProductIDs, p.Categories, p.Uses = nil, nil, nil
+		for _, id := range products {
+			p.ProductIDs = append(p.ProductIDs, *id)
+		}
+		for _, category := range categories {
+			p.Categories = append(p.Categories, *category)
+		}
+		for _, u := range uses {
+			if p.Uses == nil {
+				p.Uses = make(map[string]int)
+			}
+			p.Uses[u.buyerID] = u.uses
+		}
+	}
+	return nil
+}
+
+func getPromotion(q querier, id string) (*models.Promotion, error) {
+	promotion, err := scanPromotion(q.QueryRow(`SELECT `+promotionColumns+` FROM promotions WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return promotion, loadPromotionDetails(q, promotion)
+}
+
+// requirePromotionReferences returns ErrInvalidReference unless every
+// product the promotion names exists
+func requirePromotionReferences(tx *sql.Tx, promotion *models.Promotion) error {
+	for _, id := range promotion.ProductIDs {
+		if err := requireReference(tx, "products", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deletePromotionDetails deletes the stored products, categories and uses
+// of a promotion
+func deletePromotionDetails(tx *sql.Tx, id string) error {
+	for _, table := range []string{"promotion_products", "promotion_categories", "promotion_uses"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE promotion_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replacePromotionDetails replaces the stored products, categories and
+// uses of promotion with its current ones
+func replacePromotionDetails(tx *sql.Tx, promotion *models.Promotion) error {
+	if err := deletePromotionDetails(tx, promotion.ID); err != nil {
+		return err
+	}
+	for i, id := range promotion.ProductIDs {
+		_, err := tx.Exec(`INSERT INTO promotion_products (promotion_id, seq, product_id) VALUES (?, ?, ?)`,
+			promotion.ID, i, id)
+		if err != nil {
+			return err
+		}
+	}
+	for i, category := range promotion.Categories {
+		_, err := tx.Exec(`INSERT INTO promotion_categories (promotion_id, seq, category) VALUES (?, ?, ?)`,
+			promotion.ID, i, category)
+		if err != nil {
+			return err
+		}
+	}
+	for buyerID, uses := range promotion.Uses {
+		_, err := tx.Exec(`INSERT INTO promotion_uses (promotion_id, buyer_id, uses) VALUES (?, ?, ?)`,
+			promotion.ID, buyerID, uses)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepository) CreatePromotion(promotion *models.Promotion) error {
+	promotion.CreatedAt = time.Now()
+	promotion.Version = 1
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := requireAbsent(tx, "promotions", promotion.ID); err != nil {
+			return err
+		}
+		if err := requirePromotionReferences(tx, promotion); err != nil {
+			return err
+		}
+		p := promotion
+		_, err := tx.Exec(`INSERT INTO promotions (`+promotionColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			p.ID, p.Name, p.Type, p.Percent, p.Amount.Minor, p.Amount.Currency, p.BuyQuantity, p.FreeQuantity,
+			p.Unit, p.MinSubtotal.Minor, p.MinSubtotal.Currency, p.Exclusive, p.Group, p.Priority,
+			p.ValidFrom, p.ValidTo, p.LimitPerBuyer, p.CreatedAt, p.Version)
+		if err != nil {
+			return err
+		}
+		if err := replacePromotionDetails(tx, promotion); err != nil {
+			return err
+		}
+		return recordChange(tx, kindPromotion, promotion.ID, nil, promotion)
+	})
+}
+
+func (r *SQLRepository) GetPromotion(id string) (*models.Promotion, error) {
+	return getPromotion(r.conn(), id)
+}
+
+// ListPromotions returns every promotion, ordered by ID
+func (r *SQLRepository) ListPromotions() ([]*models.Promotion, error) {
+	promotions, err := selectRows(r.conn(), scanPromotion, `SELECT `+promotionColumns+` FROM promotions ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return promotions, loadPromotionDetails(r.conn(), promotions...)
+}
+
+func (r *SQLRepository) UpdatePromotion(promotion *models.Promotion) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getPromotion(tx, promotion.ID)
+		if err != nil {
+			return err
+		}
+		if err := requirePromotionReferences(tx, promotion); err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, promotion.Version); err != nil {
+			return err
+		}
+		promotion.CreatedAt = existing.CreatedAt
+		promotion.Version = existing.Version + 1
+		p := promotion
+		err = execVersioned(tx, `UPDATE promotions SET name = ?, type = ?, percent_basis_points = ?, amount_minor = ?,
+			amount_currency = ?, buy_quantity = ?, free_quantity = ?, unit = ?, min_subtotal_minor = ?,
+			min_subtotal_currency = ?, exclusive = ?, group_name = ?, priority = ?, valid_from = ?, valid_to = ?,
+			limit_per_buyer = ?, version = ? WHERE id = ? AND version = ?`,
+			p.Name, p.Type, p.Percent, p.Amount.Minor, p.Amount.Currency, p.BuyQuantity, p.FreeQuantity,
+			p.Unit, p.MinSubtotal.Minor, p.MinSubtotal.Currency, p.Exclusive, p.Group, p.Priority,
+			p.ValidFrom, p.ValidTo, p.LimitPerBuyer, p.Version, p.ID, existing.Version)
+		if err != nil {
+			return err
+		}
+		if err := replacePromotionDetails(tx, promotion); err != nil {
+			return err
+		}
+		return recordChange(tx, kindPromotion, promotion.ID, existing, promotion)
+	})
+}
+
+func (r *SQLRepository) DeletePromotion(id string, version int64) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getPromotion(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		if err := deletePromotionDetails(tx, id); err != nil {
+			return err
+		}
+		if err := execVersioned(tx, `DELETE FROM promotions WHERE id = ? AND version = ?`, id, existing.Version); err != nil {
+			return err
+		}
+		return recordChange(tx, kindPromotion, id, existing, nil)
+	})
+}
+
+// Serial methods
+
+const serialColumns = `id, product_id, item_id, location, status, buyer_id, reference, created_at, updated_at,
+	version`
+
+func scanSerial(row scanner) (*models.Serial, error) {
+	var serial models.Serial
+	err := row.Scan(&serial.ID, &serial.ProductID, &serial.ItemID, &serial.Location, &serial.Status,
+		&serial.BuyerID, &serial.Reference, &serial.CreatedAt, &serial.UpdatedAt, &serial.Version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &serial, nil
+}
+
+func getSerial(q querier, id string) (*models.Serial, error) {
+	return scanSerial(q.QueryRow(`SELECT `+serialColumns+` FROM serials WHERE id = ?`, id))
+}
+
+// requireSerialReferences returns ErrInvalidReference unless the serial's
+// product and item exist, and its buyer if it has one. The buyer has no
+// foreign key because it is empty until the unit is sold.
+func requireSerialReferences(tx *sql.Tx, serial *models.Serial) error {
+	if err := requireReference(tx, "products", serial.ProductID); err != nil {
+		return err
+	}
+	if err := requireReference(tx, "inventory_items", serial.ItemID); err != nil {
+		return err
+	}
+	if serial.BuyerID == "" {
+		return nil
+	}
+	return requireReference(tx, "buyers", serial.BuyerID)
+}
+
+func (r *SQLRepository) CreateSerial(serial *models.Serial) error {
+	serial.CreatedAt = time.Now()
+	serial.UpdatedAt = serial.CreatedAt
+	serial.Version = 1
+	return r.inTx(func(tx *sql.Tx) error {
+		if err := requireAbsent(tx, "serials", serial.ID); err != nil {
+			return err
+		}
+		if err := requireSerialReferences(tx, serial); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO serials (`+serialColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			serial.ID, serial.ProductID, serial.ItemID, serial.Location, serial.Status,
+			serial.BuyerID, serial.Reference, serial.CreatedAt, serial.UpdatedAt, serial.Version)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, kindSerial, serial.ID, nil, serial)
+	})
+}
+
+func (r *SQLRepository) GetSerial(id string) (*models.Serial, error) {
+	return getSerial(r.conn(), id)
+}
+
+// FindSerials returns the serials matching filter, ordered by ID
+func (r *SQLRepository) FindSerials(filter SerialFilter) ([]*models.Serial, error) {
+	where, args := whereEqual(
+		column{"product_id", filter.ProductID},
+		column{"item_id", filter.ItemID},
+		column{"status", string(filter.Status)},
+		column{"buyer_id", filter.BuyerID},
+	)
+	return selectRows(r.conn(), scanSerial, `SELECT `+serialColumns+` FROM serials`+where+` ORDER BY id`, args...)
+}
+
+// UpdateSerial replaces every field of a serial except ID and CreatedAt
+func (r *SQLRepository) UpdateSerial(serial *models.Serial) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getSerial(tx, serial.ID)
+		if err != nil {
+			return err
+		}
+		if err := requireSerialReferences(tx, serial); err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, serial.Version); err != nil {
+			return err
+		}
+		serial.CreatedAt = existing.CreatedAt
+		serial.UpdatedAt = time.Now()
+		serial.Version = existing.Version + 1
+		err = execVersioned(tx, `UPDATE serials SET product_id = ?, item_id = ?, location = ?, status = ?, buyer_id = ?,
+			reference = ?, updated_at = ?, version = ? WHERE id = ? AND version = ?`,
+			serial.ProductID, serial.ItemID, serial.Location, serial.Status, serial.BuyerID,
+			serial.Reference, serial.UpdatedAt, serial.Version, serial.ID, existing.Version)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, kindSerial, serial.ID, existing, serial)
+	})
+}
+
+func (r *SQLRepository) DeleteSerial(id string, version int64) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		existing, err := getSerial(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		if err := execVersioned(tx, `DELETE FROM serials WHERE id = ? AND version = ?`, id, existing.Version); err != nil {
+			return err
+		}
+		return recordChange(tx, kindSerial, id, existing, nil)
+	})
+}
+
+// Change log methods
+
+const changeColumns = `seq, entity, entity_id, op, version, before_image, after_image, committed_at`
+
+func scanChange(row scanner) (*models.ChangeEvent, error) {
+	var event models.ChangeEvent
+	var before, after sql.NullString
+	err := row.Scan(&event.Seq, &event.Entity, &event.ID, &event.Op, &event.Version,
+		&before, &after, &event.CommittedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if before.Valid {
+		event.Before = json.RawMessage(before.String)
+	}
+	if after.Valid {
+		event.After = json.RawMessage(after.String)
+	}
+	return &event, nil
+}
+
+// recordChange appends the change event for a change of an entity from
+// before to after, either of which may be nil, to the change log
+func recordChange(tx *sql.Tx, kind, id string, before, after any) error {
+	event, err := newChangeEvent(kind, id, before, after)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO change_events (entity, entity_id, op, version, before_image, after_image, committed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		event.Entity, event.ID, event.Op, event.Version,
+		image(event.Before), image(event.After), event.CommittedAt)
+	return err
+}
+
+// image returns a change event image as a nullable column value
+func image(raw json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(raw), Valid: raw != nil}
+}
+
+// Changes returns up to limit change events with Seq greater than after,
+// oldest first. A limit of zero or less means no limit.
+func (r *SQLRepository) Changes(after int64, limit int) ([]*models.ChangeEvent, error) {
+	query := `SELECT ` + changeColumns + ` FROM change_events WHERE seq > ? ORDER BY seq`
+	args := []any{after}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	return selectRows(r.conn(), scanChange, query, args...)
+}
+
+// FindChanges returns the change events matching filter, oldest first.
+// Commit times are stored as text in the committing process's zone, so
+// they are compared here rather than in SQL: the keys of the events are
+// read first, and the images only of the events the filter keeps.
+func (r *SQLRepository) FindChanges(filter ChangeFilter) ([]*models.ChangeEvent, error) {
+	where, args := whereEqual(
+		column{"entity", filter.Entity},
+		column{"entity_id", filter.ID},
+	)
+	if filter.After.IsZero() && filter.Until.IsZero() && filter.Keep == KeepAll {
+		return selectRows(r.conn(), scanChange, `SELECT `+changeColumns+` FROM change_events`+where+` ORDER BY seq`, args...)
+	}
+
+	keys, err := selectRows(r.conn(), scanChangeKey, `SELECT `+changeKeyColumns+` FROM change_events`+where+` ORDER BY seq`, args...)
+	if err != nil {
+		return nil, err
+	}
+	matching := keys[:0]
+	for _, key := range keys {
+		if filter.matches(key) {
+			matching = append(matching, key)
+		}
+	}
+
+	kept := filter.keep(matching)
+	events := make([]*models.ChangeEvent, 0, len(kept))
+	for _, key := range kept {
+		event, err := scanChange(r.conn().QueryRow(`SELECT `+changeColumns+` FROM change_events WHERE seq = ?`, key.Seq))
+		if err == ErrNotFound {
+			continue // compacted since the keys were read
+		} else if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// CompactChanges removes the events before the cutoff that are not needed
+// to reconstruct states at or after it, as described for ChangeFeed
+func (r *SQLRepository) CompactChanges(before time.Time) (int, error) {
+	count := 0
+	err := r.inTx(func(tx *sql.Tx) error {
+		keys, err := selectRows(tx, scanChangeKey, `SELECT `+changeKeyColumns+` FROM change_events ORDER BY seq`)
+		if err != nil {
+			return err
+		}
+		removed := compactable(keys, before)
+		for _, event := range removed {
+			if _, err := tx.Exec(`DELETE FROM change_events WHERE seq = ?`, event.Seq); err != nil {
+				return err
+			}
+		}
+		count = len(removed)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// changeKeyColumns are the columns of a change event apart from its images
+const changeKeyColumns = `seq, entity, entity_id, op, version, committed_at`
+
+// scanChangeKey scans a change event without its images
+func scanChangeKey(row scanner) (*models.ChangeEvent, error) {
+	var event models.ChangeEvent
+	err := row.Scan(&event.Seq, &event.Entity, &event.ID, &event.Op, &event.Version, &event.CommittedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &event, nil
+}
+
+// Subscribe delivers the change events after seq after, then each event as
+// it is committed. It is woken by commits through this repository; changes
+// committed by other processes are delivered with the next one.
+func (r *SQLRepository) Subscribe(after int64) (*Subscription, error) {
+	return subscribe(r.Changes, r.changed, after), nil
+}
@@ -0,0 +1,373 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+)
+
+// SellerReader reads sellers
+type SellerReader interface {
+	GetSeller(id string) (*models.Seller, error)
+	ListSellers() ([]*models.Seller, error)
+}
+
+// SellerStore persists sellers
+type SellerStore interface {
+	SellerReader
+	CreateSeller(seller *models.Seller) error
+	UpdateSeller(seller *models.Seller) error
+	DeleteSeller(id string, version int64) error
+}
+
+// BuyerReader reads buyers
+type BuyerReader interface {
+	GetBuyer(id string) (*models.Buyer, error)
+	ListBuyers() ([]*models.Buyer, error)
+}
+
+// BuyerStore persists buyers
+type BuyerStore interface {
+	BuyerReader
+	CreateBuyer(buyer *models.Buyer) error
+	UpdateBuyer(buyer *models.Buyer) error
+	DeleteBuyer(id string, version int64) error
+}
+
+// VendorReader reads vendors
+type VendorReader interface {
+	GetVendor(id string) (*models.Vendor, error)
+	ListVendors() ([]*models.Vendor, error)
+}
+
+// VendorStore persists vendors
+type VendorStore interface {
+	VendorReader
+	CreateVendor(vendor *models.Vendor) error
+	UpdateVendor(vendor *models.Vendor) error
+	DeleteVendor(id string, version int64, cascade bool) error
+}
+
+// ProductReader reads products
+type ProductReader interface {
+	GetProduct(id string) (*models.Product, error)
+	ListProducts() ([]*models.Product, error)
+	FindProducts(filter ProductFilter) ([]*models.Product, error)
+}
+
+// ProductStore persists products
+type ProductStore interface {
+	ProductReader
+	CreateProduct(product *models.Product) error
+	UpdateProduct(product *models.Product) error
+	DeleteProduct(id string, version int64, cascade bool) error
+}
+
+// InventoryReader reads inventory items
+type InventoryReader interface {
+	GetInventoryItem(id string) (*models.InventoryItem, error)
+	ListInventoryItems() ([]*models.InventoryItem, error)
+	FindInventoryItems(filter InventoryFilter) ([]*models.InventoryItem, error)
+}
+
+// InventoryStore persists inventory items
+type InventoryStore interface {
+	InventoryReader
+	CreateInventoryItem(item *models.InventoryItem) error
+	UpdateInventoryItem(item *models.InventoryItem) error
+	DeleteInventoryItem(id string, version int64) error
+}
+
+// MovementReader reads the stock movement ledger
+type MovementReader interface {
+	ListMovements(itemID string) ([]*models.StockMovement, error)
+}
+
+// MovementStore persists the stock movement ledger
+type MovementStore interface {
+	MovementReader
+	PostMovement(movement *models.StockMovement, version int64) error
+}
+
+// ChangeFeed publishes a change event for every entity created, updated or
+// deleted by a committed write, including the stock movements and cascaded
+// deletes a write implies. The events of one write or unit of work have
+// consecutive sequence numbers. Consumers can resume after a restart by
+// passing the last Seq they processed.
+//
+// CompactChanges bounds the log: of the events committed before a cutoff
+// it keeps only the newest of each entity, and none for an entity whose
+// newest is a delete, and returns how many it removed. States at or after
+// the cutoff can still be reconstructed; revisions before it are lost, and
+// consumers resuming from before it skip the removed events.
+type ChangeFeed interface {
+	Changes(after int64, limit int) ([]*models.ChangeEvent, error)
+	FindChanges(filter ChangeFilter) ([]*models.ChangeEvent, error)
+	Subscribe(after int64) (*Subscription, error)
+	CompactChanges(before time.Time) (int, error)
+}
+
+// ReservationReader reads reservations
+type ReservationReader interface {
+	GetReservation(id string) (*models.Reservation, error)
+	ListReservations(itemID string) ([]*models.Reservation, error)
+}
+
+// ReservationStore persists reservations
+type ReservationStore interface {
+	ReservationReader
+	CreateReservation(reservation *models.Reservation) error
+	ReleaseReservation(id string) error
+	ReleaseExpiredReservations(now time.Time) (int, error)
+}
+
+// SalesOrderReader reads sales orders
+type SalesOrderReader interface {
+	GetSalesOrder(id string) (*models.SalesOrder, error)
+	FindSalesOrders(filter SalesOrderFilter) ([]*models.SalesOrder, error)
+}
+
+// SalesOrderStore persists sales orders. It checks that an order's buyer
+// and the products of its lines exist but leaves its status rules to the
+// caller.
+type SalesOrderStore interface {
+	SalesOrderReader
+	CreateSalesOrder(order *models.SalesOrder) error
+	UpdateSalesOrder(order *models.SalesOrder) error
+	DeleteSalesOrder(id string, version int64) error
+}
+
+// PurchaseOrderReader reads purchase orders
+type PurchaseOrderReader interface {
+	GetPurchaseOrder(id string) (*models.PurchaseOrder, error)
+	FindPurchaseOrders(filter PurchaseOrderFilter) ([]*models.PurchaseOrder, error)
+}
+
+// PurchaseOrderStore persists purchase orders. Like SalesOrderStore it
+// checks references but leaves status and receiving rules to the caller.
+type PurchaseOrderStore interface {
+	PurchaseOrderReader
+	CreatePurchaseOrder(order *models.PurchaseOrder) error
+	UpdatePurchaseOrder(order *models.PurchaseOrder) error
+	DeletePurchaseOrder(id string, version int64) error
+}
+
+// LocationReader reads locations
+type LocationReader interface {
+	GetLocation(id string) (*models.Location, error)
+	FindLocations(filter LocationFilter) ([]*models.Location, error)
+}
+
+// LocationStore persists locations. It checks that a location's parent
+// exists but leaves the shape of the hierarchy to the caller.
+type LocationStore interface {
+	LocationReader
+	CreateLocation(location *models.Location) error
+	UpdateLocation(location *models.Location) error
+	DeleteLocation(id string, version int64) error
+}
+
+// TransferOrderReader reads transfer orders
+type TransferOrderReader interface {
+	GetTransferOrder(id string) (*models.TransferOrder, error)
+	FindTransferOrders(filter TransferOrderFilter) ([]*models.TransferOrder, error)
+}
+
+// TransferOrderStore persists transfer orders. Like SalesOrderStore it
+// checks references but leaves status and stock rules to the caller.
+type TransferOrderStore interface {
+	TransferOrderReader
+	CreateTransferOrder(order *models.TransferOrder) error
+	UpdateTransferOrder(order *models.TransferOrder) error
+	DeleteTransferOrder(id string, version int64) error
+}
+
+// SerialReader reads serial numbers
+type SerialReader interface {
+	GetSerial(id string) (*models.Serial, error)
+	FindSerials(filter SerialFilter) ([]*models.Serial, error)
+}
+
+// SerialStore persists serial numbers. It checks references but leaves
+// status and stock rules to the caller.
+type SerialStore interface {
+	SerialReader
+	CreateSerial(serial *models.Serial) error
+	UpdateSerial(serial *models.Serial) error
+	DeleteSerial(id string, version int64) error
+}
+
+// PriceListReader reads price lists
+type PriceListReader interface {
+	GetPriceList(id string) (*models.PriceList, error)
+	ListPriceLists() ([]*models.PriceList, error)
+}
+
+// PriceListStore persists price lists. It checks that their products exist
+// but leaves the prices themselves to the caller.
+type PriceListStore interface {
+	PriceListReader
+	CreatePriceList(list *models.PriceList) error
+	UpdatePriceList(list *models.PriceList) error
+	DeletePriceList(id string, version int64) error
+}
+
+// PromotionReader reads promotions
+type PromotionReader interface {
+	GetPromotion(id string) (*models.Promotion, error)
+	ListPromotions() ([]*models.Promotion, error)
+}
+
+// PromotionStore persists promotions. It checks that the products they name
+// exist but leaves their rules and uses to the caller.
+type PromotionStore interface {
+	PromotionReader
+	CreatePromotion(promotion *models.Promotion) error
+	UpdatePromotion(promotion *models.Promotion) error
+	DeletePromotion(id string, version int64) error
+}
+
+// Reader is the set of read operations on all entities. Returned entities
+// are copies that the caller owns.
+type Reader interface {
+	SellerReader
+	BuyerReader
+	VendorReader
+	ProductReader
+	InventoryReader
+	MovementReader
+	ReservationReader
+	SalesOrderReader
+	PurchaseOrderReader
+	LocationReader
+	TransferOrderReader
+	SerialReader
+	PriceListReader
+	PromotionReader
+}
+
+// Snapshot is a read-only view of a store at the instant it was taken.
+// Reads from a snapshot are consistent with each other however long they
+// take and whatever is written to the store meanwhile. Close releases the
+// snapshot; it must not be used afterwards.
+type Snapshot interface {
+	Reader
+	Close() error
+}
+
+// EntityStore is the set of operations on all entities. It is implemented
+// both by a Store and by a Tx staging a unit of work against one.
+type EntityStore interface {
+	SellerStore
+	BuyerStore
+	VendorStore
+	ProductStore
+	InventoryStore
+	MovementStore
+	ReservationStore
+	SalesOrderStore
+	PurchaseOrderStore
+	LocationStore
+	TransferOrderStore
+	SerialStore
+	PriceListStore
+	PromotionStore
+}
+
+// Tx is a unit of work begun by Store.Begin. Operations on a Tx see the
+// store as it was at Begin plus the operations staged so far; an operation
+// that fails leaves earlier ones staged. Commit makes every staged
+// operation visible at once, durably if the store is durable, and Rollback
+// discards them. Exactly one of the two must be called, after which the Tx
+// must not be used; further calls to Commit or Rollback return ErrTxDone.
+type Tx interface {
+	EntityStore
+	Commit() error
+	Rollback() error
+}
+
+// Store is a storage backend for all entities. Implementations return the
+// package's sentinel errors unwrapped so callers can compare them directly,
+// and must pass the conformance suite in package storetest.
+//
+// Stores enforce referential integrity: creating or updating a product or
+// inventory item whose vendor or product does not exist fails with
+// ErrInvalidReference, and deleting a vendor with products or a product with
+// inventory fails with ErrInUse unless cascade is requested. Updates replace
+// every field except ID and CreatedAt.
+//
+// A product's ParentID, when set, must name another product, as must each
+// of its Components. A product with variants or that is a component of a
+// kit cannot be deleted; cascading a vendor deletion deletes variants and
+// kits with the products they reference, but fails with ErrInUse if
+// another vendor's product is a variant or kit of one of them. A sales
+// order allocation's ProductID is stored as given.
+//
+// An inventory item's Quantity is the balance of its stock movements.
+// Creating an item with a non-zero quantity records an opening balance
+// movement, updating an item leaves its quantity unchanged, and deleting it
+// deletes its ledger. A movement that would make the balance negative, or
+// that removes more than the item has available, fails with
+// ErrInsufficientStock. A movement whose ReservationID names a reservation
+// of its item consumes it: the reservation is released with the movement,
+// so the movement may take the stock it held. A ReservationID naming a
+// missing reservation, or one of another item, fails with
+// ErrInvalidReference. PostMovement takes the expected item version like a
+// delete does.
+//
+// Reservations hold an item's stock for an owner until they are released
+// or expire. An item's Reserved is the total its reservations hold and its
+// Available is Quantity less Reserved. Creating a reservation releases the
+// item's expired reservations and then fails with ErrInsufficientStock if
+// it would hold more than is available; creating one for a missing item
+// fails with ErrInvalidReference. Releasing reservations, including through
+// ReleaseExpiredReservations, and creating them update the item, so they
+// increment its version. Deleting an item deletes its reservations.
+//
+// Sales orders reference a buyer and purchase orders a vendor, and both
+// reference products through their lines. Creating or updating an order
+// with a missing buyer, vendor or product fails with ErrInvalidReference,
+// and deleting a buyer, vendor or product that an order references fails
+// with ErrInUse, even when cascade is requested.
+//
+// Locations may reference a parent location, which must exist. Deleting a
+// location that has children, that an inventory item's Location names or
+// that a transfer order moves stock from or to fails with ErrInUse. Stores
+// do not check an item's Location, which the service validates. Transfer
+// orders reference their locations and products like the other orders do.
+//
+// Serials reference a product, an inventory item and, once sold, a buyer.
+// Creating or updating a serial with a missing product, item or buyer fails
+// with ErrInvalidReference, and deleting any of them while a serial
+// references it fails with ErrInUse, even when cascade is requested.
+//
+// Price lists reference the products they price, and buyers may reference
+// a price list. Creating or updating either with a missing reference fails
+// with ErrInvalidReference, and deleting a product that a price list
+// prices, or a price list that a buyer is assigned, fails with ErrInUse,
+// even when cascade is requested. Promotions reference the products they
+// name in the same way price lists do.
+//
+// Every entity has a Version that is 1 after creation and incremented by
+// each update. Updates and deletes are compare-and-swap operations: the
+// expected version is the Version field of the entity passed to an update,
+// or the version argument of a delete, and a mismatch fails with
+// ErrVersionMismatch without changing anything. An expected version of zero
+// skips the check. On success the entity passed to an update holds its new
+// version.
+//
+// FindProducts, FindInventoryItems and FindLocations return the entities
+// matching a filter, ordered by ID, using secondary indexes on the filtered
+// fields.
+//
+// Begin starts a unit of work. Backends may serialize writers while a Tx
+// is open, so it should be short-lived. Snapshot takes a point-in-time
+// snapshot.
+type Store interface {
+	EntityStore
+	ChangeFeed
+	Begin() (Tx, error)
+	Snapshot() (Snapshot, error)
+}
+
+var _ Store = (*InMemoryRepository)(nil)
@@ -0,0 +1,88 @@
+package repository_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository/migrations"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository/storetest"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestInMemoryRepositoryConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.Store {
+		return repository.NewInMemoryRepository()
+	})
+}
+
+func TestFileRepositoryConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.Store {
+		repo, err := repository.OpenFileRepository(t.TempDir(), repository.FileOptions{SnapshotEvery: 3})
+		if err != nil {
+			t.Fatalf("Failed to open file repository: %v", err)
+		}
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	})
+}
+
+func TestSQLRepositoryConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.Store {
+		dsn := "file:" + filepath.Join(t.TempDir(), "inventory.db") +
+			"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+		db, err := sql.Open("sqlite", dsn)
+		if err != nil {
+			t.Fatalf("Failed to open database: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		if err := migrations.Up(db); err != nil {
+			t.Fatalf("Failed to migrate database: %v", err)
+		}
+		repo, err := repository.NewSQLRepository(db)
+		if err != nil {
+			t.Fatalf("Failed to create SQL repository: %v", err)
+		}
+		return repo
+	})
+}
+
+func TestSQLRepositoryUpgradesLegacyPrices(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "inventory.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// A product written before prices were exact has only a float price
+	if err := migrations.Migrate(db, 16); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO vendors (id, name, email, phone, address, created_at, version)
+		VALUES ('v1', 'Garden Supplies Co', '', '', '', CURRENT_TIMESTAMP, 1)`); err != nil {
+		t.Fatalf("Failed to insert vendor: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO products (id, name, description, category, price, vendor_id, created_at, version)
+		VALUES ('p1', 'Fertilizer', '', '', 24.99, 'v1', CURRENT_TIMESTAMP, 1)`); err != nil {
+		t.Fatalf("Failed to insert product: %v", err)
+	}
+	if err := migrations.Up(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	repo, err := repository.NewSQLRepository(db)
+	if err != nil {
+		t.Fatalf("Failed to create SQL repository: %v", err)
+	}
+	product, err := repo.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if product.Price != (models.Money{Minor: 2499, Currency: "USD"}) {
+		t.Errorf("Expected the float price to become 24.99 USD, got %v", product.Price)
+	}
+}
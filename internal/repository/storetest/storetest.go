@@ -0,0 +1,3088 @@
+// Package storetest provides a conformance suite that every
+// repository.Store implementation must pass.
+package storetest
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+// Run runs the conformance suite. newStore is called once per subtest and
+// must return an empty store.
+func Run(t *testing.T, newStore func(t *testing.T) repository.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store repository.Store)
+	}{
+		{"CreateAndGetSeller", testCreateAndGetSeller},
+		{"CreateDuplicateSeller", testCreateDuplicateSeller},
+		{"ListSellers", testListSellers},
+		{"CreateAndGetBuyer", testCreateAndGetBuyer},
+		{"CreateAndGetVendor", testCreateAndGetVendor},
+		{"CreateDuplicateEntities", testCreateDuplicateEntities},
+		{"CreateProductAndInventory", testCreateProductAndInventory},
+		{"PostMovement", testPostMovement},
+		{"PostMovementToMissingItem", testPostMovementToMissingItem},
+		{"InsufficientStock", testInsufficientStock},
+		{"MovementHistory", testMovementHistory},
+		{"ListEntities", testListEntities},
+		{"GetNonExistentEntity", testGetNonExistentEntity},
+		{"UpdateEntities", testUpdateEntities},
+		{"UpdateNonExistentEntity", testUpdateNonExistentEntity},
+		{"DeleteEntities", testDeleteEntities},
+		{"DeleteNonExistentEntity", testDeleteNonExistentEntity},
+		{"InvalidReferences", testInvalidReferences},
+		{"DeleteVendorInUse", testDeleteVendorInUse},
+		{"DeleteProductInUse", testDeleteProductInUse},
+		{"VersionIncrements", testVersionIncrements},
+		{"VersionMismatch", testVersionMismatch},
+		{"TxCommit", testTxCommit},
+		{"TxRollback", testTxRollback},
+		{"TxFailedOperation", testTxFailedOperation},
+		{"CopyOnRead", testCopyOnRead},
+		{"Snapshot", testSnapshot},
+		{"SnapshotsAndUnitsOfWork", testSnapshotsAndUnitsOfWork},
+		{"FindProducts", testFindProducts},
+		{"FindInventoryItems", testFindInventoryItems},
+		{"IndexesFollowWrites", testIndexesFollowWrites},
+		{"ChangeEvents", testChangeEvents},
+		{"ChangeEventsForMovementsAndCascades", testChangeEventsForMovementsAndCascades},
+		{"ChangeEventsInTx", testChangeEventsInTx},
+		{"Subscribe", testSubscribe},
+		{"FindChanges", testFindChanges},
+		{"FindChangesByTime", testFindChangesByTime},
+		{"CompactChanges", testCompactChanges},
+		{"ProductHistory", testProductHistory},
+		{"InventoryAsOf", testInventoryAsOf},
+		{"Reservations", testReservations},
+		{"ReservationExpiry", testReservationExpiry},
+		{"DeleteItemWithReservations", testDeleteItemWithReservations},
+		{"MovementsRespectReservations", testMovementsRespectReservations},
+		{"SalesOrders", testSalesOrders},
+		{"PurchaseOrders", testPurchaseOrders},
+		{"Locations", testLocations},
+		{"TransferOrders", testTransferOrders},
+		{"InventoryLots", testInventoryLots},
+		{"Serials", testSerials},
+		{"Units", testUnits},
+		{"Variants", testVariants},
+		{"Kits", testKits},
+		{"PriceLists", testPriceLists},
+		{"Promotions", testPromotions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+// usd returns minor cents of US dollars
+func usd(minor int64) models.Money {
+	return models.Money{Minor: minor, Currency: "USD"}
+}
+
+// seedInventory creates vendor v1, product p1 and inventory item i1
+func seedInventory(t *testing.T, store repository.Store) {
+	t.Helper()
+
+	vendor := &models.Vendor{
+		ID:    "v1",
+		Name:  "Garden Supplies Co",
+		Email: "info@gardensupplies.com",
+		Phone: "555-0200",
+	}
+	if err := store.CreateVendor(vendor); err != nil {
+		t.Fatalf("Failed to create vendor: %v", err)
+	}
+
+	product := &models.Product{
+		ID:          "p1",
+		Name:        "Fertilizer",
+		Description: "Organic fertilizer",
+		Category:    "Soil Amendments",
+		Price:       usd(2999),
+		VendorID:    "v1",
+	}
+	if err := store.CreateProduct(product); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+
+	item := &models.InventoryItem{
+		ID:        "i1",
+		ProductID: "p1",
+		Quantity:  100,
+		Location:  "Warehouse A",
+	}
+	if err := store.CreateInventoryItem(item); err != nil {
+		t.Fatalf("Failed to create inventory item: %v", err)
+	}
+}
+
+func testCreateAndGetSeller(t *testing.T, store repository.Store) {
+	seller := &models.Seller{
+		ID:    "s1",
+		Name:  "John Doe",
+		Email: "john@example.com",
+		Phone: "555-0100",
+	}
+
+	if err := store.CreateSeller(seller); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	if seller.CreatedAt.IsZero() {
+		t.Error("Expected CreatedAt to be set")
+	}
+
+	retrieved, err := store.GetSeller("s1")
+	if err != nil {
+		t.Fatalf("Failed to get seller: %v", err)
+	}
+
+	if retrieved.Name != seller.Name {
+		t.Errorf("Expected name %s, got %s", seller.Name, retrieved.Name)
+	}
+	if retrieved.Email != seller.Email {
+		t.Errorf("Expected email %s, got %s", seller.Email, retrieved.Email)
+	}
+}
+
+func testCreateDuplicateSeller(t *testing.T, store repository.Store) {
+	seller := &models.Seller{
+		ID:    "s1",
+		Name:  "John Doe",
+		Email: "john@example.com",
+		Phone: "555-0100",
+	}
+
+	if err := store.CreateSeller(seller); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+
+	err := store.CreateSeller(seller)
+	if err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+}
+
+func testListSellers(t *testing.T, store repository.Store) {
+	sellers := []*models.Seller{
+		{ID: "s1", Name: "Seller 1", Email: "s1@example.com", Phone: "555-0101"},
+		{ID: "s2", Name: "Seller 2", Email: "s2@example.com", Phone: "555-0102"},
+	}
+
+	for _, seller := range sellers {
+		if err := store.CreateSeller(seller); err != nil {
+			t.Fatalf("Failed to create seller: %v", err)
+		}
+	}
+
+	list, err := store.ListSellers()
+	if err != nil {
+		t.Fatalf("Failed to list sellers: %v", err)
+	}
+
+	if len(list) != 2 {
+		t.Errorf("Expected 2 sellers, got %d", len(list))
+	}
+}
+
+func testCreateAndGetBuyer(t *testing.T, store repository.Store) {
+	buyer := &models.Buyer{
+		ID:      "b1",
+		Name:    "Green Thumb Landscaping",
+		Email:   "orders@greenthumb.com",
+		Phone:   "555-0300",
+		Address: "9 Fern Rd",
+	}
+
+	if err := store.CreateBuyer(buyer); err != nil {
+		t.Fatalf("Failed to create buyer: %v", err)
+	}
+
+	retrieved, err := store.GetBuyer("b1")
+	if err != nil {
+		t.Fatalf("Failed to get buyer: %v", err)
+	}
+
+	if retrieved.Address != buyer.Address {
+		t.Errorf("Expected address %s, got %s", buyer.Address, retrieved.Address)
+	}
+}
+
+func testCreateAndGetVendor(t *testing.T, store repository.Store) {
+	vendor := &models.Vendor{
+		ID:      "v1",
+		Name:    "Garden Supplies Co",
+		Email:   "info@gardensupplies.com",
+		Phone:   "555-0200",
+		Address: "123 Garden St",
+	}
+
+	if err := store.CreateVendor(vendor); err != nil {
+		t.Fatalf("Failed to create vendor: %v", err)
+	}
+
+	retrieved, err := store.GetVendor("v1")
+	if err != nil {
+		t.Fatalf("Failed to get vendor: %v", err)
+	}
+
+	if retrieved.Name != vendor.Name {
+		t.Errorf("Expected name %s, got %s", vendor.Name, retrieved.Name)
+	}
+}
+
+func testCreateDuplicateEntities(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	if err := store.CreateBuyer(&models.Buyer{ID: "b1"}); err != nil {
+		t.Fatalf("Failed to create buyer: %v", err)
+	}
+	if err := store.CreateBuyer(&models.Buyer{ID: "b1"}); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists for buyer, got %v", err)
+	}
+	if err := store.CreateVendor(&models.Vendor{ID: "v1"}); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists for vendor, got %v", err)
+	}
+	if err := store.CreateProduct(&models.Product{ID: "p1", VendorID: "v1"}); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists for product, got %v", err)
+	}
+	if err := store.CreateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1"}); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists for inventory item, got %v", err)
+	}
+}
+
+func testCreateProductAndInventory(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	product, err := store.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if product.VendorID != "v1" {
+		t.Errorf("Expected vendor v1, got %s", product.VendorID)
+	}
+	if product.Price != usd(2999) {
+		t.Errorf("Expected price 29.99, got %v", product.Price)
+	}
+
+	retrieved, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+
+	if retrieved.Quantity != 100 {
+		t.Errorf("Expected quantity 100, got %v", retrieved.Quantity)
+	}
+	if retrieved.Location != "Warehouse A" {
+		t.Errorf("Expected location Warehouse A, got %s", retrieved.Location)
+	}
+	if retrieved.UpdatedAt.IsZero() {
+		t.Error("Expected UpdatedAt to be set")
+	}
+}
+
+// shipment returns a movement that ships quantity units of item i1
+func shipment(quantity float64) *models.StockMovement {
+	return &models.StockMovement{
+		ItemID:    "i1",
+		Type:      models.MovementShipment,
+		Delta:     -quantity,
+		Reason:    "sale",
+		Reference: "SO-1001",
+		Actor:     "alice",
+	}
+}
+
+func testPostMovement(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	movement := shipment(50)
+	if err := store.PostMovement(movement, 0); err != nil {
+		t.Fatalf("Failed to post movement: %v", err)
+	}
+	if movement.ID == 0 || movement.Balance != 50 {
+		t.Errorf("Expected an assigned ID and balance 50, got ID %v and balance %v", movement.ID, movement.Balance)
+	}
+
+	updated, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+
+	if updated.Quantity != 50 {
+		t.Errorf("Expected quantity 50, got %v", updated.Quantity)
+	}
+	if updated.Version != 2 {
+		t.Errorf("Expected posting to bump the item to version 2, got %d", updated.Version)
+	}
+}
+
+func testPostMovementToMissingItem(t *testing.T, store repository.Store) {
+	movement := shipment(10)
+	movement.ItemID = "nonexistent"
+	if err := store.PostMovement(movement, 0); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := store.ListMovements("nonexistent"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound listing movements, got %v", err)
+	}
+}
+
+func testInsufficientStock(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	if err := store.PostMovement(shipment(101), 0); err != repository.ErrInsufficientStock {
+		t.Errorf("Expected ErrInsufficientStock, got %v", err)
+	}
+	item, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Quantity != 100 {
+		t.Errorf("Expected rejected movement to leave quantity 100, got %v", item.Quantity)
+	}
+
+	err = store.CreateInventoryItem(&models.InventoryItem{ID: "i2", ProductID: "p1", Quantity: -1})
+	if err != repository.ErrInsufficientStock {
+		t.Errorf("Expected ErrInsufficientStock creating a negative item, got %v", err)
+	}
+}
+
+func testMovementHistory(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	if err := store.PostMovement(shipment(30), 0); err != nil {
+		t.Fatalf("Failed to post shipment: %v", err)
+	}
+	receipt := &models.StockMovement{ItemID: "i1", Type: models.MovementReceipt, Delta: 40, Reason: "purchase", Reference: "PO-7", Actor: "bob"}
+	if err := store.PostMovement(receipt, 0); err != nil {
+		t.Fatalf("Failed to post receipt: %v", err)
+	}
+
+	movements, err := store.ListMovements("i1")
+	if err != nil {
+		t.Fatalf("Failed to list movements: %v", err)
+	}
+	if len(movements) != 3 {
+		t.Fatalf("Expected opening balance and 2 movements, got %d", len(movements))
+	}
+
+	opening := movements[0]
+	if opening.Reason != models.ReasonOpeningBalance || opening.Delta != 100 || opening.Balance != 100 {
+		t.Errorf("Expected an opening balance of 100, got %+v", opening)
+	}
+	if movements[1].Delta != -30 || movements[1].Balance != 70 || movements[1].Reference != "SO-1001" || movements[1].Actor != "alice" {
+		t.Errorf("Expected the shipment second, got %+v", movements[1])
+	}
+	if movements[2].Type != models.MovementReceipt || movements[2].Balance != 110 {
+		t.Errorf("Expected the receipt last with balance 110, got %+v", movements[2])
+	}
+	if !(movements[0].ID < movements[1].ID && movements[1].ID < movements[2].ID) {
+		t.Errorf("Expected increasing movement IDs, got %d, %d, %d", movements[0].ID, movements[1].ID, movements[2].ID)
+	}
+
+	// Updating an item never changes its quantity
+	if err := store.UpdateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 5}); err != nil {
+		t.Fatalf("Failed to update inventory item: %v", err)
+	}
+	item, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Quantity != 110 {
+		t.Errorf("Expected quantity to stay at the ledger balance 110, got %v", item.Quantity)
+	}
+
+	// Deleting the item deletes its ledger
+	if err := store.DeleteProduct("p1", 0, true); err != nil {
+		t.Fatalf("Failed to cascade delete product: %v", err)
+	}
+	if err := store.CreateProduct(&models.Product{ID: "p1", VendorID: "v1"}); err != nil {
+		t.Fatalf("Failed to recreate product: %v", err)
+	}
+	if err := store.CreateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1"}); err != nil {
+		t.Fatalf("Failed to recreate inventory item: %v", err)
+	}
+	movements, err = store.ListMovements("i1")
+	if err != nil {
+		t.Fatalf("Failed to list movements: %v", err)
+	}
+	if len(movements) != 0 {
+		t.Errorf("Expected a recreated empty item to have no movements, got %d", len(movements))
+	}
+}
+
+func testListEntities(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	buyers, err := store.ListBuyers()
+	if err != nil {
+		t.Fatalf("Failed to list buyers: %v", err)
+	}
+	if len(buyers) != 0 {
+		t.Errorf("Expected 0 buyers, got %d", len(buyers))
+	}
+
+	vendors, err := store.ListVendors()
+	if err != nil {
+		t.Fatalf("Failed to list vendors: %v", err)
+	}
+	if len(vendors) != 1 {
+		t.Errorf("Expected 1 vendor, got %d", len(vendors))
+	}
+
+	products, err := store.ListProducts()
+	if err != nil {
+		t.Fatalf("Failed to list products: %v", err)
+	}
+	if len(products) != 1 {
+		t.Errorf("Expected 1 product, got %d", len(products))
+	}
+
+	items, err := store.ListInventoryItems()
+	if err != nil {
+		t.Fatalf("Failed to list inventory items: %v", err)
+	}
+	if len(items) != 1 {
+		t.Errorf("Expected 1 inventory item, got %d", len(items))
+	}
+}
+
+func testGetNonExistentEntity(t *testing.T, store repository.Store) {
+	_, err := store.GetSeller("nonexistent")
+	if err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	_, err = store.GetBuyer("nonexistent")
+	if err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	_, err = store.GetVendor("nonexistent")
+	if err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	_, err = store.GetProduct("nonexistent")
+	if err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	_, err = store.GetInventoryItem("nonexistent")
+	if err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func testUpdateEntities(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	if err := store.CreateSeller(&models.Seller{ID: "s1", Name: "John Doe"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	original, err := store.GetSeller("s1")
+	if err != nil {
+		t.Fatalf("Failed to get seller: %v", err)
+	}
+	createdAt := original.CreatedAt
+
+	seller := &models.Seller{ID: "s1", Name: "Jane Doe", Email: "jane@example.com"}
+	if err := store.UpdateSeller(seller); err != nil {
+		t.Fatalf("Failed to update seller: %v", err)
+	}
+	if !seller.CreatedAt.Equal(createdAt) {
+		t.Errorf("Expected CreatedAt %v to be preserved, got %v", createdAt, seller.CreatedAt)
+	}
+	retrievedSeller, err := store.GetSeller("s1")
+	if err != nil {
+		t.Fatalf("Failed to get seller: %v", err)
+	}
+	if retrievedSeller.Name != "Jane Doe" || retrievedSeller.Email != "jane@example.com" {
+		t.Errorf("Expected updated seller, got %+v", retrievedSeller)
+	}
+
+	if err := store.CreateBuyer(&models.Buyer{ID: "b1", Name: "Green Thumb"}); err != nil {
+		t.Fatalf("Failed to create buyer: %v", err)
+	}
+	if err := store.UpdateBuyer(&models.Buyer{ID: "b1", Name: "Green Thumb", Address: "9 Fern Rd"}); err != nil {
+		t.Fatalf("Failed to update buyer: %v", err)
+	}
+	retrievedBuyer, err := store.GetBuyer("b1")
+	if err != nil {
+		t.Fatalf("Failed to get buyer: %v", err)
+	}
+	if retrievedBuyer.Address != "9 Fern Rd" {
+		t.Errorf("Expected address 9 Fern Rd, got %s", retrievedBuyer.Address)
+	}
+
+	if err := store.UpdateVendor(&models.Vendor{ID: "v1", Name: "Garden Supplies Inc"}); err != nil {
+		t.Fatalf("Failed to update vendor: %v", err)
+	}
+	retrievedVendor, err := store.GetVendor("v1")
+	if err != nil {
+		t.Fatalf("Failed to get vendor: %v", err)
+	}
+	if retrievedVendor.Name != "Garden Supplies Inc" {
+		t.Errorf("Expected name Garden Supplies Inc, got %s", retrievedVendor.Name)
+	}
+
+	product := &models.Product{ID: "p1", Name: "Fertilizer", Category: "Soil Amendments", Price: usd(2499), VendorID: "v1"}
+	if err := store.UpdateProduct(product); err != nil {
+		t.Fatalf("Failed to update product: %v", err)
+	}
+	retrievedProduct, err := store.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if retrievedProduct.Price != usd(2499) {
+		t.Errorf("Expected price 24.99, got %v", retrievedProduct.Price)
+	}
+
+	item := &models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 80, Location: "Warehouse B"}
+	if err := store.UpdateInventoryItem(item); err != nil {
+		t.Fatalf("Failed to update inventory item: %v", err)
+	}
+	retrievedItem, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if retrievedItem.Quantity != 100 || retrievedItem.Location != "Warehouse B" {
+		t.Errorf("Expected quantity 100 at Warehouse B, got %v at %s", retrievedItem.Quantity, retrievedItem.Location)
+	}
+}
+
+func testUpdateNonExistentEntity(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	if err := store.UpdateSeller(&models.Seller{ID: "nonexistent"}); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for seller, got %v", err)
+	}
+	if err := store.UpdateBuyer(&models.Buyer{ID: "nonexistent"}); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for buyer, got %v", err)
+	}
+	if err := store.UpdateVendor(&models.Vendor{ID: "nonexistent"}); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for vendor, got %v", err)
+	}
+	if err := store.UpdateProduct(&models.Product{ID: "nonexistent", VendorID: "v1"}); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for product, got %v", err)
+	}
+	if err := store.UpdateInventoryItem(&models.InventoryItem{ID: "nonexistent", ProductID: "p1"}); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for inventory item, got %v", err)
+	}
+}
+
+func testDeleteEntities(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	if err := store.CreateSeller(&models.Seller{ID: "s1"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	if err := store.CreateBuyer(&models.Buyer{ID: "b1"}); err != nil {
+		t.Fatalf("Failed to create buyer: %v", err)
+	}
+
+	if err := store.DeleteSeller("s1", 0); err != nil {
+		t.Fatalf("Failed to delete seller: %v", err)
+	}
+	if _, err := store.GetSeller("s1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for deleted seller, got %v", err)
+	}
+
+	if err := store.DeleteBuyer("b1", 0); err != nil {
+		t.Fatalf("Failed to delete buyer: %v", err)
+	}
+	if _, err := store.GetBuyer("b1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for deleted buyer, got %v", err)
+	}
+
+	if err := store.DeleteInventoryItem("i1", 0); err != nil {
+		t.Fatalf("Failed to delete inventory item: %v", err)
+	}
+	if err := store.DeleteProduct("p1", 0, false); err != nil {
+		t.Fatalf("Failed to delete product without inventory: %v", err)
+	}
+	if err := store.DeleteVendor("v1", 0, false); err != nil {
+		t.Fatalf("Failed to delete vendor without products: %v", err)
+	}
+	if _, err := store.GetVendor("v1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for deleted vendor, got %v", err)
+	}
+}
+
+func testDeleteNonExistentEntity(t *testing.T, store repository.Store) {
+	if err := store.DeleteSeller("nonexistent", 0); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for seller, got %v", err)
+	}
+	if err := store.DeleteBuyer("nonexistent", 0); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for buyer, got %v", err)
+	}
+	if err := store.DeleteVendor("nonexistent", 0, true); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for vendor, got %v", err)
+	}
+	if err := store.DeleteProduct("nonexistent", 0, true); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for product, got %v", err)
+	}
+	if err := store.DeleteInventoryItem("nonexistent", 0); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for inventory item, got %v", err)
+	}
+}
+
+func testInvalidReferences(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	err := store.CreateProduct(&models.Product{ID: "p2", VendorID: "nonexistent"})
+	if err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference creating product, got %v", err)
+	}
+	err = store.UpdateProduct(&models.Product{ID: "p1", VendorID: "nonexistent"})
+	if err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference updating product, got %v", err)
+	}
+	err = store.CreateInventoryItem(&models.InventoryItem{ID: "i2", ProductID: "nonexistent"})
+	if err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference creating inventory item, got %v", err)
+	}
+	err = store.UpdateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "nonexistent"})
+	if err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference updating inventory item, got %v", err)
+	}
+
+	product, err := store.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if product.VendorID != "v1" {
+		t.Errorf("Expected rejected update to leave vendor v1, got %s", product.VendorID)
+	}
+}
+
+func testDeleteVendorInUse(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	if err := store.DeleteVendor("v1", 0, false); err != repository.ErrInUse {
+		t.Fatalf("Expected ErrInUse, got %v", err)
+	}
+	if _, err := store.GetVendor("v1"); err != nil {
+		t.Errorf("Expected vendor to survive a rejected delete, got %v", err)
+	}
+
+	if err := store.DeleteVendor("v1", 0, true); err != nil {
+		t.Fatalf("Failed to cascade delete vendor: %v", err)
+	}
+	if _, err := store.GetVendor("v1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for vendor, got %v", err)
+	}
+	if _, err := store.GetProduct("p1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for cascaded product, got %v", err)
+	}
+	if _, err := store.GetInventoryItem("i1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for cascaded inventory item, got %v", err)
+	}
+}
+
+func testDeleteProductInUse(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	if err := store.DeleteProduct("p1", 0, false); err != repository.ErrInUse {
+		t.Fatalf("Expected ErrInUse, got %v", err)
+	}
+	if _, err := store.GetInventoryItem("i1"); err != nil {
+		t.Errorf("Expected inventory item to survive a rejected delete, got %v", err)
+	}
+
+	if err := store.DeleteProduct("p1", 0, true); err != nil {
+		t.Fatalf("Failed to cascade delete product: %v", err)
+	}
+	if _, err := store.GetProduct("p1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for product, got %v", err)
+	}
+	if _, err := store.GetInventoryItem("i1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for cascaded inventory item, got %v", err)
+	}
+	if _, err := store.GetVendor("v1"); err != nil {
+		t.Errorf("Expected vendor to remain, got %v", err)
+	}
+}
+
+func testVersionIncrements(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	product, err := store.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if product.Version != 1 {
+		t.Errorf("Expected new product at version 1, got %d", product.Version)
+	}
+
+	product.Price = usd(1999)
+	if err := store.UpdateProduct(product); err != nil {
+		t.Fatalf("Failed to update product: %v", err)
+	}
+	if product.Version != 2 {
+		t.Errorf("Expected update to set version 2, got %d", product.Version)
+	}
+
+	// Version zero skips the check
+	if err := store.UpdateProduct(&models.Product{ID: "p1", Name: "Seeds", VendorID: "v1"}); err != nil {
+		t.Fatalf("Failed unconditional update: %v", err)
+	}
+	retrieved, err := store.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if retrieved.Version != 3 {
+		t.Errorf("Expected version 3, got %d", retrieved.Version)
+	}
+
+	if err := store.PostMovement(shipment(10), 1); err != nil {
+		t.Fatalf("Failed to post movement: %v", err)
+	}
+	item, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Version != 2 {
+		t.Errorf("Expected inventory item at version 2, got %d", item.Version)
+	}
+}
+
+func testVersionMismatch(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	if err := store.CreateSeller(&models.Seller{ID: "s1", Name: "Alice"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+
+	stale := &models.Seller{ID: "s1", Name: "Bob", Version: 2}
+	if err := store.UpdateSeller(stale); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch updating seller, got %v", err)
+	}
+	seller, err := store.GetSeller("s1")
+	if err != nil {
+		t.Fatalf("Failed to get seller: %v", err)
+	}
+	if seller.Name != "Alice" || seller.Version != 1 {
+		t.Errorf("Expected rejected update to leave Alice at version 1, got %s at %d", seller.Name, seller.Version)
+	}
+
+	item := &models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 5, Version: 3}
+	if err := store.UpdateInventoryItem(item); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch updating inventory item, got %v", err)
+	}
+	if err := store.PostMovement(shipment(5), 3); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch posting a movement, got %v", err)
+	}
+	retrievedItem, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if retrievedItem.Quantity != 100 {
+		t.Errorf("Expected rejected updates to leave quantity 100, got %v", retrievedItem.Quantity)
+	}
+
+	if err := store.DeleteSeller("s1", 2); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch deleting seller, got %v", err)
+	}
+	if err := store.DeleteVendor("v1", 2, true); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch deleting vendor, got %v", err)
+	}
+	if _, err := store.GetProduct("p1"); err != nil {
+		t.Errorf("Expected rejected cascade to leave product, got %v", err)
+	}
+	if err := store.DeleteSeller("s1", 1); err != nil {
+		t.Errorf("Failed to delete seller at its current version: %v", err)
+	}
+}
+
+func testTxCommit(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	tx, err := store.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	if err := tx.CreateProduct(&models.Product{ID: "p2", Name: "Mulch", VendorID: "v1"}); err != nil {
+		t.Fatalf("Failed to stage product: %v", err)
+	}
+	for _, item := range []*models.InventoryItem{
+		{ID: "i2", ProductID: "p2", Quantity: 10, Location: "Warehouse A"},
+		{ID: "i3", ProductID: "p2", Quantity: 20, Location: "Warehouse B"},
+	} {
+		if err := tx.CreateInventoryItem(item); err != nil {
+			t.Fatalf("Failed to stage inventory item: %v", err)
+		}
+	}
+	if err := tx.PostMovement(shipment(40), 0); err != nil {
+		t.Fatalf("Failed to stage movement: %v", err)
+	}
+
+	// The unit of work sees its own staged operations
+	staged, err := tx.GetInventoryItem("i3")
+	if err != nil {
+		t.Fatalf("Failed to read staged item: %v", err)
+	}
+	if staged.Quantity != 20 {
+		t.Errorf("Expected staged quantity 20, got %v", staged.Quantity)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if err := tx.Commit(); err != repository.ErrTxDone {
+		t.Errorf("Expected ErrTxDone committing twice, got %v", err)
+	}
+	if err := tx.Rollback(); err != repository.ErrTxDone {
+		t.Errorf("Expected ErrTxDone rolling back after commit, got %v", err)
+	}
+
+	items, err := store.ListInventoryItems()
+	if err != nil {
+		t.Fatalf("Failed to list inventory items: %v", err)
+	}
+	if len(items) != 3 {
+		t.Errorf("Expected 3 inventory items after commit, got %d", len(items))
+	}
+	item, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Quantity != 60 {
+		t.Errorf("Expected committed shipment to leave 60, got %v", item.Quantity)
+	}
+}
+
+func testTxRollback(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	tx, err := store.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	if err := tx.CreateProduct(&models.Product{ID: "p2", Name: "Mulch", VendorID: "v1"}); err != nil {
+		t.Fatalf("Failed to stage product: %v", err)
+	}
+	if err := tx.PostMovement(shipment(40), 0); err != nil {
+		t.Fatalf("Failed to stage movement: %v", err)
+	}
+	if err := tx.DeleteVendor("v1", 0, true); err != nil {
+		t.Fatalf("Failed to stage vendor deletion: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+	if err := tx.Rollback(); err != repository.ErrTxDone {
+		t.Errorf("Expected ErrTxDone rolling back twice, got %v", err)
+	}
+
+	if _, err := store.GetProduct("p2"); err != repository.ErrNotFound {
+		t.Errorf("Expected rolled back product to be absent, got %v", err)
+	}
+	if _, err := store.GetVendor("v1"); err != nil {
+		t.Errorf("Expected rolled back deletion to leave vendor, got %v", err)
+	}
+	item, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Quantity != 100 || item.Version != 1 {
+		t.Errorf("Expected quantity 100 at version 1, got %v at %v", item.Quantity, item.Version)
+	}
+	movements, err := store.ListMovements("i1")
+	if err != nil {
+		t.Fatalf("Failed to list movements: %v", err)
+	}
+	if len(movements) != 1 {
+		t.Errorf("Expected only the opening balance, got %d movements", len(movements))
+	}
+}
+
+func testTxFailedOperation(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	tx, err := store.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := tx.CreateSeller(&models.Seller{ID: "s1", Name: "Alice"}); err != nil {
+		t.Fatalf("Failed to stage seller: %v", err)
+	}
+	if err := tx.PostMovement(shipment(500), 0); err != repository.ErrInsufficientStock {
+		t.Fatalf("Expected ErrInsufficientStock, got %v", err)
+	}
+	if err := tx.CreateInventoryItem(&models.InventoryItem{ID: "i2", ProductID: "nonexistent", Quantity: 5}); err != repository.ErrInvalidReference {
+		t.Fatalf("Expected ErrInvalidReference, got %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	if _, err := store.GetSeller("s1"); err != nil {
+		t.Errorf("Expected staged seller to be committed, got %v", err)
+	}
+	if _, err := store.GetInventoryItem("i2"); err != repository.ErrNotFound {
+		t.Errorf("Expected failed operation to leave nothing, got %v", err)
+	}
+	item, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Quantity != 100 {
+		t.Errorf("Expected failed movement to leave 100, got %v", item.Quantity)
+	}
+}
+
+func testCopyOnRead(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	product, err := store.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	product.Price = usd(0)
+	products, err := store.ListProducts()
+	if err != nil {
+		t.Fatalf("Failed to list products: %v", err)
+	}
+	products[0].Name = "Changed"
+
+	created := &models.Seller{ID: "s1", Name: "Alice"}
+	if err := store.CreateSeller(created); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	created.Name = "Changed"
+
+	retrieved, err := store.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if retrieved.Price != usd(2999) || retrieved.Name != "Fertilizer" {
+		t.Errorf("Expected callers' changes not to reach the store, got %+v", retrieved)
+	}
+	seller, err := store.GetSeller("s1")
+	if err != nil {
+		t.Fatalf("Failed to get seller: %v", err)
+	}
+	if seller.Name != "Alice" {
+		t.Errorf("Expected stored seller Alice, got %s", seller.Name)
+	}
+}
+
+func testSnapshot(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	if err := store.CreateSeller(&models.Seller{ID: "s1", Name: "Alice"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	defer snapshot.Close()
+
+	if err := store.PostMovement(shipment(40), 0); err != nil {
+		t.Fatalf("Failed to post movement: %v", err)
+	}
+	if err := store.UpdateProduct(&models.Product{ID: "p1", Name: "Mulch", VendorID: "v1"}); err != nil {
+		t.Fatalf("Failed to update product: %v", err)
+	}
+	if err := store.DeleteSeller("s1", 0); err != nil {
+		t.Fatalf("Failed to delete seller: %v", err)
+	}
+	if err := store.CreateBuyer(&models.Buyer{ID: "b1"}); err != nil {
+		t.Fatalf("Failed to create buyer: %v", err)
+	}
+
+	item, err := snapshot.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item from snapshot: %v", err)
+	}
+	if item.Quantity != 100 {
+		t.Errorf("Expected snapshot quantity 100, got %v", item.Quantity)
+	}
+	movements, err := snapshot.ListMovements("i1")
+	if err != nil {
+		t.Fatalf("Failed to list movements from snapshot: %v", err)
+	}
+	if len(movements) != 1 {
+		t.Errorf("Expected 1 movement in snapshot, got %d", len(movements))
+	}
+	product, err := snapshot.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product from snapshot: %v", err)
+	}
+	if product.Name != "Fertilizer" {
+		t.Errorf("Expected snapshot product name Fertilizer, got %s", product.Name)
+	}
+	if _, err := snapshot.GetSeller("s1"); err != nil {
+		t.Errorf("Expected deleted seller in snapshot, got %v", err)
+	}
+	buyers, err := snapshot.ListBuyers()
+	if err != nil {
+		t.Fatalf("Failed to list buyers from snapshot: %v", err)
+	}
+	if len(buyers) != 0 {
+		t.Errorf("Expected no buyers in snapshot, got %d", len(buyers))
+	}
+
+	if err := snapshot.Close(); err != nil {
+		t.Errorf("Failed to close snapshot: %v", err)
+	}
+	current, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if current.Quantity != 60 {
+		t.Errorf("Expected store quantity 60, got %v", current.Quantity)
+	}
+}
+
+// testSnapshotsAndUnitsOfWork checks that snapshots taken around units of
+// work each keep the state they were taken at
+func testSnapshotsAndUnitsOfWork(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	first, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	defer first.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	if err := tx.PostMovement(shipment(10), 0); err != nil {
+		t.Fatalf("Failed to post movement: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	second, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	defer second.Close()
+
+	if err := store.PostMovement(shipment(20), 0); err != nil {
+		t.Fatalf("Failed to post movement: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		reader   repository.Reader
+		quantity float64
+	}{
+		{"first snapshot", first, 100},
+		{"second snapshot", second, 90},
+		{"store", store, 70},
+	} {
+		item, err := tt.reader.GetInventoryItem("i1")
+		if err != nil {
+			t.Fatalf("Failed to get inventory item from %s: %v", tt.name, err)
+		}
+		if item.Quantity != tt.quantity {
+			t.Errorf("Expected %s quantity %v, got %v", tt.name, tt.quantity, item.Quantity)
+		}
+	}
+}
+
+// seedCatalog adds vendor v2, products p2 and p3, and items at two
+// locations to seedInventory's data
+func seedCatalog(t *testing.T, store repository.Store) {
+	t.Helper()
+	seedInventory(t, store)
+
+	if err := store.CreateVendor(&models.Vendor{ID: "v2", Name: "Tool Works"}); err != nil {
+		t.Fatalf("Failed to create vendor: %v", err)
+	}
+	products := []*models.Product{
+		{ID: "p2", Name: "Compost", Category: "Soil Amendments", VendorID: "v2"},
+		{ID: "p3", Name: "Trowel", Category: "Tools", VendorID: "v2"},
+	}
+	for _, product := range products {
+		if err := store.CreateProduct(product); err != nil {
+			t.Fatalf("Failed to create product: %v", err)
+		}
+	}
+	items := []*models.InventoryItem{
+		{ID: "i2", ProductID: "p1", Quantity: 5, Location: "Warehouse B"},
+		{ID: "i3", ProductID: "p2", Quantity: 7, Location: "Warehouse A"},
+	}
+	for _, item := range items {
+		if err := store.CreateInventoryItem(item); err != nil {
+			t.Fatalf("Failed to create inventory item: %v", err)
+		}
+	}
+}
+
+func productIDs(products []*models.Product) []string {
+	ids := make([]string, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	return ids
+}
+
+func itemIDs(items []*models.InventoryItem) []string {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+func testFindProducts(t *testing.T, store repository.Store) {
+	seedCatalog(t, store)
+
+	tests := []struct {
+		filter repository.ProductFilter
+		want   []string
+	}{
+		{repository.ProductFilter{}, []string{"p1", "p2", "p3"}},
+		{repository.ProductFilter{VendorID: "v2"}, []string{"p2", "p3"}},
+		{repository.ProductFilter{Category: "Soil Amendments"}, []string{"p1", "p2"}},
+		{repository.ProductFilter{VendorID: "v2", Category: "Soil Amendments"}, []string{"p2"}},
+		{repository.ProductFilter{VendorID: "v1", Category: "Tools"}, []string{}},
+		{repository.ProductFilter{VendorID: "missing"}, []string{}},
+	}
+	for _, tt := range tests {
+		products, err := store.FindProducts(tt.filter)
+		if err != nil {
+			t.Fatalf("Failed to find products by %+v: %v", tt.filter, err)
+		}
+		if got := productIDs(products); !slices.Equal(got, tt.want) {
+			t.Errorf("Expected products %v for %+v, got %v", tt.want, tt.filter, got)
+		}
+	}
+}
+
+func testFindInventoryItems(t *testing.T, store repository.Store) {
+	seedCatalog(t, store)
+
+	tests := []struct {
+		filter repository.InventoryFilter
+		want   []string
+	}{
+		{repository.InventoryFilter{}, []string{"i1", "i2", "i3"}},
+		{repository.InventoryFilter{ProductID: "p1"}, []string{"i1", "i2"}},
+		{repository.InventoryFilter{Location: "Warehouse A"}, []string{"i1", "i3"}},
+		{repository.InventoryFilter{ProductID: "p1", Location: "Warehouse A"}, []string{"i1"}},
+		{repository.InventoryFilter{ProductID: "p3"}, []string{}},
+	}
+	for _, tt := range tests {
+		items, err := store.FindInventoryItems(tt.filter)
+		if err != nil {
+			t.Fatalf("Failed to find inventory items by %+v: %v", tt.filter, err)
+		}
+		if got := itemIDs(items); !slices.Equal(got, tt.want) {
+			t.Errorf("Expected items %v for %+v, got %v", tt.want, tt.filter, got)
+		}
+	}
+}
+
+func testIndexesFollowWrites(t *testing.T, store repository.Store) {
+	seedCatalog(t, store)
+
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	defer snapshot.Close()
+
+	// Move i1 to Warehouse B and p3 to vendor v1
+	item, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	item.Location = "Warehouse B"
+	if err := store.UpdateInventoryItem(item); err != nil {
+		t.Fatalf("Failed to update inventory item: %v", err)
+	}
+	product, err := store.GetProduct("p3")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	product.VendorID = "v1"
+	if err := store.UpdateProduct(product); err != nil {
+		t.Fatalf("Failed to update product: %v", err)
+	}
+
+	// A rolled back delete leaves the indexes as they were
+	tx, err := store.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	if err := tx.DeleteInventoryItem("i2", 0); err != nil {
+		t.Fatalf("Failed to delete inventory item in tx: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+
+	if err := store.DeleteProduct("p2", 0, true); err != nil {
+		t.Fatalf("Failed to delete product: %v", err)
+	}
+
+	items, err := store.FindInventoryItems(repository.InventoryFilter{Location: "Warehouse B"})
+	if err != nil {
+		t.Fatalf("Failed to find inventory items: %v", err)
+	}
+	if got, want := itemIDs(items), []string{"i1", "i2"}; !slices.Equal(got, want) {
+		t.Errorf("Expected items %v in Warehouse B, got %v", want, got)
+	}
+	items, err = store.FindInventoryItems(repository.InventoryFilter{Location: "Warehouse A"})
+	if err != nil {
+		t.Fatalf("Failed to find inventory items: %v", err)
+	}
+	if len(items) != 0 {
+		t.Errorf("Expected no items in Warehouse A, got %v", itemIDs(items))
+	}
+	products, err := store.FindProducts(repository.ProductFilter{VendorID: "v2"})
+	if err != nil {
+		t.Fatalf("Failed to find products: %v", err)
+	}
+	if len(products) != 0 {
+		t.Errorf("Expected no products from v2, got %v", productIDs(products))
+	}
+
+	// The snapshot still sees the catalog as seeded
+	items, err = snapshot.FindInventoryItems(repository.InventoryFilter{Location: "Warehouse A"})
+	if err != nil {
+		t.Fatalf("Failed to find inventory items in snapshot: %v", err)
+	}
+	if got, want := itemIDs(items), []string{"i1", "i3"}; !slices.Equal(got, want) {
+		t.Errorf("Expected snapshot items %v in Warehouse A, got %v", want, got)
+	}
+	products, err = snapshot.FindProducts(repository.ProductFilter{VendorID: "v2"})
+	if err != nil {
+		t.Fatalf("Failed to find products in snapshot: %v", err)
+	}
+	if got, want := productIDs(products), []string{"p2", "p3"}; !slices.Equal(got, want) {
+		t.Errorf("Expected snapshot products %v from v2, got %v", want, got)
+	}
+}
+
+// changes returns every change event after seq after
+func changes(t *testing.T, store repository.Store, after int64) []*models.ChangeEvent {
+	t.Helper()
+	events, err := store.Changes(after, 0)
+	if err != nil {
+		t.Fatalf("Failed to read changes: %v", err)
+	}
+	return events
+}
+
+// lastSeq returns the Seq of the newest change event, or zero
+func lastSeq(t *testing.T, store repository.Store) int64 {
+	t.Helper()
+	events := changes(t, store, 0)
+	if len(events) == 0 {
+		return 0
+	}
+	return events[len(events)-1].Seq
+}
+
+// describe summarizes events as "op entity id" strings
+func describe(events []*models.ChangeEvent) []string {
+	summary := make([]string, len(events))
+	for i, e := range events {
+		summary[i] = string(e.Op) + " " + e.Entity + " " + e.ID
+	}
+	return summary
+}
+
+// checkConsecutive fails unless events have consecutive Seqs after after
+func checkConsecutive(t *testing.T, events []*models.ChangeEvent, after int64) {
+	t.Helper()
+	for i, e := range events {
+		if e.Seq != after+int64(i)+1 {
+			t.Errorf("Expected event %d to have seq %d, got %d", i, after+int64(i)+1, e.Seq)
+		}
+	}
+}
+
+func testChangeEvents(t *testing.T, store repository.Store) {
+	if events := changes(t, store, 0); len(events) != 0 {
+		t.Fatalf("Expected an empty change log, got %v", describe(events))
+	}
+
+	seller := &models.Seller{ID: "s1", Name: "Alice"}
+	if err := store.CreateSeller(seller); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	seller.Name = "Alice Smith"
+	if err := store.UpdateSeller(seller); err != nil {
+		t.Fatalf("Failed to update seller: %v", err)
+	}
+	if err := store.DeleteSeller("s1", 2); err != nil {
+		t.Fatalf("Failed to delete seller: %v", err)
+	}
+	// Failed writes are not recorded
+	if err := store.DeleteSeller("s1", 0); err != repository.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	events := changes(t, store, 0)
+	want := []string{"create seller s1", "update seller s1", "delete seller s1"}
+	if got := describe(events); !slices.Equal(got, want) {
+		t.Fatalf("Expected events %v, got %v", want, got)
+	}
+	checkConsecutive(t, events, events[0].Seq-1)
+
+	for i, wantVersion := range []int64{1, 2, 2} {
+		if events[i].Version != wantVersion {
+			t.Errorf("Expected event %d to have version %d, got %d", i, wantVersion, events[i].Version)
+		}
+		if events[i].CommittedAt.IsZero() {
+			t.Errorf("Expected event %d to have a commit time", i)
+		}
+	}
+	if events[0].Before != nil || events[2].After != nil {
+		t.Errorf("Expected no before image on create and no after image on delete")
+	}
+	var before, after models.Seller
+	if err := json.Unmarshal(events[1].Before, &before); err != nil {
+		t.Fatalf("Failed to decode before image: %v", err)
+	}
+	if err := json.Unmarshal(events[1].After, &after); err != nil {
+		t.Fatalf("Failed to decode after image: %v", err)
+	}
+	if before.Name != "Alice" || after.Name != "Alice Smith" || after.Version != 2 {
+		t.Errorf("Expected images Alice v1 and Alice Smith v2, got %s v%d and %s v%d",
+			before.Name, before.Version, after.Name, after.Version)
+	}
+
+	// Reading resumes after a given seq and honors the limit
+	tail, err := store.Changes(events[0].Seq, 1)
+	if err != nil {
+		t.Fatalf("Failed to read changes: %v", err)
+	}
+	if got := describe(tail); !slices.Equal(got, want[1:2]) {
+		t.Errorf("Expected events %v, got %v", want[1:2], got)
+	}
+}
+
+func testChangeEventsForMovementsAndCascades(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	events := changes(t, store, 0)
+	want := []string{
+		"create vendor v1",
+		"create product p1",
+		"create inventory_item i1",
+		"create stock_movement 1",
+	}
+	if got := describe(events); !slices.Equal(got, want) {
+		t.Fatalf("Expected events %v, got %v", want, got)
+	}
+
+	seq := lastSeq(t, store)
+	if err := store.PostMovement(shipment(40), 0); err != nil {
+		t.Fatalf("Failed to post movement: %v", err)
+	}
+	events = changes(t, store, seq)
+	want = []string{"update inventory_item i1", "create stock_movement 2"}
+	if got := describe(events); !slices.Equal(got, want) {
+		t.Fatalf("Expected events %v, got %v", want, got)
+	}
+	checkConsecutive(t, events, seq)
+	var item models.InventoryItem
+	if err := json.Unmarshal(events[0].After, &item); err != nil {
+		t.Fatalf("Failed to decode after image: %v", err)
+	}
+	if item.Quantity != 60 || events[0].Version != 2 {
+		t.Errorf("Expected item at quantity 60 and version 2, got %v and %v", item.Quantity, events[0].Version)
+	}
+
+	seq = lastSeq(t, store)
+	if err := store.DeleteVendor("v1", 0, true); err != nil {
+		t.Fatalf("Failed to delete vendor: %v", err)
+	}
+	events = changes(t, store, seq)
+	got := describe(events)
+	slices.Sort(got)
+	want = []string{
+		"delete inventory_item i1",
+		"delete product p1",
+		"delete stock_movement 1",
+		"delete stock_movement 2",
+		"delete vendor v1",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected events %v, got %v", want, got)
+	}
+	checkConsecutive(t, events, seq)
+}
+
+func testChangeEventsInTx(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	seq := lastSeq(t, store)
+
+	tx, err := store.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	if err := tx.CreateSeller(&models.Seller{ID: "s1"}); err != nil {
+		t.Fatalf("Failed to create seller in tx: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+	if events := changes(t, store, seq); len(events) != 0 {
+		t.Fatalf("Expected no events from a rolled back tx, got %v", describe(events))
+	}
+
+	tx, err = store.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	if err := tx.CreateSeller(&models.Seller{ID: "s2"}); err != nil {
+		t.Fatalf("Failed to create seller in tx: %v", err)
+	}
+	// A failed operation in the tx is not recorded
+	if err := tx.CreateSeller(&models.Seller{ID: "s2"}); err != repository.ErrAlreadyExists {
+		t.Fatalf("Expected ErrAlreadyExists, got %v", err)
+	}
+	if err := tx.PostMovement(shipment(10), 0); err != nil {
+		t.Fatalf("Failed to post movement in tx: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	events := changes(t, store, seq)
+	want := []string{"create seller s2", "update inventory_item i1", "create stock_movement 2"}
+	if got := describe(events); !slices.Equal(got, want) {
+		t.Fatalf("Expected events %v, got %v", want, got)
+	}
+	checkConsecutive(t, events, seq)
+}
+
+// receive returns the next event from sub, failing after a timeout
+func receive(t *testing.T, sub *repository.Subscription) models.ChangeEvent {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events():
+		if !ok {
+			t.Fatalf("Subscription ended: %v", sub.Err())
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a change event")
+	}
+	return models.ChangeEvent{}
+}
+
+func testSubscribe(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	events := changes(t, store, 0)
+
+	// Resume after the first event: the rest of the log is replayed first
+	sub, err := store.Subscribe(events[0].Seq)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	defer sub.Close()
+	for _, want := range events[1:] {
+		if got := receive(t, sub); got.Seq != want.Seq || got.ID != want.ID {
+			t.Fatalf("Expected replayed event %d %s, got %d %s", want.Seq, want.ID, got.Seq, got.ID)
+		}
+	}
+
+	// Then new events are delivered as they are committed
+	if err := store.CreateSeller(&models.Seller{ID: "s1"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	got := receive(t, sub)
+	if got.Op != models.ChangeCreate || got.Entity != models.EntitySeller || got.ID != "s1" {
+		t.Errorf("Expected create seller s1, got %s %s %s", got.Op, got.Entity, got.ID)
+	}
+	if got.Seq != events[len(events)-1].Seq+1 {
+		t.Errorf("Expected seq %d, got %d", events[len(events)-1].Seq+1, got.Seq)
+	}
+
+	tx, err := store.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	if err := tx.CreateBuyer(&models.Buyer{ID: "b1"}); err != nil {
+		t.Fatalf("Failed to create buyer in tx: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if got := receive(t, sub); got.ID != "b1" {
+		t.Errorf("Expected the committed buyer, got %s %s", got.Entity, got.ID)
+	}
+
+	if err := sub.Close(); err != nil {
+		t.Errorf("Failed to close subscription: %v", err)
+	}
+	if _, ok := <-sub.Events(); ok {
+		t.Error("Expected no events after Close")
+	}
+}
+
+// instant returns the current time, with some time passing on either side
+// so that changes before and after it have distinct timestamps
+func instant() time.Time {
+	time.Sleep(2 * time.Millisecond)
+	defer time.Sleep(2 * time.Millisecond)
+	return time.Now()
+}
+
+func testFindChanges(t *testing.T, store repository.Store) {
+	seedCatalog(t, store)
+
+	tests := []struct {
+		filter repository.ChangeFilter
+		want   []string
+	}{
+		{repository.ChangeFilter{Entity: models.EntityProduct}, []string{
+			"create product p1", "create product p2", "create product p3",
+		}},
+		{repository.ChangeFilter{Entity: models.EntityInventoryItem, ID: "i2"}, []string{
+			"create inventory_item i2",
+		}},
+		{repository.ChangeFilter{Entity: models.EntityVendor, ID: "missing"}, []string{}},
+	}
+	for _, tt := range tests {
+		events, err := store.FindChanges(tt.filter)
+		if err != nil {
+			t.Fatalf("Failed to find changes by %+v: %v", tt.filter, err)
+		}
+		if got := describe(events); !slices.Equal(got, tt.want) {
+			t.Errorf("Expected events %v for %+v, got %v", tt.want, tt.filter, got)
+		}
+	}
+
+	all, err := store.FindChanges(repository.ChangeFilter{})
+	if err != nil {
+		t.Fatalf("Failed to find changes: %v", err)
+	}
+	if len(all) != len(changes(t, store, 0)) {
+		t.Errorf("Expected an empty filter to match all %d events, got %d", len(changes(t, store, 0)), len(all))
+	}
+}
+
+// updateProductName renames product p1
+func updateProductName(t *testing.T, store repository.Store, name string) {
+	t.Helper()
+	product, err := store.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	product.Name = name
+	if err := store.UpdateProduct(product); err != nil {
+		t.Fatalf("Failed to update product: %v", err)
+	}
+}
+
+func testFindChangesByTime(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	updateProductName(t, store, "Mulch")
+	seeded := instant()
+	updateProductName(t, store, "Compost")
+	updateProductName(t, store, "Peat")
+
+	tests := []struct {
+		filter repository.ChangeFilter
+		want   []string
+	}{
+		{repository.ChangeFilter{Entity: models.EntityProduct, Until: seeded}, []string{
+			"create product p1", "update product p1",
+		}},
+		{repository.ChangeFilter{Entity: models.EntityProduct, After: seeded}, []string{
+			"update product p1", "update product p1",
+		}},
+		{repository.ChangeFilter{Until: seeded, Keep: repository.KeepLast}, []string{
+			"create vendor v1", "create inventory_item i1", "create stock_movement 1", "update product p1",
+		}},
+		{repository.ChangeFilter{Entity: models.EntityProduct, After: seeded, Keep: repository.KeepFirst}, []string{
+			"update product p1",
+		}},
+	}
+	for _, tt := range tests {
+		events, err := store.FindChanges(tt.filter)
+		if err != nil {
+			t.Fatalf("Failed to find changes by %+v: %v", tt.filter, err)
+		}
+		if got := describe(events); !slices.Equal(got, tt.want) {
+			t.Errorf("Expected events %v for %+v, got %v", tt.want, tt.filter, got)
+		}
+	}
+
+	last, err := store.FindChanges(repository.ChangeFilter{Entity: models.EntityProduct, ID: "p1", Keep: repository.KeepLast})
+	if err != nil {
+		t.Fatalf("Failed to find changes: %v", err)
+	}
+	if len(last) != 1 || !strings.Contains(string(last[0].After), "Peat") {
+		t.Errorf("Expected the last change to rename p1 to Peat, got %v", describe(last))
+	}
+	first, err := store.FindChanges(repository.ChangeFilter{Entity: models.EntityProduct, After: seeded, Keep: repository.KeepFirst})
+	if err != nil {
+		t.Fatalf("Failed to find changes: %v", err)
+	}
+	if len(first) != 1 || !strings.Contains(string(first[0].Before), "Mulch") {
+		t.Errorf("Expected the first change after seeding to rename p1 from Mulch, got %v", describe(first))
+	}
+}
+
+func testCompactChanges(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	updateProductName(t, store, "Mulch")
+	if err := store.CreateSeller(&models.Seller{ID: "s1", Name: "Alice"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	if err := store.DeleteSeller("s1", 0); err != nil {
+		t.Fatalf("Failed to delete seller: %v", err)
+	}
+	cutoff := instant()
+	updateProductName(t, store, "Compost")
+	before := lastSeq(t, store)
+
+	removed, err := store.CompactChanges(cutoff)
+	if err != nil {
+		t.Fatalf("Failed to compact changes: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("Expected 3 events removed, got %d", removed)
+	}
+	want := []string{
+		"create vendor v1", "create inventory_item i1", "create stock_movement 1",
+		"update product p1", "update product p1",
+	}
+	if got := describe(changes(t, store, 0)); !slices.Equal(got, want) {
+		t.Errorf("Expected events %v after compacting, got %v", want, got)
+	}
+
+	products, err := repository.ProductsAsOf(store, cutoff, repository.ProductFilter{})
+	if err != nil {
+		t.Fatalf("Failed to list products as of %v: %v", cutoff, err)
+	}
+	if len(products) != 1 || products[0].Name != "Mulch" {
+		t.Errorf("Expected p1 named Mulch at the cutoff, got %+v", products)
+	}
+	items, err := repository.InventoryItemsAsOf(store, cutoff, repository.InventoryFilter{})
+	if err != nil {
+		t.Fatalf("Failed to list inventory as of %v: %v", cutoff, err)
+	}
+	if len(items) != 1 || items[0].Quantity != 100 {
+		t.Errorf("Expected i1 with 100 at the cutoff, got %+v", items)
+	}
+
+	if removed, err := store.CompactChanges(cutoff); err != nil || removed != 0 {
+		t.Errorf("Expected compacting again to remove nothing, got %d, %v", removed, err)
+	}
+	if err := store.CreateSeller(&models.Seller{ID: "s2", Name: "Bob"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+	if got := lastSeq(t, store); got != before+1 {
+		t.Errorf("Expected the next event to have seq %d, got %d", before+1, got)
+	}
+}
+
+func testProductHistory(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	product, err := store.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	product.Price = usd(3499)
+	if err := store.UpdateProduct(product); err != nil {
+		t.Fatalf("Failed to update product: %v", err)
+	}
+	if err := store.DeleteProduct("p1", 0, true); err != nil {
+		t.Fatalf("Failed to delete product: %v", err)
+	}
+
+	history, err := repository.ProductHistory(store, "p1")
+	if err != nil {
+		t.Fatalf("Failed to get product history: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 revisions, got %d", len(history))
+	}
+	if history[0].Op != models.ChangeCreate || history[0].Data.Price != usd(2999) || history[0].Data.Version != 1 {
+		t.Errorf("Expected a create at 29.99 v1 first, got %s %+v", history[0].Op, history[0].Data)
+	}
+	if history[1].Op != models.ChangeUpdate || history[1].Data.Price != usd(3499) || history[1].Data.Version != 2 {
+		t.Errorf("Expected an update to 34.99 v2 second, got %s %+v", history[1].Op, history[1].Data)
+	}
+	if history[2].Op != models.ChangeDelete || history[2].Data != nil {
+		t.Errorf("Expected a delete last, got %s %+v", history[2].Op, history[2].Data)
+	}
+	for i := 1; i < len(history); i++ {
+		if history[i].Seq <= history[i-1].Seq || history[i].CommittedAt.Before(history[i-1].CommittedAt) {
+			t.Errorf("Expected revisions in commit order, got %+v then %+v", history[i-1], history[i])
+		}
+	}
+
+	if _, err := repository.ProductHistory(store, "missing"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a product that never existed, got %v", err)
+	}
+}
+
+func testInventoryAsOf(t *testing.T, store repository.Store) {
+	beforeAll := instant()
+	seedCatalog(t, store)
+	seeded := instant()
+
+	if err := store.PostMovement(shipment(40), 0); err != nil {
+		t.Fatalf("Failed to post movement: %v", err)
+	}
+	item, err := store.GetInventoryItem("i3")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	item.Location = "Warehouse B"
+	if err := store.UpdateInventoryItem(item); err != nil {
+		t.Fatalf("Failed to update inventory item: %v", err)
+	}
+	if err := store.DeleteInventoryItem("i2", 0); err != nil {
+		t.Fatalf("Failed to delete inventory item: %v", err)
+	}
+	changed := instant()
+
+	items, err := repository.InventoryItemsAsOf(store, beforeAll, repository.InventoryFilter{})
+	if err != nil {
+		t.Fatalf("Failed to list inventory as of %v: %v", beforeAll, err)
+	}
+	if len(items) != 0 {
+		t.Errorf("Expected no items before seeding, got %v", itemIDs(items))
+	}
+
+	items, err = repository.InventoryItemsAsOf(store, seeded, repository.InventoryFilter{Location: "Warehouse A"})
+	if err != nil {
+		t.Fatalf("Failed to list inventory as of %v: %v", seeded, err)
+	}
+	if got, want := itemIDs(items), []string{"i1", "i3"}; !slices.Equal(got, want) {
+		t.Fatalf("Expected items %v in Warehouse A after seeding, got %v", want, got)
+	}
+	if items[0].Quantity != 100 {
+		t.Errorf("Expected i1 to have had 100, got %v", items[0].Quantity)
+	}
+	items, err = repository.InventoryItemsAsOf(store, seeded, repository.InventoryFilter{ProductID: "p1"})
+	if err != nil {
+		t.Fatalf("Failed to list inventory as of %v: %v", seeded, err)
+	}
+	if got, want := itemIDs(items), []string{"i1", "i2"}; !slices.Equal(got, want) {
+		t.Errorf("Expected items %v of p1 after seeding, got %v", want, got)
+	}
+
+	items, err = repository.InventoryItemsAsOf(store, changed, repository.InventoryFilter{})
+	if err != nil {
+		t.Fatalf("Failed to list inventory as of %v: %v", changed, err)
+	}
+	if got, want := itemIDs(items), []string{"i1", "i3"}; !slices.Equal(got, want) {
+		t.Fatalf("Expected items %v after the changes, got %v", want, got)
+	}
+	if items[0].Quantity != 60 || items[1].Location != "Warehouse B" {
+		t.Errorf("Expected i1 at 60 and i3 in Warehouse B, got %v and %s", items[0].Quantity, items[1].Location)
+	}
+
+	products, err := repository.ProductsAsOf(store, seeded, repository.ProductFilter{VendorID: "v2"})
+	if err != nil {
+		t.Fatalf("Failed to list products as of %v: %v", seeded, err)
+	}
+	if got, want := productIDs(products), []string{"p2", "p3"}; !slices.Equal(got, want) {
+		t.Errorf("Expected products %v from v2 after seeding, got %v", want, got)
+	}
+}
+
+// reserve holds quantity of item i1 for owner until expiresAt
+func reserve(store repository.Store, id string, quantity float64, expiresAt time.Time) error {
+	return store.CreateReservation(&models.Reservation{
+		ID:        id,
+		ItemID:    "i1",
+		Quantity:  quantity,
+		Owner:     "order-" + id,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// checkStock fails unless item i1 has the given on-hand, reserved and
+// available quantities
+func checkStock(t *testing.T, store repository.Store, quantity, reserved, available float64) {
+	t.Helper()
+	item, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Quantity != quantity || item.Reserved != reserved || item.Available != available {
+		t.Errorf("Expected quantity %v, reserved %v and available %v, got %v, %v and %v",
+			quantity, reserved, available, item.Quantity, item.Reserved, item.Available)
+	}
+}
+
+func testReservations(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	checkStock(t, store, 100, 0, 100)
+	later := time.Now().Add(time.Hour)
+
+	if err := reserve(store, "r1", 30, later); err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	if err := reserve(store, "r2", 50, later); err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	checkStock(t, store, 100, 80, 20)
+
+	if err := reserve(store, "r3", 21, later); err != repository.ErrInsufficientStock {
+		t.Errorf("Expected ErrInsufficientStock reserving more than available, got %v", err)
+	}
+	if err := reserve(store, "r1", 1, later); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+	err := store.CreateReservation(&models.Reservation{ID: "r4", ItemID: "missing", Quantity: 1, ExpiresAt: later})
+	if err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a missing item, got %v", err)
+	}
+	checkStock(t, store, 100, 80, 20)
+
+	// Shipping stock reduces what is available, not what is reserved
+	if err := store.PostMovement(shipment(10), 0); err != nil {
+		t.Fatalf("Failed to post movement: %v", err)
+	}
+	checkStock(t, store, 90, 80, 10)
+
+	reservation, err := store.GetReservation("r1")
+	if err != nil {
+		t.Fatalf("Failed to get reservation: %v", err)
+	}
+	if reservation.Quantity != 30 || reservation.Owner != "order-r1" || reservation.CreatedAt.IsZero() {
+		t.Errorf("Unexpected reservation %+v", reservation)
+	}
+	reservations, err := store.ListReservations("i1")
+	if err != nil {
+		t.Fatalf("Failed to list reservations: %v", err)
+	}
+	if len(reservations) != 2 || reservations[0].ID != "r1" || reservations[1].ID != "r2" {
+		t.Errorf("Expected reservations r1 and r2, got %v", reservations)
+	}
+	if _, err := store.ListReservations("missing"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound listing reservations of a missing item, got %v", err)
+	}
+
+	seq := lastSeq(t, store)
+	if err := store.ReleaseReservation("r1"); err != nil {
+		t.Fatalf("Failed to release reservation: %v", err)
+	}
+	checkStock(t, store, 90, 50, 40)
+	if _, err := store.GetReservation("r1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a released reservation, got %v", err)
+	}
+	if err := store.ReleaseReservation("r1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound releasing twice, got %v", err)
+	}
+	got := describe(changes(t, store, seq))
+	slices.Sort(got)
+	want := []string{"delete reservation r1", "update inventory_item i1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected events %v, got %v", want, got)
+	}
+}
+
+func testReservationExpiry(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	now := time.Now()
+
+	if err := reserve(store, "r1", 60, now.Add(50*time.Millisecond)); err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	if err := reserve(store, "r2", 30, now.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// An expired hold is released when the item is next reserved
+	if err := reserve(store, "r3", 50, now.Add(time.Hour)); err != nil {
+		t.Fatalf("Expected the expired hold to make room, got %v", err)
+	}
+	checkStock(t, store, 100, 80, 20)
+	if _, err := store.GetReservation("r1"); err != repository.ErrNotFound {
+		t.Errorf("Expected the expired reservation to be released, got %v", err)
+	}
+
+	n, err := store.ReleaseExpiredReservations(now.Add(30 * time.Minute))
+	if err != nil || n != 0 {
+		t.Errorf("Expected nothing to release, got %d and %v", n, err)
+	}
+	n, err = store.ReleaseExpiredReservations(now.Add(2 * time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to release expired reservations: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 reservations released, got %d", n)
+	}
+	checkStock(t, store, 100, 0, 100)
+}
+
+func testDeleteItemWithReservations(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	if err := reserve(store, "r1", 10, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+
+	seq := lastSeq(t, store)
+	if err := store.DeleteInventoryItem("i1", 0); err != nil {
+		t.Fatalf("Failed to delete inventory item: %v", err)
+	}
+	if _, err := store.GetReservation("r1"); err != repository.ErrNotFound {
+		t.Errorf("Expected the item's reservation to be deleted, got %v", err)
+	}
+	got := describe(changes(t, store, seq))
+	slices.Sort(got)
+	want := []string{"delete inventory_item i1", "delete reservation r1", "delete stock_movement 1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected events %v, got %v", want, got)
+	}
+}
+
+func testMovementsRespectReservations(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	later := time.Now().Add(time.Hour)
+	if err := reserve(store, "r1", 30, later); err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	if err := reserve(store, "r2", 50, later); err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+
+	// Only the 20 available can go without consuming a reservation
+	writeOff := &models.StockMovement{ItemID: "i1", Type: models.MovementWriteOff, Delta: -21, Reason: "damaged",
+		Actor: "alice"}
+	if err := store.PostMovement(writeOff, 0); err != repository.ErrInsufficientStock {
+		t.Errorf("Expected ErrInsufficientStock writing off reserved stock, got %v", err)
+	}
+	if err := store.PostMovement(shipment(21), 0); err != repository.ErrInsufficientStock {
+		t.Errorf("Expected ErrInsufficientStock shipping reserved stock, got %v", err)
+	}
+	checkStock(t, store, 100, 80, 20)
+	if err := store.PostMovement(shipment(20), 0); err != nil {
+		t.Fatalf("Failed to ship the available stock: %v", err)
+	}
+	checkStock(t, store, 80, 80, 0)
+
+	if err := store.CreateInventoryItem(&models.InventoryItem{ID: "i2", ProductID: "p1", Quantity: 10}); err != nil {
+		t.Fatalf("Failed to create inventory item: %v", err)
+	}
+	other := shipment(5)
+	other.ItemID = "i2"
+	other.ReservationID = "r1"
+	if err := store.PostMovement(other, 0); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference consuming another item's reservation, got %v", err)
+	}
+	missing := shipment(5)
+	missing.ReservationID = "missing"
+	if err := store.PostMovement(missing, 0); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference consuming a missing reservation, got %v", err)
+	}
+
+	// Consuming r1 takes its stock, and what it does not ship becomes available
+	seq := lastSeq(t, store)
+	consume := shipment(25)
+	consume.ReservationID = "r1"
+	if err := store.PostMovement(consume, 0); err != nil {
+		t.Fatalf("Failed to ship reserved stock: %v", err)
+	}
+	checkStock(t, store, 55, 50, 5)
+	if _, err := store.GetReservation("r1"); err != repository.ErrNotFound {
+		t.Errorf("Expected the consumed reservation to be released, got %v", err)
+	}
+	got := describe(changes(t, store, seq))
+	slices.Sort(got)
+	want := []string{"create stock_movement 4", "delete reservation r1", "update inventory_item i1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected events %v, got %v", want, got)
+	}
+	movements, err := store.ListMovements("i1")
+	if err != nil {
+		t.Fatalf("Failed to list movements: %v", err)
+	}
+	if last := movements[len(movements)-1]; last.ReservationID != "r1" || last.Balance != 55 {
+		t.Errorf("Expected the ledger to record the consumed reservation, got %+v", last)
+	}
+
+	// A consumed reservation cannot cover more than it held
+	over := shipment(56)
+	over.ReservationID = "r2"
+	if err := store.PostMovement(over, 0); err != repository.ErrInsufficientStock {
+		t.Errorf("Expected ErrInsufficientStock shipping more than is on hand, got %v", err)
+	}
+	checkStock(t, store, 55, 50, 5)
+}
+
+func testSalesOrders(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	if err := store.CreateBuyer(&models.Buyer{ID: "b1", Name: "Bob"}); err != nil {
+		t.Fatalf("Failed to create buyer: %v", err)
+	}
+
+	invalid := []*models.SalesOrder{
+		{ID: "so1", BuyerID: "missing", Lines: []models.SalesOrderLine{{ProductID: "p1", Quantity: 1}}},
+		{ID: "so1", BuyerID: "b1", Lines: []models.SalesOrderLine{{ProductID: "missing", Quantity: 1}}},
+	}
+	for _, order := range invalid {
+		if err := store.CreateSalesOrder(order); err != repository.ErrInvalidReference {
+			t.Errorf("Expected ErrInvalidReference for %+v, got %v", order, err)
+		}
+	}
+
+	order := &models.SalesOrder{
+		ID:      "so1",
+		BuyerID: "b1",
+		Status:  models.SalesOrderDraft,
+		Lines: []models.SalesOrderLine{
+			{ProductID: "p1", Quantity: 3, UnitPrice: usd(1299), PriceUnit: "each", Total: usd(3897)},
+			{ProductID: "p1", Quantity: 4},
+		},
+	}
+	if err := store.CreateSalesOrder(order); err != nil {
+		t.Fatalf("Failed to create sales order: %v", err)
+	}
+	if order.Version != 1 || order.CreatedAt.IsZero() {
+		t.Errorf("Expected version 1 and a creation time, got %d and %v", order.Version, order.CreatedAt)
+	}
+	if err := store.CreateSalesOrder(order); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+
+	order.Status = models.SalesOrderConfirmed
+	order.Lines[1].Allocations = []models.Allocation{
+		{ItemID: "i1", ReservationID: "r1", Quantity: 1},
+		{ItemID: "i1", ReservationID: "r2", Quantity: 3},
+	}
+	if err := store.UpdateSalesOrder(order); err != nil {
+		t.Fatalf("Failed to update sales order: %v", err)
+	}
+	got, err := store.GetSalesOrder("so1")
+	if err != nil {
+		t.Fatalf("Failed to get sales order: %v", err)
+	}
+	if got.Status != models.SalesOrderConfirmed || got.Version != 2 || len(got.Lines) != 2 {
+		t.Fatalf("Unexpected sales order %+v", got)
+	}
+	if got.Lines[0].UnitPrice != usd(1299) || got.Lines[0].PriceUnit != "each" || got.Lines[0].Total != usd(3897) ||
+		got.Lines[1].UnitPrice != (models.Money{}) {
+		t.Errorf("Expected a unit price of 12.99 USD an each on the first line only, got %+v", got.Lines)
+	}
+	if len(got.Lines[0].Allocations) != 0 || !slices.Equal(got.Lines[1].Allocations, order.Lines[1].Allocations) {
+		t.Errorf("Expected allocations %v on the second line only, got %+v", order.Lines[1].Allocations, got.Lines)
+	}
+
+	// Lines read from the store are the caller's own
+	got.Lines[1].Allocations[0].Quantity = 99
+	if again, _ := store.GetSalesOrder("so1"); again.Lines[1].Allocations[0].Quantity != 1 {
+		t.Errorf("Expected stored allocation to be unchanged, got %+v", again.Lines[1].Allocations[0])
+	}
+
+	found, err := store.FindSalesOrders(repository.SalesOrderFilter{BuyerID: "b1", Status: models.SalesOrderConfirmed})
+	if err != nil || len(found) != 1 || found[0].ID != "so1" || len(found[0].Lines) != 2 {
+		t.Errorf("Expected to find so1 with its lines, got %v and %v", found, err)
+	}
+	found, err = store.FindSalesOrders(repository.SalesOrderFilter{Status: models.SalesOrderDraft})
+	if err != nil || len(found) != 0 {
+		t.Errorf("Expected no draft orders, got %v and %v", found, err)
+	}
+
+	// Orders keep their buyer and products
+	if err := store.DeleteBuyer("b1", 0); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a buyer with orders, got %v", err)
+	}
+	if err := store.DeleteProduct("p1", 0, true); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting an ordered product, got %v", err)
+	}
+	if err := store.DeleteVendor("v1", 0, true); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting the vendor of an ordered product, got %v", err)
+	}
+
+	if err := store.DeleteSalesOrder("so1", 1); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch, got %v", err)
+	}
+	if err := store.DeleteSalesOrder("so1", 2); err != nil {
+		t.Fatalf("Failed to delete sales order: %v", err)
+	}
+	if _, err := store.GetSalesOrder("so1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if err := store.DeleteBuyer("b1", 0); err != nil {
+		t.Errorf("Failed to delete buyer without orders: %v", err)
+	}
+	if err := store.DeleteProduct("p1", 0, true); err != nil {
+		t.Errorf("Failed to delete product without orders: %v", err)
+	}
+}
+
+func testPurchaseOrders(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	expectedAt := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	invalid := []*models.PurchaseOrder{
+		{ID: "po1", VendorID: "missing", Lines: []models.PurchaseOrderLine{{ProductID: "p1", Quantity: 1, ExpectedAt: expectedAt}}},
+		{ID: "po1", VendorID: "v1", Lines: []models.PurchaseOrderLine{{ProductID: "missing", Quantity: 1, ExpectedAt: expectedAt}}},
+	}
+	for _, order := range invalid {
+		if err := store.CreatePurchaseOrder(order); err != repository.ErrInvalidReference {
+			t.Errorf("Expected ErrInvalidReference for %+v, got %v", order, err)
+		}
+	}
+
+	order := &models.PurchaseOrder{
+		ID:            "po1",
+		VendorID:      "v1",
+		Status:        models.PurchaseOrderDraft,
+		OverTolerance: 10,
+		Lines: []models.PurchaseOrderLine{
+			{ProductID: "p1", Quantity: 20, Cost: usd(450), CostUnit: "each", Total: usd(9000), ExpectedAt: expectedAt},
+			{ProductID: "p1", Quantity: 5, ExpectedAt: expectedAt.AddDate(0, 0, 7)},
+		},
+	}
+	if err := store.CreatePurchaseOrder(order); err != nil {
+		t.Fatalf("Failed to create purchase order: %v", err)
+	}
+	if order.Version != 1 || order.CreatedAt.IsZero() {
+		t.Errorf("Expected version 1 and a creation time, got %d and %v", order.Version, order.CreatedAt)
+	}
+	if err := store.CreatePurchaseOrder(order); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+
+	order.Status = models.PurchaseOrderOpen
+	order.Lines[0].Received = 8
+	if err := store.UpdatePurchaseOrder(order); err != nil {
+		t.Fatalf("Failed to update purchase order: %v", err)
+	}
+	got, err := store.GetPurchaseOrder("po1")
+	if err != nil {
+		t.Fatalf("Failed to get purchase order: %v", err)
+	}
+	if got.Status != models.PurchaseOrderOpen || got.Version != 2 || got.OverTolerance != 10 || len(got.Lines) != 2 {
+		t.Fatalf("Unexpected purchase order %+v", got)
+	}
+	if got.Lines[0].Received != 8 || got.Lines[1].Received != 0 || !got.Lines[1].ExpectedAt.Equal(order.Lines[1].ExpectedAt) ||
+		got.Lines[0].Cost != usd(450) || got.Lines[0].CostUnit != "each" || got.Lines[0].Total != usd(9000) ||
+		got.Lines[1].Cost != (models.Money{}) {
+		t.Errorf("Expected lines %+v, got %+v", order.Lines, got.Lines)
+	}
+
+	// Lines read from the store are the caller's own
+	got.Lines[0].Received = 99
+	if again, _ := store.GetPurchaseOrder("po1"); again.Lines[0].Received != 8 {
+		t.Errorf("Expected stored line to be unchanged, got %+v", again.Lines[0])
+	}
+
+	found, err := store.FindPurchaseOrders(repository.PurchaseOrderFilter{VendorID: "v1", ProductID: "p1", Status: models.PurchaseOrderOpen})
+	if err != nil || len(found) != 1 || found[0].ID != "po1" || len(found[0].Lines) != 2 {
+		t.Errorf("Expected to find po1 with its lines, got %v and %v", found, err)
+	}
+	found, err = store.FindPurchaseOrders(repository.PurchaseOrderFilter{Status: models.PurchaseOrderDraft})
+	if err != nil || len(found) != 0 {
+		t.Errorf("Expected no draft orders, got %v and %v", found, err)
+	}
+
+	// Orders keep their vendor and products
+	if err := store.DeleteProduct("p1", 0, true); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting an ordered product, got %v", err)
+	}
+	if err := store.DeleteVendor("v1", 0, true); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a vendor with orders, got %v", err)
+	}
+
+	if err := store.DeletePurchaseOrder("po1", 1); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch, got %v", err)
+	}
+	if err := store.DeletePurchaseOrder("po1", 2); err != nil {
+		t.Fatalf("Failed to delete purchase order: %v", err)
+	}
+	if _, err := store.GetPurchaseOrder("po1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if err := store.DeleteVendor("v1", 0, true); err != nil {
+		t.Errorf("Failed to delete vendor without orders: %v", err)
+	}
+}
+
+func testLocations(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	if err := store.CreateLocation(&models.Location{ID: "z1", Type: models.LocationZone, ParentID: "missing"}); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a missing parent, got %v", err)
+	}
+	locations := []*models.Location{
+		{ID: "w1", Name: "Main", Type: models.LocationWarehouse, Capacity: 1000, Active: true},
+		{ID: "z1", Name: "Cold", Type: models.LocationZone, ParentID: "w1", Active: true},
+		{ID: "z2", Name: "Dry", Type: models.LocationZone, ParentID: "w1"},
+		{ID: "b1", Name: "Bin 1", Type: models.LocationBin, ParentID: "z1", Capacity: 50, Active: true},
+	}
+	for _, location := range locations {
+		if err := store.CreateLocation(location); err != nil {
+			t.Fatalf("Failed to create location %s: %v", location.ID, err)
+		}
+	}
+	if err := store.CreateLocation(locations[0]); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+
+	got, err := store.GetLocation("b1")
+	if err != nil {
+		t.Fatalf("Failed to get location: %v", err)
+	}
+	if got.Name != "Bin 1" || got.Type != models.LocationBin || got.ParentID != "z1" ||
+		got.Capacity != 50 || !got.Active || got.Version != 1 || got.CreatedAt.IsZero() {
+		t.Errorf("Unexpected location %+v", got)
+	}
+
+	found, err := store.FindLocations(repository.LocationFilter{ParentID: "w1"})
+	if err != nil || len(found) != 2 || found[0].ID != "z1" || found[1].ID != "z2" {
+		t.Errorf("Expected z1 and z2 under w1, got %v and %v", found, err)
+	}
+	found, err = store.FindLocations(repository.LocationFilter{Type: models.LocationBin})
+	if err != nil || len(found) != 1 || found[0].ID != "b1" {
+		t.Errorf("Expected bin b1, got %v and %v", found, err)
+	}
+
+	// Moving a bin updates the parent index
+	got.ParentID = "z2"
+	got.Active = false
+	if err := store.UpdateLocation(got); err != nil {
+		t.Fatalf("Failed to update location: %v", err)
+	}
+	if got.Version != 2 {
+		t.Errorf("Expected version 2, got %d", got.Version)
+	}
+	found, err = store.FindLocations(repository.LocationFilter{ParentID: "z2"})
+	if err != nil || len(found) != 1 || found[0].ID != "b1" || found[0].Active {
+		t.Errorf("Expected inactive b1 under z2, got %v and %v", found, err)
+	}
+	got.ParentID = "missing"
+	if err := store.UpdateLocation(got); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference moving under a missing parent, got %v", err)
+	}
+
+	// Locations with children or stock are in use
+	if err := store.DeleteLocation("z2", 0); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a location with children, got %v", err)
+	}
+	item, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	item.Location = "b1"
+	if err := store.UpdateInventoryItem(item); err != nil {
+		t.Fatalf("Failed to update inventory item: %v", err)
+	}
+	if err := store.DeleteLocation("b1", 0); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a location holding stock, got %v", err)
+	}
+	if err := store.DeleteInventoryItem("i1", 0); err != nil {
+		t.Fatalf("Failed to delete inventory item: %v", err)
+	}
+
+	if err := store.DeleteLocation("b1", 1); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch, got %v", err)
+	}
+	if err := store.DeleteLocation("b1", 2); err != nil {
+		t.Fatalf("Failed to delete location: %v", err)
+	}
+	if _, err := store.GetLocation("b1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if err := store.DeleteLocation("z2", 0); err != nil {
+		t.Errorf("Failed to delete emptied location: %v", err)
+	}
+}
+
+func testTransferOrders(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	for _, location := range []*models.Location{
+		{ID: "yard", Type: models.LocationWarehouse, Active: true},
+		{ID: "store", Type: models.LocationWarehouse, Active: true},
+	} {
+		if err := store.CreateLocation(location); err != nil {
+			t.Fatalf("Failed to create location %s: %v", location.ID, err)
+		}
+	}
+
+	invalid := []*models.TransferOrder{
+		{ID: "to1", FromLocation: "missing", ToLocation: "store", Lines: []models.TransferOrderLine{{ProductID: "p1", Quantity: 1}}},
+		{ID: "to1", FromLocation: "yard", ToLocation: "missing", Lines: []models.TransferOrderLine{{ProductID: "p1", Quantity: 1}}},
+		{ID: "to1", FromLocation: "yard", ToLocation: "store", Lines: []models.TransferOrderLine{{ProductID: "missing", Quantity: 1}}},
+	}
+	for _, order := range invalid {
+		if err := store.CreateTransferOrder(order); err != repository.ErrInvalidReference {
+			t.Errorf("Expected ErrInvalidReference for %+v, got %v", order, err)
+		}
+	}
+
+	order := &models.TransferOrder{
+		ID:           "to1",
+		FromLocation: "yard",
+		ToLocation:   "store",
+		Status:       models.TransferOrderDraft,
+		Lines: []models.TransferOrderLine{
+			{ProductID: "p1", Quantity: 40},
+			{ProductID: "p1", Quantity: 5, FromItemID: "i1"},
+		},
+	}
+	if err := store.CreateTransferOrder(order); err != nil {
+		t.Fatalf("Failed to create transfer order: %v", err)
+	}
+	if order.Version != 1 || order.CreatedAt.IsZero() {
+		t.Errorf("Expected version 1 and a creation time, got %d and %v", order.Version, order.CreatedAt)
+	}
+	if err := store.CreateTransferOrder(order); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+	got, err := store.GetTransferOrder("to1")
+	if err != nil {
+		t.Fatalf("Failed to get transfer order: %v", err)
+	}
+	if got.DispatchedAt != nil || got.ReceivedAt != nil {
+		t.Errorf("Expected a draft with no dispatch or receipt times, got %+v", got)
+	}
+
+	dispatchedAt := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	order.Status = models.TransferOrderInTransit
+	order.DispatchedAt = &dispatchedAt
+	order.Note = "One bag torn"
+	order.Lines[0].FromItemID = "i1"
+	order.Lines[0].ToItemID = "i2"
+	order.Lines[0].Received = 39
+	order.Lines[0].Discrepancy = -1
+	order.Lines[1].LotNumber = "LOT-1"
+	order.Lines[1].ExpiresAt = &dispatchedAt
+	if err := store.UpdateTransferOrder(order); err != nil {
+		t.Fatalf("Failed to update transfer order: %v", err)
+	}
+	got, err = store.GetTransferOrder("to1")
+	if err != nil {
+		t.Fatalf("Failed to get transfer order: %v", err)
+	}
+	if got.Status != models.TransferOrderInTransit || got.Version != 2 || got.Note != "One bag torn" ||
+		got.DispatchedAt == nil || !got.DispatchedAt.Equal(dispatchedAt) || got.ReceivedAt != nil || len(got.Lines) != 2 {
+		t.Fatalf("Unexpected transfer order %+v", got)
+	}
+	lot := got.Lines[1]
+	if got.Lines[0] != order.Lines[0] || lot.LotNumber != "LOT-1" || lot.ManufacturedAt != nil ||
+		lot.ExpiresAt == nil || !lot.ExpiresAt.Equal(dispatchedAt) {
+		t.Errorf("Expected lines %+v, got %+v", order.Lines, got.Lines)
+	}
+
+	// Orders read from the store are the caller's own
+	got.Lines[0].Received = 99
+	*got.DispatchedAt = dispatchedAt.Add(time.Hour)
+	*got.Lines[1].ExpiresAt = dispatchedAt.Add(time.Hour)
+	if again, _ := store.GetTransferOrder("to1"); again.Lines[0].Received != 39 || !again.DispatchedAt.Equal(dispatchedAt) ||
+		!again.Lines[1].ExpiresAt.Equal(dispatchedAt) {
+		t.Errorf("Expected stored order to be unchanged, got %+v", again)
+	}
+
+	found, err := store.FindTransferOrders(repository.TransferOrderFilter{
+		FromLocation: "yard", ToLocation: "store", ProductID: "p1", Status: models.TransferOrderInTransit,
+	})
+	if err != nil || len(found) != 1 || found[0].ID != "to1" || len(found[0].Lines) != 2 {
+		t.Errorf("Expected to find to1 with its lines, got %v and %v", found, err)
+	}
+	found, err = store.FindTransferOrders(repository.TransferOrderFilter{FromLocation: "store"})
+	if err != nil || len(found) != 0 {
+		t.Errorf("Expected no transfers from the store, got %v and %v", found, err)
+	}
+
+	// Orders keep their locations and products
+	if err := store.DeleteProduct("p1", 0, true); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a transferred product, got %v", err)
+	}
+	for _, id := range []string{"yard", "store"} {
+		if err := store.DeleteLocation(id, 0); err != repository.ErrInUse {
+			t.Errorf("Expected ErrInUse deleting location %s of a transfer, got %v", id, err)
+		}
+	}
+
+	if err := store.DeleteTransferOrder("to1", 1); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch, got %v", err)
+	}
+	if err := store.DeleteTransferOrder("to1", 2); err != nil {
+		t.Fatalf("Failed to delete transfer order: %v", err)
+	}
+	if _, err := store.GetTransferOrder("to1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if err := store.DeleteLocation("yard", 0); err != nil {
+		t.Errorf("Failed to delete location without transfers: %v", err)
+	}
+}
+
+func testInventoryLots(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+
+	product, err := store.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	product.LotControlled = true
+	if err := store.UpdateProduct(product); err != nil {
+		t.Fatalf("Failed to update product: %v", err)
+	}
+	if got, _ := store.GetProduct("p1"); !got.LotControlled {
+		t.Errorf("Expected a lot-controlled product, got %+v", got)
+	}
+
+	madeAt := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	expiresAt := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	item := &models.InventoryItem{ID: "i2", ProductID: "p1", Quantity: 20, LotNumber: "LOT-7", ManufacturedAt: &madeAt, ExpiresAt: &expiresAt}
+	if err := store.CreateInventoryItem(item); err != nil {
+		t.Fatalf("Failed to create inventory item: %v", err)
+	}
+	got, err := store.GetInventoryItem("i2")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if got.LotNumber != "LOT-7" || got.ManufacturedAt == nil || !got.ManufacturedAt.Equal(madeAt) ||
+		got.ExpiresAt == nil || !got.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected lot LOT-7 made %v expiring %v, got %+v", madeAt, expiresAt, got)
+	}
+
+	// Dates read from the store are the caller's own
+	*got.ExpiresAt = expiresAt.AddDate(1, 0, 0)
+	if again, _ := store.GetInventoryItem("i2"); !again.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected stored expiry to be unchanged, got %v", again.ExpiresAt)
+	}
+
+	got.ExpiresAt = nil
+	got.LotNumber = "LOT-8"
+	if err := store.UpdateInventoryItem(got); err != nil {
+		t.Fatalf("Failed to update inventory item: %v", err)
+	}
+	got, err = store.GetInventoryItem("i2")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if got.LotNumber != "LOT-8" || got.ExpiresAt != nil || got.ManufacturedAt == nil || got.Quantity != 20 {
+		t.Errorf("Expected lot LOT-8 with no expiry, got %+v", got)
+	}
+	if plain, _ := store.GetInventoryItem("i1"); plain.LotNumber != "" || plain.ExpiresAt != nil {
+		t.Errorf("Expected i1 to have no lot, got %+v", plain)
+	}
+}
+
+func testSerials(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	product, err := store.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	product.Serialized = true
+	if err := store.UpdateProduct(product); err != nil {
+		t.Fatalf("Failed to update product: %v", err)
+	}
+	if got, _ := store.GetProduct("p1"); !got.Serialized {
+		t.Errorf("Expected a serialized product, got %+v", got)
+	}
+	if err := store.CreateBuyer(&models.Buyer{ID: "b1", Name: "Bob"}); err != nil {
+		t.Fatalf("Failed to create buyer: %v", err)
+	}
+
+	invalid := []*models.Serial{
+		{ID: "SN-1", ProductID: "missing", ItemID: "i1", Status: models.SerialInStock},
+		{ID: "SN-1", ProductID: "p1", ItemID: "missing", Status: models.SerialInStock},
+		{ID: "SN-1", ProductID: "p1", ItemID: "i1", Status: models.SerialSold, BuyerID: "missing"},
+	}
+	for _, serial := range invalid {
+		if err := store.CreateSerial(serial); err != repository.ErrInvalidReference {
+			t.Errorf("Expected ErrInvalidReference for %+v, got %v", serial, err)
+		}
+	}
+
+	for _, id := range []string{"SN-1", "SN-2"} {
+		serial := &models.Serial{ID: id, ProductID: "p1", ItemID: "i1", Location: "Warehouse A", Status: models.SerialInStock}
+		if err := store.CreateSerial(serial); err != nil {
+			t.Fatalf("Failed to create serial %s: %v", id, err)
+		}
+		if serial.Version != 1 || serial.CreatedAt.IsZero() {
+			t.Errorf("Expected version 1 and a creation time, got %d and %v", serial.Version, serial.CreatedAt)
+		}
+	}
+	if err := store.CreateSerial(&models.Serial{ID: "SN-1", ProductID: "p1", ItemID: "i1"}); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+
+	serial, err := store.GetSerial("SN-1")
+	if err != nil {
+		t.Fatalf("Failed to get serial: %v", err)
+	}
+	serial.Status = models.SerialSold
+	serial.BuyerID = "b1"
+	serial.Reference = "so1"
+	if err := store.UpdateSerial(serial); err != nil {
+		t.Fatalf("Failed to update serial: %v", err)
+	}
+	got, err := store.GetSerial("SN-1")
+	if err != nil {
+		t.Fatalf("Failed to get serial: %v", err)
+	}
+	if got.Status != models.SerialSold || got.BuyerID != "b1" || got.Reference != "so1" ||
+		got.Location != "Warehouse A" || got.Version != 2 {
+		t.Errorf("Expected SN-1 sold to b1 on so1 at version 2, got %+v", got)
+	}
+
+	tests := []struct {
+		filter   repository.SerialFilter
+		expected []string
+	}{
+		{repository.SerialFilter{}, []string{"SN-1", "SN-2"}},
+		{repository.SerialFilter{ProductID: "p1", ItemID: "i1"}, []string{"SN-1", "SN-2"}},
+		{repository.SerialFilter{Status: models.SerialInStock}, []string{"SN-2"}},
+		{repository.SerialFilter{BuyerID: "b1"}, []string{"SN-1"}},
+		{repository.SerialFilter{ItemID: "missing"}, []string{}},
+	}
+	for _, tt := range tests {
+		found, err := store.FindSerials(tt.filter)
+		if err != nil {
+			t.Fatalf("Failed to find serials: %v", err)
+		}
+		ids := make([]string, 0, len(found))
+		for _, serial := range found {
+			ids = append(ids, serial.ID)
+		}
+		if !slices.Equal(ids, tt.expected) {
+			t.Errorf("FindSerials(%+v): expected %v, got %v", tt.filter, tt.expected, ids)
+		}
+	}
+
+	// Serials keep their product, item and buyer
+	if err := store.DeleteProduct("p1", 0, true); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a product with serials, got %v", err)
+	}
+	if err := store.DeleteInventoryItem("i1", 0); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting an item with serials, got %v", err)
+	}
+	if err := store.DeleteBuyer("b1", 0); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a buyer with serials, got %v", err)
+	}
+
+	if err := store.DeleteSerial("SN-1", 1); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch, got %v", err)
+	}
+	if err := store.DeleteSerial("SN-1", 2); err != nil {
+		t.Fatalf("Failed to delete serial: %v", err)
+	}
+	if _, err := store.GetSerial("SN-1"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if err := store.DeleteBuyer("b1", 0); err != nil {
+		t.Errorf("Failed to delete buyer without serials: %v", err)
+	}
+
+	history, err := repository.SerialHistory(store, "SN-1")
+	if err != nil {
+		t.Fatalf("Failed to get serial history: %v", err)
+	}
+	if len(history) != 3 || history[0].Data.Status != models.SerialInStock ||
+		history[1].Data.Status != models.SerialSold || history[2].Op != models.ChangeDelete {
+		t.Errorf("Expected SN-1 created in stock, sold and deleted, got %+v", history)
+	}
+}
+
+func testUnits(t *testing.T, store repository.Store) {
+	if err := store.CreateVendor(&models.Vendor{ID: "v1", Name: "Garden Supplies Co"}); err != nil {
+		t.Fatalf("Failed to create vendor: %v", err)
+	}
+	product := &models.Product{
+		ID:       "p1",
+		Name:     "Mulch",
+		VendorID: "v1",
+		BaseUnit: "cubic_yard",
+		Packs: []models.Pack{
+			{Unit: "bag", Quantity: 2, Of: "cubic_foot"},
+			{Unit: "truckload", Quantity: 20, Of: "cubic_yard"},
+		},
+	}
+	if err := store.CreateProduct(product); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	got, err := store.GetProduct("p1")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if got.BaseUnit != "cubic_yard" || !slices.Equal(got.Packs, product.Packs) {
+		t.Errorf("Expected the product's units to round-trip, got %+v", got)
+	}
+	got.Packs[0].Quantity = 3
+	if again, _ := store.GetProduct("p1"); again.Packs[0].Quantity != 2 {
+		t.Errorf("Expected a product's packs not to be shared with readers, got %+v", again.Packs)
+	}
+
+	got.Packs = []models.Pack{{Unit: "pallet", Quantity: 50, Of: "bag"}}
+	if err := store.UpdateProduct(got); err != nil {
+		t.Fatalf("Failed to update product: %v", err)
+	}
+	products, err := store.ListProducts()
+	if err != nil {
+		t.Fatalf("Failed to list products: %v", err)
+	}
+	if len(products) != 1 || !slices.Equal(products[0].Packs, got.Packs) {
+		t.Errorf("Expected the updated packs to replace the old, got %+v", products)
+	}
+
+	item := &models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 2.5, Unit: "cubic_yard"}
+	if err := store.CreateInventoryItem(item); err != nil {
+		t.Fatalf("Failed to create inventory item: %v", err)
+	}
+	for _, delta := range []float64{0.1, 0.2, -0.3} {
+		movement := &models.StockMovement{ItemID: "i1", Type: models.MovementAdjustment, Delta: delta, Reason: "stock_count", Actor: "alice"}
+		if err := store.PostMovement(movement, 0); err != nil {
+			t.Fatalf("Failed to post movement: %v", err)
+		}
+		if movement.Unit != "cubic_yard" {
+			t.Errorf("Expected the movement to take the item's unit, got %q", movement.Unit)
+		}
+	}
+	reservation := &models.Reservation{ID: "r1", ItemID: "i1", Quantity: 0.75, Owner: "so1", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.CreateReservation(reservation); err != nil {
+		t.Fatalf("Failed to create reservation: %v", err)
+	}
+	if reservation.Unit != "cubic_yard" {
+		t.Errorf("Expected the reservation to take the item's unit, got %q", reservation.Unit)
+	}
+	current, err := store.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if current.Quantity != 2.5 || current.Reserved != 0.75 || current.Available != 1.75 || current.Unit != "cubic_yard" {
+		t.Errorf("Expected 2.5 cubic yards with 0.75 reserved, got %+v", current)
+	}
+	movements, err := store.ListMovements("i1")
+	if err != nil {
+		t.Fatalf("Failed to list movements: %v", err)
+	}
+	if len(movements) != 4 || movements[0].Unit != "cubic_yard" || movements[3].Balance != 2.5 {
+		t.Errorf("Expected four movements in cubic yards ending at 2.5, got %+v", movements)
+	}
+
+	if err := store.CreateBuyer(&models.Buyer{ID: "b1", Name: "Bob"}); err != nil {
+		t.Fatalf("Failed to create buyer: %v", err)
+	}
+	order := &models.SalesOrder{
+		ID:      "so1",
+		BuyerID: "b1",
+		Status:  models.SalesOrderDraft,
+		Lines:   []models.SalesOrderLine{{ProductID: "p1", Quantity: 1.5, Unit: "cubic_yard"}},
+	}
+	if err := store.CreateSalesOrder(order); err != nil {
+		t.Fatalf("Failed to create sales order: %v", err)
+	}
+	stored, err := store.GetSalesOrder("so1")
+	if err != nil {
+		t.Fatalf("Failed to get sales order: %v", err)
+	}
+	if line := stored.Lines[0]; line.Quantity != 1.5 || line.Unit != "cubic_yard" {
+		t.Errorf("Expected a line of 1.5 cubic yards, got %+v", line)
+	}
+}
+
+func testVariants(t *testing.T, store repository.Store) {
+	for _, vendor := range []*models.Vendor{{ID: "v1", Name: "Garden Supplies Co"}, {ID: "v2", Name: "Pots Unlimited"}} {
+		if err := store.CreateVendor(vendor); err != nil {
+			t.Fatalf("Failed to create vendor: %v", err)
+		}
+	}
+	axes := []models.Axis{
+		{Name: "length", Values: []string{"25ft", "50ft", "100ft"}},
+		{Name: "colour", Values: []string{"green", "black"}},
+	}
+	parent := &models.Product{ID: "hose", Name: "Hose", VendorID: "v1", Category: "watering", Axes: axes}
+	if err := store.CreateProduct(parent); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	got, err := store.GetProduct("hose")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if len(got.Axes) != 2 || got.Axes[1].Name != "colour" || !slices.Equal(got.Axes[0].Values, axes[0].Values) {
+		t.Errorf("Expected the product's axes to round-trip, got %+v", got.Axes)
+	}
+	got.Axes[0].Values[0] = "10ft"
+	if again, _ := store.GetProduct("hose"); again.Axes[0].Values[0] != "25ft" {
+		t.Errorf("Expected a product's axes not to be shared with readers, got %+v", again.Axes)
+	}
+
+	variants := []*models.Product{
+		{ID: "hose-25ft-green", Name: "Hose", VendorID: "v1", Category: "watering", ParentID: "hose",
+			Attributes: map[string]string{"length": "25ft", "colour": "green"}},
+		{ID: "hose-50ft-green", Name: "Hose", VendorID: "v1", Category: "watering", ParentID: "hose",
+			Attributes: map[string]string{"length": "50ft", "colour": "green"}},
+		{ID: "hose-50ft-black", Name: "Hose", VendorID: "v1", Category: "watering", ParentID: "hose",
+			Attributes: map[string]string{"length": "50ft", "colour": "black"}},
+	}
+	for _, variant := range variants {
+		if err := store.CreateProduct(variant); err != nil {
+			t.Fatalf("Failed to create variant %s: %v", variant.ID, err)
+		}
+	}
+	orphan := &models.Product{ID: "orphan", Name: "Hose", VendorID: "v1", ParentID: "missing"}
+	if err := store.CreateProduct(orphan); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a variant of a missing product, got %v", err)
+	}
+	got.ParentID = "hose"
+	got.Axes = axes
+	if err := store.UpdateProduct(got); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a product that is its own variant, got %v", err)
+	}
+
+	found, err := store.FindProducts(repository.ProductFilter{ParentID: "hose"})
+	if err != nil {
+		t.Fatalf("Failed to find variants: %v", err)
+	}
+	if len(found) != 3 || found[0].ID != "hose-25ft-green" || found[0].Attributes["colour"] != "green" {
+		t.Errorf("Expected the three variants of the hose, got %+v", found)
+	}
+	found, err = store.FindProducts(repository.ProductFilter{
+		ParentID:   "hose",
+		Attributes: map[string]string{"length": "50ft", "colour": "green"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to find variants: %v", err)
+	}
+	if len(found) != 1 || found[0].ID != "hose-50ft-green" {
+		t.Errorf("Expected the 50 ft green hose, got %+v", found)
+	}
+	found, err = store.FindProducts(repository.ProductFilter{Attributes: map[string]string{"length": "50ft"}})
+	if err != nil {
+		t.Fatalf("Failed to find variants: %v", err)
+	}
+	if len(found) != 2 {
+		t.Errorf("Expected both 50 ft hoses, got %+v", found)
+	}
+
+	variant, err := store.GetProduct("hose-50ft-black")
+	if err != nil {
+		t.Fatalf("Failed to get variant: %v", err)
+	}
+	variant.Attributes["colour"] = "green"
+	if again, _ := store.GetProduct("hose-50ft-black"); again.Attributes["colour"] != "black" {
+		t.Errorf("Expected a product's attributes not to be shared with readers, got %+v", again.Attributes)
+	}
+	variant.Attributes = map[string]string{"length": "100ft", "colour": "black"}
+	if err := store.UpdateProduct(variant); err != nil {
+		t.Fatalf("Failed to update variant: %v", err)
+	}
+	if again, _ := store.GetProduct("hose-50ft-black"); again.Attributes["length"] != "100ft" || len(again.Attributes) != 2 {
+		t.Errorf("Expected the updated attributes to replace the old, got %+v", again.Attributes)
+	}
+
+	if err := store.DeleteProduct("hose", 0, true); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a product with variants, got %v", err)
+	}
+	if err := store.DeleteProduct("hose-50ft-black", 0, false); err != nil {
+		t.Errorf("Failed to delete variant: %v", err)
+	}
+
+	// A variant of another vendor's product stops the vendor cascading
+	stray := &models.Product{ID: "hose-stray", Name: "Hose", VendorID: "v2", ParentID: "hose"}
+	if err := store.CreateProduct(stray); err != nil {
+		t.Fatalf("Failed to create variant: %v", err)
+	}
+	if err := store.DeleteVendor("v1", 0, true); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a vendor whose product has another vendor's variant, got %v", err)
+	}
+	if err := store.DeleteProduct("hose-stray", 0, false); err != nil {
+		t.Fatalf("Failed to delete variant: %v", err)
+	}
+	if err := store.DeleteVendor("v1", 0, true); err != nil {
+		t.Errorf("Failed to delete vendor with its products and their variants: %v", err)
+	}
+	if products, _ := store.ListProducts(); len(products) != 0 {
+		t.Errorf("Expected the cascade to delete every product, got %+v", products)
+	}
+}
+
+func testKits(t *testing.T, store repository.Store) {
+	for _, vendor := range []*models.Vendor{{ID: "v1", Name: "Garden Supplies Co"}, {ID: "v2", Name: "Timber Yard"}} {
+		if err := store.CreateVendor(vendor); err != nil {
+			t.Fatalf("Failed to create vendor: %v", err)
+		}
+	}
+	for _, product := range []*models.Product{
+		{ID: "lumber", Name: "Cedar Board", VendorID: "v2"},
+		{ID: "soil", Name: "Raised Bed Soil", VendorID: "v1", BaseUnit: "cubic_foot"},
+	} {
+		if err := store.CreateProduct(product); err != nil {
+			t.Fatalf("Failed to create product: %v", err)
+		}
+	}
+	components := []models.Component{
+		{ProductID: "lumber", Quantity: 4, Unit: "each"},
+		{ProductID: "soil", Quantity: 1.5, Unit: "cubic_foot"},
+	}
+	kit := &models.Product{ID: "kit", Name: "Raised Bed Starter Kit", VendorID: "v1", Components: components}
+	if err := store.CreateProduct(kit); err != nil {
+		t.Fatalf("Failed to create kit: %v", err)
+	}
+	got, err := store.GetProduct("kit")
+	if err != nil {
+		t.Fatalf("Failed to get kit: %v", err)
+	}
+	if !slices.Equal(got.Components, components) {
+		t.Errorf("Expected the kit's components to round-trip, got %+v", got.Components)
+	}
+	got.Components[0].Quantity = 8
+	if again, _ := store.GetProduct("kit"); again.Components[0].Quantity != 4 {
+		t.Errorf("Expected a product's components not to be shared with readers, got %+v", again.Components)
+	}
+
+	missing := &models.Product{ID: "bad", Name: "Bad Kit", VendorID: "v1",
+		Components: []models.Component{{ProductID: "missing", Quantity: 1, Unit: "each"}}}
+	if err := store.CreateProduct(missing); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a kit of a missing product, got %v", err)
+	}
+	got.Components = []models.Component{{ProductID: "kit", Quantity: 1, Unit: "each"}}
+	if err := store.UpdateProduct(got); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a kit of itself, got %v", err)
+	}
+
+	kits, err := store.FindProducts(repository.ProductFilter{ComponentID: "soil"})
+	if err != nil {
+		t.Fatalf("Failed to find kits: %v", err)
+	}
+	if len(kits) != 1 || kits[0].ID != "kit" {
+		t.Errorf("Expected the kit to be found by its soil, got %+v", kits)
+	}
+
+	got.Components = components[1:]
+	if err := store.UpdateProduct(got); err != nil {
+		t.Fatalf("Failed to update kit: %v", err)
+	}
+	if kits, _ := store.FindProducts(repository.ProductFilter{ComponentID: "lumber"}); len(kits) != 0 {
+		t.Errorf("Expected no kit of lumber once it is removed, got %+v", kits)
+	}
+	got.Components = components
+	if err := store.UpdateProduct(got); err != nil {
+		t.Fatalf("Failed to update kit: %v", err)
+	}
+
+	if err := store.DeleteProduct("soil", 0, true); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a component of a kit, got %v", err)
+	}
+	if err := store.DeleteVendor("v2", 0, true); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a vendor whose product is in another vendor's kit, got %v", err)
+	}
+
+	if err := store.CreateBuyer(&models.Buyer{ID: "b1", Name: "Bob"}); err != nil {
+		t.Fatalf("Failed to create buyer: %v", err)
+	}
+	order := &models.SalesOrder{
+		ID:      "so1",
+		BuyerID: "b1",
+		Status:  models.SalesOrderConfirmed,
+		Lines: []models.SalesOrderLine{{ProductID: "kit", Quantity: 1, Unit: "each", Allocations: []models.Allocation{
+			{ItemID: "kit-1", ReservationID: "r1", Quantity: 1},
+			{ItemID: "soil-1", ReservationID: "r2", Quantity: 1.5, ProductID: "soil"},
+		}}},
+	}
+	if err := store.CreateSalesOrder(order); err != nil {
+		t.Fatalf("Failed to create sales order: %v", err)
+	}
+	stored, err := store.GetSalesOrder("so1")
+	if err != nil {
+		t.Fatalf("Failed to get sales order: %v", err)
+	}
+	if !slices.Equal(stored.Lines[0].Allocations, order.Lines[0].Allocations) {
+		t.Errorf("Expected allocations of components to round-trip, got %+v", stored.Lines[0].Allocations)
+	}
+	if err := store.DeleteSalesOrder("so1", 0); err != nil {
+		t.Fatalf("Failed to delete sales order: %v", err)
+	}
+
+	if err := store.DeleteProduct("kit", 0, false); err != nil {
+		t.Fatalf("Failed to delete kit: %v", err)
+	}
+	if err := store.DeleteProduct("soil", 0, false); err != nil {
+		t.Errorf("Failed to delete a product no kit needs: %v", err)
+	}
+}
+
+func testPriceLists(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	from := instant()
+	to := from.Add(30 * 24 * time.Hour)
+	prices := []models.ListPrice{
+		{ProductID: "p1", MinQuantity: 0, Unit: "each", Price: usd(2499)},
+		{ProductID: "p1", MinQuantity: 10, Unit: "each", Price: usd(1999), ValidFrom: &from, ValidTo: &to},
+	}
+	list := &models.PriceList{ID: "contractors", Name: "Landscape Contractors", Prices: prices}
+	if err := store.CreatePriceList(list); err != nil {
+		t.Fatalf("Failed to create price list: %v", err)
+	}
+	if list.Version != 1 || list.CreatedAt.IsZero() {
+		t.Errorf("Expected a new price list at version 1 with a creation time, got %+v", list)
+	}
+	if err := store.CreatePriceList(&models.PriceList{ID: "contractors", Name: "Again"}); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+	missing := &models.PriceList{ID: "bad", Name: "Bad", Prices: []models.ListPrice{{ProductID: "missing", Price: usd(1)}}}
+	if err := store.CreatePriceList(missing); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a price of a missing product, got %v", err)
+	}
+
+	got, err := store.GetPriceList("contractors")
+	if err != nil {
+		t.Fatalf("Failed to get price list: %v", err)
+	}
+	if len(got.Prices) != 2 || got.Prices[0] != prices[0] || got.Prices[1].Price != usd(1999) ||
+		!got.Prices[1].ValidFrom.Equal(from) || !got.Prices[1].ValidTo.Equal(to) {
+		t.Errorf("Expected the prices to round-trip, got %+v", got.Prices)
+	}
+	*got.Prices[1].ValidTo = to.Add(time.Hour)
+	if again, _ := store.GetPriceList("contractors"); !again.Prices[1].ValidTo.Equal(to) {
+		t.Errorf("Expected a price list's windows not to be shared with readers, got %v", again.Prices[1].ValidTo)
+	}
+	if lists, err := store.ListPriceLists(); err != nil || len(lists) != 1 {
+		t.Errorf("Expected one price list, got %v, %v", lists, err)
+	}
+
+	if err := store.CreateBuyer(&models.Buyer{ID: "b1", Name: "Bob", PriceListID: "missing"}); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a buyer of a missing price list, got %v", err)
+	}
+	buyer := &models.Buyer{ID: "b1", Name: "Bob", PriceListID: "contractors"}
+	if err := store.CreateBuyer(buyer); err != nil {
+		t.Fatalf("Failed to create buyer: %v", err)
+	}
+	if stored, _ := store.GetBuyer("b1"); stored.PriceListID != "contractors" {
+		t.Errorf("Expected the buyer's price list to round-trip, got %+v", stored)
+	}
+	if err := store.DeletePriceList("contractors", 0); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a price list assigned to a buyer, got %v", err)
+	}
+	if err := store.DeleteProduct("p1", 0, true); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a product on a price list, got %v", err)
+	}
+
+	got.Prices = got.Prices[:1]
+	if err := store.UpdatePriceList(got); err != nil {
+		t.Fatalf("Failed to update price list: %v", err)
+	}
+	if got.Version != 2 {
+		t.Errorf("Expected version 2 after an update, got %d", got.Version)
+	}
+	got.Version = 1
+	if err := store.UpdatePriceList(got); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch, got %v", err)
+	}
+
+	buyer.PriceListID = ""
+	if err := store.UpdateBuyer(buyer); err != nil {
+		t.Fatalf("Failed to update buyer: %v", err)
+	}
+	if err := store.DeletePriceList("contractors", 0); err != nil {
+		t.Fatalf("Failed to delete price list: %v", err)
+	}
+	if _, err := store.GetPriceList("contractors"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after deletion, got %v", err)
+	}
+	if err := store.DeleteProduct("p1", 0, true); err != nil {
+		t.Errorf("Failed to delete a product on no price list: %v", err)
+	}
+}
+
+func testPromotions(t *testing.T, store repository.Store) {
+	seedInventory(t, store)
+	from := instant()
+	promotion := &models.Promotion{
+		ID:            "mulch3for2",
+		Name:          "Buy 3 get 1 free",
+		Type:          models.PromotionBuyGetFree,
+		BuyQuantity:   3,
+		FreeQuantity:  1,
+		Unit:          "each",
+		ProductIDs:    []string{"p1"},
+		Categories:    []string{"Soil Amendments"},
+		MinSubtotal:   usd(5000),
+		Exclusive:     true,
+		Group:         "spring",
+		Priority:      2,
+		ValidFrom:     &from,
+		LimitPerBuyer: 1,
+		Uses:          map[string]int{"b1": 1},
+	}
+	if err := store.CreatePromotion(promotion); err != nil {
+		t.Fatalf("Failed to create promotion: %v", err)
+	}
+	if promotion.Version != 1 || promotion.CreatedAt.IsZero() {
+		t.Errorf("Expected a new promotion at version 1 with a creation time, got %+v", promotion)
+	}
+	if err := store.CreatePromotion(&models.Promotion{ID: "mulch3for2"}); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+	if err := store.CreatePromotion(&models.Promotion{ID: "bad", ProductIDs: []string{"missing"}}); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a promotion of a missing product, got %v", err)
+	}
+
+	got, err := store.GetPromotion("mulch3for2")
+	if err != nil {
+		t.Fatalf("Failed to get promotion: %v", err)
+	}
+	if got.Type != promotion.Type || got.BuyQuantity != 3 || got.FreeQuantity != 1 || got.Unit != "each" ||
+		!slices.Equal(got.ProductIDs, promotion.ProductIDs) || !slices.Equal(got.Categories, promotion.Categories) ||
+		got.MinSubtotal != usd(5000) || !got.Exclusive || got.Group != "spring" || got.Priority != 2 ||
+		!got.ValidFrom.Equal(from) || got.ValidTo != nil || got.LimitPerBuyer != 1 || !maps.Equal(got.Uses, promotion.Uses) {
+		t.Errorf("Expected the promotion to round-trip, got %+v", got)
+	}
+	got.Uses["b1"] = 5
+	if again, _ := store.GetPromotion("mulch3for2"); again.Uses["b1"] != 1 {
+		t.Errorf("Expected a promotion's uses not to be shared with readers, got %v", again.Uses)
+	}
+
+	off := &models.Promotion{ID: "tenoff", Name: "$10 off $100", Type: models.PromotionAmountOff,
+		Amount: usd(1000), MinSubtotal: usd(10000)}
+	if err := store.CreatePromotion(off); err != nil {
+		t.Fatalf("Failed to create promotion: %v", err)
+	}
+	percent := &models.Promotion{ID: "twelve", Name: "12.5% off", Type: models.PromotionPercentOff, Percent: 1250}
+	if err := store.CreatePromotion(percent); err != nil {
+		t.Fatalf("Failed to create promotion: %v", err)
+	}
+	if promotions, err := store.ListPromotions(); err != nil || len(promotions) != 3 || promotions[1].Amount != usd(1000) ||
+		promotions[2].Percent != 1250 {
+		t.Errorf("Expected all three promotions, got %v, %v", promotions, err)
+	}
+
+	if err := store.DeleteProduct("p1", 0, true); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a product a promotion names, got %v", err)
+	}
+	got.ProductIDs = nil
+	got.Uses = map[string]int{"b1": 1, "b2": 1}
+	if err := store.UpdatePromotion(got); err != nil {
+		t.Fatalf("Failed to update promotion: %v", err)
+	}
+	if stored, _ := store.GetPromotion("mulch3for2"); stored.Version != 2 || len(stored.ProductIDs) != 0 || len(stored.Uses) != 2 {
+		t.Errorf("Expected the update to replace the products and uses, got %+v", stored)
+	}
+	got.Version = 1
+	if err := store.UpdatePromotion(got); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch, got %v", err)
+	}
+	if err := store.DeleteProduct("p1", 0, true); err != nil {
+		t.Errorf("Failed to delete a product no promotion names: %v", err)
+	}
+	if err := store.DeletePromotion("mulch3for2", 0); err != nil {
+		t.Fatalf("Failed to delete promotion: %v", err)
+	}
+	if _, err := store.GetPromotion("mulch3for2"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after deletion, got %v", err)
+	}
+}
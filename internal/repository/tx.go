@@ -0,0 +1,139 @@
+package repository
+
+import "errors"
+
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// memoryTx is a unit of work on an InMemoryRepository. It holds the
+// repository's write lock from Begin until Commit or Rollback and works on
+// a view that shares the repository's maps but collects mutations instead
+// of journaling them, so Commit can journal the whole unit of work as one
+// batch.
+type memoryTx struct {
+	*InMemoryRepository // the view
+
+	parent *InMemoryRepository
+	muts   []mutation
+	done   bool
+}
+
+// Begin starts a unit of work. The repository's write lock is held until
+// the Tx is committed or rolled back, so other callers block meanwhile.
+func (r *InMemoryRepository) Begin() (Tx, error) {
+	r.mu.Lock()
+	// The view writes to the repository's maps, so they must be its own
+	r.unshare()
+
+	tx := &memoryTx{parent: r}
+	tx.InMemoryRepository = &InMemoryRepository{
+		sellers:   r.sellers,
+		buyers:    r.buyers,
+		vendors:   r.vendors,
+		products:  r.products,
+		inventory: r.inventory,
+		movements: r.movements,
+
+		reservations: r.reservations,
+		salesOrders:  r.salesOrders,
+
+		purchaseOrders: r.purchaseOrders,
+		locations:      r.locations,
+		transferOrders: r.transferOrders,
+		serials:        r.serials,
+		priceLists:     r.priceLists,
+		promotions:     r.promotions,
+
+		productsByVendor:   r.productsByVendor,
+		productsByCategory: r.productsByCategory,
+		productsByParent:   r.productsByParent,
+		itemsByProduct:     r.itemsByProduct,
+		itemsByLocation:    r.itemsByLocation,
+		movementsByItem:    r.movementsByItem,
+		reservationsByItem: r.reservationsByItem,
+
+		productsByComponent: r.productsByComponent,
+
+		salesOrdersByBuyer:   r.salesOrdersByBuyer,
+		salesOrdersByProduct: r.salesOrdersByProduct,
+
+		purchaseOrdersByVendor:  r.purchaseOrdersByVendor,
+		purchaseOrdersByProduct: r.purchaseOrdersByProduct,
+
+		locationsByParent: r.locationsByParent,
+
+		transferOrdersByLocation: r.transferOrdersByLocation,
+		transferOrdersByProduct:  r.transferOrdersByProduct,
+
+		serialsByProduct: r.serialsByProduct,
+		serialsByItem:    r.serialsByItem,
+		serialsByBuyer:   r.serialsByBuyer,
+
+		priceListsByProduct: r.priceListsByProduct,
+		buyersByPriceList:   r.buyersByPriceList,
+
+		promotionsByProduct: r.promotionsByProduct,
+
+		lastMovementID:  r.lastMovementID,
+		changes:         r.changes,
+		changesByEntity: r.changesByEntity,
+		changesByID:     r.changesByID,
+		journal:         tx.stage,
+	}
+	return tx, nil
+}
+
+// stage is the view's journal hook. The view has already applied muts to
+// the shared maps.
+func (tx *memoryTx) stage(muts []mutation) error {
+	tx.muts = append(tx.muts, muts...)
+	return nil
+}
+
+// Commit journals the staged mutations as a single batch. If the journal
+// rejects them they are undone and the error is returned.
+func (tx *memoryTx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	defer tx.finish()
+
+	r := tx.parent
+	if r.journal != nil && len(tx.muts) > 0 {
+		if err := r.journal(tx.muts); err != nil {
+			tx.undo()
+			return err
+		}
+	}
+	r.lastMovementID = tx.lastMovementID
+	r.changes = tx.changes
+	r.changed.broadcast()
+	return nil
+}
+
+// Rollback undoes the staged mutations
+func (tx *memoryTx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	defer tx.finish()
+
+	tx.undo()
+	return nil
+}
+
+// undo reverts the staged mutations through the view, whose change log
+// holds the staged events
+func (tx *memoryTx) undo() {
+	for i := len(tx.muts) - 1; i >= 0; i-- {
+		tx.set(tx.muts[i].Kind, tx.muts[i].ID, tx.muts[i].Before)
+	}
+}
+
+// finish releases the lock and detaches the view from the shared maps, so
+// that a Tx used after it is done cannot touch the repository
+func (tx *memoryTx) finish() {
+	tx.done = true
+	tx.InMemoryRepository = NewInMemoryRepository()
+	tx.journal = func([]mutation) error { return ErrTxDone }
+	tx.parent.mu.Unlock()
+}
@@ -0,0 +1,150 @@
+// Package router wraps http.ServeMux with method-aware routing. Routes are
+// registered with Go 1.22 patterns such as "GET /products/{id}". Requests
+// whose path matches a route but whose method does not get a 405 with an
+// accurate Allow header, OPTIONS requests are answered from the same table,
+// and routes can be grouped under a prefix or marked as deprecated aliases.
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// probeMethods are the methods checked when building an Allow header
+var probeMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// Route describes a registered route
+type Route struct {
+	Method     string
+	Path       string
+	Summary    string
+	Deprecated bool
+}
+
+// Router registers routes on a shared http.ServeMux. Routers returned by
+// Group and Deprecated share the mux and route table of their parent.
+type Router struct {
+	mux    *http.ServeMux
+	routes *[]Route
+	prefix string
+
+	// successor, when set, marks routes as deprecated and returns the URL
+	// of the replacement for a request
+	successor func(r *http.Request) string
+}
+
+// New creates an empty router
+func New() *Router {
+	return &Router{mux: http.NewServeMux(), routes: new([]Route)}
+}
+
+// Group returns a router that registers routes below prefix
+func (rt *Router) Group(prefix string) *Router {
+	group := *rt
+	group.prefix = rt.prefix + prefix
+	return &group
+}
+
+// Deprecated returns a router whose routes are deprecated aliases of the
+// same path under successorPrefix. Responses carry a Deprecation header and
+// a Link to the successor.
+func (rt *Router) Deprecated(successorPrefix string) *Router {
+	prefix := rt.prefix
+	return rt.SupersededBy(func(r *http.Request) string {
+		return successorPrefix + strings.TrimPrefix(r.URL.Path, prefix)
+	})
+}
+
+// SupersededBy returns a router whose routes are deprecated in favour of the
+// URL returned by successor
+func (rt *Router) SupersededBy(successor func(r *http.Request) string) *Router {
+	deprecated := *rt
+	deprecated.successor = successor
+	return &deprecated
+}
+
+// HandleFunc registers handler for pattern, which is a method followed by a
+// path relative to the router's prefix, e.g. "GET /products/{id}"
+func (rt *Router) HandleFunc(pattern, summary string, handler http.HandlerFunc) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok || method == "" || !strings.HasPrefix(path, "/") {
+		panic(fmt.Sprintf("router: pattern %q must be a method and a path", pattern))
+	}
+	path = rt.prefix + path
+
+	var h http.Handler = handler
+	if rt.successor != nil {
+		h = deprecate(rt.successor, h)
+	}
+	rt.mux.Handle(method+" "+path, h)
+
+	*rt.routes = append(*rt.routes, Route{
+		Method:     method,
+		Path:       path,
+		Summary:    summary,
+		Deprecated: rt.successor != nil,
+	})
+}
+
+// Routes returns every registered route in registration order
+func (rt *Router) Routes() []Route {
+	return append([]Route(nil), *rt.routes...)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodOptions {
+		if _, pattern := rt.mux.Handler(r); pattern != "" {
+			rt.mux.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	allowed := rt.allowedMethods(r)
+	if len(allowed) == 0 {
+		respondError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	w.Header().Set("Allow", strings.Join(append(allowed, http.MethodOptions), ", "))
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// allowedMethods returns the methods that have a route matching r's path
+func (rt *Router) allowedMethods(r *http.Request) []string {
+	var allowed []string
+	for _, method := range probeMethods {
+		probe := *r
+		probe.Method = method
+		if _, pattern := rt.mux.Handler(&probe); pattern != "" {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+func deprecate(successor func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor(r)))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func respondError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,102 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestRouter() *Router {
+	rt := New()
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.PathValue("id")))
+	}
+
+	v1 := rt.Group("/api/v1")
+	v1.HandleFunc("GET /products/{id}", "Get a product", ok)
+	v1.HandleFunc("DELETE /products/{id}", "Delete a product", ok)
+
+	rt.Group("/api").Deprecated("/api/v1").HandleFunc("GET /products/{id}", "Get a product", ok)
+	return rt
+}
+
+func serve(rt *Router, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestMatchingRoute(t *testing.T) {
+	rec := serve(newTestRouter(), http.MethodGet, "/api/v1/products/p1")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if rec.Body.String() != "p1" {
+		t.Errorf("Expected path value p1, got %q", rec.Body.String())
+	}
+	if rec.Header().Get("Deprecation") != "" {
+		t.Error("Expected no Deprecation header on a current route")
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	rec := serve(newTestRouter(), http.MethodPost, "/api/v1/products/p1")
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected status 405, got %d", rec.Code)
+	}
+	if got, want := rec.Header().Get("Allow"), "GET, HEAD, DELETE, OPTIONS"; got != want {
+		t.Errorf("Expected Allow %q, got %q", want, got)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	rec := serve(newTestRouter(), http.MethodOptions, "/api/v1/products/p1")
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", rec.Code)
+	}
+	if got, want := rec.Header().Get("Allow"), "GET, HEAD, DELETE, OPTIONS"; got != want {
+		t.Errorf("Expected Allow %q, got %q", want, got)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	rec := serve(newTestRouter(), http.MethodGet, "/api/v1/vendors")
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404, got %d", rec.Code)
+	}
+	if rec.Header().Get("Allow") != "" {
+		t.Error("Expected no Allow header on a 404")
+	}
+}
+
+func TestDeprecatedAlias(t *testing.T) {
+	rec := serve(newTestRouter(), http.MethodGet, "/api/products/p1")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if rec.Header().Get("Deprecation") != "true" {
+		t.Error("Expected Deprecation header on an alias")
+	}
+	if got, want := rec.Header().Get("Link"), `</api/v1/products/p1>; rel="successor-version"`; got != want {
+		t.Errorf("Expected Link %q, got %q", want, got)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	routes := newTestRouter().Routes()
+
+	if len(routes) != 3 {
+		t.Fatalf("Expected 3 routes, got %d", len(routes))
+	}
+	if routes[0].Path != "/api/v1/products/{id}" || routes[0].Deprecated {
+		t.Errorf("Unexpected first route %+v", routes[0])
+	}
+	if !routes[2].Deprecated {
+		t.Errorf("Expected alias route to be deprecated, got %+v", routes[2])
+	}
+}
@@ -0,0 +1,380 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+var (
+	ErrInvalidKit      = errors.New("invalid kit")
+	ErrNotKit          = errors.New("product is not a kit")
+	ErrInvalidAssembly = errors.New("invalid assembly")
+	ErrNoComponentItem = errors.New("no inventory item to return a component to")
+)
+
+// Assembly asks for Quantity of a kit, in Unit, to be assembled from its
+// components or disassembled into them
+type Assembly struct {
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit"`
+	Actor    string  `json:"actor"`
+}
+
+// KitAvailability is how many of a kit can be sold: the Assembled stock
+// available and the kits its components can still build, Buildable. The
+// kit's components show what each holds available and how many kits that
+// is enough for; the fewest of them limits Buildable.
+type KitAvailability struct {
+	ProductID  string                  `json:"product_id"`
+	Location   string                  `json:"location,omitempty"`
+	Assembled  float64                 `json:"assembled"`
+	Buildable  float64                 `json:"buildable"`
+	Available  float64                 `json:"available"`
+	Unit       string                  `json:"unit"`
+	Components []ComponentAvailability `json:"components"`
+}
+
+// ComponentAvailability is the stock of one component of a kit. Quantity
+// goes into each kit, so Available makes Kits of them.
+type ComponentAvailability struct {
+	ProductID string  `json:"product_id"`
+	Quantity  float64 `json:"quantity"`
+	Available float64 `json:"available"`
+	Unit      string  `json:"unit"`
+	Kits      float64 `json:"kits"`
+}
+
+// validateKit checks the bill of materials of a kit and converts the
+// quantity of each component to the component's base unit as described
+// for toBase. It fails with ErrInvalidKit unless every component names
+// another product, once, in a positive quantity, and the kit is counted
+// whole and neither serialized nor lot-controlled. Kits cannot be
+// components themselves and components cannot be serialized, so a product
+// that is a component cannot become a kit or serialized. A missing
+// component fails with repository.ErrInvalidReference.
+func (s *InventoryService) validateKit(product *models.Product) error {
+	kits, err := s.repo.FindProducts(repository.ProductFilter{ComponentID: product.ID})
+	if err != nil {
+		return err
+	}
+	if len(kits) > 0 && (len(product.Components) > 0 || product.Serialized) {
+		return ErrInvalidKit
+	}
+	if len(product.Components) == 0 {
+		return nil
+	}
+	if product.Serialized || product.LotControlled || models.Units[baseUnit(product)].Fractional {
+		return ErrInvalidKit
+	}
+	for i := range product.Components {
+		component := &product.Components[i]
+		if component.ProductID == "" || component.ProductID == product.ID || component.Quantity <= 0 {
+			return ErrInvalidKit
+		}
+		if slices.ContainsFunc(product.Components[:i], func(c models.Component) bool {
+			return c.ProductID == component.ProductID
+		}) {
+			return ErrInvalidKit
+		}
+		part, err := s.repo.GetProduct(component.ProductID)
+		if err == repository.ErrNotFound {
+			return repository.ErrInvalidReference
+		} else if err != nil {
+			return err
+		}
+		if len(part.Components) > 0 || part.Serialized {
+			return ErrInvalidKit
+		}
+		quantity, err := toBase(part, component.Quantity, component.Unit)
+		if err != nil {
+			return err
+		}
+		component.Quantity, component.Unit = quantity, baseUnit(part)
+	}
+	return nil
+}
+
+// getKit returns the product with the given ID, failing with ErrNotKit if
+// it has no components
+func (s *InventoryService) getKit(id string) (*models.Product, error) {
+	kit, err := s.repo.GetProduct(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(kit.Components) == 0 {
+		return nil, ErrNotKit
+	}
+	return kit, nil
+}
+
+// usableItems returns the items of a product at location, or at every
+// location if location is empty, that have not expired, in the order stock
+// should be taken from them
+func (s *InventoryService) usableItems(productID, location string, now time.Time) ([]*models.InventoryItem, error) {
+	items, err := s.repo.FindInventoryItems(repository.InventoryFilter{ProductID: productID, Location: location})
+	if err != nil {
+		return nil, err
+	}
+	return fefo(items, now), nil
+}
+
+// available returns the stock of a product available at each location, or
+// only at location if it is not empty, leaving out lots that have expired
+func (s *InventoryService) available(productID, location string, now time.Time) (map[string]float64, error) {
+	items, err := s.usableItems(productID, location, now)
+	if err != nil {
+		return nil, err
+	}
+	available := make(map[string]float64)
+	for _, item := range items {
+		available[item.Location] = models.RoundQuantity(available[item.Location] + item.Available)
+	}
+	return available, nil
+}
+
+// KitAvailability returns how many of a kit are available at location, or
+// at all locations together if location is empty. A kit is only buildable
+// from components held at the same location, so Buildable is then the sum
+// of the kits each location's components can build, and a component's
+// Kits the sum of those its stock at each location is enough for. It fails
+// with ErrNotFound if the product does not exist and with ErrNotKit if it
+// is not a kit.
+func (s *InventoryService) KitAvailability(id, location string) (*KitAvailability, error) {
+	kit, err := s.getKit(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	assembled, err := s.available(kit.ID, location, now)
+	if err != nil {
+		return nil, err
+	}
+	availability := &KitAvailability{
+		ProductID: kit.ID,
+		Location:  location,
+		Unit:      baseUnit(kit),
+	}
+	for _, available := range assembled {
+		availability.Assembled = models.RoundQuantity(availability.Assembled + available)
+	}
+	// buildable holds the kits each location can build from the components
+	// seen so far; a location missing a component builds none
+	var buildable map[string]float64
+	for i, component := range kit.Components {
+		available, err := s.available(component.ProductID, location, now)
+		if err != nil {
+			return nil, err
+		}
+		total, kits := 0.0, 0.0
+		limited := make(map[string]float64)
+		for at, quantity := range available {
+			n := math.Floor(models.RoundQuantity(quantity / component.Quantity))
+			total = models.RoundQuantity(total + quantity)
+			kits += n
+			if i == 0 {
+				limited[at] = n
+			} else if m, ok := buildable[at]; ok {
+				limited[at] = min(m, n)
+			}
+		}
+		buildable = limited
+		availability.Components = append(availability.Components, ComponentAvailability{
+			ProductID: component.ProductID,
+			Quantity:  component.Quantity,
+			Available: total,
+			Unit:      component.Unit,
+			Kits:      kits,
+		})
+	}
+	for _, n := range buildable {
+		availability.Buildable += n
+	}
+	availability.Available = availability.Assembled + availability.Buildable
+	return availability, nil
+}
+
+// reserveComponents reserves the components of quantity kits for line i
+// of order, recording allocations that name the component each is of. It
+// fails with repository.ErrInsufficientStock if a component is short.
+func reserveComponents(svc *InventoryService, order *models.SalesOrder, i int, quantity float64, now, expiresAt time.Time) error {
+	line := &order.Lines[i]
+	kit, err := svc.repo.GetProduct(line.ProductID)
+	if err != nil {
+		return err
+	}
+	if len(kit.Components) == 0 {
+		return repository.ErrInsufficientStock
+	}
+	for _, component := range kit.Components {
+		needed := models.RoundQuantity(quantity * component.Quantity)
+		needed, err := reserveItems(svc, order, i, component.ProductID, needed, now, expiresAt)
+		if err != nil {
+			return err
+		}
+		if needed > 0 {
+			return repository.ErrInsufficientStock
+		}
+	}
+	return nil
+}
+
+// checkAssembly validates assembly and converts its quantity to the unit
+// of the kit, returning the kit item and its product. It fails with
+// ErrInvalidAssembly unless the quantity is positive and there is an
+// actor, with ErrNotFound if the item does not exist, with ErrNotKit if it
+// does not hold a kit and with ErrLocationUnavailable if it is at no
+// location that exists, as an item from before locations may be.
+func (s *InventoryService) checkAssembly(itemID string, assembly *Assembly) (*models.InventoryItem, *models.Product, error) {
+	if assembly.Quantity <= 0 || assembly.Actor == "" {
+		return nil, nil, ErrInvalidAssembly
+	}
+	item, err := s.repo.GetInventoryItem(itemID)
+	if err != nil {
+		return nil, nil, err
+	}
+	kit, err := s.getKit(item.ProductID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := s.itemLocation(item.Location); err != nil {
+		return nil, nil, err
+	}
+	quantity, err := toBase(kit, assembly.Quantity, assembly.Unit)
+	if err != nil {
+		return nil, nil, err
+	}
+	assembly.Quantity, assembly.Unit = quantity, baseUnit(kit)
+	return item, kit, nil
+}
+
+// postAssemblyMovement posts a consume or produce movement of delta to an
+// item and returns it
+func (s *InventoryService) postAssemblyMovement(itemID string, delta float64, reason, reference, actor string) (*models.StockMovement, error) {
+	movementType := models.MovementProduce
+	if delta < 0 {
+		movementType = models.MovementConsume
+	}
+	movement := &models.StockMovement{
+		ItemID:    itemID,
+		Type:      movementType,
+		Delta:     delta,
+		Reason:    reason,
+		Reference: reference,
+		Actor:     actor,
+	}
+	if err := s.repo.PostMovement(movement, 0); err != nil {
+		return nil, err
+	}
+	return movement, nil
+}
+
+// AssembleKit builds kits into an inventory item of a kit from the stock
+// of its components, posting a consume movement against each component
+// item it takes from and a produce movement against the kit item. The
+// components are taken from the items at the kit item's location, as much
+// from each as it has available, the lots that expire first first and
+// expired lots not at all. It fails as described for checkAssembly and with
+// repository.ErrInsufficientStock, taking nothing, if a component is
+// short.
+func (s *InventoryService) AssembleKit(itemID string, assembly Assembly) (*models.InventoryItem, []*models.StockMovement, error) {
+	var item *models.InventoryItem
+	var movements []*models.StockMovement
+	err := s.Atomically(func(svc *InventoryService) error {
+		kitItem, kit, err := svc.checkAssembly(itemID, &assembly)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, component := range kit.Components {
+			needed := models.RoundQuantity(assembly.Quantity * component.Quantity)
+			items, err := svc.usableItems(component.ProductID, kitItem.Location, now)
+			if err != nil {
+				return err
+			}
+			for _, from := range items {
+				if needed == 0 {
+					break
+				}
+				quantity := min(needed, from.Available)
+				if quantity <= 0 {
+					continue
+				}
+				movement, err := svc.postAssemblyMovement(from.ID, -quantity, models.ReasonAssembly, kitItem.ID, assembly.Actor)
+				if err != nil {
+					return err
+				}
+				movements = append(movements, movement)
+				needed = models.RoundQuantity(needed - quantity)
+			}
+			if needed > 0 {
+				return repository.ErrInsufficientStock
+			}
+		}
+		movement, err := svc.postAssemblyMovement(kitItem.ID, assembly.Quantity, models.ReasonAssembly, kitItem.ID, assembly.Actor)
+		if err != nil {
+			return err
+		}
+		movements = append(movements, movement)
+		item, err = svc.repo.GetInventoryItem(kitItem.ID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return item, movements, nil
+}
+
+// DisassembleKit breaks kits held by an inventory item back into their
+// components, posting a consume movement against the kit item and a
+// produce movement against an item of each component. Each component
+// returns to the item at the kit item's location that stock would be taken
+// from last: the lot that expires last, or an item without an expiry date. It fails as described for
+// checkAssembly, with repository.ErrInsufficientStock if the kit item has
+// fewer kits available and with ErrNoComponentItem if a component has no
+// item to return to.
+func (s *InventoryService) DisassembleKit(itemID string, assembly Assembly) (*models.InventoryItem, []*models.StockMovement, error) {
+	var item *models.InventoryItem
+	var movements []*models.StockMovement
+	err := s.Atomically(func(svc *InventoryService) error {
+		kitItem, kit, err := svc.checkAssembly(itemID, &assembly)
+		if err != nil {
+			return err
+		}
+		if kitItem.Available < assembly.Quantity {
+			return repository.ErrInsufficientStock
+		}
+		movement, err := svc.postAssemblyMovement(kitItem.ID, -assembly.Quantity, models.ReasonDisassembly, kitItem.ID, assembly.Actor)
+		if err != nil {
+			return err
+		}
+		movements = append(movements, movement)
+		now := time.Now()
+		for _, component := range kit.Components {
+			items, err := svc.usableItems(component.ProductID, kitItem.Location, now)
+			if err != nil {
+				return err
+			}
+			if len(items) == 0 {
+				return ErrNoComponentItem
+			}
+			to := items[len(items)-1]
+			quantity := models.RoundQuantity(assembly.Quantity * component.Quantity)
+			movement, err := svc.postAssemblyMovement(to.ID, quantity, models.ReasonDisassembly, kitItem.ID, assembly.Actor)
+			if err != nil {
+				return err
+			}
+			movements = append(movements, movement)
+		}
+		item, err = svc.repo.GetInventoryItem(kitItem.ID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return item, movements, nil
+}
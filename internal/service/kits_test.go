@@ -0,0 +1,258 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+// newKitService returns a service with buyer b1 and a raised bed starter
+// kit of vendor v1, each built from 4 boards of lumber, 2 cubic feet of
+// soil and a bag of seed. Item lumber-1 holds 10 boards, soil-1 8 cubic
+// feet, seed-1 a lot of 1 bag expiring soonest and seed-2 a lot of 5 bags,
+// all in warehouse wh, where kit-1 holds no kits yet.
+func newKitService(t *testing.T) *InventoryService {
+	t.Helper()
+	soon, later := time.Now().Add(24*time.Hour), time.Now().Add(90*24*time.Hour)
+	return newTestService(t,
+		withBuyers(&models.Buyer{ID: "b1", Name: "Bob"}),
+		withLocations(&models.Location{ID: "wh", Name: "Warehouse", Type: models.LocationWarehouse, Active: true}),
+		withProducts(
+			&models.Product{ID: "lumber", Name: "Cedar Board", VendorID: "v1"},
+			&models.Product{ID: "soil", Name: "Raised Bed Soil", VendorID: "v1", BaseUnit: "cubic_foot",
+				Packs: []models.Pack{{Unit: "bag", Quantity: 1}}},
+			&models.Product{ID: "seed", Name: "Vegetable Seed", VendorID: "v1", LotControlled: true},
+			&models.Product{ID: "kit", Name: "Raised Bed Starter Kit", VendorID: "v1", Components: []models.Component{
+				{ProductID: "lumber", Quantity: 4},
+				{ProductID: "soil", Quantity: 2, Unit: "bag"},
+				{ProductID: "seed", Quantity: 1},
+			}},
+		),
+		withItems(
+			&models.InventoryItem{ID: "lumber-1", ProductID: "lumber", Location: "wh", Quantity: 10},
+			&models.InventoryItem{ID: "soil-1", ProductID: "soil", Location: "wh", Quantity: 8},
+			&models.InventoryItem{ID: "seed-1", ProductID: "seed", Location: "wh", Quantity: 1, LotNumber: "A", ExpiresAt: &soon},
+			&models.InventoryItem{ID: "seed-2", ProductID: "seed", Location: "wh", Quantity: 5, LotNumber: "B", ExpiresAt: &later},
+			&models.InventoryItem{ID: "kit-1", ProductID: "kit", Location: "wh"},
+		),
+	)
+}
+
+// checkKitAvailability fails the test unless the kit has assembled and
+// buildable kits available
+func checkKitAvailability(t *testing.T, svc *InventoryService, assembled, buildable float64) {
+	t.Helper()
+	availability, err := svc.KitAvailability("kit", "")
+	if err != nil {
+		t.Fatalf("Failed to get kit availability: %v", err)
+	}
+	if availability.Assembled != assembled || availability.Buildable != buildable ||
+		availability.Available != assembled+buildable {
+		t.Errorf("Expected %v assembled and %v buildable kits, got %+v", assembled, buildable, availability)
+	}
+}
+
+func TestKitAvailability(t *testing.T) {
+	svc := newKitService(t)
+
+	kit, err := svc.GetProduct("kit")
+	if err != nil {
+		t.Fatalf("Failed to get kit: %v", err)
+	}
+	if soil := kit.Components[1]; soil.Quantity != 2 || soil.Unit != "cubic_foot" {
+		t.Errorf("Expected the kit's soil in cubic feet, got %+v", soil)
+	}
+
+	// Lumber is the limit: 10 boards make 2 kits
+	checkKitAvailability(t, svc, 0, 2)
+	availability, err := svc.KitAvailability("kit", "wh")
+	if err != nil {
+		t.Fatalf("Failed to get kit availability: %v", err)
+	}
+	if len(availability.Components) != 3 || availability.Components[0].Kits != 2 || availability.Components[1].Kits != 4 {
+		t.Errorf("Expected lumber for 2 kits and soil for 4, got %+v", availability.Components)
+	}
+
+	// Lumber for another 2 kits at a second warehouse builds none without
+	// soil and seed there
+	createLocation(t, svc, "wh-b", models.LocationWarehouse, "")
+	if err := svc.CreateInventoryItem(&models.InventoryItem{ID: "lumber-2", ProductID: "lumber", Location: "wh-b", Quantity: 8}); err != nil {
+		t.Fatalf("Failed to create inventory item: %v", err)
+	}
+	checkKitAvailability(t, svc, 0, 2)
+	availability, err = svc.KitAvailability("kit", "")
+	if err != nil {
+		t.Fatalf("Failed to get kit availability: %v", err)
+	}
+	if lumber := availability.Components[0]; lumber.Available != 18 || lumber.Kits != 4 {
+		t.Errorf("Expected lumber for 4 kits in all, got %+v", lumber)
+	}
+
+	if _, err := svc.KitAvailability("lumber", ""); err != ErrNotKit {
+		t.Errorf("Expected ErrNotKit for lumber, got %v", err)
+	}
+}
+
+func TestAssembleAndDisassembleKits(t *testing.T) {
+	svc := newKitService(t)
+
+	item, movements, err := svc.AssembleKit("kit-1", Assembly{Quantity: 2, Actor: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to assemble kits: %v", err)
+	}
+	if item.Quantity != 2 || len(movements) != 5 {
+		t.Errorf("Expected 2 kits from 4 consume movements and 1 produce, got %v and %d movements", item.Quantity, len(movements))
+	}
+	checkItem(t, svc, "lumber-1", 2, 0)
+	checkItem(t, svc, "soil-1", 4, 0)
+	// The lot that expires first goes first
+	checkItem(t, svc, "seed-1", 0, 0)
+	checkItem(t, svc, "seed-2", 4, 0)
+	checkKitAvailability(t, svc, 2, 0)
+
+	if _, _, err := svc.AssembleKit("kit-1", Assembly{Quantity: 1, Actor: "alice"}); err != repository.ErrInsufficientStock {
+		t.Errorf("Expected ErrInsufficientStock assembling without enough lumber, got %v", err)
+	}
+	checkItem(t, svc, "soil-1", 4, 0)
+
+	item, _, err = svc.DisassembleKit("kit-1", Assembly{Quantity: 1, Actor: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to disassemble a kit: %v", err)
+	}
+	if item.Quantity != 1 {
+		t.Errorf("Expected 1 kit left, got %v", item.Quantity)
+	}
+	checkItem(t, svc, "lumber-1", 6, 0)
+	checkItem(t, svc, "soil-1", 6, 0)
+	// Seed goes back into the lot that expires last
+	checkItem(t, svc, "seed-2", 5, 0)
+	checkKitAvailability(t, svc, 1, 1)
+
+	if _, _, err := svc.DisassembleKit("kit-1", Assembly{Quantity: 2, Actor: "alice"}); err != repository.ErrInsufficientStock {
+		t.Errorf("Expected ErrInsufficientStock disassembling more kits than held, got %v", err)
+	}
+	failures := []struct {
+		itemID   string
+		assembly Assembly
+		err      error
+	}{
+		{"kit-1", Assembly{Quantity: 0, Actor: "alice"}, ErrInvalidAssembly},
+		{"kit-1", Assembly{Quantity: 1}, ErrInvalidAssembly},
+		{"kit-1", Assembly{Quantity: 0.5, Actor: "alice"}, ErrFractionalQuantity},
+		{"lumber-1", Assembly{Quantity: 1, Actor: "alice"}, ErrNotKit},
+		{"missing", Assembly{Quantity: 1, Actor: "alice"}, repository.ErrNotFound},
+	}
+	for _, tt := range failures {
+		if _, _, err := svc.AssembleKit(tt.itemID, tt.assembly); err != tt.err {
+			t.Errorf("Expected %v assembling %+v into %s, got %v", tt.err, tt.assembly, tt.itemID, err)
+		}
+	}
+}
+
+func TestSellKitsFromComponents(t *testing.T) {
+	svc := newKitService(t)
+
+	if _, _, err := svc.AssembleKit("kit-1", Assembly{Quantity: 1, Actor: "alice"}); err != nil {
+		t.Fatalf("Failed to assemble a kit: %v", err)
+	}
+	order := &models.SalesOrder{ID: "so1", BuyerID: "b1", Lines: []models.SalesOrderLine{{ProductID: "kit", Quantity: 2}}}
+	if err := svc.CreateSalesOrder(order); err != nil {
+		t.Fatalf("Failed to create sales order: %v", err)
+	}
+	confirmed, err := svc.ConfirmSalesOrder("so1", 0)
+	if err != nil {
+		t.Fatalf("Failed to confirm sales order: %v", err)
+	}
+	allocations := confirmed.Lines[0].Allocations
+	if len(allocations) != 4 || allocations[0].ItemID != "kit-1" || allocations[0].ProductID != "" ||
+		allocations[1].ProductID != "lumber" || allocations[1].Quantity != 4 {
+		t.Errorf("Expected the assembled kit and then one kit's components, got %+v", allocations)
+	}
+	checkKitAvailability(t, svc, 0, 0)
+
+	for _, step := range []func(string, int64) (*models.SalesOrder, error){
+		svc.AllocateSalesOrder, svc.PickSalesOrder, svc.ShipSalesOrder,
+	} {
+		if _, err := step("so1", 0); err != nil {
+			t.Fatalf("Failed to advance sales order: %v", err)
+		}
+	}
+	checkItem(t, svc, "kit-1", 0, 0)
+	checkItem(t, svc, "lumber-1", 2, 0)
+	checkItem(t, svc, "soil-1", 4, 0)
+	checkItem(t, svc, "seed-2", 4, 0)
+
+	short := &models.SalesOrder{ID: "so2", BuyerID: "b1", Lines: []models.SalesOrderLine{{ProductID: "kit", Quantity: 1}}}
+	if err := svc.CreateSalesOrder(short); err != nil {
+		t.Fatalf("Failed to create sales order: %v", err)
+	}
+	if _, err := svc.ConfirmSalesOrder("so2", 0); err != repository.ErrInsufficientStock {
+		t.Errorf("Expected ErrInsufficientStock with lumber for no more kits, got %v", err)
+	}
+	checkItem(t, svc, "soil-1", 4, 0)
+}
+
+func TestInvalidKits(t *testing.T) {
+	svc := newKitService(t)
+
+	if err := svc.CreateProduct(&models.Product{ID: "saw", Name: "Saw", VendorID: "v1", Serialized: true}); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	tests := []struct {
+		name    string
+		product *models.Product
+		err     error
+	}{
+		{"missing component", &models.Product{Components: []models.Component{{ProductID: "missing", Quantity: 1}}},
+			repository.ErrInvalidReference},
+		{"component without a quantity", &models.Product{Components: []models.Component{{ProductID: "lumber"}}},
+			ErrInvalidKit},
+		{"component named twice", &models.Product{Components: []models.Component{
+			{ProductID: "lumber", Quantity: 1}, {ProductID: "lumber", Quantity: 2}}}, ErrInvalidKit},
+		{"kit of kits", &models.Product{Components: []models.Component{{ProductID: "kit", Quantity: 1}}},
+			ErrInvalidKit},
+		{"serialized component", &models.Product{Components: []models.Component{{ProductID: "saw", Quantity: 1}}},
+			ErrInvalidKit},
+		{"lot-controlled kit", &models.Product{LotControlled: true,
+			Components: []models.Component{{ProductID: "lumber", Quantity: 1}}}, ErrInvalidKit},
+		{"kit by weight", &models.Product{BaseUnit: "kg",
+			Components: []models.Component{{ProductID: "lumber", Quantity: 1}}}, ErrInvalidKit},
+		{"component in another dimension", &models.Product{Components: []models.Component{
+			{ProductID: "soil", Quantity: 1, Unit: "kg"}}}, ErrInvalidUnit},
+		{"fraction of a board", &models.Product{Components: []models.Component{{ProductID: "lumber", Quantity: 0.5}}},
+			ErrFractionalQuantity},
+	}
+	for _, tt := range tests {
+		tt.product.ID = "p-" + tt.name
+		tt.product.Name = tt.name
+		tt.product.VendorID = "v1"
+		if err := svc.CreateProduct(tt.product); err != tt.err {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	if _, err := svc.PatchProduct("lumber", 0, func(product *models.Product) error {
+		product.Components = []models.Component{{ProductID: "soil", Quantity: 1}}
+		return nil
+	}); err != ErrInvalidKit {
+		t.Errorf("Expected ErrInvalidKit making a component a kit, got %v", err)
+	}
+
+	compost := &models.Product{ID: "compost", Name: "Compost", VendorID: "v1", BaseUnit: "cubic_foot"}
+	if err := svc.CreateProduct(compost); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	bed := &models.Product{ID: "bed", Name: "Compost Bed", VendorID: "v1",
+		Components: []models.Component{{ProductID: "compost", Quantity: 3}}}
+	if err := svc.CreateProduct(bed); err != nil {
+		t.Fatalf("Failed to create kit: %v", err)
+	}
+	if _, err := svc.PatchProduct("compost", 0, func(product *models.Product) error {
+		product.BaseUnit = "cubic_yard"
+		return nil
+	}); err != ErrBaseUnitInUse {
+		t.Errorf("Expected ErrBaseUnitInUse changing a component's base unit, got %v", err)
+	}
+}
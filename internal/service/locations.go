@@ -0,0 +1,299 @@
+package service
+
+import (
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+var (
+	ErrInvalidLocation     = errors.New("invalid location")
+	ErrLocationUnavailable = errors.New("location does not exist or is inactive")
+)
+
+// locationLevels gives the depth of each location type in the hierarchy. A
+// location's parent must be of a shallower type, so a warehouse may hold
+// zones or bins directly but never a site.
+var locationLevels = map[models.LocationType]int{
+	models.LocationSite:      0,
+	models.LocationWarehouse: 1,
+	models.LocationZone:      2,
+	models.LocationAisle:     3,
+	models.LocationBin:       4,
+}
+
+// LocationStock is the stock held at a location and every location below
+// it, optionally of one product only, whose base unit is then Unit. Items
+// counts the inventory items holding it. Capacity is the location's; it has
+// no unit, so it is reported but not compared with the stock.
+type LocationStock struct {
+	LocationID string  `json:"location_id"`
+	ProductID  string  `json:"product_id,omitempty"`
+	Items      int     `json:"items"`
+	Quantity   float64 `json:"quantity"`
+	Reserved   float64 `json:"reserved"`
+	Available  float64 `json:"available"`
+	Unit       string  `json:"unit,omitempty"`
+	Capacity   int     `json:"capacity"`
+}
+
+// validateLocation fails with ErrInvalidLocation unless location has a
+// known type and a capacity that is not negative, and fits the hierarchy:
+// its parent is of a shallower type and is not the location itself or one
+// of its descendants, and its children, if any, are of deeper types. A
+// missing parent fails with repository.ErrInvalidReference.
+func (s *InventoryService) validateLocation(location *models.Location) error {
+	level, ok := locationLevels[location.Type]
+	if !ok || location.Capacity < 0 {
+		return ErrInvalidLocation
+	}
+
+	for parentID := location.ParentID; parentID != ""; {
+		if parentID == location.ID {
+			return ErrInvalidLocation
+		}
+		parent, err := s.repo.GetLocation(parentID)
+		if err == repository.ErrNotFound {
+			return repository.ErrInvalidReference
+		} else if err != nil {
+			return err
+		}
+		if parentID == location.ParentID && locationLevels[parent.Type] >= level {
+			return ErrInvalidLocation
+		}
+		parentID = parent.ParentID
+	}
+
+	children, err := s.repo.FindLocations(repository.LocationFilter{ParentID: location.ID})
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if locationLevels[child.Type] <= level {
+			return ErrInvalidLocation
+		}
+	}
+	return nil
+}
+
+// CreateLocation creates a location. It fails with ErrInvalidLocation as
+// described for validateLocation.
+func (s *InventoryService) CreateLocation(location *models.Location) error {
+	return s.Atomically(func(svc *InventoryService) error {
+		if err := svc.validateLocation(location); err != nil {
+			return err
+		}
+		return svc.repo.CreateLocation(location)
+	})
+}
+
+func (s *InventoryService) GetLocation(id string) (*models.Location, error) {
+	return s.repo.GetLocation(id)
+}
+
+func (s *InventoryService) FindLocations(filter repository.LocationFilter) ([]*models.Location, error) {
+	return s.repo.FindLocations(filter)
+}
+
+// UpdateLocation replaces a location, which may move it to another parent
+// as long as the hierarchy stays valid. Deactivating a location leaves its
+// stock in place.
+func (s *InventoryService) UpdateLocation(location *models.Location) error {
+	return s.Atomically(func(svc *InventoryService) error {
+		if err := svc.validateLocation(location); err != nil {
+			return err
+		}
+		return svc.repo.UpdateLocation(location)
+	})
+}
+
+// DeleteLocation deletes a location. It fails with repository.ErrInUse if
+// inventory items are at the location, which is checked in the same
+// transaction as the delete so that no item can be placed there meanwhile.
+func (s *InventoryService) DeleteLocation(id string, version int64) error {
+	return s.Atomically(func(svc *InventoryService) error {
+		existing, err := svc.repo.GetLocation(id)
+		if err != nil {
+			return err
+		}
+		if err := repository.CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		items, err := svc.repo.FindInventoryItems(repository.InventoryFilter{Location: id})
+		if err != nil {
+			return err
+		}
+		if len(items) > 0 {
+			return repository.ErrInUse
+		}
+		return svc.repo.DeleteLocation(id, existing.Version)
+	})
+}
+
+// LocationStock totals the stock at a location and every location below
+// it, only of productID if it is not empty. It fails with ErrNotFound if the
+// location does not exist.
+func (s *InventoryService) LocationStock(id, productID string) (*LocationStock, error) {
+	location, err := s.repo.GetLocation(id)
+	if err != nil {
+		return nil, err
+	}
+
+	stock := &LocationStock{LocationID: id, ProductID: productID, Capacity: location.Capacity}
+	if productID != "" {
+		product, err := s.repo.GetProduct(productID)
+		if err == nil {
+			stock.Unit = baseUnit(product)
+		} else if err != repository.ErrNotFound {
+			return nil, err
+		}
+	}
+	for pending := []string{id}; len(pending) > 0; {
+		locationID := pending[0]
+		pending = pending[1:]
+
+		items, err := s.repo.FindInventoryItems(repository.InventoryFilter{ProductID: productID, Location: locationID})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			stock.Items++
+			stock.Quantity = models.RoundQuantity(stock.Quantity + item.Quantity)
+			stock.Reserved = models.RoundQuantity(stock.Reserved + item.Reserved)
+			stock.Available = models.RoundQuantity(stock.Available + item.Available)
+		}
+
+		children, err := s.repo.FindLocations(repository.LocationFilter{ParentID: locationID})
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			pending = append(pending, child.ID)
+		}
+	}
+	return stock, nil
+}
+
+// checkItemLocation fails with ErrLocationUnavailable unless location names
+// an active location
+func (s *InventoryService) checkItemLocation(location string) error {
+	l, err := s.itemLocation(location)
+	if err != nil {
+		return err
+	}
+	if !l.Active {
+		return ErrLocationUnavailable
+	}
+	return nil
+}
+
+// checkItemMove checks the location of an item being updated. An item
+// moving to another location needs an active one; an item staying where it
+// is needs its location to exist, even if it has since been deactivated.
+func (s *InventoryService) checkItemMove(existing, item *models.InventoryItem) error {
+	if existing.Location != item.Location {
+		return s.checkItemLocation(item.Location)
+	}
+	_, err := s.itemLocation(item.Location)
+	return err
+}
+
+// itemLocation returns the location an item names, failing with
+// ErrLocationUnavailable if there is none
+func (s *InventoryService) itemLocation(id string) (*models.Location, error) {
+	if id == "" {
+		return nil, ErrLocationUnavailable
+	}
+	l, err := s.repo.GetLocation(id)
+	if err == repository.ErrNotFound {
+		return nil, ErrLocationUnavailable
+	}
+	return l, err
+}
+
+// UnmatchedLocation is a location that inventory items name but that does
+// not exist, as items from before the location hierarchy may, with the IDs
+// of the items naming it. Location is empty for items with no location.
+type UnmatchedLocation struct {
+	Location string   `json:"location"`
+	Items    []string `json:"items"`
+}
+
+// UnmatchedLocations lists the locations that inventory items name but that
+// do not exist, in order, so that they can be mapped to locations with
+// MapLocations
+func (s *InventoryService) UnmatchedLocations() ([]UnmatchedLocation, error) {
+	items, err := s.repo.ListInventoryItems()
+	if err != nil {
+		return nil, err
+	}
+	byLocation := make(map[string][]string)
+	for _, item := range items {
+		if _, err := s.itemLocation(item.Location); err == nil {
+			continue
+		} else if err != ErrLocationUnavailable {
+			return nil, err
+		}
+		byLocation[item.Location] = append(byLocation[item.Location], item.ID)
+	}
+	unmatched := make([]UnmatchedLocation, 0, len(byLocation))
+	for _, location := range slices.Sorted(maps.Keys(byLocation)) {
+		ids := byLocation[location]
+		slices.Sort(ids)
+		unmatched = append(unmatched, UnmatchedLocation{Location: location, Items: ids})
+	}
+	return unmatched, nil
+}
+
+// MapLocations moves the inventory items at each unmatched location in
+// mappings, and their serials, to the location it maps to, and returns how
+// many items it moved. Mapping "" places the items with no location. It
+// fails with ErrInvalidLocation if a location mapped from exists, and with
+// ErrLocationUnavailable unless each location mapped to is active; then no
+// item moves.
+func (s *InventoryService) MapLocations(mappings map[string]string) (int, error) {
+	moved := 0
+	err := s.Atomically(func(svc *InventoryService) error {
+		moved = 0
+		for from, to := range mappings {
+			if _, err := svc.itemLocation(from); err == nil {
+				return ErrInvalidLocation
+			} else if err != ErrLocationUnavailable {
+				return err
+			}
+			if err := svc.checkItemLocation(to); err != nil {
+				return err
+			}
+		}
+		items, err := svc.repo.ListInventoryItems()
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			to, ok := mappings[item.Location]
+			if !ok {
+				continue
+			}
+			item.Location = to
+			if err := svc.repo.UpdateInventoryItem(item); err != nil {
+				return err
+			}
+			serials, err := svc.repo.FindSerials(repository.SerialFilter{ItemID: item.ID})
+			if err != nil {
+				return err
+			}
+			for _, serial := range serials {
+				serial.Location = to
+				if err := svc.repo.UpdateSerial(serial); err != nil {
+					return err
+				}
+			}
+			moved++
+		}
+		return nil
+	})
+	return moved, err
+}
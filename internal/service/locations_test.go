@@ -0,0 +1,246 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+// createLocation creates an active location of the given type under parent
+func createLocation(t *testing.T, svc *InventoryService, id string, locationType models.LocationType, parent string) {
+	t.Helper()
+	withLocations(activeLocation(id, locationType, parent))(t, svc)
+}
+
+// newLocationService returns a service with site s1 holding warehouse w1,
+// whose zone z1 holds bins b1 and b2, and product p1
+func newLocationService(t *testing.T) *InventoryService {
+	t.Helper()
+	return newTestService(t,
+		withLocations(
+			activeLocation("s1", models.LocationSite, ""),
+			activeLocation("w1", models.LocationWarehouse, "s1"),
+			activeLocation("z1", models.LocationZone, "w1"),
+			activeLocation("b1", models.LocationBin, "z1"),
+			activeLocation("b2", models.LocationBin, "z1"),
+		),
+		withProducts(&models.Product{ID: "p1", Name: "Fertilizer", VendorID: "v1"}),
+	)
+}
+
+func TestLocationHierarchy(t *testing.T) {
+	svc := newLocationService(t)
+
+	invalid := []*models.Location{
+		{ID: "x", Type: "shelf"},
+		{ID: "x", Type: models.LocationBin, Capacity: -1},
+		{ID: "x", Type: models.LocationSite, ParentID: "w1"},
+		{ID: "x", Type: models.LocationZone, ParentID: "z1"},
+	}
+	for _, location := range invalid {
+		if err := svc.CreateLocation(location); err != ErrInvalidLocation {
+			t.Errorf("Expected ErrInvalidLocation for %+v, got %v", location, err)
+		}
+	}
+	if err := svc.CreateLocation(&models.Location{ID: "x", Type: models.LocationBin, ParentID: "missing"}); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a missing parent, got %v", err)
+	}
+
+	// A warehouse may hold bins directly
+	createLocation(t, svc, "b3", models.LocationBin, "w1")
+
+	// Moving a location under itself or its descendants, or making it
+	// shallower than a child, breaks the tree
+	if err := svc.UpdateLocation(&models.Location{ID: "w1", Type: models.LocationWarehouse, ParentID: "w1"}); err != ErrInvalidLocation {
+		t.Errorf("Expected ErrInvalidLocation moving a location under itself, got %v", err)
+	}
+	if err := svc.UpdateLocation(&models.Location{ID: "z1", Type: models.LocationZone, ParentID: "b1"}); err != ErrInvalidLocation {
+		t.Errorf("Expected ErrInvalidLocation moving a location under its child, got %v", err)
+	}
+	if err := svc.UpdateLocation(&models.Location{ID: "z1", Type: models.LocationBin, ParentID: "w1"}); err != ErrInvalidLocation {
+		t.Errorf("Expected ErrInvalidLocation making a parent a bin, got %v", err)
+	}
+
+	// Zones can move between warehouses
+	createLocation(t, svc, "w2", models.LocationWarehouse, "s1")
+	if err := svc.UpdateLocation(&models.Location{ID: "z1", Name: "Cold", Type: models.LocationZone, ParentID: "w2", Active: true}); err != nil {
+		t.Fatalf("Failed to move zone: %v", err)
+	}
+	children, err := svc.FindLocations(repository.LocationFilter{ParentID: "w2"})
+	if err != nil || len(children) != 1 || children[0].ID != "z1" {
+		t.Errorf("Expected z1 under w2, got %v and %v", children, err)
+	}
+
+	if err := svc.DeleteLocation("w2", 0); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a location with children, got %v", err)
+	}
+}
+
+func TestInventoryItemLocation(t *testing.T) {
+	svc := newLocationService(t)
+
+	if err := svc.CreateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1", Location: "missing"}); err != ErrLocationUnavailable {
+		t.Errorf("Expected ErrLocationUnavailable for a missing location, got %v", err)
+	}
+	if err := svc.CreateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1"}); err != ErrLocationUnavailable {
+		t.Errorf("Expected ErrLocationUnavailable for an item with no location, got %v", err)
+	}
+	item := &models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 5, Location: "b1"}
+	if err := svc.CreateInventoryItem(item); err != nil {
+		t.Fatalf("Failed to create inventory item: %v", err)
+	}
+	if err := svc.DeleteLocation("b1", 0); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a location holding stock, got %v", err)
+	}
+
+	// Items stay at a deactivated location but no more are placed there
+	if err := svc.UpdateLocation(&models.Location{ID: "b2", Type: models.LocationBin, ParentID: "z1"}); err != nil {
+		t.Fatalf("Failed to deactivate location: %v", err)
+	}
+	if err := svc.UpdateLocation(&models.Location{ID: "b1", Type: models.LocationBin, ParentID: "z1"}); err != nil {
+		t.Fatalf("Failed to deactivate location: %v", err)
+	}
+	if _, err := svc.PatchInventoryItem("i1", 0, func(i *models.InventoryItem) error {
+		i.Location = "b2"
+		return nil
+	}); err != ErrLocationUnavailable {
+		t.Errorf("Expected ErrLocationUnavailable moving to an inactive location, got %v", err)
+	}
+	if err := svc.UpdateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1", Location: "b1"}); err != nil {
+		t.Errorf("Failed to update item at a deactivated location: %v", err)
+	}
+	if err := svc.UpdateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1", Location: "z1"}); err != nil {
+		t.Errorf("Failed to move item to an active location: %v", err)
+	}
+}
+
+func TestLocationStock(t *testing.T) {
+	svc := newLocationService(t)
+	if err := svc.CreateProduct(&models.Product{ID: "p2", Name: "Mulch", VendorID: "v1"}); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	for _, item := range []*models.InventoryItem{
+		{ID: "i1", ProductID: "p1", Quantity: 5, Location: "b1"},
+		{ID: "i2", ProductID: "p1", Quantity: 7, Location: "b2"},
+		{ID: "i3", ProductID: "p2", Quantity: 3, Location: "z1"},
+		{ID: "i4", ProductID: "p2", Quantity: 9, Location: "w1"},
+	} {
+		if err := svc.CreateInventoryItem(item); err != nil {
+			t.Fatalf("Failed to create inventory item: %v", err)
+		}
+	}
+	reservation := &models.Reservation{ID: "r1", ItemID: "i2", Quantity: 2, Owner: "so1", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := svc.CreateReservation(reservation); err != nil {
+		t.Fatalf("Failed to create reservation: %v", err)
+	}
+	for id, capacity := range map[string]int{"z1": 14, "w1": 30} {
+		location, _ := svc.GetLocation(id)
+		location.Capacity = capacity
+		if err := svc.UpdateLocation(location); err != nil {
+			t.Fatalf("Failed to set the capacity of %s: %v", id, err)
+		}
+	}
+
+	tests := []struct {
+		location, product string
+		expected          LocationStock
+	}{
+		{"b1", "", LocationStock{Items: 1, Quantity: 5, Available: 5}},
+		{"z1", "", LocationStock{Items: 3, Quantity: 15, Reserved: 2, Available: 13, Capacity: 14}},
+		{"z1", "p1", LocationStock{Items: 2, Quantity: 12, Reserved: 2, Available: 10, Unit: "each", Capacity: 14}},
+		{"w1", "", LocationStock{Items: 4, Quantity: 24, Reserved: 2, Available: 22, Capacity: 30}},
+		{"s1", "", LocationStock{Items: 4, Quantity: 24, Reserved: 2, Available: 22}},
+		{"s1", "p2", LocationStock{Items: 2, Quantity: 12, Available: 12, Unit: "each"}},
+	}
+	for _, tt := range tests {
+		stock, err := svc.LocationStock(tt.location, tt.product)
+		if err != nil {
+			t.Fatalf("Failed to total stock at %s: %v", tt.location, err)
+		}
+		tt.expected.LocationID, tt.expected.ProductID = tt.location, tt.product
+		if *stock != tt.expected {
+			t.Errorf("Expected %+v, got %+v", tt.expected, *stock)
+		}
+	}
+
+	if _, err := svc.LocationStock("missing", ""); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing location, got %v", err)
+	}
+}
+
+func TestMapLocations(t *testing.T) {
+	svc := newLocationService(t)
+	if err := svc.CreateProduct(&models.Product{ID: "mower", Name: "Mower", VendorID: "v1", Serialized: true}); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	// Items from before the location hierarchy name free-form places
+	for _, item := range []*models.InventoryItem{
+		{ID: "i1", ProductID: "p1", Location: "Warehouse A"},
+		{ID: "i2", ProductID: "p1", Location: "warehouse a"},
+		{ID: "i3", ProductID: "p1", Location: "b1"},
+		{ID: "i4", ProductID: "p1"},
+		{ID: "m1", ProductID: "mower", Location: "WH-A"},
+	} {
+		if err := svc.repo.CreateInventoryItem(item); err != nil {
+			t.Fatalf("Failed to store inventory item: %v", err)
+		}
+	}
+	if err := svc.repo.CreateSerial(&models.Serial{ID: "SN-1", ProductID: "mower", ItemID: "m1", Location: "WH-A", Status: models.SerialInStock}); err != nil {
+		t.Fatalf("Failed to store serial: %v", err)
+	}
+	if err := svc.UpdateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1", Location: "Warehouse A"}); err != ErrLocationUnavailable {
+		t.Errorf("Expected ErrLocationUnavailable updating an item at a free-form location, got %v", err)
+	}
+
+	unmatched, err := svc.UnmatchedLocations()
+	if err != nil {
+		t.Fatalf("Failed to list unmatched locations: %v", err)
+	}
+	expected := []UnmatchedLocation{
+		{Location: "", Items: []string{"i4"}},
+		{Location: "WH-A", Items: []string{"m1"}},
+		{Location: "Warehouse A", Items: []string{"i1"}},
+		{Location: "warehouse a", Items: []string{"i2"}},
+	}
+	if !reflect.DeepEqual(unmatched, expected) {
+		t.Errorf("Expected unmatched locations %+v, got %+v", expected, unmatched)
+	}
+
+	for _, mappings := range []map[string]string{
+		{"Warehouse A": "w1", "b1": "b2"},
+		{"Warehouse A": "missing"},
+	} {
+		if _, err := svc.MapLocations(mappings); err != ErrInvalidLocation && err != ErrLocationUnavailable {
+			t.Errorf("Expected %v to be rejected, got %v", mappings, err)
+		}
+	}
+	if unmatched, _ := svc.UnmatchedLocations(); len(unmatched) != 4 {
+		t.Errorf("Expected a rejected mapping to move nothing, got %+v", unmatched)
+	}
+
+	// The spellings of one place all map to it
+	moved, err := svc.MapLocations(map[string]string{"Warehouse A": "w1", "warehouse a": "w1", "WH-A": "w1", "": "b2"})
+	if err != nil {
+		t.Fatalf("Failed to map locations: %v", err)
+	}
+	if moved != 4 {
+		t.Errorf("Expected 4 items moved, got %d", moved)
+	}
+	for id, location := range map[string]string{"i1": "w1", "i2": "w1", "i3": "b1", "i4": "b2", "m1": "w1"} {
+		if item, _ := svc.GetInventoryItem(id); item == nil || item.Location != location {
+			t.Errorf("Expected %s at %s, got %+v", id, location, item)
+		}
+	}
+	if serial, _ := svc.GetSerial("SN-1"); serial == nil || serial.Location != "w1" {
+		t.Errorf("Expected the serial to move with its item, got %+v", serial)
+	}
+	if unmatched, _ := svc.UnmatchedLocations(); len(unmatched) != 0 {
+		t.Errorf("Expected no unmatched locations, got %+v", unmatched)
+	}
+	if locations, _ := svc.FindLocations(repository.LocationFilter{}); len(locations) != 5 {
+		t.Errorf("Expected mapping to create no locations, got %d", len(locations))
+	}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+var (
+	ErrInvalidLot = errors.New("invalid lot")
+	ErrLotExpired = errors.New("lot has expired")
+)
+
+// checkItemLot fails with ErrInvalidLot unless an item of a lot-controlled
+// product names its lot, an item of any other product has no lot or dates,
+// and a lot's manufacture date, if known, is not after its expiry date
+func (s *InventoryService) checkItemLot(item *models.InventoryItem) error {
+	product, err := s.repo.GetProduct(item.ProductID)
+	if err == repository.ErrNotFound {
+		return repository.ErrInvalidReference
+	} else if err != nil {
+		return err
+	}
+	if !product.LotControlled {
+		if item.LotNumber != "" || item.ManufacturedAt != nil || item.ExpiresAt != nil {
+			return ErrInvalidLot
+		}
+		return nil
+	}
+	if item.LotNumber == "" {
+		return ErrInvalidLot
+	}
+	if item.ManufacturedAt != nil && item.ExpiresAt != nil && item.ManufacturedAt.After(*item.ExpiresAt) {
+		return ErrInvalidLot
+	}
+	return nil
+}
+
+// checkItemChange checks an item being updated against the stored one: its
+// location as described for checkItemMove, its serials as described for
+// checkItemSerials, its unit as described for checkItemUnit, and its lot if
+// the lot or the product is changing. Items may keep a lot that predates
+// their product becoming lot controlled.
+func (s *InventoryService) checkItemChange(existing, item *models.InventoryItem) error {
+	if err := s.checkItemMove(existing, item); err != nil {
+		return err
+	}
+	if err := s.checkItemSerials(existing, item); err != nil {
+		return err
+	}
+	if err := s.checkItemUnit(existing, item); err != nil {
+		return err
+	}
+	if existing.ProductID == item.ProductID && existing.LotNumber == item.LotNumber &&
+		equalTimes(existing.ManufacturedAt, item.ManufacturedAt) && equalTimes(existing.ExpiresAt, item.ExpiresAt) {
+		return nil
+	}
+	return s.checkItemLot(item)
+}
+
+// sameLot reports whether item holds the lot with the given number and
+// expiry date, or, when lotNumber is empty, holds no lot
+func sameLot(item *models.InventoryItem, lotNumber string, expiresAt *time.Time) bool {
+	return item.LotNumber == lotNumber && equalTimes(item.ExpiresAt, expiresAt)
+}
+
+// equalTimes reports whether a and b are both nil or the same instant
+func equalTimes(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// fefo returns the items that have not expired by now in the order stock
+// should be taken from them: first expired, first out. Items without an
+// expiry date come last, and ties keep their order.
+func fefo(items []*models.InventoryItem, now time.Time) []*models.InventoryItem {
+	usable := slices.DeleteFunc(slices.Clone(items), func(item *models.InventoryItem) bool {
+		return item.Expired(now)
+	})
+	slices.SortStableFunc(usable, func(a, b *models.InventoryItem) int {
+		switch {
+		case a.ExpiresAt == nil && b.ExpiresAt == nil:
+			return 0
+		case a.ExpiresAt == nil:
+			return 1
+		case b.ExpiresAt == nil:
+			return -1
+		}
+		return a.ExpiresAt.Compare(*b.ExpiresAt)
+	})
+	return usable
+}
+
+// checkNotExpired fails with ErrLotExpired if the item holds a lot that has
+// expired, which may not be shipped
+func (s *InventoryService) checkNotExpired(itemID string) error {
+	item, err := s.repo.GetInventoryItem(itemID)
+	if err != nil {
+		return err
+	}
+	if item.Expired(time.Now()) {
+		return ErrLotExpired
+	}
+	return nil
+}
+
+// ExpiringLots returns the lots holding stock that expire before the given
+// time, including any that have already expired, soonest first. If
+// productID is not empty only that product's lots are returned.
+func (s *InventoryService) ExpiringLots(productID string, before time.Time) ([]*models.InventoryItem, error) {
+	items, err := s.repo.FindInventoryItems(repository.InventoryFilter{ProductID: productID})
+	if err != nil {
+		return nil, err
+	}
+	expiring := slices.DeleteFunc(items, func(item *models.InventoryItem) bool {
+		return item.Quantity == 0 || item.ExpiresAt == nil || !item.ExpiresAt.Before(before)
+	})
+	slices.SortStableFunc(expiring, func(a, b *models.InventoryItem) int {
+		return a.ExpiresAt.Compare(*b.ExpiresAt)
+	})
+	return expiring, nil
+}
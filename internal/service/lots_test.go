@@ -0,0 +1,283 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+// newLotService returns a service with buyer b1, lot-controlled product
+// seed stocked by lots l1 expiring in 60 days, l2 expiring in 10 days and
+// l3 expired yesterday, with 10 each, and product p1 without lots
+func newLotService(t *testing.T) *InventoryService {
+	t.Helper()
+	now := time.Now()
+	var lots []*models.InventoryItem
+	for id, days := range map[string]int{"l1": 60, "l2": 10, "l3": -1} {
+		expiresAt := now.AddDate(0, 0, days)
+		lots = append(lots, &models.InventoryItem{ID: id, ProductID: "seed", Quantity: 10, Location: "wh", LotNumber: "LOT-" + id, ExpiresAt: &expiresAt})
+	}
+	return newTestService(t,
+		withBuyers(&models.Buyer{ID: "b1", Name: "Bob"}),
+		withProducts(
+			&models.Product{ID: "seed", Name: "Tomato Seed", VendorID: "v1", LotControlled: true},
+			&models.Product{ID: "p1", Name: "Trowel", VendorID: "v1"},
+		),
+		withLocations(activeLocation("wh", models.LocationWarehouse, "")),
+		withItems(lots...),
+	)
+}
+
+func TestInventoryItemLotValidation(t *testing.T) {
+	svc := newLotService(t)
+	expiresAt := time.Now().AddDate(1, 0, 0)
+	madeAt := expiresAt.AddDate(0, 0, 1)
+
+	invalid := []*models.InventoryItem{
+		{ID: "i1", ProductID: "seed", Location: "wh"},
+		{ID: "i1", ProductID: "seed", Location: "wh", LotNumber: "LOT-9", ManufacturedAt: &madeAt, ExpiresAt: &expiresAt},
+		{ID: "i1", ProductID: "p1", Location: "wh", LotNumber: "LOT-9"},
+		{ID: "i1", ProductID: "p1", Location: "wh", ExpiresAt: &expiresAt},
+	}
+	for _, item := range invalid {
+		if err := svc.CreateInventoryItem(item); err != ErrInvalidLot {
+			t.Errorf("Expected ErrInvalidLot for %+v, got %v", item, err)
+		}
+	}
+
+	if err := svc.CreateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 5, Location: "wh"}); err != nil {
+		t.Fatalf("Failed to create inventory item: %v", err)
+	}
+	if _, err := svc.PatchInventoryItem("l1", 0, func(i *models.InventoryItem) error {
+		i.LotNumber = ""
+		return nil
+	}); err != ErrInvalidLot {
+		t.Errorf("Expected ErrInvalidLot clearing a lot number, got %v", err)
+	}
+	item, err := svc.PatchInventoryItem("l1", 0, func(i *models.InventoryItem) error {
+		i.ExpiresAt = &expiresAt
+		return nil
+	})
+	if err != nil || !item.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Expected a new expiry date, got %v and %v", item, err)
+	}
+
+	// Items from before a product became lot controlled keep working
+	if _, err := svc.PatchProduct("p1", 0, func(p *models.Product) error {
+		p.LotControlled = true
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to make product lot controlled: %v", err)
+	}
+	createLocation(t, svc, "wh-a", models.LocationWarehouse, "")
+	if err := svc.UpdateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1", Location: "wh-a"}); err != nil {
+		t.Errorf("Failed to move an item without a lot: %v", err)
+	}
+}
+
+func TestSalesOrderAllocatesFirstExpiredFirstOut(t *testing.T) {
+	svc := newLotService(t)
+	order := &models.SalesOrder{ID: "so1", BuyerID: "b1", Lines: []models.SalesOrderLine{{ProductID: "seed", Quantity: 15}}}
+	if err := svc.CreateSalesOrder(order); err != nil {
+		t.Fatalf("Failed to create sales order: %v", err)
+	}
+
+	order, err := svc.ConfirmSalesOrder("so1", 0)
+	if err != nil {
+		t.Fatalf("Failed to confirm sales order: %v", err)
+	}
+	allocations := order.Lines[0].Allocations
+	if len(allocations) != 2 || allocations[0].ItemID != "l2" || allocations[0].Quantity != 10 ||
+		allocations[1].ItemID != "l1" || allocations[1].Quantity != 5 {
+		t.Errorf("Expected 10 from l2 and 5 from l1, got %+v", allocations)
+	}
+	checkItem(t, svc, "l3", 10, 0)
+
+	// A lot that expires before the order ships cannot be shipped
+	if _, err := svc.PatchInventoryItem("l1", 0, func(i *models.InventoryItem) error {
+		expired := time.Now().Add(-time.Minute)
+		i.ExpiresAt = &expired
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to update lot: %v", err)
+	}
+	for _, transition := range []func(string, int64) (*models.SalesOrder, error){svc.AllocateSalesOrder, svc.PickSalesOrder} {
+		if _, err := transition("so1", 0); err != nil {
+			t.Fatalf("Failed to advance sales order: %v", err)
+		}
+	}
+	if _, err := svc.ShipSalesOrder("so1", 0); err != ErrLotExpired {
+		t.Errorf("Expected ErrLotExpired shipping an expired lot, got %v", err)
+	}
+	checkItem(t, svc, "l2", 10, 10)
+}
+
+func TestPostMovementBlocksExpiredLot(t *testing.T) {
+	svc := newLotService(t)
+
+	shipment := &models.StockMovement{ItemID: "l3", Type: models.MovementShipment, Delta: -1, Reason: models.ReasonSale, Actor: "alice"}
+	if err := svc.PostMovement(shipment, 0); err != ErrLotExpired {
+		t.Errorf("Expected ErrLotExpired shipping an expired lot, got %v", err)
+	}
+	writeOff := &models.StockMovement{ItemID: "l3", Type: models.MovementWriteOff, Delta: -10, Reason: "expired", Actor: "alice"}
+	if err := svc.PostMovement(writeOff, 0); err != nil {
+		t.Errorf("Failed to write off an expired lot: %v", err)
+	}
+	shipment.ItemID = "l2"
+	if err := svc.PostMovement(shipment, 0); err != nil {
+		t.Errorf("Failed to ship a lot that has not expired: %v", err)
+	}
+}
+
+func TestExpiringLots(t *testing.T) {
+	svc := newLotService(t)
+
+	lots, err := svc.ExpiringLots("", time.Now().AddDate(0, 0, 30))
+	if err != nil {
+		t.Fatalf("Failed to list expiring lots: %v", err)
+	}
+	if len(lots) != 2 || lots[0].ID != "l3" || lots[1].ID != "l2" {
+		t.Errorf("Expected l3 then l2, got %v", lots)
+	}
+
+	// Lots with no stock left are not expiring
+	writeOff := &models.StockMovement{ItemID: "l3", Type: models.MovementWriteOff, Delta: -10, Reason: "expired", Actor: "alice"}
+	if err := svc.PostMovement(writeOff, 0); err != nil {
+		t.Fatalf("Failed to write off lot: %v", err)
+	}
+	lots, err = svc.ExpiringLots("seed", time.Now().AddDate(0, 0, 90))
+	if err != nil || len(lots) != 2 || lots[0].ID != "l2" || lots[1].ID != "l1" {
+		t.Errorf("Expected l2 then l1, got %v and %v", lots, err)
+	}
+	lots, err = svc.ExpiringLots("p1", time.Now().AddDate(0, 0, 90))
+	if err != nil || len(lots) != 0 {
+		t.Errorf("Expected no expiring lots of p1, got %v and %v", lots, err)
+	}
+}
+
+func TestTransferOrderKeepsLot(t *testing.T) {
+	svc := newLotService(t)
+	createLocation(t, svc, "yard", models.LocationWarehouse, "")
+	createLocation(t, svc, "shop", models.LocationWarehouse, "")
+	soon, later := time.Now().AddDate(0, 0, 30), time.Now().AddDate(0, 0, 90)
+	for _, item := range []*models.InventoryItem{
+		{ID: "y1", ProductID: "seed", Quantity: 10, Location: "yard", LotNumber: "LOT-A", ExpiresAt: &soon},
+		{ID: "s1", ProductID: "seed", Quantity: 5, Location: "shop", LotNumber: "LOT-B", ExpiresAt: &later},
+	} {
+		if err := svc.CreateInventoryItem(item); err != nil {
+			t.Fatalf("Failed to create lot %s: %v", item.ID, err)
+		}
+	}
+
+	// transfer dispatches and receives 3 of seed from yard to shop as order id
+	transfer := func(id, toItemID string) (*models.TransferOrder, error) {
+		t.Helper()
+		order := &models.TransferOrder{ID: id, FromLocation: "yard", ToLocation: "shop",
+			Lines: []models.TransferOrderLine{{ProductID: "seed", Quantity: 3, ToItemID: toItemID}}}
+		if err := svc.CreateTransferOrder(order); err != nil {
+			t.Fatalf("Failed to create transfer order: %v", err)
+		}
+		if _, err := svc.DispatchTransferOrder(id, 0); err != nil {
+			t.Fatalf("Failed to dispatch transfer order: %v", err)
+		}
+		return svc.ReceiveTransferOrder(id, 0, TransferReceipt{Received: []float64{3}})
+	}
+
+	order, err := transfer("to1", "")
+	if err != nil {
+		t.Fatalf("Failed to receive transfer order: %v", err)
+	}
+	line := order.Lines[0]
+	if line.LotNumber != "LOT-A" || line.ExpiresAt == nil || !line.ExpiresAt.Equal(soon) || line.ToItemID != "to1-0" {
+		t.Errorf("Expected LOT-A received into to1-0, got %+v", line)
+	}
+	item, err := svc.GetInventoryItem("to1-0")
+	if err != nil {
+		t.Fatalf("Failed to get received item: %v", err)
+	}
+	if item.LotNumber != "LOT-A" || item.ExpiresAt == nil || !item.ExpiresAt.Equal(soon) || item.Quantity != 3 {
+		t.Errorf("Expected 3 of LOT-A at the shop, got %+v", item)
+	}
+	checkItem(t, svc, "s1", 5, 0)
+
+	if _, err := transfer("to2", "s1"); err != ErrInvalidTransferOrder {
+		t.Errorf("Expected ErrInvalidTransferOrder receiving into another lot, got %v", err)
+	}
+	if _, err := transfer("to3", ""); err != nil {
+		t.Fatalf("Failed to receive transfer order: %v", err)
+	}
+	checkItem(t, svc, "to1-0", 6, 0)
+	checkItem(t, svc, "s1", 5, 0)
+}
+
+func TestReceivePurchaseOrderLots(t *testing.T) {
+	svc := newLotService(t)
+	createLocation(t, svc, "wh-a", models.LocationWarehouse, "")
+	expectedAt := time.Now().AddDate(0, 0, 7)
+	order := &models.PurchaseOrder{ID: "po1", VendorID: "v1", Lines: []models.PurchaseOrderLine{
+		{ProductID: "seed", Quantity: 30, ExpectedAt: expectedAt},
+		{ProductID: "p1", Quantity: 5, ExpectedAt: expectedAt},
+	}}
+	if err := svc.CreatePurchaseOrder(order); err != nil {
+		t.Fatalf("Failed to create purchase order: %v", err)
+	}
+	if _, err := svc.IssuePurchaseOrder("po1", 0); err != nil {
+		t.Fatalf("Failed to issue purchase order: %v", err)
+	}
+
+	expired, expiresAt := time.Now().AddDate(0, 0, -1), time.Now().AddDate(1, 0, 0)
+	for _, receipt := range []Receipt{
+		{Location: "wh-a", Quantity: 5},
+		{Location: "wh-a", Quantity: 5, ExpiresAt: &expiresAt},
+		{Line: 1, Location: "wh-a", Quantity: 5, LotNumber: "LOT-X"},
+		{ItemID: "l1", Quantity: 5, LotNumber: "LOT-X"},
+	} {
+		if _, _, err := svc.ReceivePurchaseOrder("po1", 0, receipt); err != ErrInvalidLot {
+			t.Errorf("Expected ErrInvalidLot for %+v, got %v", receipt, err)
+		}
+	}
+	for _, receipt := range []Receipt{
+		{Location: "wh-a", Quantity: 5, LotNumber: "LOT-X", ExpiresAt: &expired},
+		{ItemID: "l3", Quantity: 5},
+	} {
+		if _, _, err := svc.ReceivePurchaseOrder("po1", 0, receipt); err != ErrLotExpired {
+			t.Errorf("Expected ErrLotExpired for %+v, got %v", receipt, err)
+		}
+	}
+	if items, _ := svc.FindInventoryItems(repository.InventoryFilter{ProductID: "seed", Location: "wh-a"}); len(items) != 0 {
+		t.Errorf("Expected no item for a lot that was not received, got %+v", items)
+	}
+
+	receipt := Receipt{Location: "wh-a", Quantity: 5, LotNumber: "LOT-X", ExpiresAt: &expiresAt}
+	var itemID string
+	for range 2 {
+		_, movement, err := svc.ReceivePurchaseOrder("po1", 0, receipt)
+		if err != nil || itemID != "" && movement.ItemID != itemID {
+			t.Fatalf("Expected both receipts into one item, got %v and %v", movement, err)
+		}
+		itemID = movement.ItemID
+	}
+	item, err := svc.GetInventoryItem(itemID)
+	if err != nil {
+		t.Fatalf("Failed to get received lot: %v", err)
+	}
+	if item.Quantity != 10 || item.Location != "wh-a" || item.LotNumber != "LOT-X" || !item.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected 10 of LOT-X at wh-a, got %+v", item)
+	}
+
+	// The same lot on the same line may be received at another location
+	createLocation(t, svc, "wh-b", models.LocationWarehouse, "")
+	receipt.Location = "wh-b"
+	_, movement, err := svc.ReceivePurchaseOrder("po1", 0, receipt)
+	if err != nil {
+		t.Fatalf("Failed to receive the lot at a second location: %v", err)
+	}
+	if movement.ItemID == itemID {
+		t.Errorf("Expected a new item at wh-b, got %s", movement.ItemID)
+	}
+	if _, _, err := svc.ReceivePurchaseOrder("po1", 0, Receipt{ItemID: "l1", Quantity: 5}); err != nil {
+		t.Errorf("Failed to receive into a lot by item: %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+var (
+	ErrInvalidPriceList    = errors.New("invalid price list")
+	ErrInvalidPriceRequest = errors.New("invalid price request")
+)
+
+// Where the price of a PriceResolution comes from
+const (
+	PriceSourcePriceList = "price_list"
+	PriceSourceProduct   = "product"
+)
+
+// PriceRequest asks for the price a buyer pays for Quantity of a product,
+// in Unit, at At. Without a buyer the product's own price applies; a zero
+// quantity means one base unit and a zero time means now.
+type PriceRequest struct {
+	BuyerID  string
+	Quantity float64
+	Unit     string
+	At       time.Time
+}
+
+// PriceResolution is the effective price of a product for a PriceRequest:
+// the UnitPrice of one base unit, the Total for the quantity and where the
+// price came from. When it comes from a price list, Rule is the list price
+// that won. Candidates are the list's prices for the product, each with
+// whether it applies and why, and Explanation sums the decision up.
+type PriceResolution struct {
+	ProductID   string            `json:"product_id"`
+	BuyerID     string            `json:"buyer_id,omitempty"`
+	Quantity    float64           `json:"quantity"`
+	Unit        string            `json:"unit"`
+	At          time.Time         `json:"at"`
+	UnitPrice   models.Money      `json:"unit_price"`
+	Total       models.Money      `json:"total"`
+	Source      string            `json:"source"`
+	PriceListID string            `json:"price_list_id,omitempty"`
+	Rule        *models.ListPrice `json:"rule,omitempty"`
+	Explanation string            `json:"explanation"`
+	Candidates  []PriceCandidate  `json:"candidates,omitempty"`
+}
+
+// PriceCandidate is a list price considered by a price resolution, with
+// whether it applies to the request and why or why not
+type PriceCandidate struct {
+	models.ListPrice
+	Applies bool   `json:"applies"`
+	Reason  string `json:"reason"`
+}
+
+// validatePriceList fails with ErrInvalidPriceList unless list has a name
+// and every price names a product, has a minimum quantity that is not
+// negative, a price that is not negative in one of models.Currencies and a
+// validity window that ends after it starts. Two prices of one product for
+// the same minimum quantity cannot be valid at the same time, so at most
+// one of them ever applies. Minimum quantities are converted to their
+// products' base units, and a missing product fails with
+// repository.ErrInvalidReference.
+func (s *InventoryService) validatePriceList(list *models.PriceList) error {
+	if list.Name == "" {
+		return ErrInvalidPriceList
+	}
+	for i := range list.Prices {
+		price := &list.Prices[i]
+		if price.ProductID == "" || price.MinQuantity < 0 || !price.Price.Valid() || price.Price.Sign() < 0 {
+			return ErrInvalidPriceList
+		}
+		if price.ValidFrom != nil && price.ValidTo != nil && !price.ValidTo.After(*price.ValidFrom) {
+			return ErrInvalidPriceList
+		}
+		if err := s.toLineUnit(price.ProductID, &price.MinQuantity, &price.Unit); err != nil {
+			return err
+		}
+		for _, other := range list.Prices[:i] {
+			if other.ProductID == price.ProductID && other.MinQuantity == price.MinQuantity && overlaps(&other, price) {
+				return ErrInvalidPriceList
+			}
+		}
+	}
+	return nil
+}
+
+// overlaps reports whether the validity windows of two prices overlap
+func overlaps(a, b *models.ListPrice) bool {
+	return (a.ValidTo == nil || b.ValidFrom == nil || b.ValidFrom.Before(*a.ValidTo)) &&
+		(b.ValidTo == nil || a.ValidFrom == nil || a.ValidFrom.Before(*b.ValidTo))
+}
+
+// CreatePriceList creates a price list. It fails as described for
+// validatePriceList.
+func (s *InventoryService) CreatePriceList(list *models.PriceList) error {
+	if err := s.validatePriceList(list); err != nil {
+		return err
+	}
+	return s.repo.CreatePriceList(list)
+}
+
+func (s *InventoryService) GetPriceList(id string) (*models.PriceList, error) {
+	return s.repo.GetPriceList(id)
+}
+
+func (s *InventoryService) ListPriceLists() ([]*models.PriceList, error) {
+	return s.repo.ListPriceLists()
+}
+
+// UpdatePriceList replaces the name and prices of a price list. It fails
+// as described for validatePriceList.
+func (s *InventoryService) UpdatePriceList(list *models.PriceList) error {
+	if err := s.validatePriceList(list); err != nil {
+		return err
+	}
+	return s.repo.UpdatePriceList(list)
+}
+
+// DeletePriceList deletes a price list that no buyer is assigned
+func (s *InventoryService) DeletePriceList(id string, version int64) error {
+	return s.repo.DeletePriceList(id, version)
+}
+
+// ResolvePrice returns the price the buyer of request pays for a product.
+// If the buyer is assigned a price list, the list's prices for the product
+// that apply to the quantity at the time are candidates, and the one with
+// the largest minimum quantity wins; if none applies, or there is no
+// buyer or price list, the product's own price does. It fails with
+// ErrNotFound if the product does not exist, with
+// repository.ErrInvalidReference if the buyer does not, with
+// ErrInvalidPriceRequest if the quantity is negative and with a unit error
+// if it cannot be converted to the product's base unit.
+func (s *InventoryService) ResolvePrice(productID string, request PriceRequest) (*PriceResolution, error) {
+	if request.Quantity < 0 {
+		return nil, ErrInvalidPriceRequest
+	}
+	product, err := s.repo.GetProduct(productID)
+	if err != nil {
+		return nil, err
+	}
+	quantity := 1.0
+	if request.Quantity > 0 {
+		quantity, err = toBase(product, request.Quantity, request.Unit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	at := request.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	resolution := &PriceResolution{
+		ProductID: product.ID,
+		BuyerID:   request.BuyerID,
+		Quantity:  quantity,
+		Unit:      baseUnit(product),
+		At:        at,
+		UnitPrice: product.Price,
+		Source:    PriceSourceProduct,
+	}
+
+	var list *models.PriceList
+	if request.BuyerID != "" {
+		buyer, err := s.repo.GetBuyer(request.BuyerID)
+		if err == repository.ErrNotFound {
+			return nil, repository.ErrInvalidReference
+		} else if err != nil {
+			return nil, err
+		}
+		if buyer.PriceListID != "" {
+			list, err = s.repo.GetPriceList(buyer.PriceListID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if list == nil {
+		resolution.Explanation = "The product's own price applies: the buyer has no price list"
+		if request.BuyerID == "" {
+			resolution.Explanation = "The product's own price applies: no buyer was given"
+		}
+	} else {
+		resolution.PriceListID = list.ID
+		resolveListPrice(resolution, list, product.ID)
+	}
+
+	resolution.Total, err = resolution.UnitPrice.Mul(quantity)
+	if err != nil {
+		return nil, err
+	}
+	return resolution, nil
+}
+
+// resolveListPrice considers the prices of list for a product, making the
+// winner, if any, the price of resolution
+func resolveListPrice(resolution *PriceResolution, list *models.PriceList, productID string) {
+	winner := -1
+	for _, price := range list.Prices {
+		if price.ProductID != productID {
+			continue
+		}
+		candidate := PriceCandidate{ListPrice: price}
+		switch {
+		case price.ValidFrom != nil && resolution.At.Before(*price.ValidFrom):
+			candidate.Reason = "not valid until " + price.ValidFrom.Format(time.RFC3339)
+		case !price.Current(resolution.At):
+			candidate.Reason = "expired at " + price.ValidTo.Format(time.RFC3339)
+		case !price.Applies(resolution.Quantity, resolution.At):
+			candidate.Reason = fmt.Sprintf("needs at least %v %s", price.MinQuantity, price.Unit)
+		default:
+			candidate.Applies = true
+			if winner < 0 || price.MinQuantity > resolution.Candidates[winner].MinQuantity {
+				winner = len(resolution.Candidates)
+			}
+		}
+		resolution.Candidates = append(resolution.Candidates, candidate)
+	}
+	if winner < 0 {
+		resolution.Explanation = fmt.Sprintf("The product's own price applies: price list %s has no price for it", list.ID)
+		if len(resolution.Candidates) > 0 {
+			resolution.Explanation = fmt.Sprintf(
+				"The product's own price applies: none of price list %s's prices for it applies", list.ID)
+		}
+		return
+	}
+
+	rule := resolution.Candidates[winner].ListPrice
+	for i := range resolution.Candidates {
+		if i == winner {
+			resolution.Candidates[i].Reason = "the largest quantity break that applies"
+		} else if resolution.Candidates[i].Applies {
+			resolution.Candidates[i].Reason = fmt.Sprintf("superseded by the price for %v %s or more",
+				rule.MinQuantity, rule.Unit)
+		}
+	}
+	resolution.UnitPrice = rule.Price
+	resolution.Source = PriceSourcePriceList
+	resolution.Rule = &rule
+	resolution.Explanation = fmt.Sprintf("Price list %s charges %s for %v %s or more",
+		list.ID, rule.Price, rule.MinQuantity, rule.Unit)
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+// newPriceListService returns a service with mulch, sold by the cubic foot
+// at 4.00 USD and in 2 cubic foot bags, and price list contractors, which
+// charges 3.50 for any quantity, 3.00 for 20 cubic feet or more and, only
+// during spring, 2.50 for 100 or more. Buyer b1 is assigned the list and
+// b2 is not.
+func newPriceListService(t *testing.T) (*InventoryService, time.Time) {
+	t.Helper()
+	spring := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	summer := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	svc := newTestService(t,
+		withProducts(&models.Product{ID: "mulch", Name: "Cedar Mulch", VendorID: "v1", Price: usd(400),
+			BaseUnit: "cubic_foot", Packs: []models.Pack{{Unit: "bag", Quantity: 2}}}),
+		withPriceLists(&models.PriceList{ID: "contractors", Name: "Landscape Contractors", Prices: []models.ListPrice{
+			{ProductID: "mulch", Price: usd(350)},
+			{ProductID: "mulch", MinQuantity: 10, Unit: "bag", Price: usd(300)},
+			{ProductID: "mulch", MinQuantity: 100, Price: usd(250), ValidFrom: &spring, ValidTo: &summer},
+		}}),
+		withBuyers(
+			&models.Buyer{ID: "b1", Name: "Bob", PriceListID: "contractors"},
+			&models.Buyer{ID: "b2", Name: "Bea"},
+		),
+	)
+	return svc, spring
+}
+
+func TestCreatePriceListConvertsQuantities(t *testing.T) {
+	svc, _ := newPriceListService(t)
+
+	list, err := svc.GetPriceList("contractors")
+	if err != nil {
+		t.Fatalf("Failed to get price list: %v", err)
+	}
+	if list.Prices[1].MinQuantity != 20 || list.Prices[1].Unit != "cubic_foot" {
+		t.Errorf("Expected 10 bags to become 20 cubic feet, got %v %s", list.Prices[1].MinQuantity, list.Prices[1].Unit)
+	}
+}
+
+func TestCreatePriceListValidates(t *testing.T) {
+	svc, spring := newPriceListService(t)
+	later := spring.Add(time.Hour)
+
+	tests := []struct {
+		name  string
+		price models.ListPrice
+		err   error
+	}{
+		{"missing product", models.ListPrice{ProductID: "missing", Price: usd(100)}, repository.ErrInvalidReference},
+		{"no product", models.ListPrice{Price: usd(100)}, ErrInvalidPriceList},
+		{"negative minimum", models.ListPrice{ProductID: "mulch", MinQuantity: -1, Price: usd(100)}, ErrInvalidPriceList},
+		{"negative price", models.ListPrice{ProductID: "mulch", Price: usd(-100)}, ErrInvalidPriceList},
+		{"no currency", models.ListPrice{ProductID: "mulch", Price: models.Money{Minor: 100}}, ErrInvalidPriceList},
+		{"empty window", models.ListPrice{ProductID: "mulch", Price: usd(100), ValidFrom: &later, ValidTo: &spring},
+			ErrInvalidPriceList},
+		{"unknown unit", models.ListPrice{ProductID: "mulch", MinQuantity: 1, Unit: "pallet", Price: usd(100)}, ErrInvalidUnit},
+	}
+	for _, tt := range tests {
+		list := &models.PriceList{ID: "bad", Name: "Bad", Prices: []models.ListPrice{tt.price}}
+		if err := svc.CreatePriceList(list); err != tt.err {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	if err := svc.CreatePriceList(&models.PriceList{ID: "bad"}); err != ErrInvalidPriceList {
+		t.Errorf("Expected ErrInvalidPriceList for a list without a name, got %v", err)
+	}
+	overlapping := &models.PriceList{ID: "bad", Name: "Bad", Prices: []models.ListPrice{
+		{ProductID: "mulch", MinQuantity: 2, Price: usd(300), ValidFrom: &spring},
+		{ProductID: "mulch", MinQuantity: 1, Unit: "bag", Price: usd(200), ValidTo: &later},
+	}}
+	if err := svc.CreatePriceList(overlapping); err != ErrInvalidPriceList {
+		t.Errorf("Expected ErrInvalidPriceList for prices valid at once for the same quantity, got %v", err)
+	}
+	overlapping.Prices[1].ValidTo = &spring
+	if err := svc.CreatePriceList(overlapping); err != nil {
+		t.Errorf("Expected prices for the same quantity one after the other to be accepted, got %v", err)
+	}
+}
+
+func TestResolvePrice(t *testing.T) {
+	svc, spring := newPriceListService(t)
+	april := spring.AddDate(0, 1, 0)
+	july := spring.AddDate(0, 4, 0)
+
+	tests := []struct {
+		name     string
+		request  PriceRequest
+		source   string
+		price    models.Money
+		total    models.Money
+		minimum  float64
+		applying int
+	}{
+		{"no buyer", PriceRequest{Quantity: 3, At: april}, PriceSourceProduct, usd(400), usd(1200), 0, 0},
+		{"buyer without a list", PriceRequest{BuyerID: "b2", Quantity: 3, At: april}, PriceSourceProduct, usd(400), usd(1200), 0, 0},
+		{"one unit", PriceRequest{BuyerID: "b1", At: april}, PriceSourcePriceList, usd(350), usd(350), 0, 1},
+		{"quantity break", PriceRequest{BuyerID: "b1", Quantity: 10, Unit: "bag", At: april},
+			PriceSourcePriceList, usd(300), usd(6000), 20, 2},
+		{"seasonal break", PriceRequest{BuyerID: "b1", Quantity: 120, At: april},
+			PriceSourcePriceList, usd(250), usd(30000), 100, 3},
+		{"season over", PriceRequest{BuyerID: "b1", Quantity: 120, At: july},
+			PriceSourcePriceList, usd(300), usd(36000), 20, 2},
+	}
+	for _, tt := range tests {
+		resolution, err := svc.ResolvePrice("mulch", tt.request)
+		if err != nil {
+			t.Fatalf("%s: failed to resolve price: %v", tt.name, err)
+		}
+		if resolution.Source != tt.source || resolution.UnitPrice != tt.price || resolution.Total != tt.total {
+			t.Errorf("%s: expected %v each, %v in all, from %s, got %v, %v from %s",
+				tt.name, tt.price, tt.total, tt.source, resolution.UnitPrice, resolution.Total, resolution.Source)
+		}
+		if tt.source == PriceSourcePriceList && (resolution.Rule == nil || resolution.Rule.MinQuantity != tt.minimum) {
+			t.Errorf("%s: expected the price for %v or more to win, got %+v", tt.name, tt.minimum, resolution.Rule)
+		}
+		applying := 0
+		for _, candidate := range resolution.Candidates {
+			if candidate.Applies {
+				applying++
+			}
+			if candidate.Reason == "" {
+				t.Errorf("%s: expected every candidate to have a reason, got %+v", tt.name, candidate)
+			}
+		}
+		if applying != tt.applying || resolution.Explanation == "" {
+			t.Errorf("%s: expected %d applying candidates and an explanation, got %+v", tt.name, tt.applying, resolution)
+		}
+	}
+}
+
+func TestResolvePriceFallsBackToProduct(t *testing.T) {
+	svc, spring := newPriceListService(t)
+
+	if err := svc.CreateProduct(&models.Product{ID: "edging", Name: "Steel Edging", VendorID: "v1", Price: usd(1299)}); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	resolution, err := svc.ResolvePrice("edging", PriceRequest{BuyerID: "b1", Quantity: 2, At: spring})
+	if err != nil {
+		t.Fatalf("Failed to resolve price: %v", err)
+	}
+	if resolution.Source != PriceSourceProduct || resolution.Total != usd(2598) || resolution.PriceListID != "contractors" {
+		t.Errorf("Expected the product's own price for a product not on the list, got %+v", resolution)
+	}
+
+	if _, err := svc.ResolvePrice("missing", PriceRequest{}); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing product, got %v", err)
+	}
+	if _, err := svc.ResolvePrice("mulch", PriceRequest{BuyerID: "missing"}); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a missing buyer, got %v", err)
+	}
+	if _, err := svc.ResolvePrice("mulch", PriceRequest{Quantity: -1}); err != ErrInvalidPriceRequest {
+		t.Errorf("Expected ErrInvalidPriceRequest for a negative quantity, got %v", err)
+	}
+}
+
+func TestSalesOrderLinesArePriced(t *testing.T) {
+	svc, _ := newPriceListService(t)
+
+	order := &models.SalesOrder{ID: "so1", BuyerID: "b1", Lines: []models.SalesOrderLine{
+		{ProductID: "mulch", Quantity: 5, Unit: "bag"},
+		{ProductID: "mulch", Quantity: 12, Unit: "bag"},
+		{ProductID: "mulch", Quantity: 3, Unit: "bag", UnitPrice: usd(700)},
+	}}
+	if err := svc.CreateSalesOrder(order); err != nil {
+		t.Fatalf("Failed to create sales order: %v", err)
+	}
+	// Resolved prices are per cubic foot, and given ones keep their unit
+	lines := []struct {
+		price     models.Money
+		priceUnit string
+		total     models.Money
+	}{
+		{usd(350), "cubic_foot", usd(3500)},
+		{usd(300), "cubic_foot", usd(7200)},
+		{usd(700), "bag", usd(2100)},
+	}
+	for i, want := range lines {
+		line := order.Lines[i]
+		if line.UnitPrice != want.price || line.PriceUnit != want.priceUnit || line.Total != want.total {
+			t.Errorf("Expected line %d at %v a %s, %v in all, got %v a %s, %v", i, want.price, want.priceUnit, want.total,
+				line.UnitPrice, line.PriceUnit, line.Total)
+		}
+	}
+
+	// A buyer without a price list pays the product's price
+	order = &models.SalesOrder{ID: "so2", BuyerID: "b2", Lines: []models.SalesOrderLine{{ProductID: "mulch", Quantity: 1}}}
+	if err := svc.CreateSalesOrder(order); err != nil {
+		t.Fatalf("Failed to create sales order: %v", err)
+	}
+	if order.Lines[0].UnitPrice != usd(400) {
+		t.Errorf("Expected the product's price of 4.00, got %v", order.Lines[0].UnitPrice)
+	}
+
+	for _, price := range []models.Money{usd(-1), {Minor: 100, Currency: "EUR"}} {
+		order := &models.SalesOrder{ID: "so3", BuyerID: "b1", Lines: []models.SalesOrderLine{
+			{ProductID: "mulch", Quantity: 1},
+			{ProductID: "mulch", Quantity: 1, UnitPrice: price},
+		}}
+		if err := svc.CreateSalesOrder(order); err != ErrInvalidPrice {
+			t.Errorf("Expected ErrInvalidPrice for a line at %v, got %v", price, err)
+		}
+	}
+}
+
+func TestDeletePriceListInUse(t *testing.T) {
+	svc, _ := newPriceListService(t)
+
+	if err := svc.DeletePriceList("contractors", 0); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting a price list assigned to a buyer, got %v", err)
+	}
+	if err := svc.UpdateBuyer(&models.Buyer{ID: "b1", Name: "Bob", PriceListID: "missing"}); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference assigning a missing price list, got %v", err)
+	}
+}
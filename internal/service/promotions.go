@@ -0,0 +1,468 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+var (
+	ErrInvalidPromotion = errors.New("invalid promotion")
+	ErrInvalidCart      = errors.New("invalid cart")
+)
+
+// Cart is what a buyer means to buy at At, for a quote. Without a buyer
+// products' own prices apply and promotions limited per buyer do not; a
+// zero time means now.
+type Cart struct {
+	BuyerID string     `json:"buyer_id"`
+	At      time.Time  `json:"at"`
+	Lines   []CartLine `json:"lines"`
+}
+
+// CartLine is Quantity of a product, in Unit
+type CartLine struct {
+	ProductID string  `json:"product_id"`
+	Quantity  float64 `json:"quantity"`
+	Unit      string  `json:"unit"`
+}
+
+// Quote prices a cart: each line at the price the buyer pays, the
+// Subtotal of the lines, the Discount the promotions that apply take off
+// and the Total left to pay. Applied lists those promotions, in the order
+// they applied, and Skipped every other current one with the reason.
+type Quote struct {
+	BuyerID  string             `json:"buyer_id,omitempty"`
+	At       time.Time          `json:"at"`
+	Lines    []QuoteLine        `json:"lines"`
+	Subtotal models.Money       `json:"subtotal"`
+	Discount models.Money       `json:"discount"`
+	Total    models.Money       `json:"total"`
+	Applied  []AppliedPromotion `json:"applied"`
+	Skipped  []SkippedPromotion `json:"skipped"`
+}
+
+// QuoteLine is a cart line priced: Quantity, in the product's base unit,
+// at UnitPrice makes Amount, of which promotions take off Discount
+type QuoteLine struct {
+	ProductID   string       `json:"product_id"`
+	Quantity    float64      `json:"quantity"`
+	Unit        string       `json:"unit"`
+	UnitPrice   models.Money `json:"unit_price"`
+	PriceSource string       `json:"price_source"`
+	Amount      models.Money `json:"amount"`
+	Discount    models.Money `json:"discount"`
+	Total       models.Money `json:"total"`
+}
+
+// AppliedPromotion is a promotion that applied to a quote and what it
+// took off
+type AppliedPromotion struct {
+	PromotionID string       `json:"promotion_id"`
+	Name        string       `json:"name"`
+	Discount    models.Money `json:"discount"`
+}
+
+// SkippedPromotion is a promotion that did not apply to a quote and why
+type SkippedPromotion struct {
+	PromotionID string `json:"promotion_id"`
+	Name        string `json:"name"`
+	Reason      string `json:"reason"`
+}
+
+// validatePromotion fails with ErrInvalidPromotion unless promotion has a
+// name and a type, the settings of its type and no others: a Percent over
+// 0 and at most 100 percent, a positive Amount in one of models.Currencies, or a
+// positive BuyQuantity and FreeQuantity. Its MinSubtotal, if any, must not
+// be negative, its validity window must end after it starts and its
+// LimitPerBuyer must not be negative. A missing product fails with
+// repository.ErrInvalidReference, and one whose units do not include the
+// promotion's Unit with a unit error.
+func (s *InventoryService) validatePromotion(promotion *models.Promotion) error {
+	p := promotion
+	if p.Name == "" || p.LimitPerBuyer < 0 {
+		return ErrInvalidPromotion
+	}
+	var ok bool
+	switch p.Type {
+	case models.PromotionPercentOff:
+		ok = p.Percent > 0 && p.Percent <= 100*models.OnePercent && p.Amount.IsZero() && p.BuyQuantity == 0 && p.FreeQuantity == 0
+	case models.PromotionAmountOff:
+		ok = p.Amount.Valid() && p.Amount.Sign() > 0 && p.Percent == 0 && p.BuyQuantity == 0 && p.FreeQuantity == 0
+	case models.PromotionBuyGetFree:
+		ok = p.BuyQuantity > 0 && p.FreeQuantity > 0 && p.Percent == 0 && p.Amount.IsZero()
+	}
+	if !ok {
+		return ErrInvalidPromotion
+	}
+	if p.MinSubtotal.Sign() < 0 || (p.MinSubtotal != (models.Money{}) && !p.MinSubtotal.Valid()) {
+		return ErrInvalidPromotion
+	}
+	if p.ValidFrom != nil && p.ValidTo != nil && !p.ValidTo.After(*p.ValidFrom) {
+		return ErrInvalidPromotion
+	}
+	if p.Type != models.PromotionBuyGetFree {
+		return nil
+	}
+	for _, id := range p.ProductIDs {
+		for _, quantity := range []float64{p.BuyQuantity, p.FreeQuantity} {
+			if _, _, err := s.inBaseUnit(id, quantity, p.Unit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CreatePromotion creates a promotion, which no buyer has used yet. It
+// fails as described for validatePromotion.
+func (s *InventoryService) CreatePromotion(promotion *models.Promotion) error {
+	if err := s.validatePromotion(promotion); err != nil {
+		return err
+	}
+	promotion.Uses = nil
+	return s.repo.CreatePromotion(promotion)
+}
+
+func (s *InventoryService) GetPromotion(id string) (*models.Promotion, error) {
+	return s.repo.GetPromotion(id)
+}
+
+func (s *InventoryService) ListPromotions() ([]*models.Promotion, error) {
+	return s.repo.ListPromotions()
+}
+
+// UpdatePromotion replaces the rules of a promotion, keeping the uses
+// recorded against it. It fails as described for validatePromotion.
+func (s *InventoryService) UpdatePromotion(promotion *models.Promotion) error {
+	if err := s.validatePromotion(promotion); err != nil {
+		return err
+	}
+	existing, err := s.repo.GetPromotion(promotion.ID)
+	if err != nil {
+		return err
+	}
+	promotion.Uses = existing.Uses
+	if err := repository.CheckVersion(existing.Version, promotion.Version); err != nil {
+		return err
+	}
+	promotion.Version = existing.Version
+	return s.repo.UpdatePromotion(promotion)
+}
+
+func (s *InventoryService) DeletePromotion(id string, version int64) error {
+	return s.repo.DeletePromotion(id, version)
+}
+
+// QuoteCart prices a cart and applies the promotions to it. Each line is
+// priced as described for ResolvePrice. The promotions that are current
+// at the cart's time, that the buyer has not used up, that cover a line
+// and whose minimum subtotal the lines they cover reach are then applied
+// as described for models.Promotion, each to what is left of the lines it
+// covers: PromotionPercentOff takes its percent off each line,
+// PromotionAmountOff takes its amount off the lines in order and
+// PromotionBuyGetFree takes the price of the free units off each line.
+// No promotion takes a line below nothing. It fails with ErrInvalidCart
+// unless the cart has lines with positive quantities, with
+// repository.ErrInvalidReference if a product or the buyer does not exist,
+// with models.ErrCurrencyMismatch if the lines are priced in different
+// currencies and with a unit error if a quantity cannot be converted.
+func (s *InventoryService) QuoteCart(cart Cart) (*Quote, error) {
+	if len(cart.Lines) == 0 {
+		return nil, ErrInvalidCart
+	}
+	if cart.At.IsZero() {
+		cart.At = time.Now()
+	}
+	quote := &Quote{BuyerID: cart.BuyerID, At: cart.At}
+	products := make([]*models.Product, len(cart.Lines))
+	for i, line := range cart.Lines {
+		if line.Quantity <= 0 {
+			return nil, ErrInvalidCart
+		}
+		price, err := s.ResolvePrice(line.ProductID, PriceRequest{
+			BuyerID:  cart.BuyerID,
+			Quantity: line.Quantity,
+			Unit:     line.Unit,
+			At:       cart.At,
+		})
+		if err == repository.ErrNotFound {
+			return nil, repository.ErrInvalidReference
+		} else if err != nil {
+			return nil, err
+		}
+		if products[i], err = s.repo.GetProduct(line.ProductID); err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			quote.Subtotal = models.Money{Currency: price.Total.Currency}
+		}
+		if quote.Subtotal, err = quote.Subtotal.Add(price.Total); err != nil {
+			return nil, err
+		}
+		quote.Lines = append(quote.Lines, QuoteLine{
+			ProductID:   line.ProductID,
+			Quantity:    price.Quantity,
+			Unit:        price.Unit,
+			UnitPrice:   price.UnitPrice,
+			PriceSource: price.Source,
+			Amount:      price.Total,
+		})
+	}
+
+	promotions, err := s.repo.ListPromotions()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(promotions, func(i, j int) bool { return promotions[i].Priority > promotions[j].Priority })
+	var stackable, exclusive []*models.Promotion
+	for _, promotion := range promotions {
+		if !promotion.Current(cart.At) {
+			continue
+		}
+		if reason, err := quote.ineligible(promotion, products); err != nil {
+			return nil, err
+		} else if reason != "" {
+			quote.skip(promotion, reason)
+		} else if promotion.Exclusive {
+			exclusive = append(exclusive, promotion)
+		} else {
+			stackable = append(stackable, promotion)
+		}
+	}
+
+	// The stackable promotions apply together and each exclusive one alone;
+	// whichever saves most wins, the stack on a tie
+	best, err := quote.stack(stackable, products)
+	if err != nil {
+		return nil, err
+	}
+	var winner *models.Promotion
+	for _, promotion := range exclusive {
+		alone, err := quote.stack([]*models.Promotion{promotion}, products)
+		if err != nil {
+			return nil, err
+		}
+		if c, _ := alone.discount.Cmp(best.discount); c > 0 {
+			best, winner = alone, promotion
+		}
+	}
+	for _, promotion := range exclusive {
+		if promotion != winner && winner != nil {
+			quote.skip(promotion, fmt.Sprintf("exclusive, and exclusive promotion %s saves more", winner.ID))
+		} else if promotion != winner {
+			quote.skip(promotion, "exclusive, and the other promotions together save at least as much")
+		}
+	}
+	if winner != nil {
+		for _, promotion := range stackable {
+			quote.skip(promotion, fmt.Sprintf("exclusive promotion %s saves more than the others together", winner.ID))
+		}
+	} else {
+		quote.Skipped = append(quote.Skipped, best.skipped...)
+	}
+	quote.Applied = best.applied
+	if quote.Applied == nil {
+		quote.Applied = []AppliedPromotion{}
+	}
+	if quote.Skipped == nil {
+		quote.Skipped = []SkippedPromotion{}
+	}
+
+	quote.Discount = best.discount
+	for i := range quote.Lines {
+		line := &quote.Lines[i]
+		line.Discount = best.lines[i]
+		if line.Total, err = line.Amount.Sub(line.Discount); err != nil {
+			return nil, err
+		}
+	}
+	if quote.Total, err = quote.Subtotal.Sub(quote.Discount); err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+// skip records that promotion did not apply to the quote and why
+func (q *Quote) skip(promotion *models.Promotion, reason string) {
+	q.Skipped = append(q.Skipped, SkippedPromotion{PromotionID: promotion.ID, Name: promotion.Name, Reason: reason})
+}
+
+// ineligible returns why promotion cannot apply to the quote, or nothing
+// if it can
+func (q *Quote) ineligible(promotion *models.Promotion, products []*models.Product) (string, error) {
+	if promotion.LimitPerBuyer > 0 {
+		if q.BuyerID == "" {
+			return "limited per buyer, and no buyer was given", nil
+		}
+		if uses := promotion.Uses[q.BuyerID]; uses >= promotion.LimitPerBuyer {
+			return fmt.Sprintf("the buyer has used it %d of %d times", uses, promotion.LimitPerBuyer), nil
+		}
+	}
+	currency := q.Subtotal.Currency
+	if (!promotion.Amount.IsZero() && promotion.Amount.Currency != currency) ||
+		(!promotion.MinSubtotal.IsZero() && promotion.MinSubtotal.Currency != currency) {
+		return "in a different currency from the cart", nil
+	}
+	covered := models.Money{Currency: currency}
+	matched := false
+	for i, line := range q.Lines {
+		if !covers(promotion, products[i]) {
+			continue
+		}
+		matched = true
+		var err error
+		if covered, err = covered.Add(line.Amount); err != nil {
+			return "", err
+		}
+	}
+	if !matched {
+		return "covers nothing in the cart", nil
+	}
+	if covered.Minor < promotion.MinSubtotal.Minor {
+		return fmt.Sprintf("needs %s of the products it covers, and the cart has %s", promotion.MinSubtotal, covered), nil
+	}
+	return "", nil
+}
+
+// covers reports whether promotion covers a line of product. A buy and
+// get free promotion covers only products its quantities convert to.
+func covers(promotion *models.Promotion, product *models.Product) bool {
+	if !promotion.Covers(product) {
+		return false
+	}
+	if promotion.Type == models.PromotionBuyGetFree {
+		if _, err := toBase(product, promotion.BuyQuantity, promotion.Unit); err != nil {
+			return false
+		}
+		if _, err := toBase(product, promotion.FreeQuantity, promotion.Unit); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// stacking is the outcome of applying promotions to a quote together: the
+// promotions that took something off, the discount on each line and in
+// all, and the promotions skipped
+type stacking struct {
+	applied  []AppliedPromotion
+	lines    []models.Money
+	discount models.Money
+	skipped  []SkippedPromotion
+}
+
+// stack applies promotions to the quote's lines one after the other. Of
+// promotions sharing a group only the first applies; the others, and those
+// that save nothing, are skipped.
+func (q *Quote) stack(promotions []*models.Promotion, products []*models.Product) (*stacking, error) {
+	currency := q.Subtotal.Currency
+	result := &stacking{lines: make([]models.Money, len(q.Lines)), discount: models.Money{Currency: currency}}
+	for i := range result.lines {
+		result.lines[i] = models.Money{Currency: currency}
+	}
+	groups := make(map[string]string)
+	for _, promotion := range promotions {
+		if by, ok := groups[promotion.Group]; ok && promotion.Group != "" {
+			result.skipped = append(result.skipped, SkippedPromotion{PromotionID: promotion.ID, Name: promotion.Name,
+				Reason: fmt.Sprintf("promotion %s of group %s applied instead", by, promotion.Group)})
+			continue
+		}
+		total := models.Money{Currency: currency}
+		for i, line := range q.Lines {
+			if !covers(promotion, products[i]) {
+				continue
+			}
+			remaining, err := line.Amount.Sub(result.lines[i])
+			if err != nil {
+				return nil, err
+			}
+			off, err := lineDiscount(promotion, products[i], line, remaining, total)
+			if err != nil {
+				return nil, err
+			}
+			if result.lines[i], err = result.lines[i].Add(off); err != nil {
+				return nil, err
+			}
+			if total, err = total.Add(off); err != nil {
+				return nil, err
+			}
+		}
+		if total.IsZero() {
+			result.skipped = append(result.skipped, SkippedPromotion{PromotionID: promotion.ID, Name: promotion.Name,
+				Reason: "saves nothing on this cart"})
+			continue
+		}
+		groups[promotion.Group] = promotion.ID
+		result.applied = append(result.applied, AppliedPromotion{PromotionID: promotion.ID, Name: promotion.Name, Discount: total})
+		var err error
+		if result.discount, err = result.discount.Add(total); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// lineDiscount returns what promotion takes off a line of product, of
+// which remaining is left to pay, given that it has already taken taken
+// off earlier lines
+func lineDiscount(promotion *models.Promotion, product *models.Product, line QuoteLine,
+	remaining, taken models.Money) (models.Money, error) {
+	var off models.Money
+	var err error
+	switch promotion.Type {
+	case models.PromotionPercentOff:
+		off, err = promotion.Percent.Of(remaining)
+	case models.PromotionAmountOff:
+		off, err = promotion.Amount.Sub(taken)
+	case models.PromotionBuyGetFree:
+		buy, _ := toBase(product, promotion.BuyQuantity, promotion.Unit)
+		free, _ := toBase(product, promotion.FreeQuantity, promotion.Unit)
+		sets := math.Floor(models.RoundQuantity(line.Quantity / (buy + free)))
+		off, err = line.UnitPrice.Mul(models.RoundQuantity(sets * free))
+	}
+	if err != nil {
+		return models.Money{}, err
+	}
+	if off.Minor > remaining.Minor {
+		off = remaining
+	}
+	return off, nil
+}
+
+// RedeemCart quotes a cart as described for QuoteCart and records a use
+// of each promotion applied by the cart's buyer, in a single unit of work,
+// so that promotions limited per buyer run out
+func (s *InventoryService) RedeemCart(cart Cart) (*Quote, error) {
+	var quote *Quote
+	err := s.Atomically(func(svc *InventoryService) error {
+		var err error
+		quote, err = svc.QuoteCart(cart)
+		if err != nil || cart.BuyerID == "" {
+			return err
+		}
+		for _, applied := range quote.Applied {
+			promotion, err := svc.repo.GetPromotion(applied.PromotionID)
+			if err != nil {
+				return err
+			}
+			if promotion.Uses == nil {
+				promotion.Uses = make(map[string]int)
+			}
+			promotion.Uses[cart.BuyerID]++
+			if err := svc.repo.UpdatePromotion(promotion); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+// newPromotionService returns a service with buyers b1 and b2, mulch at
+// 5.00 a bag, compost, a Soil Amendment, at 20.00 and edging at 30.00, and
+// three promotions, in order of priority: bogo, buy 3 bags of mulch get 1
+// free; soil15, 15% off Soil Amendments; and ten, 10.00 off orders of
+// 100.00 or more, once per buyer.
+func newPromotionService(t *testing.T) *InventoryService {
+	t.Helper()
+	return newTestService(t,
+		withBuyers(&models.Buyer{ID: "b1", Name: "Bob"}, &models.Buyer{ID: "b2", Name: "Bea"}),
+		withProducts(
+			&models.Product{ID: "mulch", Name: "Cedar Mulch", Category: "Mulch", VendorID: "v1", Price: usd(500)},
+			&models.Product{ID: "compost", Name: "Compost", Category: "Soil Amendments", VendorID: "v1", Price: usd(2000)},
+			&models.Product{ID: "edging", Name: "Steel Edging", Category: "Hardscape", VendorID: "v1", Price: usd(3000)},
+		),
+		withPromotions(
+			&models.Promotion{ID: "bogo", Name: "Buy 3 bags of mulch get 1 free", Type: models.PromotionBuyGetFree,
+				BuyQuantity: 3, FreeQuantity: 1, ProductIDs: []string{"mulch"}, Priority: 3},
+			&models.Promotion{ID: "soil15", Name: "15% off Soil Amendments", Type: models.PromotionPercentOff,
+				Percent: 15 * models.OnePercent, Categories: []string{"Soil Amendments"}, Priority: 2},
+			&models.Promotion{ID: "ten", Name: "$10 off orders over $100", Type: models.PromotionAmountOff,
+				Amount: usd(1000), MinSubtotal: usd(10000), LimitPerBuyer: 1, Priority: 1},
+		),
+	)
+}
+
+// springCart is 8 bags of mulch, 3 of compost and 1 length of edging,
+// 130.00 in all
+func springCart(buyerID string) Cart {
+	return Cart{BuyerID: buyerID, Lines: []CartLine{
+		{ProductID: "mulch", Quantity: 8},
+		{ProductID: "compost", Quantity: 3},
+		{ProductID: "edging", Quantity: 1},
+	}}
+}
+
+// appliedIDs returns the IDs of the promotions applied to a quote
+func appliedIDs(quote *Quote) []string {
+	ids := make([]string, len(quote.Applied))
+	for i, applied := range quote.Applied {
+		ids[i] = applied.PromotionID
+	}
+	return ids
+}
+
+// skipReason returns why a promotion was skipped by a quote, or nothing
+func skipReason(quote *Quote, id string) string {
+	for _, skipped := range quote.Skipped {
+		if skipped.PromotionID == id {
+			return skipped.Reason
+		}
+	}
+	return ""
+}
+
+func TestQuoteStacksPromotions(t *testing.T) {
+	svc := newPromotionService(t)
+
+	quote, err := svc.QuoteCart(springCart("b1"))
+	if err != nil {
+		t.Fatalf("Failed to quote cart: %v", err)
+	}
+	if ids := appliedIDs(quote); len(ids) != 3 || ids[0] != "bogo" || ids[1] != "soil15" || ids[2] != "ten" {
+		t.Errorf("Expected bogo, soil15 and ten to apply in order, got %v", ids)
+	}
+	// Two bags of mulch free, 9.00 off the compost and 10.00 off the rest,
+	// taken from the mulch first
+	if quote.Subtotal != usd(13000) || quote.Discount != usd(2900) || quote.Total != usd(10100) {
+		t.Errorf("Expected 130.00 less 29.00, got %v less %v, %v", quote.Subtotal, quote.Discount, quote.Total)
+	}
+	want := []models.Money{usd(2000), usd(900), usd(0)}
+	for i, line := range quote.Lines {
+		if line.Discount != want[i] {
+			t.Errorf("Expected line %d to have %v off, got %v", i, want[i], line.Discount)
+		}
+	}
+
+	small := Cart{Lines: []CartLine{{ProductID: "edging", Quantity: 1}}}
+	quote, err = svc.QuoteCart(small)
+	if err != nil {
+		t.Fatalf("Failed to quote cart: %v", err)
+	}
+	if quote.Total != usd(3000) || skipReason(quote, "bogo") == "" || skipReason(quote, "ten") == "" {
+		t.Errorf("Expected no promotion to apply to a length of edging, with reasons, got %+v", quote)
+	}
+}
+
+func TestRedeemCartUsesUpLimits(t *testing.T) {
+	svc := newPromotionService(t)
+
+	if quote, err := svc.QuoteCart(springCart("")); err != nil || skipReason(quote, "ten") == "" {
+		t.Errorf("Expected a promotion limited per buyer to be skipped without a buyer, got %+v, %v", quote, err)
+	}
+	if _, err := svc.RedeemCart(springCart("b1")); err != nil {
+		t.Fatalf("Failed to redeem cart: %v", err)
+	}
+	promotion, err := svc.GetPromotion("ten")
+	if err != nil {
+		t.Fatalf("Failed to get promotion: %v", err)
+	}
+	if promotion.Uses["b1"] != 1 {
+		t.Errorf("Expected one use by b1, got %v", promotion.Uses)
+	}
+
+	quote, err := svc.QuoteCart(springCart("b1"))
+	if err != nil {
+		t.Fatalf("Failed to quote cart: %v", err)
+	}
+	if quote.Total != usd(11100) || skipReason(quote, "ten") == "" {
+		t.Errorf("Expected b1 to have used up ten, got %v and %v", quote.Total, quote.Skipped)
+	}
+	if quote, _ := svc.QuoteCart(springCart("b2")); quote.Total != usd(10100) {
+		t.Errorf("Expected b2 still to get ten, got %v", quote.Total)
+	}
+
+	// Replacing the rules keeps the uses
+	promotion.Uses = nil
+	promotion.Amount = usd(1500)
+	if err := svc.UpdatePromotion(promotion); err != nil {
+		t.Fatalf("Failed to update promotion: %v", err)
+	}
+	if again, _ := svc.GetPromotion("ten"); again.Uses["b1"] != 1 {
+		t.Errorf("Expected an update to keep the uses, got %v", again.Uses)
+	}
+}
+
+func TestQuoteExclusivePromotions(t *testing.T) {
+	svc := newPromotionService(t)
+
+	half := &models.Promotion{ID: "half", Name: "Half off everything", Type: models.PromotionPercentOff,
+		Percent: 50 * models.OnePercent, Exclusive: true}
+	if err := svc.CreatePromotion(half); err != nil {
+		t.Fatalf("Failed to create promotion: %v", err)
+	}
+	quote, err := svc.QuoteCart(springCart("b1"))
+	if err != nil {
+		t.Fatalf("Failed to quote cart: %v", err)
+	}
+	if ids := appliedIDs(quote); len(ids) != 1 || ids[0] != "half" || quote.Total != usd(6500) {
+		t.Errorf("Expected half alone to apply, saving more than the rest together, got %v and %v", ids, quote.Total)
+	}
+	if skipReason(quote, "bogo") == "" || skipReason(quote, "soil15") == "" {
+		t.Errorf("Expected the others to be skipped for the exclusive promotion, got %+v", quote.Skipped)
+	}
+
+	half.Percent = 5 * models.OnePercent
+	if err := svc.UpdatePromotion(half); err != nil {
+		t.Fatalf("Failed to update promotion: %v", err)
+	}
+	quote, err = svc.QuoteCart(springCart("b1"))
+	if err != nil {
+		t.Fatalf("Failed to quote cart: %v", err)
+	}
+	if len(quote.Applied) != 3 || quote.Total != usd(10100) || skipReason(quote, "half") == "" {
+		t.Errorf("Expected the others together to beat 5%% off, got %v and %v", appliedIDs(quote), quote.Total)
+	}
+}
+
+func TestQuoteGroupsAndWindows(t *testing.T) {
+	svc := newPromotionService(t)
+	now := time.Now()
+	past := now.Add(-time.Hour)
+
+	for _, promotion := range []*models.Promotion{
+		{ID: "mulch10", Name: "10% off mulch", Type: models.PromotionPercentOff, Percent: 10 * models.OnePercent,
+			Categories: []string{"Mulch"}, Group: "mulch", Priority: 5},
+		{ID: "mulch20", Name: "20% off mulch", Type: models.PromotionPercentOff, Percent: 20 * models.OnePercent,
+			Categories: []string{"Mulch"}, Group: "mulch", Priority: 4},
+		{ID: "expired", Name: "Winter sale", Type: models.PromotionPercentOff, Percent: 90 * models.OnePercent, ValidTo: &past},
+	} {
+		if err := svc.CreatePromotion(promotion); err != nil {
+			t.Fatalf("Failed to create promotion %s: %v", promotion.ID, err)
+		}
+	}
+	quote, err := svc.QuoteCart(springCart("b2"))
+	if err != nil {
+		t.Fatalf("Failed to quote cart: %v", err)
+	}
+	ids := appliedIDs(quote)
+	if len(ids) != 4 || ids[0] != "mulch10" || skipReason(quote, "mulch20") == "" {
+		t.Errorf("Expected only the first promotion of a group to apply, got %v and %+v", ids, quote.Skipped)
+	}
+	for _, id := range ids {
+		if id == "expired" {
+			t.Errorf("Expected an expired promotion not to apply")
+		}
+	}
+}
+
+func TestCreatePromotionValidates(t *testing.T) {
+	svc := newPromotionService(t)
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		promotion models.Promotion
+		err       error
+	}{
+		{"no name", models.Promotion{Type: models.PromotionPercentOff, Percent: 10 * models.OnePercent}, ErrInvalidPromotion},
+		{"no type", models.Promotion{Name: "X"}, ErrInvalidPromotion},
+		{"percent over 100", models.Promotion{Name: "X", Type: models.PromotionPercentOff, Percent: 120 * models.OnePercent}, ErrInvalidPromotion},
+		{"percent with an amount", models.Promotion{Name: "X", Type: models.PromotionPercentOff, Percent: 10 * models.OnePercent,
+			Amount: usd(100)}, ErrInvalidPromotion},
+		{"no amount", models.Promotion{Name: "X", Type: models.PromotionAmountOff}, ErrInvalidPromotion},
+		{"nothing free", models.Promotion{Name: "X", Type: models.PromotionBuyGetFree, BuyQuantity: 3}, ErrInvalidPromotion},
+		{"negative minimum", models.Promotion{Name: "X", Type: models.PromotionAmountOff, Amount: usd(100),
+			MinSubtotal: usd(-1)}, ErrInvalidPromotion},
+		{"empty window", models.Promotion{Name: "X", Type: models.PromotionPercentOff, Percent: 10 * models.OnePercent,
+			ValidFrom: &now, ValidTo: &earlier}, ErrInvalidPromotion},
+		{"negative limit", models.Promotion{Name: "X", Type: models.PromotionPercentOff, Percent: 10 * models.OnePercent,
+			LimitPerBuyer: -1}, ErrInvalidPromotion},
+		{"missing product", models.Promotion{Name: "X", Type: models.PromotionBuyGetFree, BuyQuantity: 1,
+			FreeQuantity: 1, ProductIDs: []string{"missing"}}, repository.ErrInvalidReference},
+		{"unknown unit", models.Promotion{Name: "X", Type: models.PromotionBuyGetFree, BuyQuantity: 1,
+			FreeQuantity: 1, Unit: "pallet", ProductIDs: []string{"mulch"}}, ErrInvalidUnit},
+	}
+	for _, tt := range tests {
+		tt.promotion.ID = "bad"
+		if err := svc.CreatePromotion(&tt.promotion); err != tt.err {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	if _, err := svc.QuoteCart(Cart{}); err != ErrInvalidCart {
+		t.Errorf("Expected ErrInvalidCart for an empty cart, got %v", err)
+	}
+	if _, err := svc.QuoteCart(Cart{Lines: []CartLine{{ProductID: "missing", Quantity: 1}}}); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a missing product, got %v", err)
+	}
+}
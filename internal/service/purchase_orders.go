@@ -0,0 +1,428 @@
+package service
+
+import (
+	"crypto/rand"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+var (
+	ErrInvalidPurchaseOrder = errors.New("invalid purchase order")
+	ErrInvalidReceipt       = errors.New("invalid receipt")
+	ErrOverReceipt          = errors.New("receipt exceeds the ordered quantity and its tolerance")
+)
+
+// Receipt is stock received against one line of a purchase order, of
+// Quantity in Unit, or in the line's unit when that is empty. The stock
+// goes to ItemID, or, when that is empty, to the item of the line's product
+// at Location. Serials gives the serial number of each unit of a serialized
+// product. Stock of a lot-controlled product is received as the lot
+// LotNumber, made at ManufacturedAt and expiring at ExpiresAt, either of
+// which may be unknown.
+type Receipt struct {
+	Line           int        `json:"line"`
+	ItemID         string     `json:"item_id"`
+	Location       string     `json:"location"`
+	Quantity       float64    `json:"quantity"`
+	Unit           string     `json:"unit"`
+	Serials        []string   `json:"serials,omitempty"`
+	LotNumber      string     `json:"lot_number,omitempty"`
+	ManufacturedAt *time.Time `json:"manufactured_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	Actor          string     `json:"actor"`
+}
+
+// tolerance returns percent of a line's quantity, whole if the line's
+// quantity is counted whole
+func tolerance(line models.PurchaseOrderLine, percent float64) float64 {
+	allowed := line.Quantity * percent / 100
+	if line.Quantity == math.Trunc(line.Quantity) {
+		return math.Floor(allowed)
+	}
+	return models.RoundQuantity(allowed)
+}
+
+// maxReceivable returns the most a line may receive under the order's over
+// tolerance
+func maxReceivable(order *models.PurchaseOrder, line models.PurchaseOrderLine) float64 {
+	return line.Quantity + tolerance(line, order.OverTolerance)
+}
+
+// fullyReceived reports whether a line has received its quantity, less the
+// order's under tolerance
+func fullyReceived(order *models.PurchaseOrder, line models.PurchaseOrderLine) bool {
+	return line.Received >= line.Quantity-tolerance(line, order.UnderTolerance)
+}
+
+// validatePurchaseOrder fails with ErrInvalidPurchaseOrder unless order has
+// tolerances between 0 and 100 percent and at least one line, and every line
+// names a product of the order's vendor, a positive quantity and an expected
+// date. A missing product fails with repository.ErrInvalidReference, and
+// line quantities are converted to their products' base units as described
+// for toBase. Each line's cost is kept as given, as the cost of one of its
+// cost unit or, if it names none, of the unit its quantity was given in;
+// costs fail with ErrInvalidPrice as described for validateLinePrices, and
+// each line totals its cost times its quantity as described for lineTotals.
+func (s *InventoryService) validatePurchaseOrder(order *models.PurchaseOrder) error {
+	if order.OverTolerance < 0 || order.OverTolerance > 100 ||
+		order.UnderTolerance < 0 || order.UnderTolerance > 100 || len(order.Lines) == 0 {
+		return ErrInvalidPurchaseOrder
+	}
+	costs := make([]*models.Money, len(order.Lines))
+	totals := make([]*models.Money, len(order.Lines))
+	quantities := make([]*big.Rat, len(order.Lines))
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		if line.Quantity <= 0 || line.ExpectedAt.IsZero() {
+			return ErrInvalidPurchaseOrder
+		}
+		product, err := s.repo.GetProduct(line.ProductID)
+		if err == repository.ErrNotFound {
+			return repository.ErrInvalidReference
+		} else if err != nil {
+			return err
+		}
+		if product.VendorID != order.VendorID {
+			return ErrInvalidPurchaseOrder
+		}
+		quantity, unit := line.Quantity, line.Unit
+		if line.Quantity, err = toBase(product, line.Quantity, line.Unit); err != nil {
+			return err
+		}
+		line.Unit = baseUnit(product)
+		if line.CostUnit == "" {
+			line.CostUnit = unit
+		}
+		if line.CostUnit == "" {
+			line.CostUnit = line.Unit
+		}
+		if quantities[i], err = priceQuantity(product, quantity, unit, line.CostUnit); err != nil {
+			return err
+		}
+		costs[i], totals[i] = &line.Cost, &line.Total
+	}
+	if err := validateLinePrices(costs); err != nil {
+		return err
+	}
+	return lineTotals(costs, totals, quantities)
+}
+
+// draftPurchaseOrder resets what a client may not set on an order: its
+// status and the quantities received
+func draftPurchaseOrder(order *models.PurchaseOrder) {
+	order.Status = models.PurchaseOrderDraft
+	for i := range order.Lines {
+		order.Lines[i].Received = 0
+	}
+}
+
+// CreatePurchaseOrder creates order as a draft. It fails with
+// ErrInvalidPurchaseOrder or ErrInvalidPrice as described for
+// validatePurchaseOrder.
+func (s *InventoryService) CreatePurchaseOrder(order *models.PurchaseOrder) error {
+	if err := s.validatePurchaseOrder(order); err != nil {
+		return err
+	}
+	draftPurchaseOrder(order)
+	return s.repo.CreatePurchaseOrder(order)
+}
+
+func (s *InventoryService) GetPurchaseOrder(id string) (*models.PurchaseOrder, error) {
+	return s.repo.GetPurchaseOrder(id)
+}
+
+func (s *InventoryService) FindPurchaseOrders(filter repository.PurchaseOrderFilter) ([]*models.PurchaseOrder, error) {
+	return s.repo.FindPurchaseOrders(filter)
+}
+
+// UpdatePurchaseOrder replaces the vendor, tolerances and lines of a draft
+// order. Orders past the draft stage fail with ErrInvalidTransition.
+func (s *InventoryService) UpdatePurchaseOrder(order *models.PurchaseOrder) error {
+	if err := s.validatePurchaseOrder(order); err != nil {
+		return err
+	}
+	existing, err := s.repo.GetPurchaseOrder(order.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Status != models.PurchaseOrderDraft {
+		return ErrInvalidTransition
+	}
+	draftPurchaseOrder(order)
+	if err := repository.CheckVersion(existing.Version, order.Version); err != nil {
+		return err
+	}
+	order.Version = existing.Version
+	return s.repo.UpdatePurchaseOrder(order)
+}
+
+// DeletePurchaseOrder deletes a draft or cancelled order. Other orders fail
+// with ErrInvalidTransition.
+func (s *InventoryService) DeletePurchaseOrder(id string, version int64) error {
+	existing, err := s.repo.GetPurchaseOrder(id)
+	if err != nil {
+		return err
+	}
+	if existing.Status != models.PurchaseOrderDraft && existing.Status != models.PurchaseOrderCancelled {
+		return ErrInvalidTransition
+	}
+	if err := repository.CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	return s.repo.DeletePurchaseOrder(id, existing.Version)
+}
+
+// changePurchaseOrder applies change to an order and stores it in a single
+// unit of work
+func (s *InventoryService) changePurchaseOrder(id string, version int64,
+	change func(svc *InventoryService, order *models.PurchaseOrder) error) (*models.PurchaseOrder, error) {
+	var order *models.PurchaseOrder
+	err := s.Atomically(func(svc *InventoryService) error {
+		var err error
+		order, err = svc.repo.GetPurchaseOrder(id)
+		if err != nil {
+			return err
+		}
+		if err := repository.CheckVersion(order.Version, version); err != nil {
+			return err
+		}
+		if err := change(svc, order); err != nil {
+			return err
+		}
+		return svc.repo.UpdatePurchaseOrder(order)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// IssuePurchaseOrder opens a draft order for receiving
+func (s *InventoryService) IssuePurchaseOrder(id string, version int64) (*models.PurchaseOrder, error) {
+	return s.changePurchaseOrder(id, version, func(_ *InventoryService, order *models.PurchaseOrder) error {
+		if order.Status != models.PurchaseOrderDraft {
+			return ErrInvalidTransition
+		}
+		order.Status = models.PurchaseOrderOpen
+		return nil
+	})
+}
+
+// ClosePurchaseOrder closes an open order, so that whatever has not been
+// received is no longer on order
+func (s *InventoryService) ClosePurchaseOrder(id string, version int64) (*models.PurchaseOrder, error) {
+	return s.changePurchaseOrder(id, version, func(_ *InventoryService, order *models.PurchaseOrder) error {
+		if order.Status != models.PurchaseOrderOpen {
+			return ErrInvalidTransition
+		}
+		order.Status = models.PurchaseOrderClosed
+		return nil
+	})
+}
+
+// CancelPurchaseOrder cancels a draft order, or an open one that has
+// received nothing; an order that has received stock must be closed instead
+func (s *InventoryService) CancelPurchaseOrder(id string, version int64) (*models.PurchaseOrder, error) {
+	return s.changePurchaseOrder(id, version, func(_ *InventoryService, order *models.PurchaseOrder) error {
+		if order.Status != models.PurchaseOrderDraft && order.Status != models.PurchaseOrderOpen {
+			return ErrInvalidTransition
+		}
+		for _, line := range order.Lines {
+			if line.Received > 0 {
+				return ErrInvalidTransition
+			}
+		}
+		order.Status = models.PurchaseOrderCancelled
+		return nil
+	})
+}
+
+// ReceivePurchaseOrder receives stock against a line of an open order. It
+// posts a receipt movement to the receiving item and adds the quantity,
+// converted to the line's unit, to the line, then closes the order if every
+// line has been fully received. It fails with ErrInvalidReceipt if the line
+// does not exist, the quantity is
+// not positive, the item is missing or holds another product or is at
+// another location, or the receipt does not give a serial number for each
+// unit of a serialized product and none for any other, and with
+// ErrOverReceipt if the line would receive more than its over tolerance
+// allows. The serials are registered as in stock at the item. The lot of a
+// lot-controlled product goes to the receiving item as described for
+// receivingItem, and a receipt into a lot that has expired fails with
+// ErrLotExpired.
+func (s *InventoryService) ReceivePurchaseOrder(id string, version int64, receipt Receipt) (*models.PurchaseOrder, *models.StockMovement, error) {
+	var movement *models.StockMovement
+	order, err := s.changePurchaseOrder(id, version, func(svc *InventoryService, order *models.PurchaseOrder) error {
+		if order.Status != models.PurchaseOrderOpen {
+			return ErrInvalidTransition
+		}
+		if receipt.Line < 0 || receipt.Line >= len(order.Lines) || receipt.Quantity <= 0 {
+			return ErrInvalidReceipt
+		}
+		line := &order.Lines[receipt.Line]
+		quantity, _, err := svc.inBaseUnit(line.ProductID, receipt.Quantity, receipt.Unit)
+		if err != nil {
+			return err
+		}
+		if models.RoundQuantity(line.Received+quantity) > maxReceivable(order, *line) {
+			return ErrOverReceipt
+		}
+		item, err := svc.receivingItem(order, receipt)
+		if err != nil {
+			return err
+		}
+		if item.Expired(time.Now()) {
+			return ErrLotExpired
+		}
+		serialized, err := svc.isSerialized(line.ProductID)
+		if err != nil {
+			return err
+		}
+		if serialized && float64(len(receipt.Serials)) != quantity || !serialized && len(receipt.Serials) != 0 {
+			return ErrInvalidReceipt
+		}
+		if err := svc.receiveSerials(item, receipt.Serials, order.ID); err != nil {
+			return err
+		}
+
+		actor := receipt.Actor
+		if actor == "" {
+			actor = models.SystemActor
+		}
+		movement = &models.StockMovement{
+			ItemID:    item.ID,
+			Type:      models.MovementReceipt,
+			Delta:     quantity,
+			Reason:    models.ReasonPurchase,
+			Reference: order.ID,
+			Actor:     actor,
+		}
+		if err := svc.repo.PostMovement(movement, 0); err != nil {
+			return err
+		}
+		line.Received = models.RoundQuantity(line.Received + quantity)
+
+		for _, line := range order.Lines {
+			if !fullyReceived(order, line) {
+				return nil
+			}
+		}
+		order.Status = models.PurchaseOrderClosed
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return order, movement, nil
+}
+
+// receivingItem returns the inventory item that receipt names for its line
+// of order. A receipt at a Location goes to the first item there of the
+// line's product that holds the receipt's lot, or no lot if it names none,
+// with the dates the receipt gives; if there is none, a new item with a
+// random ID is created there as CreateInventoryItem does, for any product.
+// Stock of a lot-controlled product must go to an item of the receipt's lot.
+// A receipt by ItemID may leave the lot to the item. A receipt at a Location
+// without a lot for a lot-controlled product, or with one for any other,
+// fails with ErrInvalidLot, as does a lot the item does not hold.
+func (s *InventoryService) receivingItem(order *models.PurchaseOrder, receipt Receipt) (*models.InventoryItem, error) {
+	productID := order.Lines[receipt.Line].ProductID
+	if receipt.LotNumber == "" && (receipt.ManufacturedAt != nil || receipt.ExpiresAt != nil) {
+		return nil, ErrInvalidLot
+	}
+
+	if receipt.ItemID == "" {
+		if receipt.Location == "" {
+			return nil, ErrInvalidReceipt
+		}
+		product, err := s.repo.GetProduct(productID)
+		if err != nil {
+			return nil, err
+		}
+		if product.LotControlled != (receipt.LotNumber != "") {
+			return nil, ErrInvalidLot
+		}
+		items, err := s.repo.FindInventoryItems(repository.InventoryFilter{ProductID: productID, Location: receipt.Location})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if receipt.holds(item) {
+				return item, nil
+			}
+		}
+		item := &models.InventoryItem{
+			ID:             newItemID(),
+			ProductID:      productID,
+			Location:       receipt.Location,
+			LotNumber:      receipt.LotNumber,
+			ManufacturedAt: receipt.ManufacturedAt,
+			ExpiresAt:      receipt.ExpiresAt,
+		}
+		if err := s.CreateInventoryItem(item); err != nil {
+			return nil, err
+		}
+		return item, nil
+	}
+
+	item, err := s.repo.GetInventoryItem(receipt.ItemID)
+	if err == repository.ErrNotFound {
+		return nil, ErrInvalidReceipt
+	} else if err != nil {
+		return nil, err
+	}
+	if item.ProductID != productID || receipt.Location != "" && item.Location != receipt.Location {
+		return nil, ErrInvalidReceipt
+	}
+	if receipt.LotNumber != "" && !receipt.holds(item) {
+		return nil, ErrInvalidLot
+	}
+	return item, nil
+}
+
+// newItemID returns a random ID for an inventory item created to receive
+// stock, which cannot collide with an ID a client chose or with an item
+// created for the same lot elsewhere
+func newItemID() string {
+	return "item-" + strings.ToLower(rand.Text())
+}
+
+// holds reports whether item holds the receipt's lot, or no lot if the
+// receipt names none, with the dates the receipt gives
+func (r Receipt) holds(item *models.InventoryItem) bool {
+	return item.LotNumber == r.LotNumber &&
+		(r.ManufacturedAt == nil || equalTimes(item.ManufacturedAt, r.ManufacturedAt)) &&
+		(r.ExpiresAt == nil || equalTimes(item.ExpiresAt, r.ExpiresAt))
+}
+
+// OnOrder returns the quantity of a product ordered on open purchase orders
+// and not yet received, with the product's base unit it is in, or
+// ErrNotFound if the product does not exist
+func (s *InventoryService) OnOrder(productID string) (float64, string, error) {
+	product, err := s.repo.GetProduct(productID)
+	if err != nil {
+		return 0, "", err
+	}
+	orders, err := s.repo.FindPurchaseOrders(repository.PurchaseOrderFilter{
+		ProductID: productID,
+		Status:    models.PurchaseOrderOpen,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	onOrder := 0.0
+	for _, order := range orders {
+		for _, line := range order.Lines {
+			if line.ProductID == productID && line.Received < line.Quantity {
+				onOrder += line.Quantity - line.Received
+			}
+		}
+	}
+	return models.RoundQuantity(onOrder), baseUnit(product), nil
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+// newPurchaseService returns a service with vendor v1, its product p1 stocked
+// by item i1 with 10 at warehouse wh-a, and draft order po1 for 20 and 10 of p1
+// with 10% over and under tolerances
+func newPurchaseService(t *testing.T) *InventoryService {
+	t.Helper()
+	expectedAt := time.Now().AddDate(0, 0, 7)
+	order := &models.PurchaseOrder{
+		ID:             "po1",
+		VendorID:       "v1",
+		OverTolerance:  10,
+		UnderTolerance: 10,
+		Lines: []models.PurchaseOrderLine{
+			{ProductID: "p1", Quantity: 20, ExpectedAt: expectedAt},
+			{ProductID: "p1", Quantity: 10, ExpectedAt: expectedAt},
+		},
+	}
+	svc := newTestService(t,
+		withProducts(&models.Product{ID: "p1", Name: "Fertilizer", VendorID: "v1"}),
+		withLocations(
+			activeLocation("wh-a", models.LocationWarehouse, ""),
+			activeLocation("wh-b", models.LocationWarehouse, ""),
+		),
+		withItems(&models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 10, Location: "wh-a"}),
+		withPurchaseOrders(order),
+	)
+	if order.Status != models.PurchaseOrderDraft {
+		t.Errorf("Expected a draft order, got %s", order.Status)
+	}
+	return svc
+}
+
+// checkOnOrder fails unless p1 has the given quantity on order
+func checkOnOrder(t *testing.T, svc *InventoryService, expected float64) {
+	t.Helper()
+	onOrder, _, err := svc.OnOrder("p1")
+	if err != nil {
+		t.Fatalf("Failed to get quantity on order: %v", err)
+	}
+	if onOrder != expected {
+		t.Errorf("Expected %v on order, got %v", expected, onOrder)
+	}
+}
+
+func TestCreatePurchaseOrderValidation(t *testing.T) {
+	svc := newPurchaseService(t)
+	if err := svc.CreateVendor(&models.Vendor{ID: "v2", Name: "Other Vendor"}); err != nil {
+		t.Fatalf("Failed to create vendor: %v", err)
+	}
+
+	expectedAt := time.Now()
+	invalid := []*models.PurchaseOrder{
+		{ID: "po2", VendorID: "v1"},
+		{ID: "po2", VendorID: "v1", OverTolerance: 101, Lines: []models.PurchaseOrderLine{{ProductID: "p1", Quantity: 1, ExpectedAt: expectedAt}}},
+		{ID: "po2", VendorID: "v1", Lines: []models.PurchaseOrderLine{{ProductID: "p1", Quantity: 0, ExpectedAt: expectedAt}}},
+		{ID: "po2", VendorID: "v1", Lines: []models.PurchaseOrderLine{{ProductID: "p1", Quantity: 1}}},
+		{ID: "po2", VendorID: "v2", Lines: []models.PurchaseOrderLine{{ProductID: "p1", Quantity: 1, ExpectedAt: expectedAt}}},
+	}
+	for _, order := range invalid {
+		if err := svc.CreatePurchaseOrder(order); err != ErrInvalidPurchaseOrder {
+			t.Errorf("Expected ErrInvalidPurchaseOrder for %+v, got %v", order, err)
+		}
+	}
+
+	missing := &models.PurchaseOrder{ID: "po2", VendorID: "v1",
+		Lines: []models.PurchaseOrderLine{{ProductID: "missing", Quantity: 1, ExpectedAt: expectedAt}}}
+	if err := svc.CreatePurchaseOrder(missing); err != repository.ErrInvalidReference {
+		t.Errorf("Expected ErrInvalidReference for a missing product, got %v", err)
+	}
+}
+
+func TestReceivePurchaseOrder(t *testing.T) {
+	svc := newPurchaseService(t)
+	checkOnOrder(t, svc, 0)
+
+	if _, _, err := svc.ReceivePurchaseOrder("po1", 0, Receipt{ItemID: "i1", Quantity: 1}); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition receiving a draft, got %v", err)
+	}
+	if _, err := svc.IssuePurchaseOrder("po1", 0); err != nil {
+		t.Fatalf("Failed to issue purchase order: %v", err)
+	}
+	checkOnOrder(t, svc, 30)
+
+	for _, receipt := range []Receipt{
+		{Line: 2, ItemID: "i1", Quantity: 1},
+		{Line: 0, ItemID: "i1", Quantity: 0},
+		{Line: 0, ItemID: "missing", Quantity: 1},
+		{Line: 0, ItemID: "i1", Location: "wh-b", Quantity: 1},
+		{Line: 0, Quantity: 1},
+	} {
+		if _, _, err := svc.ReceivePurchaseOrder("po1", 0, receipt); err != ErrInvalidReceipt {
+			t.Errorf("Expected ErrInvalidReceipt for %+v, got %v", receipt, err)
+		}
+	}
+	if _, _, err := svc.ReceivePurchaseOrder("po1", 0, Receipt{ItemID: "i1", Quantity: 23}); err != ErrOverReceipt {
+		t.Errorf("Expected ErrOverReceipt beyond the tolerance, got %v", err)
+	}
+
+	order, movement, err := svc.ReceivePurchaseOrder("po1", 0, Receipt{Location: "wh-a", Quantity: 12, Actor: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to receive purchase order: %v", err)
+	}
+	if order.Status != models.PurchaseOrderOpen || order.Lines[0].Received != 12 {
+		t.Errorf("Expected an open order with 12 received, got %+v", order)
+	}
+	if movement.ItemID != "i1" || movement.Type != models.MovementReceipt || movement.Delta != 12 ||
+		movement.Reason != models.ReasonPurchase || movement.Reference != "po1" || movement.Actor != "alice" {
+		t.Errorf("Expected a purchase receipt of 12 into i1 for po1, got %+v", movement)
+	}
+	checkItem(t, svc, "i1", 22, 0)
+	checkOnOrder(t, svc, 18)
+
+	// Within the over tolerance, then within the under tolerance, which
+	// closes the order
+	if _, _, err := svc.ReceivePurchaseOrder("po1", 0, Receipt{ItemID: "i1", Quantity: 10}); err != nil {
+		t.Fatalf("Failed to receive purchase order: %v", err)
+	}
+	order, _, err = svc.ReceivePurchaseOrder("po1", 0, Receipt{Line: 1, ItemID: "i1", Quantity: 9})
+	if err != nil {
+		t.Fatalf("Failed to receive purchase order: %v", err)
+	}
+	if order.Status != models.PurchaseOrderClosed {
+		t.Errorf("Expected the fully received order to close, got %s", order.Status)
+	}
+	checkItem(t, svc, "i1", 41, 0)
+	checkOnOrder(t, svc, 0)
+
+	if _, _, err := svc.ReceivePurchaseOrder("po1", 0, Receipt{Line: 1, ItemID: "i1", Quantity: 1}); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition receiving a closed order, got %v", err)
+	}
+}
+
+func TestReceivePurchaseOrderCreatesItem(t *testing.T) {
+	svc := newPurchaseService(t)
+	if _, err := svc.IssuePurchaseOrder("po1", 0); err != nil {
+		t.Fatalf("Failed to issue purchase order: %v", err)
+	}
+
+	_, movement, err := svc.ReceivePurchaseOrder("po1", 0, Receipt{Location: "wh-b", Quantity: 4})
+	if err != nil {
+		t.Fatalf("Failed to receive purchase order: %v", err)
+	}
+	item, err := svc.GetInventoryItem(movement.ItemID)
+	if err != nil {
+		t.Fatalf("Failed to get received item: %v", err)
+	}
+	if !strings.HasPrefix(item.ID, "item-") || item.ProductID != "p1" || item.Location != "wh-b" || item.Quantity != 4 {
+		t.Errorf("Expected a new item holding 4 of p1 at wh-b, got %+v", item)
+	}
+	if _, movement, err := svc.ReceivePurchaseOrder("po1", 0, Receipt{Location: "wh-b", Quantity: 1}); err != nil || movement.ItemID != item.ID {
+		t.Errorf("Expected a second receipt into %s, got %v and %v", item.ID, movement, err)
+	}
+	if _, _, err := svc.ReceivePurchaseOrder("po1", 0, Receipt{Location: "missing", Quantity: 1}); err != ErrLocationUnavailable {
+		t.Errorf("Expected ErrLocationUnavailable receiving at a missing location, got %v", err)
+	}
+}
+
+func TestClosePurchaseOrderPartlyReceived(t *testing.T) {
+	svc := newPurchaseService(t)
+	if _, err := svc.IssuePurchaseOrder("po1", 0); err != nil {
+		t.Fatalf("Failed to issue purchase order: %v", err)
+	}
+	if _, _, err := svc.ReceivePurchaseOrder("po1", 0, Receipt{ItemID: "i1", Quantity: 5}); err != nil {
+		t.Fatalf("Failed to receive purchase order: %v", err)
+	}
+
+	if _, err := svc.CancelPurchaseOrder("po1", 0); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition cancelling a received order, got %v", err)
+	}
+	if err := svc.DeletePurchaseOrder("po1", 0); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition deleting an open order, got %v", err)
+	}
+	order, err := svc.ClosePurchaseOrder("po1", 0)
+	if err != nil || order.Status != models.PurchaseOrderClosed {
+		t.Fatalf("Expected a closed order, got %v and %v", order, err)
+	}
+	checkOnOrder(t, svc, 0)
+	checkItem(t, svc, "i1", 15, 0)
+}
+
+func TestCancelPurchaseOrder(t *testing.T) {
+	svc := newPurchaseService(t)
+	if _, err := svc.IssuePurchaseOrder("po1", 0); err != nil {
+		t.Fatalf("Failed to issue purchase order: %v", err)
+	}
+	if err := svc.UpdatePurchaseOrder(&models.PurchaseOrder{ID: "po1", VendorID: "v1",
+		Lines: []models.PurchaseOrderLine{{ProductID: "p1", Quantity: 1, ExpectedAt: time.Now()}}}); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition changing an open order, got %v", err)
+	}
+
+	order, err := svc.CancelPurchaseOrder("po1", 0)
+	if err != nil || order.Status != models.PurchaseOrderCancelled {
+		t.Fatalf("Expected a cancelled order, got %v and %v", order, err)
+	}
+	checkOnOrder(t, svc, 0)
+	if err := svc.DeletePurchaseOrder("po1", 0); err != nil {
+		t.Errorf("Failed to delete cancelled order: %v", err)
+	}
+	if _, _, err := svc.OnOrder("missing"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing product, got %v", err)
+	}
+}
@@ -0,0 +1,371 @@
+package service
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+var (
+	ErrInvalidSalesOrder = errors.New("invalid sales order")
+	ErrInvalidTransition = errors.New("order status does not allow this")
+)
+
+// salesOrderHold is how long the stock reserved by confirming an order is
+// held. Shipping an order whose holds have lapsed still ships it if the
+// stock is on hand.
+const salesOrderHold = 30 * 24 * time.Hour
+
+// salesOrderTransitions lists the statuses each status may move to.
+// Shipped orders can only be invoiced, and invoiced and cancelled orders
+// are final.
+var salesOrderTransitions = map[models.SalesOrderStatus][]models.SalesOrderStatus{
+	models.SalesOrderDraft:     {models.SalesOrderConfirmed, models.SalesOrderCancelled},
+	models.SalesOrderConfirmed: {models.SalesOrderAllocated, models.SalesOrderCancelled},
+	models.SalesOrderAllocated: {models.SalesOrderPicked, models.SalesOrderCancelled},
+	models.SalesOrderPicked:    {models.SalesOrderShipped, models.SalesOrderCancelled},
+	models.SalesOrderShipped:   {models.SalesOrderInvoiced},
+}
+
+// canTransition reports whether an order may move from one status to another
+func canTransition(from, to models.SalesOrderStatus) bool {
+	for _, next := range salesOrderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// validateLines fails with ErrInvalidSalesOrder unless order has at least one
+// line and every line names a product and a positive quantity, which is
+// converted to the product's base unit as described for toBase. A missing
+// product fails with repository.ErrInvalidReference. Each line's unit price
+// is kept as given, as the price of one of its price unit or, if it names
+// none, of the unit its quantity was given in; a line without one costs
+// what the order's buyer pays for its quantity per base unit, as described
+// for ResolvePrice. Prices fail with ErrInvalidPrice as described for
+// validateLinePrices, and each line totals its price times its quantity as
+// described for lineTotals.
+func (s *InventoryService) validateLines(order *models.SalesOrder) error {
+	if len(order.Lines) == 0 {
+		return ErrInvalidSalesOrder
+	}
+	prices := make([]*models.Money, len(order.Lines))
+	totals := make([]*models.Money, len(order.Lines))
+	quantities := make([]*big.Rat, len(order.Lines))
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		if line.ProductID == "" || line.Quantity <= 0 {
+			return ErrInvalidSalesOrder
+		}
+		product, err := s.repo.GetProduct(line.ProductID)
+		if err == repository.ErrNotFound {
+			return repository.ErrInvalidReference
+		} else if err != nil {
+			return err
+		}
+		quantity, unit := line.Quantity, line.Unit
+		if line.Quantity, err = toBase(product, line.Quantity, line.Unit); err != nil {
+			return err
+		}
+		line.Unit = baseUnit(product)
+		if line.UnitPrice == (models.Money{}) {
+			resolution, err := s.ResolvePrice(line.ProductID, PriceRequest{
+				BuyerID:  order.BuyerID,
+				Quantity: line.Quantity,
+				Unit:     line.Unit,
+			})
+			if err != nil {
+				return err
+			}
+			line.UnitPrice, line.PriceUnit = resolution.UnitPrice, line.Unit
+		} else if line.PriceUnit == "" {
+			line.PriceUnit = unit
+		}
+		if line.PriceUnit == "" {
+			line.PriceUnit = line.Unit
+		}
+		if quantities[i], err = priceQuantity(product, quantity, unit, line.PriceUnit); err != nil {
+			return err
+		}
+		prices[i], totals[i] = &line.UnitPrice, &line.Total
+	}
+	if err := validateLinePrices(prices); err != nil {
+		return err
+	}
+	return lineTotals(prices, totals, quantities)
+}
+
+// draft resets what a client may not set on an order: its status and the
+// allocations of its lines
+func draft(order *models.SalesOrder) {
+	order.Status = models.SalesOrderDraft
+	for i := range order.Lines {
+		order.Lines[i].Allocations = nil
+	}
+}
+
+// CreateSalesOrder creates order as a draft. It fails with
+// ErrInvalidSalesOrder if the order has no lines or a line lacks a product
+// or a positive quantity, and with ErrInvalidPrice as described for
+// validateLines.
+func (s *InventoryService) CreateSalesOrder(order *models.SalesOrder) error {
+	if err := s.validateLines(order); err != nil {
+		return err
+	}
+	draft(order)
+	return s.repo.CreateSalesOrder(order)
+}
+
+func (s *InventoryService) GetSalesOrder(id string) (*models.SalesOrder, error) {
+	return s.repo.GetSalesOrder(id)
+}
+
+func (s *InventoryService) FindSalesOrders(filter repository.SalesOrderFilter) ([]*models.SalesOrder, error) {
+	return s.repo.FindSalesOrders(filter)
+}
+
+// UpdateSalesOrder replaces the buyer and lines of a draft order. Orders
+// past the draft stage fail with ErrInvalidTransition.
+func (s *InventoryService) UpdateSalesOrder(order *models.SalesOrder) error {
+	if err := s.validateLines(order); err != nil {
+		return err
+	}
+	existing, err := s.repo.GetSalesOrder(order.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Status != models.SalesOrderDraft {
+		return ErrInvalidTransition
+	}
+	draft(order)
+	if err := repository.CheckVersion(existing.Version, order.Version); err != nil {
+		return err
+	}
+	order.Version = existing.Version
+	return s.repo.UpdateSalesOrder(order)
+}
+
+// DeleteSalesOrder deletes a draft or cancelled order. Other orders fail
+// with ErrInvalidTransition.
+func (s *InventoryService) DeleteSalesOrder(id string, version int64) error {
+	existing, err := s.repo.GetSalesOrder(id)
+	if err != nil {
+		return err
+	}
+	if existing.Status != models.SalesOrderDraft && existing.Status != models.SalesOrderCancelled {
+		return ErrInvalidTransition
+	}
+	if err := repository.CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	return s.repo.DeleteSalesOrder(id, existing.Version)
+}
+
+// transition moves an order to status to in a single unit of work, after
+// apply has made the stock changes that go with it. It fails with
+// ErrInvalidTransition if the order's status may not move to to.
+func (s *InventoryService) transition(id string, version int64, to models.SalesOrderStatus,
+	apply func(svc *InventoryService, order *models.SalesOrder) error) (*models.SalesOrder, error) {
+	var order *models.SalesOrder
+	err := s.Atomically(func(svc *InventoryService) error {
+		var err error
+		order, err = svc.repo.GetSalesOrder(id)
+		if err != nil {
+			return err
+		}
+		if err := repository.CheckVersion(order.Version, version); err != nil {
+			return err
+		}
+		if !canTransition(order.Status, to) {
+			return ErrInvalidTransition
+		}
+		if apply != nil {
+			if err := apply(svc, order); err != nil {
+				return err
+			}
+		}
+		order.Status = to
+		return svc.repo.UpdateSalesOrder(order)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// ConfirmSalesOrder confirms a draft order and reserves its stock. Each
+// line is reserved from the inventory items of its product, as much from
+// each as it has available, taking the lots that expire first first and
+// skipping those that have expired; items without an expiry date follow in
+// ID order. A line for a kit that its items cannot cover is made up by
+// reserving, in the same way, the components of the kits still needed. If
+// there is not enough available, it fails with
+// repository.ErrInsufficientStock and nothing is reserved.
+func (s *InventoryService) ConfirmSalesOrder(id string, version int64) (*models.SalesOrder, error) {
+	return s.transition(id, version, models.SalesOrderConfirmed, reserveLines)
+}
+
+// reserveLines reserves the stock for every line of order and records the
+// allocations
+func reserveLines(svc *InventoryService, order *models.SalesOrder) error {
+	now := time.Now()
+	expiresAt := now.Add(salesOrderHold)
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		needed, err := reserveItems(svc, order, i, line.ProductID, line.Quantity, now, expiresAt)
+		if err != nil {
+			return err
+		}
+		if needed > 0 {
+			if err := reserveComponents(svc, order, i, needed, now, expiresAt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reserveItems reserves up to quantity of productID for line i of order
+// from the product's items, recording the allocations, and returns the
+// quantity it could not reserve. Allocations of a product other than the
+// line's name it.
+func reserveItems(svc *InventoryService, order *models.SalesOrder, i int, productID string, quantity float64,
+	now, expiresAt time.Time) (float64, error) {
+	line := &order.Lines[i]
+	items, err := svc.repo.FindInventoryItems(repository.InventoryFilter{ProductID: productID})
+	if err != nil {
+		return 0, err
+	}
+	allocated := ""
+	if productID != line.ProductID {
+		allocated = productID
+	}
+	needed := quantity
+	for _, item := range fefo(items, now) {
+		if needed == 0 {
+			break
+		}
+		quantity := min(needed, item.Available)
+		if quantity <= 0 {
+			continue
+		}
+		reservation := &models.Reservation{
+			ID:        newReservationID(),
+			ItemID:    item.ID,
+			Quantity:  quantity,
+			Owner:     order.ID,
+			ExpiresAt: expiresAt,
+		}
+		if err := svc.repo.CreateReservation(reservation); err != nil {
+			return 0, err
+		}
+		line.Allocations = append(line.Allocations, models.Allocation{
+			ItemID:        item.ID,
+			ReservationID: reservation.ID,
+			Quantity:      quantity,
+			ProductID:     allocated,
+		})
+		needed = models.RoundQuantity(needed - quantity)
+	}
+	return needed, nil
+}
+
+// newReservationID returns a random ID for a reservation made on an
+// order's behalf. Being opaque, it cannot collide with an ID a client
+// chose, nor be reused when lines are reordered and confirmed again.
+func newReservationID() string {
+	return "res-" + strings.ToLower(rand.Text())
+}
+
+// AllocateSalesOrder marks a confirmed order's stock as allocated to it
+func (s *InventoryService) AllocateSalesOrder(id string, version int64) (*models.SalesOrder, error) {
+	return s.transition(id, version, models.SalesOrderAllocated, nil)
+}
+
+// PickSalesOrder marks an allocated order as picked
+func (s *InventoryService) PickSalesOrder(id string, version int64) (*models.SalesOrder, error) {
+	return s.transition(id, version, models.SalesOrderPicked, nil)
+}
+
+// ShipSalesOrder ships a picked order: it posts a shipment movement against
+// each allocated inventory item that consumes the allocation's reservation,
+// or takes available stock if the reservation has expired since. It
+// fails with repository.ErrInsufficientStock if an item no longer has the
+// stock or no longer exists, and with ErrLotExpired if an item's lot has
+// expired since the order was confirmed. Units of a serialized product are
+// marked sold to the order's buyer, as many from each item as it ships.
+func (s *InventoryService) ShipSalesOrder(id string, version int64) (*models.SalesOrder, error) {
+	return s.transition(id, version, models.SalesOrderShipped, shipLines)
+}
+
+func shipLines(svc *InventoryService, order *models.SalesOrder) error {
+	for _, line := range order.Lines {
+		serialized, err := svc.isSerialized(line.ProductID)
+		if err != nil {
+			return err
+		}
+		for _, allocation := range line.Allocations {
+			if err := svc.checkNotExpired(allocation.ItemID); err != nil && err != repository.ErrNotFound {
+				return err
+			}
+			if serialized {
+				if err := svc.sellSerials(allocation.ItemID, allocation.Quantity, order); err != nil {
+					return err
+				}
+			}
+			movement := &models.StockMovement{
+				ItemID:        allocation.ItemID,
+				Type:          models.MovementShipment,
+				Delta:         -allocation.Quantity,
+				Reason:        models.ReasonSale,
+				Reference:     order.ID,
+				Actor:         models.SystemActor,
+				ReservationID: allocation.ReservationID,
+			}
+			if _, err := svc.repo.GetReservation(allocation.ReservationID); err == repository.ErrNotFound {
+				movement.ReservationID = ""
+			} else if err != nil {
+				return err
+			}
+			err := svc.repo.PostMovement(movement, 0)
+			if err == repository.ErrNotFound {
+				return repository.ErrInsufficientStock
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// InvoiceSalesOrder marks a shipped order as invoiced
+func (s *InventoryService) InvoiceSalesOrder(id string, version int64) (*models.SalesOrder, error) {
+	return s.transition(id, version, models.SalesOrderInvoiced, nil)
+}
+
+// CancelSalesOrder cancels an order that has not shipped and releases the
+// stock reserved for it
+func (s *InventoryService) CancelSalesOrder(id string, version int64) (*models.SalesOrder, error) {
+	return s.transition(id, version, models.SalesOrderCancelled, releaseLines)
+}
+
+// releaseLines releases the reservations of order that are still held;
+// those that have expired or were deleted with their item are skipped
+func releaseLines(svc *InventoryService, order *models.SalesOrder) error {
+	for _, line := range order.Lines {
+		for _, allocation := range line.Allocations {
+			err := svc.repo.ReleaseReservation(allocation.ReservationID)
+			if err != nil && err != repository.ErrNotFound {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+// newOrderService returns a service with buyer b1 and product p1 stocked by
+// items i1 and i2 with 10 each, and draft order so1 for quantity of p1
+func newOrderService(t *testing.T, quantity float64) *InventoryService {
+	t.Helper()
+	order := &models.SalesOrder{
+		ID:      "so1",
+		BuyerID: "b1",
+		Lines:   []models.SalesOrderLine{{ProductID: "p1", Quantity: quantity}},
+	}
+	svc := newTestService(t,
+		withBuyers(&models.Buyer{ID: "b1", Name: "Bob"}),
+		withProducts(&models.Product{ID: "p1", Name: "Fertilizer", VendorID: "v1"}),
+		withLocations(activeLocation("wh", models.LocationWarehouse, "")),
+		withItems(
+			&models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 10, Location: "wh"},
+			&models.InventoryItem{ID: "i2", ProductID: "p1", Quantity: 10, Location: "wh"},
+		),
+		withSalesOrders(order),
+	)
+	if order.Status != models.SalesOrderDraft {
+		t.Errorf("Expected a draft order, got %s", order.Status)
+	}
+	return svc
+}
+
+// checkItem fails unless an item has the given on-hand and reserved
+// quantities
+func checkItem(t *testing.T, svc *InventoryService, id string, quantity, reserved float64) {
+	t.Helper()
+	item, err := svc.GetInventoryItem(id)
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Quantity != quantity || item.Reserved != reserved {
+		t.Errorf("Expected %s to have quantity %v and reserved %v, got %v and %v",
+			id, quantity, reserved, item.Quantity, item.Reserved)
+	}
+}
+
+func TestSalesOrderLifecycle(t *testing.T) {
+	svc := newOrderService(t, 15)
+
+	if _, err := svc.PickSalesOrder("so1", 0); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition picking a draft, got %v", err)
+	}
+
+	order, err := svc.ConfirmSalesOrder("so1", 1)
+	if err != nil {
+		t.Fatalf("Failed to confirm sales order: %v", err)
+	}
+	allocations := order.Lines[0].Allocations
+	if order.Status != models.SalesOrderConfirmed || len(allocations) != 2 ||
+		allocations[0].ItemID != "i1" || allocations[0].Quantity != 10 ||
+		allocations[1].ItemID != "i2" || allocations[1].Quantity != 5 {
+		t.Fatalf("Expected a confirmed order allocated 10 from i1 and 5 from i2, got %+v", order)
+	}
+	checkItem(t, svc, "i1", 10, 10)
+	checkItem(t, svc, "i2", 10, 5)
+
+	if err := svc.UpdateSalesOrder(&models.SalesOrder{ID: "so1", BuyerID: "b1", Lines: order.Lines}); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition changing a confirmed order, got %v", err)
+	}
+	if _, err := svc.AllocateSalesOrder("so1", 1); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch, got %v", err)
+	}
+
+	for _, step := range []func(string, int64) (*models.SalesOrder, error){
+		svc.AllocateSalesOrder,
+		svc.PickSalesOrder,
+		svc.ShipSalesOrder,
+	} {
+		if order, err = step("so1", 0); err != nil {
+			t.Fatalf("Failed to advance sales order: %v", err)
+		}
+	}
+	if order.Status != models.SalesOrderShipped {
+		t.Errorf("Expected a shipped order, got %s", order.Status)
+	}
+	checkItem(t, svc, "i1", 0, 0)
+	checkItem(t, svc, "i2", 5, 0)
+	movements, err := svc.ListMovements("i2")
+	if err != nil {
+		t.Fatalf("Failed to list movements: %v", err)
+	}
+	last := movements[len(movements)-1]
+	if last.Type != models.MovementShipment || last.Delta != -5 || last.Reason != models.ReasonSale || last.Reference != "so1" ||
+		last.ReservationID != allocations[1].ReservationID {
+		t.Errorf("Expected a sale shipment of 5 for so1 consuming its reservation, got %+v", last)
+	}
+
+	if _, err := svc.CancelSalesOrder("so1", 0); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition cancelling a shipped order, got %v", err)
+	}
+	if order, err = svc.InvoiceSalesOrder("so1", 0); err != nil || order.Status != models.SalesOrderInvoiced {
+		t.Errorf("Expected an invoiced order, got %v and %v", order, err)
+	}
+}
+
+func TestConfirmSalesOrderBesideClientReservations(t *testing.T) {
+	svc := newOrderService(t, 5)
+
+	// A client reservation with the ID derived from the order, line and
+	// allocation does not stop the order reserving
+	client := &models.Reservation{ID: "so1-0-0", ItemID: "i1", Quantity: 1, Owner: "walk-in", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := svc.CreateReservation(client); err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	order, err := svc.ConfirmSalesOrder("so1", 0)
+	if err != nil {
+		t.Fatalf("Failed to confirm sales order: %v", err)
+	}
+	allocations := order.Lines[0].Allocations
+	if len(allocations) != 1 || allocations[0].ReservationID == client.ID {
+		t.Fatalf("Expected one allocation with its own reservation, got %+v", allocations)
+	}
+	if _, err := svc.GetReservation(allocations[0].ReservationID); err != nil {
+		t.Errorf("Expected the allocation's reservation to exist, got %v", err)
+	}
+	checkItem(t, svc, "i1", 10, 6)
+}
+
+func TestConfirmSalesOrderWithoutStock(t *testing.T) {
+	svc := newOrderService(t, 25)
+
+	if _, err := svc.ConfirmSalesOrder("so1", 0); err != repository.ErrInsufficientStock {
+		t.Fatalf("Expected ErrInsufficientStock, got %v", err)
+	}
+	// The reservation made against i1 before i2 fell short is rolled back
+	checkItem(t, svc, "i1", 10, 0)
+	checkItem(t, svc, "i2", 10, 0)
+	order, err := svc.GetSalesOrder("so1")
+	if err != nil || order.Status != models.SalesOrderDraft || order.Lines[0].Allocations != nil {
+		t.Errorf("Expected so1 to remain an unallocated draft, got %+v and %v", order, err)
+	}
+}
+
+func TestCancelSalesOrderReleasesStock(t *testing.T) {
+	svc := newOrderService(t, 4)
+
+	if _, err := svc.ConfirmSalesOrder("so1", 0); err != nil {
+		t.Fatalf("Failed to confirm sales order: %v", err)
+	}
+	if err := svc.DeleteSalesOrder("so1", 0); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition deleting a confirmed order, got %v", err)
+	}
+	checkItem(t, svc, "i1", 10, 4)
+
+	order, err := svc.CancelSalesOrder("so1", 0)
+	if err != nil || order.Status != models.SalesOrderCancelled {
+		t.Fatalf("Expected a cancelled order, got %v and %v", order, err)
+	}
+	checkItem(t, svc, "i1", 10, 0)
+	if err := svc.DeleteSalesOrder("so1", 0); err != nil {
+		t.Errorf("Failed to delete cancelled order: %v", err)
+	}
+}
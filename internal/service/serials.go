@@ -0,0 +1,314 @@
+package service
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+var (
+	ErrInvalidSerial   = errors.New("invalid serial")
+	ErrSerializedStock = errors.New("stock of a serialized product changes only through its serials")
+)
+
+// serialStatuses are the statuses a serial may have
+var serialStatuses = map[models.SerialStatus]bool{
+	models.SerialInStock:  true,
+	models.SerialSold:     true,
+	models.SerialReturned: true,
+	models.SerialInRepair: true,
+}
+
+// onHand reports whether a unit with status is counted by its item's
+// quantity
+func onHand(status models.SerialStatus) bool {
+	return status == models.SerialInStock || status == models.SerialReturned
+}
+
+// serialItem returns the item id, failing with ErrInvalidSerial unless it
+// exists and holds productID
+func (s *InventoryService) serialItem(id, productID string) (*models.InventoryItem, error) {
+	item, err := s.repo.GetInventoryItem(id)
+	if err == repository.ErrNotFound {
+		return nil, ErrInvalidSerial
+	} else if err != nil {
+		return nil, err
+	}
+	if item.ProductID != productID {
+		return nil, ErrInvalidSerial
+	}
+	return item, nil
+}
+
+// postSerialMovement posts a movement of one unit of serial to or from an
+// item, referencing the serial
+func (s *InventoryService) postSerialMovement(serial *models.Serial, itemID string, movementType models.MovementType, delta float64, reason string) error {
+	return s.repo.PostMovement(&models.StockMovement{
+		ItemID:    itemID,
+		Type:      movementType,
+		Delta:     delta,
+		Reason:    reason,
+		Reference: serial.ID,
+		Actor:     models.SystemActor,
+	}, 0)
+}
+
+// CreateSerial registers a unit of a serialized product held by an item of
+// that product, in stock unless the serial gives another status. The
+// serial takes its location from the item, and a unit that is in stock or
+// returned is added to the item's quantity. It fails with ErrInvalidSerial
+// unless the serial has an ID and a known status and the product is
+// serialized and matches the item's.
+func (s *InventoryService) CreateSerial(serial *models.Serial) error {
+	if serial.Status == "" {
+		serial.Status = models.SerialInStock
+	}
+	if serial.ID == "" || !serialStatuses[serial.Status] {
+		return ErrInvalidSerial
+	}
+	return s.Atomically(func(svc *InventoryService) error {
+		product, err := svc.repo.GetProduct(serial.ProductID)
+		if err == repository.ErrNotFound {
+			return repository.ErrInvalidReference
+		} else if err != nil {
+			return err
+		}
+		if !product.Serialized {
+			return ErrInvalidSerial
+		}
+		item, err := svc.serialItem(serial.ItemID, serial.ProductID)
+		if err != nil {
+			return err
+		}
+		serial.Location = item.Location
+		if err := svc.repo.CreateSerial(serial); err != nil {
+			return err
+		}
+		switch serial.Status {
+		case models.SerialInStock:
+			return svc.postSerialMovement(serial, item.ID, models.MovementReceipt, 1, models.ReasonOpeningBalance)
+		case models.SerialReturned:
+			return svc.postSerialMovement(serial, item.ID, models.MovementReturn, 1, models.ReasonReturn)
+		}
+		return nil
+	})
+}
+
+func (s *InventoryService) GetSerial(id string) (*models.Serial, error) {
+	return s.repo.GetSerial(id)
+}
+
+func (s *InventoryService) FindSerials(filter repository.SerialFilter) ([]*models.Serial, error) {
+	return s.repo.FindSerials(filter)
+}
+
+// UpdateSerial changes the status, item, buyer or reference of a unit and
+// keeps its items' quantities in step, posting a movement of one unit for
+// each item it leaves or joins: a shipment when it is sold, a transfer for
+// repair when it goes in for repair or comes back, a return when a sold unit
+// comes back, and a transfer when it moves between items. It fails with
+// ErrInvalidSerial if the product changes, the status is unknown or the item
+// is missing or holds another product.
+func (s *InventoryService) UpdateSerial(serial *models.Serial) error {
+	if !serialStatuses[serial.Status] {
+		return ErrInvalidSerial
+	}
+	return s.Atomically(func(svc *InventoryService) error {
+		existing, err := svc.repo.GetSerial(serial.ID)
+		if err != nil {
+			return err
+		}
+		if serial.ProductID != existing.ProductID {
+			return ErrInvalidSerial
+		}
+		item, err := svc.serialItem(serial.ItemID, serial.ProductID)
+		if err != nil {
+			return err
+		}
+		serial.Location = item.Location
+		if err := repository.CheckVersion(existing.Version, serial.Version); err != nil {
+			return err
+		}
+		serial.Version = existing.Version
+		if err := svc.repo.UpdateSerial(serial); err != nil {
+			return err
+		}
+		return svc.moveSerial(existing, serial)
+	})
+}
+
+// moveSerial posts the movements that take a unit from how it was to how it
+// is
+func (s *InventoryService) moveSerial(was, is *models.Serial) error {
+	switch {
+	case onHand(was.Status) && onHand(is.Status):
+		if was.ItemID == is.ItemID {
+			return nil
+		}
+		if err := s.postSerialMovement(is, was.ItemID, models.MovementTransferOut, -1, models.ReasonTransfer); err != nil {
+			return err
+		}
+		return s.postSerialMovement(is, is.ItemID, models.MovementTransferIn, 1, models.ReasonTransfer)
+	case onHand(was.Status):
+		if is.Status == models.SerialSold {
+			return s.postSerialMovement(is, was.ItemID, models.MovementShipment, -1, models.ReasonSale)
+		}
+		return s.postSerialMovement(is, was.ItemID, models.MovementTransferOut, -1, models.ReasonRepair)
+	case onHand(is.Status):
+		if was.Status == models.SerialInRepair {
+			return s.postSerialMovement(is, is.ItemID, models.MovementTransferIn, 1, models.ReasonRepair)
+		}
+		return s.postSerialMovement(is, is.ItemID, models.MovementReturn, 1, models.ReasonReturn)
+	}
+	return nil
+}
+
+// DeleteSerial deletes a serial registered in error. A unit that is in
+// stock or returned is taken off its item's quantity by a stock count
+// adjustment.
+func (s *InventoryService) DeleteSerial(id string, version int64) error {
+	return s.Atomically(func(svc *InventoryService) error {
+		existing, err := svc.repo.GetSerial(id)
+		if err != nil {
+			return err
+		}
+		if err := repository.CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		if err := svc.repo.DeleteSerial(id, existing.Version); err != nil {
+			return err
+		}
+		if !onHand(existing.Status) {
+			return nil
+		}
+		return svc.postSerialMovement(existing, existing.ItemID, models.MovementAdjustment, -1, models.ReasonStockCount)
+	})
+}
+
+// sellSerials marks quantity units held by an item as sold to the buyer of
+// order, units in stock before returned ones, in serial order. It does not
+// post movements; the caller ships the units. It fails with
+// repository.ErrInsufficientStock if the item holds too few units.
+func (s *InventoryService) sellSerials(itemID string, quantity float64, order *models.SalesOrder) error {
+	serials, err := s.repo.FindSerials(repository.SerialFilter{ItemID: itemID})
+	if err != nil {
+		return err
+	}
+	serials = slices.DeleteFunc(serials, func(serial *models.Serial) bool {
+		return !onHand(serial.Status)
+	})
+	slices.SortStableFunc(serials, func(a, b *models.Serial) int {
+		switch {
+		case a.Status == b.Status:
+			return 0
+		case a.Status == models.SerialInStock:
+			return -1
+		}
+		return 1
+	})
+	if float64(len(serials)) < quantity {
+		return repository.ErrInsufficientStock
+	}
+	for _, serial := range serials[:int(quantity)] {
+		serial.Status = models.SerialSold
+		serial.BuyerID = order.BuyerID
+		serial.Reference = order.ID
+		if err := s.repo.UpdateSerial(serial); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiveSerials registers units received into item as in stock. It does
+// not post movements; the caller receives the units.
+func (s *InventoryService) receiveSerials(item *models.InventoryItem, ids []string, reference string) error {
+	for _, id := range ids {
+		err := s.repo.CreateSerial(&models.Serial{
+			ID:        id,
+			ProductID: item.ProductID,
+			ItemID:    item.ID,
+			Location:  item.Location,
+			Status:    models.SerialInStock,
+			Reference: reference,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSerialized reports whether productID is a serialized product. A
+// missing product is not.
+func (s *InventoryService) isSerialized(productID string) (bool, error) {
+	product, err := s.repo.GetProduct(productID)
+	if err == repository.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return product.Serialized, nil
+}
+
+// checkNotSerialized fails with ErrSerializedStock if an item holds a
+// serialized product, whose quantity may only change through its serials
+func (s *InventoryService) checkNotSerialized(itemID string) error {
+	item, err := s.repo.GetInventoryItem(itemID)
+	if err != nil {
+		return err
+	}
+	serialized, err := s.isSerialized(item.ProductID)
+	if err != nil {
+		return err
+	}
+	if serialized {
+		return ErrSerializedStock
+	}
+	return nil
+}
+
+// checkItemSerials fails with ErrSerializedStock if an item that holds
+// serials is changing product or location, which would leave its serials
+// behind
+func (s *InventoryService) checkItemSerials(existing, item *models.InventoryItem) error {
+	if existing.ProductID == item.ProductID && existing.Location == item.Location {
+		return nil
+	}
+	serials, err := s.repo.FindSerials(repository.SerialFilter{ItemID: existing.ID})
+	if err != nil {
+		return err
+	}
+	if len(serials) > 0 {
+		return ErrSerializedStock
+	}
+	return nil
+}
+
+// checkSerializedChange fails with ErrSerializedStock if a product is
+// becoming serialized or ceasing to be while it has stock or serials, whose
+// counts would then disagree
+func (s *InventoryService) checkSerializedChange(existing, product *models.Product) error {
+	if existing.Serialized == product.Serialized {
+		return nil
+	}
+	items, err := s.repo.FindInventoryItems(repository.InventoryFilter{ProductID: existing.ID})
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if item.Quantity != 0 {
+			return ErrSerializedStock
+		}
+	}
+	serials, err := s.repo.FindSerials(repository.SerialFilter{ProductID: existing.ID})
+	if err != nil {
+		return err
+	}
+	if len(serials) > 0 {
+		return ErrSerializedStock
+	}
+	return nil
+}
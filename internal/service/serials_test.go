@@ -0,0 +1,257 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+// newSerialService returns a service with buyer b1, serialized product mower
+// held by empty items m1 at warehouse wh-a and m2 at wh-b, units SN-1, SN-2
+// and SN-3 in stock in m1, and product p1 stocked by item i1 with 5
+func newSerialService(t *testing.T) *InventoryService {
+	t.Helper()
+	return newTestService(t,
+		withBuyers(&models.Buyer{ID: "b1", Name: "Bob"}),
+		withProducts(
+			&models.Product{ID: "mower", Name: "Ride-on Mower", VendorID: "v1", Serialized: true},
+			&models.Product{ID: "p1", Name: "Spark Plug", VendorID: "v1"},
+		),
+		withLocations(
+			activeLocation("wh-a", models.LocationWarehouse, ""),
+			activeLocation("wh-b", models.LocationWarehouse, ""),
+		),
+		withItems(
+			&models.InventoryItem{ID: "m1", ProductID: "mower", Location: "wh-a"},
+			&models.InventoryItem{ID: "m2", ProductID: "mower", Location: "wh-b"},
+			&models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 5, Location: "wh-a"},
+		),
+		withSerials(
+			&models.Serial{ID: "SN-1", ProductID: "mower", ItemID: "m1"},
+			&models.Serial{ID: "SN-2", ProductID: "mower", ItemID: "m1"},
+			&models.Serial{ID: "SN-3", ProductID: "mower", ItemID: "m1"},
+		),
+	)
+}
+
+// changeSerial applies change to serial id and stores it
+func changeSerial(t *testing.T, svc *InventoryService, id string, change func(*models.Serial)) error {
+	t.Helper()
+	serial, err := svc.GetSerial(id)
+	if err != nil {
+		t.Fatalf("Failed to get serial: %v", err)
+	}
+	change(serial)
+	return svc.UpdateSerial(serial)
+}
+
+func TestCreateSerialValidation(t *testing.T) {
+	svc := newSerialService(t)
+	checkItem(t, svc, "m1", 3, 0)
+
+	serial, err := svc.GetSerial("SN-1")
+	if err != nil {
+		t.Fatalf("Failed to get serial: %v", err)
+	}
+	if serial.Status != models.SerialInStock || serial.Location != "wh-a" {
+		t.Errorf("Expected SN-1 in stock at wh-a, got %+v", serial)
+	}
+
+	invalid := []*models.Serial{
+		{ProductID: "mower", ItemID: "m1"},
+		{ID: "SN-9", ProductID: "mower", ItemID: "m1", Status: "lost"},
+		{ID: "SN-9", ProductID: "p1", ItemID: "i1"},
+		{ID: "SN-9", ProductID: "mower", ItemID: "i1"},
+		{ID: "SN-9", ProductID: "mower", ItemID: "missing"},
+	}
+	for _, serial := range invalid {
+		if err := svc.CreateSerial(serial); err != ErrInvalidSerial {
+			t.Errorf("Expected ErrInvalidSerial for %+v, got %v", serial, err)
+		}
+	}
+	if err := svc.CreateSerial(&models.Serial{ID: "SN-1", ProductID: "mower", ItemID: "m1"}); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+	checkItem(t, svc, "m1", 3, 0)
+
+	// A unit registered as sold is not in stock
+	if err := svc.CreateSerial(&models.Serial{ID: "SN-9", ProductID: "mower", ItemID: "m2", Status: models.SerialSold}); err != nil {
+		t.Fatalf("Failed to create sold serial: %v", err)
+	}
+	checkItem(t, svc, "m2", 0, 0)
+}
+
+func TestSerializedStockChangesOnlyThroughSerials(t *testing.T) {
+	svc := newSerialService(t)
+
+	receipt := &models.StockMovement{ItemID: "m1", Type: models.MovementReceipt, Delta: 1, Reason: models.ReasonPurchase, Actor: "alice"}
+	if err := svc.PostMovement(receipt, 0); err != ErrSerializedStock {
+		t.Errorf("Expected ErrSerializedStock posting a movement, got %v", err)
+	}
+	if _, err := svc.UpdateInventoryQuantity("m1", 4, "", 0); err != ErrSerializedStock {
+		t.Errorf("Expected ErrSerializedStock counting stock, got %v", err)
+	}
+	if err := svc.CreateInventoryItem(&models.InventoryItem{ID: "m3", ProductID: "mower", Quantity: 1}); err != ErrSerializedStock {
+		t.Errorf("Expected ErrSerializedStock creating an item with stock, got %v", err)
+	}
+	if _, err := svc.PatchInventoryItem("m1", 0, func(i *models.InventoryItem) error {
+		i.Location = "wh-b"
+		return nil
+	}); err != ErrSerializedStock {
+		t.Errorf("Expected ErrSerializedStock moving an item with serials, got %v", err)
+	}
+	if _, err := svc.PatchProduct("mower", 0, func(p *models.Product) error {
+		p.Serialized = false
+		return nil
+	}); err != ErrSerializedStock {
+		t.Errorf("Expected ErrSerializedStock unserializing a product with serials, got %v", err)
+	}
+	if _, err := svc.PatchProduct("p1", 0, func(p *models.Product) error {
+		p.Serialized = true
+		return nil
+	}); err != ErrSerializedStock {
+		t.Errorf("Expected ErrSerializedStock serializing a product with stock, got %v", err)
+	}
+	if err := svc.DeleteInventoryItem("m1", 0); err != repository.ErrInUse {
+		t.Errorf("Expected ErrInUse deleting an item with serials, got %v", err)
+	}
+	checkItem(t, svc, "m1", 3, 0)
+}
+
+func TestSerialLifecycle(t *testing.T) {
+	svc := newSerialService(t)
+
+	steps := []struct {
+		change   func(*models.Serial)
+		m1, m2   float64
+		movement models.MovementType
+		reason   string
+	}{
+		{func(s *models.Serial) { s.Status = models.SerialSold; s.BuyerID = "b1" }, 2, 0, models.MovementShipment, models.ReasonSale},
+		{func(s *models.Serial) { s.Status = models.SerialReturned }, 3, 0, models.MovementReturn, models.ReasonReturn},
+		{func(s *models.Serial) { s.Status = models.SerialInRepair }, 2, 0, models.MovementTransferOut, models.ReasonRepair},
+		{func(s *models.Serial) { s.Status = models.SerialInStock }, 3, 0, models.MovementTransferIn, models.ReasonRepair},
+		{func(s *models.Serial) { s.ItemID = "m2" }, 2, 1, models.MovementTransferIn, models.ReasonTransfer},
+	}
+	for i, step := range steps {
+		if err := changeSerial(t, svc, "SN-1", step.change); err != nil {
+			t.Fatalf("Step %d: failed to update serial: %v", i, err)
+		}
+		checkItem(t, svc, "m1", step.m1, 0)
+		checkItem(t, svc, "m2", step.m2, 0)
+
+		serial, _ := svc.GetSerial("SN-1")
+		movements, err := svc.ListMovements(serial.ItemID)
+		if err != nil {
+			t.Fatalf("Failed to list movements: %v", err)
+		}
+		last := movements[len(movements)-1]
+		if last.Type != step.movement || last.Reason != step.reason || last.Reference != "SN-1" {
+			t.Errorf("Step %d: expected a %s for %s referencing SN-1, got %+v", i, step.movement, step.reason, last)
+		}
+	}
+
+	serial, err := svc.GetSerial("SN-1")
+	if err != nil {
+		t.Fatalf("Failed to get serial: %v", err)
+	}
+	if serial.Location != "wh-b" || serial.BuyerID != "b1" {
+		t.Errorf("Expected SN-1 at wh-b last sold to b1, got %+v", serial)
+	}
+	if err := changeSerial(t, svc, "SN-1", func(s *models.Serial) { s.ProductID = "p1" }); err != ErrInvalidSerial {
+		t.Errorf("Expected ErrInvalidSerial changing the product, got %v", err)
+	}
+
+	history, err := svc.SerialHistory("SN-1")
+	if err != nil {
+		t.Fatalf("Failed to get serial history: %v", err)
+	}
+	statuses := []models.SerialStatus{models.SerialInStock, models.SerialSold, models.SerialReturned,
+		models.SerialInRepair, models.SerialInStock, models.SerialInStock}
+	if len(history) != len(statuses) {
+		t.Fatalf("Expected %d revisions, got %d", len(statuses), len(history))
+	}
+	for i, status := range statuses {
+		if history[i].Data.Status != status {
+			t.Errorf("Revision %d: expected %s, got %s", i, status, history[i].Data.Status)
+		}
+	}
+
+	if err := svc.DeleteSerial("SN-1", 0); err != nil {
+		t.Fatalf("Failed to delete serial: %v", err)
+	}
+	checkItem(t, svc, "m2", 0, 0)
+}
+
+func TestSalesOrderSellsSerials(t *testing.T) {
+	svc := newSerialService(t)
+	if err := changeSerial(t, svc, "SN-1", func(s *models.Serial) { s.Status = models.SerialReturned }); err != nil {
+		t.Fatalf("Failed to update serial: %v", err)
+	}
+
+	order := &models.SalesOrder{ID: "so1", BuyerID: "b1", Lines: []models.SalesOrderLine{{ProductID: "mower", Quantity: 2}}}
+	if err := svc.CreateSalesOrder(order); err != nil {
+		t.Fatalf("Failed to create sales order: %v", err)
+	}
+	for _, transition := range []func(string, int64) (*models.SalesOrder, error){
+		svc.ConfirmSalesOrder, svc.AllocateSalesOrder, svc.PickSalesOrder, svc.ShipSalesOrder,
+	} {
+		if _, err := transition("so1", 0); err != nil {
+			t.Fatalf("Failed to advance sales order: %v", err)
+		}
+	}
+	checkItem(t, svc, "m1", 1, 0)
+
+	sold, err := svc.FindSerials(repository.SerialFilter{BuyerID: "b1", Status: models.SerialSold})
+	if err != nil {
+		t.Fatalf("Failed to find serials: %v", err)
+	}
+	if len(sold) != 2 || sold[0].ID != "SN-2" || sold[1].ID != "SN-3" || sold[0].Reference != "so1" {
+		t.Errorf("Expected SN-2 and SN-3 sold on so1 before the returned SN-1, got %v", sold)
+	}
+}
+
+func TestPurchaseOrderReceivesSerials(t *testing.T) {
+	svc := newSerialService(t)
+	order := &models.PurchaseOrder{
+		ID:       "po1",
+		VendorID: "v1",
+		Lines:    []models.PurchaseOrderLine{{ProductID: "mower", Quantity: 2, ExpectedAt: time.Now()}},
+	}
+	if err := svc.CreatePurchaseOrder(order); err != nil {
+		t.Fatalf("Failed to create purchase order: %v", err)
+	}
+	if _, err := svc.IssuePurchaseOrder("po1", 0); err != nil {
+		t.Fatalf("Failed to issue purchase order: %v", err)
+	}
+
+	for _, serials := range [][]string{nil, {"SN-4"}} {
+		receipt := Receipt{ItemID: "m2", Quantity: 2, Serials: serials}
+		if _, _, err := svc.ReceivePurchaseOrder("po1", 0, receipt); err != ErrInvalidReceipt {
+			t.Errorf("Expected ErrInvalidReceipt for serials %v, got %v", serials, err)
+		}
+	}
+	receipt := Receipt{ItemID: "m2", Quantity: 2, Serials: []string{"SN-4", "SN-1"}}
+	if _, _, err := svc.ReceivePurchaseOrder("po1", 0, receipt); err != repository.ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists receiving a known serial, got %v", err)
+	}
+	checkItem(t, svc, "m2", 0, 0)
+
+	receipt.Serials = []string{"SN-4", "SN-5"}
+	if _, _, err := svc.ReceivePurchaseOrder("po1", 0, receipt); err != nil {
+		t.Fatalf("Failed to receive purchase order: %v", err)
+	}
+	checkItem(t, svc, "m2", 2, 0)
+	serials, err := svc.FindSerials(repository.SerialFilter{ItemID: "m2", Status: models.SerialInStock})
+	if err != nil || len(serials) != 2 || serials[0].Location != "wh-b" || serials[0].Reference != "po1" {
+		t.Errorf("Expected SN-4 and SN-5 in stock at wh-b from po1, got %v and %v", serials, err)
+	}
+
+	transfer := &models.TransferOrder{ID: "to1", FromLocation: "wh-b", ToLocation: "wh-a",
+		Lines: []models.TransferOrderLine{{ProductID: "mower", Quantity: 1}}}
+	if err := svc.CreateTransferOrder(transfer); err != ErrInvalidTransferOrder {
+		t.Errorf("Expected ErrInvalidTransferOrder transferring serialized units, got %v", err)
+	}
+}
@@ -1,18 +1,135 @@
 package service
 
 import (
+	"errors"
+	"slices"
+	"time"
+
 	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
 	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
 )
 
+var (
+	ErrInvalidMovement    = errors.New("invalid stock movement")
+	ErrInvalidReservation = errors.New("invalid reservation")
+	ErrInvalidPrice       = errors.New("invalid price")
+)
+
 // InventoryService provides business logic for inventory management
 type InventoryService struct {
-	repo *repository.InMemoryRepository
+	repo  repository.EntityStore
+	store repository.Store // nil when the service is bound to a unit of work
+}
+
+// NewInventoryService creates a new inventory service backed by repo
+func NewInventoryService(repo repository.Store) *InventoryService {
+	return &InventoryService{repo: repo, store: repo}
+}
+
+// Atomically runs fn with a service whose operations are staged in a single
+// unit of work, which is committed if fn returns nil and rolled back
+// otherwise. Inside fn, operations see each other's effects. If s is itself
+// bound to a unit of work, fn joins it.
+func (s *InventoryService) Atomically(fn func(svc *InventoryService) error) error {
+	if s.store == nil {
+		return fn(s)
+	}
+
+	tx, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	if err := fn(&InventoryService{repo: tx}); err != nil {
+		return err
+	}
+	committed = true
+	return tx.Commit()
+}
+
+var errSnapshotInUnitOfWork = errors.New("snapshots cannot be taken inside a unit of work")
+
+// Snapshot takes a point-in-time snapshot of the store. The caller must
+// close it.
+func (s *InventoryService) Snapshot() (repository.Snapshot, error) {
+	if s.store == nil {
+		return nil, errSnapshotInUnitOfWork
+	}
+	return s.store.Snapshot()
+}
+
+var errChangesInUnitOfWork = errors.New("the change log cannot be read inside a unit of work")
+
+// Changes returns up to limit change events after seq after, oldest first
+func (s *InventoryService) Changes(after int64, limit int) ([]*models.ChangeEvent, error) {
+	if s.store == nil {
+		return nil, errChangesInUnitOfWork
+	}
+	return s.store.Changes(after, limit)
+}
+
+// Subscribe delivers the change events after seq after, then each event as
+// it is committed. The caller must close the subscription.
+func (s *InventoryService) Subscribe(after int64) (*repository.Subscription, error) {
+	if s.store == nil {
+		return nil, errChangesInUnitOfWork
+	}
+	return s.store.Subscribe(after)
+}
+
+// CompactChanges removes change events older than retention that are not
+// needed to reconstruct states since, and returns how many it removed
+func (s *InventoryService) CompactChanges(retention time.Duration) (int, error) {
+	if s.store == nil {
+		return 0, errChangesInUnitOfWork
+	}
+	return s.store.CompactChanges(time.Now().Add(-retention))
+}
+
+// History operations
+
+func (s *InventoryService) ProductHistory(id string) ([]repository.Revision[models.Product], error) {
+	if s.store == nil {
+		return nil, errChangesInUnitOfWork
+	}
+	return repository.ProductHistory(s.store, id)
+}
+
+func (s *InventoryService) InventoryItemHistory(id string) ([]repository.Revision[models.InventoryItem], error) {
+	if s.store == nil {
+		return nil, errChangesInUnitOfWork
+	}
+	return repository.InventoryItemHistory(s.store, id)
+}
+
+func (s *InventoryService) SerialHistory(id string) ([]repository.Revision[models.Serial], error) {
+	if s.store == nil {
+		return nil, errChangesInUnitOfWork
+	}
+	return repository.SerialHistory(s.store, id)
+}
+
+// ProductsAsOf returns the products matching filter as they were at t
+func (s *InventoryService) ProductsAsOf(t time.Time, filter repository.ProductFilter) ([]*models.Product, error) {
+	if s.store == nil {
+		return nil, errChangesInUnitOfWork
+	}
+	return repository.ProductsAsOf(s.store, t, filter)
 }
 
-// NewInventoryService creates a new inventory service
-func NewInventoryService(repo *repository.InMemoryRepository) *InventoryService {
-	return &InventoryService{repo: repo}
+// InventoryItemsAsOf returns the inventory items matching filter as they
+// were at t
+func (s *InventoryService) InventoryItemsAsOf(t time.Time, filter repository.InventoryFilter) ([]*models.InventoryItem, error) {
+	if s.store == nil {
+		return nil, errChangesInUnitOfWork
+	}
+	return repository.InventoryItemsAsOf(s.store, t, filter)
 }
 
 // Seller operations
@@ -29,6 +146,37 @@ func (s *InventoryService) ListSellers() ([]*models.Seller, error) {
 	return s.repo.ListSellers()
 }
 
+func (s *InventoryService) UpdateSeller(seller *models.Seller) error {
+	return s.repo.UpdateSeller(seller)
+}
+
+// PatchSeller applies patch to a copy of the stored seller and saves the result.
+// It fails with repository.ErrVersionMismatch if version is non-zero and
+// differs from the stored version, or if the seller changes concurrently.
+func (s *InventoryService) PatchSeller(id string, version int64, patch func(*models.Seller) error) (*models.Seller, error) {
+	existing, err := s.repo.GetSeller(id)
+	if err != nil {
+		return nil, err
+	}
+	seller := *existing
+	if err := patch(&seller); err != nil {
+		return nil, err
+	}
+	seller.ID = id
+	if err := repository.CheckVersion(existing.Version, version); err != nil {
+		return nil, err
+	}
+	seller.Version = existing.Version
+	if err := s.repo.UpdateSeller(&seller); err != nil {
+		return nil, err
+	}
+	return &seller, nil
+}
+
+func (s *InventoryService) DeleteSeller(id string, version int64) error {
+	return s.repo.DeleteSeller(id, version)
+}
+
 // Buyer operations
 
 func (s *InventoryService) CreateBuyer(buyer *models.Buyer) error {
@@ -43,6 +191,36 @@ func (s *InventoryService) ListBuyers() ([]*models.Buyer, error) {
 	return s.repo.ListBuyers()
 }
 
+func (s *InventoryService) UpdateBuyer(buyer *models.Buyer) error {
+	return s.repo.UpdateBuyer(buyer)
+}
+
+// PatchBuyer applies patch to a copy of the stored buyer and saves the result.
+// Versions are checked as in PatchSeller.
+func (s *InventoryService) PatchBuyer(id string, version int64, patch func(*models.Buyer) error) (*models.Buyer, error) {
+	existing, err := s.repo.GetBuyer(id)
+	if err != nil {
+		return nil, err
+	}
+	buyer := *existing
+	if err := patch(&buyer); err != nil {
+		return nil, err
+	}
+	buyer.ID = id
+	if err := repository.CheckVersion(existing.Version, version); err != nil {
+		return nil, err
+	}
+	buyer.Version = existing.Version
+	if err := s.repo.UpdateBuyer(&buyer); err != nil {
+		return nil, err
+	}
+	return &buyer, nil
+}
+
+func (s *InventoryService) DeleteBuyer(id string, version int64) error {
+	return s.repo.DeleteBuyer(id, version)
+}
+
 // Vendor operations
 
 func (s *InventoryService) CreateVendor(vendor *models.Vendor) error {
@@ -57,17 +235,100 @@ func (s *InventoryService) ListVendors() ([]*models.Vendor, error) {
 	return s.repo.ListVendors()
 }
 
+func (s *InventoryService) UpdateVendor(vendor *models.Vendor) error {
+	return s.repo.UpdateVendor(vendor)
+}
+
+// PatchVendor applies patch to a copy of the stored vendor and saves the result.
+// Versions are checked as in PatchSeller.
+func (s *InventoryService) PatchVendor(id string, version int64, patch func(*models.Vendor) error) (*models.Vendor, error) {
+	existing, err := s.repo.GetVendor(id)
+	if err != nil {
+		return nil, err
+	}
+	vendor := *existing
+	if err := patch(&vendor); err != nil {
+		return nil, err
+	}
+	vendor.ID = id
+	if err := repository.CheckVersion(existing.Version, version); err != nil {
+		return nil, err
+	}
+	vendor.Version = existing.Version
+	if err := s.repo.UpdateVendor(&vendor); err != nil {
+		return nil, err
+	}
+	return &vendor, nil
+}
+
+func (s *InventoryService) DeleteVendor(id string, version int64, cascade bool) error {
+	return s.repo.DeleteVendor(id, version, cascade)
+}
+
 // Product operations
 
+// CreateProduct creates a product of an existing vendor. It fails with
+// ErrInvalidPrice as described for validatePrice and with ErrInvalidUnit
+// as described for validateUnits. A variant takes its vendor
+// and category from its parent and fails as described for validateVariant,
+// and a kit fails as described for validateKit.
 func (s *InventoryService) CreateProduct(product *models.Product) error {
+	if err := s.validateVariant(product); err != nil {
+		return err
+	}
 	// Verify vendor exists before creating product
 	_, err := s.repo.GetVendor(product.VendorID)
 	if err != nil {
 		return err
 	}
+	if err := validatePrice(product); err != nil {
+		return err
+	}
+	if err := validateUnits(product); err != nil {
+		return err
+	}
+	if err := s.validateKit(product); err != nil {
+		return err
+	}
 	return s.repo.CreateProduct(product)
 }
 
+// validatePrice fails with ErrInvalidPrice unless product's price is not
+// negative and in one of models.Currencies. A price of nothing in no
+// currency becomes one in models.DefaultCurrency.
+func validatePrice(product *models.Product) error {
+	if product.Price == (models.Money{}) {
+		product.Price.Currency = models.DefaultCurrency
+	}
+	if !product.Price.Valid() || product.Price.Sign() < 0 {
+		return ErrInvalidPrice
+	}
+	return nil
+}
+
+// validateLinePrices fails with ErrInvalidPrice unless the prices of an
+// order's lines are not negative and all in the same one of
+// models.Currencies. Prices of nothing in no currency take the currency of
+// the others, or models.DefaultCurrency if none has one.
+func validateLinePrices(prices []*models.Money) error {
+	currency := models.DefaultCurrency
+	for _, price := range prices {
+		if price.Currency != "" {
+			currency = price.Currency
+			break
+		}
+	}
+	for _, price := range prices {
+		if *price == (models.Money{}) {
+			price.Currency = currency
+		}
+		if !price.Valid() || price.Sign() < 0 || price.Currency != currency {
+			return ErrInvalidPrice
+		}
+	}
+	return nil
+}
+
 func (s *InventoryService) GetProduct(id string) (*models.Product, error) {
 	return s.repo.GetProduct(id)
 }
@@ -76,25 +337,381 @@ func (s *InventoryService) ListProducts() ([]*models.Product, error) {
 	return s.repo.ListProducts()
 }
 
-// Inventory operations
+func (s *InventoryService) FindProducts(filter repository.ProductFilter) ([]*models.Product, error) {
+	return s.repo.FindProducts(filter)
+}
 
-func (s *InventoryService) CreateInventoryItem(item *models.InventoryItem) error {
-	// Verify product exists before creating inventory item
-	_, err := s.repo.GetProduct(item.ProductID)
-	if err != nil {
+// ListVendorProducts returns a vendor's products, or ErrNotFound if the
+// vendor does not exist
+func (s *InventoryService) ListVendorProducts(vendorID string) ([]*models.Product, error) {
+	if _, err := s.repo.GetVendor(vendorID); err != nil {
+		return nil, err
+	}
+	return s.repo.FindProducts(repository.ProductFilter{VendorID: vendorID})
+}
+
+// UpdateProduct replaces a product. It fails with ErrSerializedStock as
+// described for checkSerializedChange, with ErrInvalidPrice as described
+// for validatePrice, with ErrInvalidUnit as described for validateUnits,
+// with ErrBaseUnitInUse as described for checkBaseUnitChange, with
+// ErrInvalidVariant or ErrVariantExists as described for validateVariant
+// and checkAxesChange and with ErrInvalidKit as described for validateKit.
+// The product's variants take its new vendor and category.
+func (s *InventoryService) UpdateProduct(product *models.Product) error {
+	return s.Atomically(func(svc *InventoryService) error {
+		existing, err := svc.repo.GetProduct(product.ID)
+		if err != nil {
+			return err
+		}
+		if err := svc.checkProductChange(existing, product); err != nil {
+			return err
+		}
+		if err := svc.repo.UpdateProduct(product); err != nil {
+			return err
+		}
+		return svc.updateVariants(product)
+	})
+}
+
+// checkProductChange fails unless product may replace existing
+func (s *InventoryService) checkProductChange(existing, product *models.Product) error {
+	if err := s.validateVariant(product); err != nil {
+		return err
+	}
+	if err := s.checkAxesChange(product); err != nil {
+		return err
+	}
+	if err := s.checkSerializedChange(existing, product); err != nil {
 		return err
 	}
-	return s.repo.CreateInventoryItem(item)
+	if err := validatePrice(product); err != nil {
+		return err
+	}
+	if err := validateUnits(product); err != nil {
+		return err
+	}
+	if err := s.validateKit(product); err != nil {
+		return err
+	}
+	return s.checkBaseUnitChange(existing, product)
+}
+
+// PatchProduct applies patch to a copy of the stored product and saves the result.
+// Versions are checked as in PatchSeller, and it fails as UpdateProduct does.
+func (s *InventoryService) PatchProduct(id string, version int64, patch func(*models.Product) error) (*models.Product, error) {
+	var product *models.Product
+	err := s.Atomically(func(svc *InventoryService) error {
+		existing, err := svc.repo.GetProduct(id)
+		if err != nil {
+			return err
+		}
+		product = existing.Clone()
+		if err := patch(product); err != nil {
+			return err
+		}
+		product.ID = id
+		if err := svc.checkProductChange(existing, product); err != nil {
+			return err
+		}
+		if err := repository.CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		product.Version = existing.Version
+		if err := svc.repo.UpdateProduct(product); err != nil {
+			return err
+		}
+		return svc.updateVariants(product)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+func (s *InventoryService) DeleteProduct(id string, version int64, cascade bool) error {
+	return s.repo.DeleteProduct(id, version, cascade)
+}
+
+// Inventory operations
+
+// CreateInventoryItem creates an item of an existing product, converting
+// its opening quantity from the item's unit to the product's base unit. It
+// fails with ErrLocationUnavailable unless the item's location is active,
+// with ErrInvalidLot as described for checkItemLot, with ErrInvalidUnit or
+// ErrFractionalQuantity as described for toBase, and with ErrSerializedStock
+// if the product is serialized and the item has a quantity, since serials
+// bring its stock in.
+func (s *InventoryService) CreateInventoryItem(item *models.InventoryItem) error {
+	return s.Atomically(func(svc *InventoryService) error {
+		// Verify product exists before creating inventory item
+		product, err := svc.repo.GetProduct(item.ProductID)
+		if err != nil {
+			return err
+		}
+		if item.Quantity, err = toBase(product, item.Quantity, item.Unit); err != nil {
+			return err
+		}
+		item.Unit = baseUnit(product)
+		if product.Serialized && item.Quantity != 0 {
+			return ErrSerializedStock
+		}
+		if err := svc.checkItemLocation(item.Location); err != nil {
+			return err
+		}
+		if err := svc.checkItemLot(item); err != nil {
+			return err
+		}
+		return svc.repo.CreateInventoryItem(item)
+	})
 }
 
 func (s *InventoryService) GetInventoryItem(id string) (*models.InventoryItem, error) {
 	return s.repo.GetInventoryItem(id)
 }
 
-func (s *InventoryService) UpdateInventoryQuantity(id string, quantity int) error {
-	return s.repo.UpdateInventoryQuantity(id, quantity)
+// UpdateInventoryQuantity records a stock count of quantity in unit by
+// posting an adjustment for the difference between it and the item's
+// current balance. A count below the item's reserved stock releases
+// reservations, expired ones and then the newest first, until what remains
+// reserved fits in the count, and returns the released reservations so
+// their owners can be told. Items of a serialized product fail with
+// ErrSerializedStock, and the count fails as described for toBase.
+func (s *InventoryService) UpdateInventoryQuantity(id string, quantity float64, unit string, version int64) ([]*models.Reservation, error) {
+	if quantity < 0 {
+		return nil, ErrInvalidMovement
+	}
+	var released []*models.Reservation
+	err := s.Atomically(func(svc *InventoryService) error {
+		item, err := svc.repo.GetInventoryItem(id)
+		if err != nil {
+			return err
+		}
+		if err := svc.checkNotSerialized(id); err != nil {
+			return err
+		}
+		if quantity, _, err = svc.inBaseUnit(item.ProductID, quantity, unit); err != nil {
+			return err
+		}
+		if err := repository.CheckVersion(item.Version, version); err != nil {
+			return err
+		}
+		if quantity == item.Quantity {
+			return nil
+		}
+		if quantity < item.Reserved {
+			if released, err = svc.releaseOverCount(item, quantity); err != nil {
+				return err
+			}
+			if item, err = svc.repo.GetInventoryItem(id); err != nil {
+				return err
+			}
+		}
+		return svc.repo.PostMovement(&models.StockMovement{
+			ItemID: id,
+			Type:   models.MovementAdjustment,
+			Delta:  models.RoundQuantity(quantity - item.Quantity),
+			Reason: models.ReasonStockCount,
+			Actor:  models.SystemActor,
+		}, item.Version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return released, nil
+}
+
+// releaseOverCount releases reservations of item, expired ones and then
+// the newest first, until what it has reserved is no more than quantity,
+// and returns them
+func (s *InventoryService) releaseOverCount(item *models.InventoryItem, quantity float64) ([]*models.Reservation, error) {
+	reservations, err := s.repo.ListReservations(item.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	slices.SortStableFunc(reservations, func(a, b *models.Reservation) int {
+		switch {
+		case a.Expired(now) && !b.Expired(now):
+			return -1
+		case b.Expired(now) && !a.Expired(now):
+			return 1
+		}
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	reserved := item.Reserved
+	released := make([]*models.Reservation, 0)
+	for _, reservation := range reservations {
+		if reserved <= quantity {
+			break
+		}
+		if err := s.repo.ReleaseReservation(reservation.ID); err != nil {
+			return nil, err
+		}
+		reserved = models.RoundQuantity(reserved - reservation.Quantity)
+		released = append(released, reservation)
+	}
+	return released, nil
 }
 
 func (s *InventoryService) ListInventoryItems() ([]*models.InventoryItem, error) {
 	return s.repo.ListInventoryItems()
 }
+
+func (s *InventoryService) FindInventoryItems(filter repository.InventoryFilter) ([]*models.InventoryItem, error) {
+	return s.repo.FindInventoryItems(filter)
+}
+
+// ListProductInventory returns a product's inventory items, optionally only
+// those at location, or ErrNotFound if the product does not exist
+func (s *InventoryService) ListProductInventory(productID, location string) ([]*models.InventoryItem, error) {
+	if _, err := s.repo.GetProduct(productID); err != nil {
+		return nil, err
+	}
+	return s.repo.FindInventoryItems(repository.InventoryFilter{ProductID: productID, Location: location})
+}
+
+// UpdateInventoryItem replaces an item. It fails with
+// ErrLocationUnavailable as described for checkItemMove, and changing its
+// lot fails with ErrInvalidLot as described for checkItemLot.
+func (s *InventoryService) UpdateInventoryItem(item *models.InventoryItem) error {
+	return s.Atomically(func(svc *InventoryService) error {
+		existing, err := svc.repo.GetInventoryItem(item.ID)
+		if err != nil {
+			return err
+		}
+		if err := svc.checkItemChange(existing, item); err != nil {
+			return err
+		}
+		return svc.repo.UpdateInventoryItem(item)
+	})
+}
+
+// PatchInventoryItem applies patch to a copy of the stored item and saves
+// the result. Versions are checked as in PatchSeller.
+func (s *InventoryService) PatchInventoryItem(id string, version int64, patch func(*models.InventoryItem) error) (*models.InventoryItem, error) {
+	var item *models.InventoryItem
+	err := s.Atomically(func(svc *InventoryService) error {
+		existing, err := svc.repo.GetInventoryItem(id)
+		if err != nil {
+			return err
+		}
+		item = existing.Clone()
+		if err := patch(item); err != nil {
+			return err
+		}
+		item.ID = id
+		if err := repository.CheckVersion(existing.Version, version); err != nil {
+			return err
+		}
+		item.Version = existing.Version
+		if err := svc.checkItemChange(existing, item); err != nil {
+			return err
+		}
+		return svc.repo.UpdateInventoryItem(item)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (s *InventoryService) DeleteInventoryItem(id string, version int64) error {
+	return s.repo.DeleteInventoryItem(id, version)
+}
+
+// Stock movement operations
+
+// movementSigns gives the sign a delta must have for each movement type;
+// zero allows either
+var movementSigns = map[models.MovementType]int{
+	models.MovementReceipt:     1,
+	models.MovementShipment:    -1,
+	models.MovementAdjustment:  0,
+	models.MovementTransferIn:  1,
+	models.MovementTransferOut: -1,
+	models.MovementReturn:      1,
+	models.MovementWriteOff:    -1,
+	models.MovementConsume:     -1,
+	models.MovementProduce:     1,
+}
+
+// PostMovement validates movement and appends it to its item's ledger,
+// converting its delta from the movement's unit to the item's. The
+// movement needs a known type, a non-zero delta with the type's sign, a
+// reason code and an actor, and may only consume a reservation if it
+// removes stock; otherwise it fails with ErrInvalidMovement.
+// Shipping from a lot that has expired fails with ErrLotExpired, moving
+// stock of a serialized product fails with ErrSerializedStock, and the
+// delta's unit is checked as described for toBase. The item is read and
+// the movement posted in one transaction.
+func (s *InventoryService) PostMovement(movement *models.StockMovement, version int64) error {
+	sign, ok := movementSigns[movement.Type]
+	if !ok || movement.Delta == 0 || movement.Delta*float64(sign) < 0 {
+		return ErrInvalidMovement
+	}
+	if movement.Reason == "" || movement.Actor == "" {
+		return ErrInvalidMovement
+	}
+	if movement.ReservationID != "" && movement.Delta > 0 {
+		return ErrInvalidMovement
+	}
+	return s.Atomically(func(svc *InventoryService) error {
+		if err := svc.checkNotSerialized(movement.ItemID); err != nil {
+			return err
+		}
+		delta, err := svc.inItemUnit(movement.ItemID, movement.Delta, movement.Unit)
+		if err != nil {
+			return err
+		}
+		movement.Delta = delta
+		if movement.Type == models.MovementShipment {
+			if err := svc.checkNotExpired(movement.ItemID); err != nil {
+				return err
+			}
+		}
+		return svc.repo.PostMovement(movement, version)
+	})
+}
+
+func (s *InventoryService) ListMovements(itemID string) ([]*models.StockMovement, error) {
+	return s.repo.ListMovements(itemID)
+}
+
+// CreateReservation validates reservation and holds its quantity of its
+// item's stock, converted from the reservation's unit to the item's. The
+// reservation needs a positive quantity, an owner and an expiry in the
+// future; otherwise it fails with ErrInvalidReservation. A missing item
+// fails with repository.ErrInvalidReference, and the quantity's unit is
+// checked as described for toBase.
+func (s *InventoryService) CreateReservation(reservation *models.Reservation) error {
+	if reservation.Quantity <= 0 || reservation.Owner == "" || !reservation.ExpiresAt.After(time.Now()) {
+		return ErrInvalidReservation
+	}
+	quantity, err := s.inItemUnit(reservation.ItemID, reservation.Quantity, reservation.Unit)
+	if err == repository.ErrNotFound {
+		return repository.ErrInvalidReference
+	} else if err != nil {
+		return err
+	}
+	reservation.Quantity = quantity
+	return s.repo.CreateReservation(reservation)
+}
+
+func (s *InventoryService) GetReservation(id string) (*models.Reservation, error) {
+	return s.repo.GetReservation(id)
+}
+
+func (s *InventoryService) ListReservations(itemID string) ([]*models.Reservation, error) {
+	return s.repo.ListReservations(itemID)
+}
+
+func (s *InventoryService) ReleaseReservation(id string) error {
+	return s.repo.ReleaseReservation(id)
+}
+
+// ReleaseExpiredReservations releases every reservation that has expired
+// and returns how many it released
+func (s *InventoryService) ReleaseExpiredReservations() (int, error) {
+	return s.repo.ReleaseExpiredReservations(time.Now())
+}
@@ -1,12 +1,96 @@
 package service
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
 	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
 )
 
+// usd returns minor cents of US dollars
+func usd(minor int64) models.Money {
+	return models.Money{Minor: minor, Currency: "USD"}
+}
+
+// testOption adds fixtures to a service made by newTestService
+type testOption func(t *testing.T, svc *InventoryService)
+
+// newTestService returns a service over an in-memory store holding vendor
+// v1, with opts applied in order
+func newTestService(t *testing.T, opts ...testOption) *InventoryService {
+	t.Helper()
+	svc := NewInventoryService(repository.NewInMemoryRepository())
+	opts = append([]testOption{withVendors(&models.Vendor{ID: "v1", Name: "Garden Supplies Co"})}, opts...)
+	for _, opt := range opts {
+		opt(t, svc)
+	}
+	return svc
+}
+
+// creating returns an option that creates each of entities, of the named
+// kind, with create
+func creating[T any](kind string, create func(*InventoryService, *T) error, entities []*T) testOption {
+	return func(t *testing.T, svc *InventoryService) {
+		t.Helper()
+		for _, entity := range entities {
+			if err := create(svc, entity); err != nil {
+				t.Fatalf("Failed to create %s %+v: %v", kind, entity, err)
+			}
+		}
+	}
+}
+
+func withBuyers(buyers ...*models.Buyer) testOption {
+	return creating("buyer", (*InventoryService).CreateBuyer, buyers)
+}
+
+func withVendors(vendors ...*models.Vendor) testOption {
+	return creating("vendor", (*InventoryService).CreateVendor, vendors)
+}
+
+func withLocations(locations ...*models.Location) testOption {
+	return creating("location", (*InventoryService).CreateLocation, locations)
+}
+
+func withProducts(products ...*models.Product) testOption {
+	return creating("product", (*InventoryService).CreateProduct, products)
+}
+
+func withItems(items ...*models.InventoryItem) testOption {
+	return creating("inventory item", (*InventoryService).CreateInventoryItem, items)
+}
+
+func withSerials(serials ...*models.Serial) testOption {
+	return creating("serial", (*InventoryService).CreateSerial, serials)
+}
+
+func withPriceLists(lists ...*models.PriceList) testOption {
+	return creating("price list", (*InventoryService).CreatePriceList, lists)
+}
+
+func withPromotions(promotions ...*models.Promotion) testOption {
+	return creating("promotion", (*InventoryService).CreatePromotion, promotions)
+}
+
+func withSalesOrders(orders ...*models.SalesOrder) testOption {
+	return creating("sales order", (*InventoryService).CreateSalesOrder, orders)
+}
+
+func withPurchaseOrders(orders ...*models.PurchaseOrder) testOption {
+	return creating("purchase order", (*InventoryService).CreatePurchaseOrder, orders)
+}
+
+func withTransferOrders(orders ...*models.TransferOrder) testOption {
+	return creating("transfer order", (*InventoryService).CreateTransferOrder, orders)
+}
+
+// activeLocation returns an active location of the given type under parent
+func activeLocation(id string, locationType models.LocationType, parent string) *models.Location {
+	return &models.Location{ID: id, Name: id, Type: locationType, ParentID: parent, Active: true}
+}
+
 func TestCreateProductWithValidVendor(t *testing.T) {
 	repo := repository.NewInMemoryRepository()
 	svc := NewInventoryService(repo)
@@ -28,7 +112,7 @@ func TestCreateProductWithValidVendor(t *testing.T) {
 		Name:        "Fertilizer",
 		Description: "Organic fertilizer",
 		Category:    "Soil Amendments",
-		Price:       29.99,
+		Price:       usd(2999),
 		VendorID:    "v1",
 	}
 	err := svc.CreateProduct(product)
@@ -47,7 +131,7 @@ func TestCreateProductWithInvalidVendor(t *testing.T) {
 		Name:        "Fertilizer",
 		Description: "Organic fertilizer",
 		Category:    "Soil Amendments",
-		Price:       29.99,
+		Price:       usd(2999),
 		VendorID:    "nonexistent",
 	}
 	err := svc.CreateProduct(product)
@@ -79,12 +163,14 @@ func TestCreateInventoryItemWithValidProduct(t *testing.T) {
 		t.Fatalf("Failed to create product: %v", err)
 	}
 
+	createLocation(t, svc, "wh-a", models.LocationWarehouse, "")
+
 	// Create inventory item
 	item := &models.InventoryItem{
 		ID:        "i1",
 		ProductID: "p1",
 		Quantity:  100,
-		Location:  "Warehouse A",
+		Location:  "wh-a",
 	}
 	err := svc.CreateInventoryItem(item)
 	if err != nil {
@@ -108,3 +194,246 @@ func TestCreateInventoryItemWithInvalidProduct(t *testing.T) {
 		t.Errorf("Expected ErrNotFound, got %v", err)
 	}
 }
+
+func TestPatchProductKeepsUnsetFields(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	svc := NewInventoryService(repo)
+
+	if err := svc.CreateVendor(&models.Vendor{ID: "v1", Name: "Garden Supplies Co"}); err != nil {
+		t.Fatalf("Failed to create vendor: %v", err)
+	}
+	product := &models.Product{
+		ID:       "p1",
+		Name:     "Fertilizer",
+		Category: "Soil Amendments",
+		Price:    usd(2999),
+		VendorID: "v1",
+	}
+	if err := svc.CreateProduct(product); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+
+	patched, err := svc.PatchProduct("p1", 0, func(p *models.Product) error {
+		p.Price = usd(2499)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to patch product: %v", err)
+	}
+	if patched.Price != usd(2499) {
+		t.Errorf("Expected price 24.99, got %v", patched.Price)
+	}
+	if patched.Name != "Fertilizer" || patched.Category != "Soil Amendments" {
+		t.Errorf("Expected other fields to be kept, got %+v", patched)
+	}
+}
+
+func TestProductPrices(t *testing.T) {
+	svc := NewInventoryService(repository.NewInMemoryRepository())
+	if err := svc.CreateVendor(&models.Vendor{ID: "v1", Name: "Garden Supplies Co"}); err != nil {
+		t.Fatalf("Failed to create vendor: %v", err)
+	}
+
+	free := &models.Product{ID: "p1", Name: "Seed Catalogue", VendorID: "v1"}
+	if err := svc.CreateProduct(free); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	if free.Price != usd(0) {
+		t.Errorf("Expected a product without a price to cost nothing in USD, got %v", free.Price)
+	}
+
+	tests := []struct {
+		name  string
+		price models.Money
+	}{
+		{"negative", usd(-100)},
+		{"unknown currency", models.Money{Minor: 100, Currency: "XYZ"}},
+		{"amount without a currency", models.Money{Minor: 100}},
+	}
+	for _, tt := range tests {
+		product := &models.Product{ID: "p-" + tt.name, Name: tt.name, VendorID: "v1", Price: tt.price}
+		if err := svc.CreateProduct(product); err != ErrInvalidPrice {
+			t.Errorf("%s: expected ErrInvalidPrice, got %v", tt.name, err)
+		}
+	}
+	if _, err := svc.PatchProduct("p1", 0, func(p *models.Product) error {
+		p.Price = usd(-1)
+		return nil
+	}); err != ErrInvalidPrice {
+		t.Errorf("Expected ErrInvalidPrice patching a negative price, got %v", err)
+	}
+}
+
+func TestPatchMissingProduct(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	svc := NewInventoryService(repo)
+
+	_, err := svc.PatchProduct("nonexistent", 0, func(p *models.Product) error { return nil })
+	if err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDeleteVendorWithProducts(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	svc := NewInventoryService(repo)
+
+	if err := svc.CreateVendor(&models.Vendor{ID: "v1", Name: "Garden Supplies Co"}); err != nil {
+		t.Fatalf("Failed to create vendor: %v", err)
+	}
+	if err := svc.CreateProduct(&models.Product{ID: "p1", Name: "Fertilizer", VendorID: "v1"}); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+
+	if err := svc.DeleteVendor("v1", 0, false); err != repository.ErrInUse {
+		t.Fatalf("Expected ErrInUse, got %v", err)
+	}
+	if err := svc.DeleteVendor("v1", 0, true); err != nil {
+		t.Fatalf("Failed to cascade delete vendor: %v", err)
+	}
+	if _, err := svc.GetProduct("p1"); err != repository.ErrNotFound {
+		t.Errorf("Expected product to be deleted, got %v", err)
+	}
+}
+
+func TestPatchDetectsConcurrentUpdate(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	svc := NewInventoryService(repo)
+
+	if err := svc.CreateSeller(&models.Seller{ID: "s1", Name: "Alice"}); err != nil {
+		t.Fatalf("Failed to create seller: %v", err)
+	}
+
+	// The seller changes between the patch reading it and saving it
+	_, err := svc.PatchSeller("s1", 0, func(s *models.Seller) error {
+		if err := repo.UpdateSeller(&models.Seller{ID: "s1", Name: "Bob"}); err != nil {
+			t.Fatalf("Failed concurrent update: %v", err)
+		}
+		s.Email = "alice@example.com"
+		return nil
+	})
+	if err != repository.ErrVersionMismatch {
+		t.Fatalf("Expected ErrVersionMismatch, got %v", err)
+	}
+
+	if _, err := svc.PatchSeller("s1", 1, func(s *models.Seller) error { return nil }); err != repository.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch for a stale If-Match version, got %v", err)
+	}
+	patched, err := svc.PatchSeller("s1", 2, func(s *models.Seller) error {
+		s.Email = "bob@example.com"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to patch at the current version: %v", err)
+	}
+	if patched.Name != "Bob" || patched.Version != 3 {
+		t.Errorf("Expected Bob at version 3, got %s at %d", patched.Name, patched.Version)
+	}
+}
+
+func TestPostMovementValidatesSign(t *testing.T) {
+	svc := newTestService(t,
+		withProducts(&models.Product{ID: "p1", Name: "Fertilizer", VendorID: "v1"}),
+		withLocations(activeLocation("wh", models.LocationWarehouse, "")),
+		withItems(&models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 10, Location: "wh"}),
+	)
+
+	invalid := []*models.StockMovement{
+		{ItemID: "i1", Type: models.MovementReceipt, Delta: -5, Reason: "purchase", Actor: "bob"},
+		{ItemID: "i1", Type: models.MovementWriteOff, Delta: 5, Reason: "damaged", Actor: "bob"},
+		{ItemID: "i1", Type: models.MovementAdjustment, Delta: 0, Reason: "count", Actor: "bob"},
+		{ItemID: "i1", Type: "teleport", Delta: 5, Reason: "magic", Actor: "bob"},
+		{ItemID: "i1", Type: models.MovementReturn, Delta: 5, Actor: "bob"},
+		{ItemID: "i1", Type: models.MovementReturn, Delta: 5, Reason: "customer_return"},
+		{ItemID: "i1", Type: models.MovementReturn, Delta: 5, Reason: "customer_return", Actor: "bob", ReservationID: "r1"},
+	}
+	for _, movement := range invalid {
+		if err := svc.PostMovement(movement, 0); err != ErrInvalidMovement {
+			t.Errorf("Expected ErrInvalidMovement for %+v, got %v", movement, err)
+		}
+	}
+
+	adjustment := &models.StockMovement{ItemID: "i1", Type: models.MovementAdjustment, Delta: -3, Reason: "count", Actor: "bob"}
+	if err := svc.PostMovement(adjustment, 0); err != nil {
+		t.Fatalf("Failed to post a negative adjustment: %v", err)
+	}
+
+	// A stock count posts an adjustment for the difference
+	if _, err := svc.UpdateInventoryQuantity("i1", 12, "", 0); err != nil {
+		t.Fatalf("Failed to record stock count: %v", err)
+	}
+	movements, err := svc.ListMovements("i1")
+	if err != nil {
+		t.Fatalf("Failed to list movements: %v", err)
+	}
+	last := movements[len(movements)-1]
+	if last.Delta != 5 || last.Balance != 12 || last.Reason != models.ReasonStockCount {
+		t.Errorf("Expected a stock count adjustment of +5 to 12, got %+v", last)
+	}
+
+	// A count below what is reserved releases the newest reservations until
+	// the rest fit
+	for i, quantity := range []float64{4, 3, 3} {
+		reservation := &models.Reservation{
+			ID:        fmt.Sprintf("r%d", i+1),
+			ItemID:    "i1",
+			Quantity:  quantity,
+			Owner:     "so1",
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+		if err := svc.CreateReservation(reservation); err != nil {
+			t.Fatalf("Failed to reserve: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	released, err := svc.UpdateInventoryQuantity("i1", 5, "", 0)
+	if err != nil {
+		t.Fatalf("Failed to record a count below what is reserved: %v", err)
+	}
+	if len(released) != 2 || released[0].ID != "r3" || released[1].ID != "r2" {
+		t.Errorf("Expected r3 and r2 to be released, got %+v", released)
+	}
+	item, err := svc.GetInventoryItem("i1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	if item.Quantity != 5 || item.Reserved != 4 || item.Available != 1 {
+		t.Errorf("Expected 5 on hand with 4 reserved, got %v with %v reserved", item.Quantity, item.Reserved)
+	}
+	if _, err := svc.GetReservation("r1"); err != nil {
+		t.Errorf("Expected the oldest reservation to be kept, got %v", err)
+	}
+}
+
+func TestAtomicallyRollsBackOnError(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	svc := NewInventoryService(repo)
+
+	if err := svc.CreateVendor(&models.Vendor{ID: "v1", Name: "Garden Supplies Co"}); err != nil {
+		t.Fatalf("Failed to create vendor: %v", err)
+	}
+	createLocation(t, svc, "wh-a", models.LocationWarehouse, "")
+	createLocation(t, svc, "wh-b", models.LocationWarehouse, "")
+
+	// A product with opening stock at two locations, where the second
+	// location's item is invalid
+	err := svc.Atomically(func(tx *InventoryService) error {
+		if err := tx.CreateProduct(&models.Product{ID: "p1", Name: "Fertilizer", VendorID: "v1"}); err != nil {
+			return err
+		}
+		if err := tx.CreateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 10, Location: "wh-a"}); err != nil {
+			return err
+		}
+		return tx.CreateInventoryItem(&models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 5, Location: "wh-b"})
+	})
+	if err != repository.ErrAlreadyExists {
+		t.Fatalf("Expected ErrAlreadyExists, got %v", err)
+	}
+
+	if _, err := svc.GetProduct("p1"); err != repository.ErrNotFound {
+		t.Errorf("Expected product to be rolled back, got %v", err)
+	}
+	if _, err := svc.GetInventoryItem("i1"); err != repository.ErrNotFound {
+		t.Errorf("Expected inventory item to be rolled back, got %v", err)
+	}
+}
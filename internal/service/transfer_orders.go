@@ -0,0 +1,374 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+var ErrInvalidTransferOrder = errors.New("invalid transfer order")
+
+// TransferReceipt is what arrived at the destination of a transfer order.
+// Received gives the quantity that arrived for each line, in order, in Unit
+// or, when that is empty, in the line's unit; when it is empty every line
+// arrived in full.
+type TransferReceipt struct {
+	Received []float64 `json:"received"`
+	Unit     string    `json:"unit"`
+	Note     string    `json:"note"`
+	Actor    string    `json:"actor"`
+}
+
+// validateTransferOrder fails with ErrInvalidTransferOrder unless order
+// moves stock between two different locations and has at least one line,
+// each with a positive quantity of a product that is not serialized, and
+// with ErrLocationUnavailable unless both locations exist and are active.
+// Serialized units move between items by updating their serials. Line
+// quantities are converted to their products' base units.
+func (s *InventoryService) validateTransferOrder(order *models.TransferOrder) error {
+	if order.FromLocation == "" || order.ToLocation == "" || order.FromLocation == order.ToLocation ||
+		len(order.Lines) == 0 {
+		return ErrInvalidTransferOrder
+	}
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		if line.Quantity <= 0 {
+			return ErrInvalidTransferOrder
+		}
+		if err := s.toLineUnit(line.ProductID, &line.Quantity, &line.Unit); err != nil {
+			return err
+		}
+		serialized, err := s.isSerialized(line.ProductID)
+		if err != nil {
+			return err
+		}
+		if serialized {
+			return ErrInvalidTransferOrder
+		}
+	}
+	if err := s.checkItemLocation(order.FromLocation); err != nil {
+		return err
+	}
+	return s.checkItemLocation(order.ToLocation)
+}
+
+// draftTransferOrder resets what a client may not set on an order: its
+// status, note and times, the lots dispatched and the quantities received
+func draftTransferOrder(order *models.TransferOrder) {
+	order.Status = models.TransferOrderDraft
+	order.Note = ""
+	order.DispatchedAt = nil
+	order.ReceivedAt = nil
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		line.LotNumber = ""
+		line.ManufacturedAt = nil
+		line.ExpiresAt = nil
+		line.Received = 0
+		line.Discrepancy = 0
+	}
+}
+
+// CreateTransferOrder creates order as a draft. It fails as described for
+// validateTransferOrder.
+func (s *InventoryService) CreateTransferOrder(order *models.TransferOrder) error {
+	if err := s.validateTransferOrder(order); err != nil {
+		return err
+	}
+	draftTransferOrder(order)
+	return s.repo.CreateTransferOrder(order)
+}
+
+func (s *InventoryService) GetTransferOrder(id string) (*models.TransferOrder, error) {
+	return s.repo.GetTransferOrder(id)
+}
+
+func (s *InventoryService) FindTransferOrders(filter repository.TransferOrderFilter) ([]*models.TransferOrder, error) {
+	return s.repo.FindTransferOrders(filter)
+}
+
+// UpdateTransferOrder replaces the locations and lines of a draft order.
+// Orders past the draft stage fail with ErrInvalidTransition.
+func (s *InventoryService) UpdateTransferOrder(order *models.TransferOrder) error {
+	if err := s.validateTransferOrder(order); err != nil {
+		return err
+	}
+	existing, err := s.repo.GetTransferOrder(order.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Status != models.TransferOrderDraft {
+		return ErrInvalidTransition
+	}
+	draftTransferOrder(order)
+	if err := repository.CheckVersion(existing.Version, order.Version); err != nil {
+		return err
+	}
+	order.Version = existing.Version
+	return s.repo.UpdateTransferOrder(order)
+}
+
+// DeleteTransferOrder deletes a draft or cancelled order. Other orders fail
+// with ErrInvalidTransition.
+func (s *InventoryService) DeleteTransferOrder(id string, version int64) error {
+	existing, err := s.repo.GetTransferOrder(id)
+	if err != nil {
+		return err
+	}
+	if existing.Status != models.TransferOrderDraft && existing.Status != models.TransferOrderCancelled {
+		return ErrInvalidTransition
+	}
+	if err := repository.CheckVersion(existing.Version, version); err != nil {
+		return err
+	}
+	return s.repo.DeleteTransferOrder(id, existing.Version)
+}
+
+// changeTransferOrder applies change to an order and stores it in a single
+// unit of work
+func (s *InventoryService) changeTransferOrder(id string, version int64,
+	change func(svc *InventoryService, order *models.TransferOrder) error) (*models.TransferOrder, error) {
+	var order *models.TransferOrder
+	err := s.Atomically(func(svc *InventoryService) error {
+		var err error
+		order, err = svc.repo.GetTransferOrder(id)
+		if err != nil {
+			return err
+		}
+		if err := repository.CheckVersion(order.Version, version); err != nil {
+			return err
+		}
+		if err := change(svc, order); err != nil {
+			return err
+		}
+		return svc.repo.UpdateTransferOrder(order)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// CancelTransferOrder cancels a draft order. Stock already dispatched must
+// be received instead, recording what arrived.
+func (s *InventoryService) CancelTransferOrder(id string, version int64) (*models.TransferOrder, error) {
+	return s.changeTransferOrder(id, version, func(_ *InventoryService, order *models.TransferOrder) error {
+		if order.Status != models.TransferOrderDraft {
+			return ErrInvalidTransition
+		}
+		order.Status = models.TransferOrderCancelled
+		return nil
+	})
+}
+
+// DispatchTransferOrder sends a draft order on its way. It posts a transfer
+// out of each line's quantity from the line's source item, which is
+// FromItemID or, when that is empty, the item of the line's product at the
+// order's source location with enough stock available whose lot expires
+// first, skipping expired lots, and holds the stock in transit on the order.
+// It fails with ErrInvalidTransferOrder if a line's FromItemID is missing or
+// holds another product or is at another location, and with
+// repository.ErrInsufficientStock if the stock is short.
+func (s *InventoryService) DispatchTransferOrder(id string, version int64) (*models.TransferOrder, error) {
+	return s.changeTransferOrder(id, version, func(svc *InventoryService, order *models.TransferOrder) error {
+		if order.Status != models.TransferOrderDraft {
+			return ErrInvalidTransition
+		}
+		for i := range order.Lines {
+			line := &order.Lines[i]
+			item, err := svc.sourceItem(order, *line)
+			if err != nil {
+				return err
+			}
+			err = svc.repo.PostMovement(&models.StockMovement{
+				ItemID:    item.ID,
+				Type:      models.MovementTransferOut,
+				Delta:     -line.Quantity,
+				Reason:    models.ReasonTransfer,
+				Reference: order.ID,
+				Actor:     models.SystemActor,
+			}, 0)
+			if err != nil {
+				return err
+			}
+			line.FromItemID = item.ID
+			line.LotNumber = item.LotNumber
+			line.ManufacturedAt = item.ManufacturedAt
+			line.ExpiresAt = item.ExpiresAt
+		}
+		now := time.Now()
+		order.Status = models.TransferOrderInTransit
+		order.DispatchedAt = &now
+		return nil
+	})
+}
+
+// sourceItem returns the inventory item that line of order takes its stock
+// from
+func (s *InventoryService) sourceItem(order *models.TransferOrder, line models.TransferOrderLine) (*models.InventoryItem, error) {
+	if line.FromItemID != "" {
+		return s.transferItem(line.FromItemID, line.ProductID, order.FromLocation)
+	}
+	items, err := s.repo.FindInventoryItems(repository.InventoryFilter{ProductID: line.ProductID, Location: order.FromLocation})
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range fefo(items, time.Now()) {
+		if item.Available >= line.Quantity {
+			return item, nil
+		}
+	}
+	return nil, repository.ErrInsufficientStock
+}
+
+// transferItem returns the item id, failing with ErrInvalidTransferOrder
+// unless it exists and holds productID at location
+func (s *InventoryService) transferItem(id, productID, location string) (*models.InventoryItem, error) {
+	item, err := s.repo.GetInventoryItem(id)
+	if err == repository.ErrNotFound {
+		return nil, ErrInvalidTransferOrder
+	} else if err != nil {
+		return nil, err
+	}
+	if item.ProductID != productID || item.Location != location {
+		return nil, ErrInvalidTransferOrder
+	}
+	return item, nil
+}
+
+// ReceiveTransferOrder receives an order in transit at its destination. It
+// posts a transfer in of what arrived for each line to the line's
+// destination item, which is ToItemID or, when that is empty, the first item
+// of the line's product and lot at the destination, or else a new item of
+// the lot there. Each
+// line records what arrived and how far it differs from what was sent, and
+// the order is received. It fails with ErrInvalidReceipt unless the receipt
+// gives a quantity that is not negative for every line, as described for
+// toBase if a quantity does not convert to its line's unit, and with
+// ErrInvalidTransferOrder if a line's ToItemID is missing, holds another
+// product or lot or is at another location.
+func (s *InventoryService) ReceiveTransferOrder(id string, version int64, receipt TransferReceipt) (*models.TransferOrder, error) {
+	return s.changeTransferOrder(id, version, func(svc *InventoryService, order *models.TransferOrder) error {
+		if order.Status != models.TransferOrderInTransit {
+			return ErrInvalidTransition
+		}
+		if len(receipt.Received) != 0 && len(receipt.Received) != len(order.Lines) {
+			return ErrInvalidReceipt
+		}
+		actor := receipt.Actor
+		if actor == "" {
+			actor = models.SystemActor
+		}
+
+		for i := range order.Lines {
+			line := &order.Lines[i]
+			received := line.Quantity
+			if len(receipt.Received) != 0 {
+				if receipt.Received[i] < 0 {
+					return ErrInvalidReceipt
+				}
+				var err error
+				if received, _, err = svc.inBaseUnit(line.ProductID, receipt.Received[i], receipt.Unit); err != nil {
+					return err
+				}
+			}
+			line.Received = received
+			line.Discrepancy = models.RoundQuantity(received - line.Quantity)
+			if received == 0 {
+				continue
+			}
+
+			item, err := svc.destinationItem(order, i)
+			if err != nil {
+				return err
+			}
+			err = svc.repo.PostMovement(&models.StockMovement{
+				ItemID:    item.ID,
+				Type:      models.MovementTransferIn,
+				Delta:     received,
+				Reason:    models.ReasonTransfer,
+				Reference: order.ID,
+				Actor:     actor,
+			}, 0)
+			if err != nil {
+				return err
+			}
+			line.ToItemID = item.ID
+		}
+		now := time.Now()
+		order.Status = models.TransferOrderReceived
+		order.Note = receipt.Note
+		order.ReceivedAt = &now
+		return nil
+	})
+}
+
+// destinationItem returns the inventory item that the line at index i of
+// order puts its stock into, creating it at the destination as
+// CreateInventoryItem does if the destination holds none of the line's
+// product and lot. Creating it fails with ErrLocationUnavailable if the
+// destination has been deactivated since the order was dispatched.
+func (s *InventoryService) destinationItem(order *models.TransferOrder, i int) (*models.InventoryItem, error) {
+	line := order.Lines[i]
+	if line.ToItemID != "" {
+		item, err := s.transferItem(line.ToItemID, line.ProductID, order.ToLocation)
+		if err != nil {
+			return nil, err
+		}
+		if !sameLot(item, line.LotNumber, line.ExpiresAt) {
+			return nil, ErrInvalidTransferOrder
+		}
+		return item, nil
+	}
+	items, err := s.repo.FindInventoryItems(repository.InventoryFilter{ProductID: line.ProductID, Location: order.ToLocation})
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if sameLot(item, line.LotNumber, line.ExpiresAt) {
+			return item, nil
+		}
+	}
+	item := &models.InventoryItem{
+		ID:             fmt.Sprintf("%s-%d", order.ID, i),
+		ProductID:      line.ProductID,
+		Unit:           line.Unit,
+		Location:       order.ToLocation,
+		LotNumber:      line.LotNumber,
+		ManufacturedAt: line.ManufacturedAt,
+		ExpiresAt:      line.ExpiresAt,
+	}
+	if err := s.CreateInventoryItem(item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// InTransit returns the quantity of a product dispatched on transfer orders
+// and not yet received, with the product's base unit it is in, or
+// ErrNotFound if the product does not exist
+func (s *InventoryService) InTransit(productID string) (float64, string, error) {
+	product, err := s.repo.GetProduct(productID)
+	if err != nil {
+		return 0, "", err
+	}
+	orders, err := s.repo.FindTransferOrders(repository.TransferOrderFilter{
+		ProductID: productID,
+		Status:    models.TransferOrderInTransit,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	inTransit := 0.0
+	for _, order := range orders {
+		for _, line := range order.Lines {
+			if line.ProductID == productID {
+				inTransit += line.Quantity
+			}
+		}
+	}
+	return models.RoundQuantity(inTransit), baseUnit(product), nil
+}
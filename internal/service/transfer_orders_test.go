@@ -0,0 +1,187 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+// newTransferService returns a service with product p1 stocked by item i1
+// with 50 at warehouse yard, retail store shop, and draft order to1 moving
+// 40 of p1 from yard to shop
+func newTransferService(t *testing.T) *InventoryService {
+	t.Helper()
+	order := &models.TransferOrder{
+		ID:           "to1",
+		FromLocation: "yard",
+		ToLocation:   "shop",
+		Lines:        []models.TransferOrderLine{{ProductID: "p1", Quantity: 40}},
+	}
+	svc := newTestService(t,
+		withProducts(&models.Product{ID: "p1", Name: "Potting Soil", VendorID: "v1"}),
+		withLocations(
+			activeLocation("yard", models.LocationWarehouse, ""),
+			activeLocation("shop", models.LocationWarehouse, ""),
+		),
+		withItems(&models.InventoryItem{ID: "i1", ProductID: "p1", Quantity: 50, Location: "yard"}),
+		withTransferOrders(order),
+	)
+	if order.Status != models.TransferOrderDraft {
+		t.Errorf("Expected a draft order, got %s", order.Status)
+	}
+	return svc
+}
+
+// checkInTransit fails unless p1 has the given quantity in transit
+func checkInTransit(t *testing.T, svc *InventoryService, expected float64) {
+	t.Helper()
+	inTransit, _, err := svc.InTransit("p1")
+	if err != nil {
+		t.Fatalf("Failed to get quantity in transit: %v", err)
+	}
+	if inTransit != expected {
+		t.Errorf("Expected %v in transit, got %v", expected, inTransit)
+	}
+}
+
+func TestCreateTransferOrderValidation(t *testing.T) {
+	svc := newTransferService(t)
+	if err := svc.UpdateLocation(&models.Location{ID: "shop", Type: models.LocationWarehouse}); err != nil {
+		t.Fatalf("Failed to deactivate location: %v", err)
+	}
+
+	line := []models.TransferOrderLine{{ProductID: "p1", Quantity: 1}}
+	invalid := []*models.TransferOrder{
+		{ID: "to2", FromLocation: "yard", ToLocation: "yard", Lines: line},
+		{ID: "to2", FromLocation: "yard", Lines: line},
+		{ID: "to2", FromLocation: "yard", ToLocation: "shop"},
+		{ID: "to2", FromLocation: "yard", ToLocation: "shop", Lines: []models.TransferOrderLine{{ProductID: "p1"}}},
+	}
+	for _, order := range invalid {
+		if err := svc.CreateTransferOrder(order); err != ErrInvalidTransferOrder {
+			t.Errorf("Expected ErrInvalidTransferOrder for %+v, got %v", order, err)
+		}
+	}
+	for _, to := range []string{"shop", "missing"} {
+		order := &models.TransferOrder{ID: "to2", FromLocation: "yard", ToLocation: to, Lines: line}
+		if err := svc.CreateTransferOrder(order); err != ErrLocationUnavailable {
+			t.Errorf("Expected ErrLocationUnavailable moving to %s, got %v", to, err)
+		}
+	}
+}
+
+func TestTransferOrderLifecycle(t *testing.T) {
+	svc := newTransferService(t)
+	checkInTransit(t, svc, 0)
+
+	if _, err := svc.ReceiveTransferOrder("to1", 0, TransferReceipt{}); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition receiving a draft, got %v", err)
+	}
+	order, err := svc.DispatchTransferOrder("to1", 0)
+	if err != nil {
+		t.Fatalf("Failed to dispatch transfer order: %v", err)
+	}
+	if order.Status != models.TransferOrderInTransit || order.DispatchedAt == nil || order.Lines[0].FromItemID != "i1" {
+		t.Errorf("Expected an order in transit from i1, got %+v", order)
+	}
+	checkItem(t, svc, "i1", 10, 0)
+	checkInTransit(t, svc, 40)
+
+	movements, err := svc.ListMovements("i1")
+	if err != nil {
+		t.Fatalf("Failed to list movements: %v", err)
+	}
+	if out := movements[len(movements)-1]; out.Type != models.MovementTransferOut || out.Delta != -40 ||
+		out.Reason != models.ReasonTransfer || out.Reference != "to1" {
+		t.Errorf("Expected a transfer out of 40 for to1, got %+v", out)
+	}
+
+	if _, err := svc.CancelTransferOrder("to1", 0); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition cancelling an order in transit, got %v", err)
+	}
+	if err := svc.DeleteTransferOrder("to1", 0); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition deleting an order in transit, got %v", err)
+	}
+	for _, receipt := range []TransferReceipt{{Received: []float64{40, 1}}, {Received: []float64{-1}}} {
+		if _, err := svc.ReceiveTransferOrder("to1", 0, receipt); err != ErrInvalidReceipt {
+			t.Errorf("Expected ErrInvalidReceipt for %+v, got %v", receipt, err)
+		}
+	}
+
+	// One bag is lost on the way; the shop has no item for the product yet
+	order, err = svc.ReceiveTransferOrder("to1", 0, TransferReceipt{Received: []float64{39}, Note: "One bag torn", Actor: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to receive transfer order: %v", err)
+	}
+	line := order.Lines[0]
+	if order.Status != models.TransferOrderReceived || order.ReceivedAt == nil || order.Note != "One bag torn" ||
+		line.Received != 39 || line.Discrepancy != -1 || line.ToItemID != "to1-0" {
+		t.Errorf("Expected a received order with a discrepancy of -1 into to1-0, got %+v", order)
+	}
+	item, err := svc.GetInventoryItem("to1-0")
+	if err != nil {
+		t.Fatalf("Failed to get received item: %v", err)
+	}
+	if item.Quantity != 39 || item.Location != "shop" || item.ProductID != "p1" {
+		t.Errorf("Expected 39 of p1 at the shop, got %+v", item)
+	}
+	checkInTransit(t, svc, 0)
+
+	found, err := svc.FindTransferOrders(repository.TransferOrderFilter{ToLocation: "shop", Status: models.TransferOrderReceived})
+	if err != nil || len(found) != 1 || found[0].ID != "to1" {
+		t.Errorf("Expected to find received order to1, got %v and %v", found, err)
+	}
+}
+
+func TestReceiveTransferOrderAtDeactivatedLocation(t *testing.T) {
+	svc := newTransferService(t)
+	if _, err := svc.DispatchTransferOrder("to1", 0); err != nil {
+		t.Fatalf("Failed to dispatch transfer order: %v", err)
+	}
+	shop, _ := svc.GetLocation("shop")
+	shop.Active = false
+	if err := svc.UpdateLocation(shop); err != nil {
+		t.Fatalf("Failed to deactivate location: %v", err)
+	}
+
+	if _, err := svc.ReceiveTransferOrder("to1", 0, TransferReceipt{}); err != ErrLocationUnavailable {
+		t.Errorf("Expected ErrLocationUnavailable receiving at an inactive location, got %v", err)
+	}
+	if _, err := svc.GetInventoryItem("to1-0"); err != repository.ErrNotFound {
+		t.Errorf("Expected no item created at an inactive location, got %v", err)
+	}
+	checkInTransit(t, svc, 40)
+}
+
+func TestDispatchTransferOrderInsufficientStock(t *testing.T) {
+	svc := newTransferService(t)
+	if err := svc.UpdateTransferOrder(&models.TransferOrder{ID: "to1", FromLocation: "yard", ToLocation: "shop",
+		Lines: []models.TransferOrderLine{{ProductID: "p1", Quantity: 60}}}); err != nil {
+		t.Fatalf("Failed to update transfer order: %v", err)
+	}
+	if _, err := svc.DispatchTransferOrder("to1", 0); err != repository.ErrInsufficientStock {
+		t.Errorf("Expected ErrInsufficientStock, got %v", err)
+	}
+	checkItem(t, svc, "i1", 50, 0)
+	checkInTransit(t, svc, 0)
+
+	if err := svc.UpdateTransferOrder(&models.TransferOrder{ID: "to1", FromLocation: "yard", ToLocation: "shop",
+		Lines: []models.TransferOrderLine{{ProductID: "p1", Quantity: 1, FromItemID: "missing"}}}); err != nil {
+		t.Fatalf("Failed to update transfer order: %v", err)
+	}
+	if _, err := svc.DispatchTransferOrder("to1", 0); err != ErrInvalidTransferOrder {
+		t.Errorf("Expected ErrInvalidTransferOrder for a missing source item, got %v", err)
+	}
+
+	order, err := svc.CancelTransferOrder("to1", 0)
+	if err != nil || order.Status != models.TransferOrderCancelled {
+		t.Fatalf("Expected a cancelled order, got %v and %v", order, err)
+	}
+	if err := svc.DeleteTransferOrder("to1", 0); err != nil {
+		t.Errorf("Failed to delete cancelled order: %v", err)
+	}
+	if _, _, err := svc.InTransit("missing"); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing product, got %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+var (
+	ErrInvalidUnit        = errors.New("invalid unit")
+	ErrFractionalQuantity = errors.New("quantity is not a whole number of its unit")
+	ErrBaseUnitInUse      = errors.New("base unit cannot change while the product has stock or orders")
+)
+
+// baseUnit returns the unit product's quantities are stored in. Products
+// stored before units existed have none and count in each.
+func baseUnit(product *models.Product) string {
+	if product.BaseUnit == "" {
+		return models.DefaultUnit
+	}
+	return product.BaseUnit
+}
+
+// findPack returns the pack of product named unit, or nil
+func findPack(product *models.Product, unit string) *models.Pack {
+	for i := range product.Packs {
+		if product.Packs[i].Unit == unit {
+			return &product.Packs[i]
+		}
+	}
+	return nil
+}
+
+// unitSize returns the size of unit in the reference unit of the dimension
+// of product's base unit, and whether quantities in unit may be fractional.
+// Packs are followed down to a standard unit. It fails with ErrInvalidUnit
+// if unit is unknown to the product, measures another dimension or is a
+// pack that contains itself.
+func unitSize(product *models.Product, unit string) (float64, bool, error) {
+	dimension := models.Units[baseUnit(product)].Dimension
+	size, fractional := 1.0, true
+	for range len(product.Packs) + 1 {
+		if standard, ok := models.Units[unit]; ok {
+			if standard.Dimension != dimension {
+				return 0, false, ErrInvalidUnit
+			}
+			return size * standard.Size, fractional && standard.Fractional, nil
+		}
+		pack := findPack(product, unit)
+		if pack == nil {
+			return 0, false, ErrInvalidUnit
+		}
+		size *= pack.Quantity
+		fractional = false
+		unit = pack.Of
+	}
+	return 0, false, ErrInvalidUnit
+}
+
+// toBase converts quantity in unit, the base unit when empty, to product's
+// base unit. It fails with ErrInvalidUnit as described for unitSize and
+// with ErrFractionalQuantity if quantity is fractional in a unit, or comes
+// to a fraction of a base unit, that is counted whole.
+func toBase(product *models.Product, quantity float64, unit string) (float64, error) {
+	base := baseUnit(product)
+	if unit == "" {
+		unit = base
+	}
+	size, fractional, err := unitSize(product, unit)
+	if err != nil {
+		return 0, err
+	}
+	if !fractional && quantity != math.Trunc(quantity) {
+		return 0, ErrFractionalQuantity
+	}
+	converted := models.RoundQuantity(quantity * size / models.Units[base].Size)
+	if !models.Units[base].Fractional && converted != math.Trunc(converted) {
+		return 0, ErrFractionalQuantity
+	}
+	return converted, nil
+}
+
+// priceQuantity converts quantity of product in unit to priceUnit, the
+// base unit when either is empty, as an exact fraction, so that a line
+// priced by the pack totals exactly the pack price times the packs. It
+// fails with ErrInvalidUnit as described for unitSize.
+func priceQuantity(product *models.Product, quantity float64, unit, priceUnit string) (*big.Rat, error) {
+	converted := decimalRat(quantity)
+	for i, u := range []string{unit, priceUnit} {
+		if u == "" {
+			u = baseUnit(product)
+		}
+		size, _, err := unitSize(product, u)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			converted.Mul(converted, decimalRat(size))
+		} else {
+			converted.Quo(converted, decimalRat(size))
+		}
+	}
+	return converted, nil
+}
+
+// lineTotals sets the total of each line of an order to its price times
+// its quantity in the price's unit, rounded to the minor unit as described
+// for models.Money.MulRat. It fails with ErrInvalidPrice if a total is out
+// of range.
+func lineTotals(prices, totals []*models.Money, quantities []*big.Rat) error {
+	for i, price := range prices {
+		total, err := price.MulRat(quantities[i])
+		if err != nil {
+			return ErrInvalidPrice
+		}
+		*totals[i] = total
+	}
+	return nil
+}
+
+// decimalRat returns the shortest decimal that f represents as an exact
+// fraction
+func decimalRat(f float64) *big.Rat {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'f', -1, 64))
+	return r
+}
+
+// inBaseUnit converts quantity in unit to the base unit of productID and
+// returns it with the base unit's name. A missing product fails with
+// repository.ErrInvalidReference.
+func (s *InventoryService) inBaseUnit(productID string, quantity float64, unit string) (float64, string, error) {
+	product, err := s.repo.GetProduct(productID)
+	if err == repository.ErrNotFound {
+		return 0, "", repository.ErrInvalidReference
+	} else if err != nil {
+		return 0, "", err
+	}
+	converted, err := toBase(product, quantity, unit)
+	if err != nil {
+		return 0, "", err
+	}
+	return converted, baseUnit(product), nil
+}
+
+// toLineUnit converts the quantity of an order line for productID from its
+// unit to the product's base unit, which becomes the line's unit
+func (s *InventoryService) toLineUnit(productID string, quantity *float64, unit *string) error {
+	converted, base, err := s.inBaseUnit(productID, *quantity, *unit)
+	if err != nil {
+		return err
+	}
+	*quantity, *unit = converted, base
+	return nil
+}
+
+// inItemUnit converts quantity in unit to the unit of an item
+func (s *InventoryService) inItemUnit(itemID string, quantity float64, unit string) (float64, error) {
+	item, err := s.repo.GetInventoryItem(itemID)
+	if err != nil {
+		return 0, err
+	}
+	converted, _, err := s.inBaseUnit(item.ProductID, quantity, unit)
+	return converted, err
+}
+
+// validateUnits fails with ErrInvalidUnit unless product's base unit is a
+// standard unit, whole if the product is serialized, since each unit of it
+// has a serial, and every pack has a new name, a positive quantity, whole
+// unless the unit it holds is fractional, and holds a unit the product
+// knows, without containing itself. An empty base unit becomes
+// models.DefaultUnit and a pack that holds no unit holds the base unit.
+func validateUnits(product *models.Product) error {
+	if product.BaseUnit == "" {
+		product.BaseUnit = models.DefaultUnit
+	}
+	base, ok := models.Units[product.BaseUnit]
+	if !ok || product.Serialized && base.Fractional {
+		return ErrInvalidUnit
+	}
+	for i := range product.Packs {
+		pack := &product.Packs[i]
+		if _, standard := models.Units[pack.Unit]; standard || pack.Unit == "" || pack.Quantity <= 0 {
+			return ErrInvalidUnit
+		}
+		if findPack(product, pack.Unit) != pack {
+			return ErrInvalidUnit
+		}
+		if pack.Of == "" {
+			pack.Of = product.BaseUnit
+		}
+	}
+	for _, pack := range product.Packs {
+		if _, _, err := unitSize(product, pack.Unit); err != nil {
+			return err
+		}
+		if _, fractional, _ := unitSize(product, pack.Of); !fractional && pack.Quantity != math.Trunc(pack.Quantity) {
+			return ErrInvalidUnit
+		}
+	}
+	return nil
+}
+
+// checkBaseUnitChange fails with ErrBaseUnitInUse if product's base unit is
+// changing while it has inventory items, is on an order or is a component
+// of a kit, whose quantities are in the old unit
+func (s *InventoryService) checkBaseUnitChange(existing, product *models.Product) error {
+	if baseUnit(existing) == product.BaseUnit {
+		return nil
+	}
+	items, err := s.repo.FindInventoryItems(repository.InventoryFilter{ProductID: existing.ID})
+	if err != nil {
+		return err
+	}
+	if len(items) > 0 {
+		return ErrBaseUnitInUse
+	}
+	kits, err := s.repo.FindProducts(repository.ProductFilter{ComponentID: existing.ID})
+	if err != nil {
+		return err
+	}
+	if len(kits) > 0 {
+		return ErrBaseUnitInUse
+	}
+	referenced, err := s.onOrders(existing.ID)
+	if err != nil {
+		return err
+	}
+	if referenced {
+		return ErrBaseUnitInUse
+	}
+	return nil
+}
+
+// checkItemUnit sets item's unit to its product's base unit. Moving an item
+// that holds stock to a product with another base unit fails with
+// ErrInvalidUnit.
+func (s *InventoryService) checkItemUnit(existing, item *models.InventoryItem) error {
+	product, err := s.repo.GetProduct(item.ProductID)
+	if err == repository.ErrNotFound {
+		return repository.ErrInvalidReference
+	} else if err != nil {
+		return err
+	}
+	item.Unit = baseUnit(product)
+	if existing.Quantity != 0 && existing.Unit != "" && existing.Unit != item.Unit {
+		return ErrInvalidUnit
+	}
+	return nil
+}
+
+// onOrders reports whether a line of any sales, purchase or transfer order
+// names productID
+func (s *InventoryService) onOrders(productID string) (bool, error) {
+	purchases, err := s.repo.FindPurchaseOrders(repository.PurchaseOrderFilter{ProductID: productID})
+	if err != nil || len(purchases) > 0 {
+		return len(purchases) > 0, err
+	}
+	transfers, err := s.repo.FindTransferOrders(repository.TransferOrderFilter{ProductID: productID})
+	if err != nil || len(transfers) > 0 {
+		return len(transfers) > 0, err
+	}
+	sales, err := s.repo.FindSalesOrders(repository.SalesOrderFilter{})
+	if err != nil {
+		return false, err
+	}
+	for _, order := range sales {
+		for _, line := range order.Lines {
+			if line.ProductID == productID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
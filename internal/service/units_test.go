@@ -0,0 +1,357 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+)
+
+// newUnitService returns a service with buyer b1 and three products of
+// vendor v1: mulch, stocked in cubic yards and packed in 2 cubic foot bags
+// and 20 cubic yard truckloads, held by item mulch-1 with 5; bolts, counted
+// each and packed in cases of 12 and pallets of 4 cases, held by item
+// bolts-1 with 100; and seed, weighed in pounds, held by empty item seed-1
+func newUnitService(t *testing.T) *InventoryService {
+	t.Helper()
+	return newTestService(t,
+		withBuyers(&models.Buyer{ID: "b1", Name: "Bob"}),
+		withProducts(
+			&models.Product{ID: "mulch", Name: "Mulch", VendorID: "v1", BaseUnit: "cubic_yard", Packs: []models.Pack{
+				{Unit: "bag", Quantity: 2, Of: "cubic_foot"},
+				{Unit: "truckload", Quantity: 20},
+			}},
+			&models.Product{ID: "bolts", Name: "Bolts", VendorID: "v1", Packs: []models.Pack{
+				{Unit: "case", Quantity: 12},
+				{Unit: "pallet", Quantity: 4, Of: "case"},
+			}},
+			&models.Product{ID: "seed", Name: "Grass Seed", VendorID: "v1", BaseUnit: "lb"},
+		),
+		withLocations(activeLocation("wh", models.LocationWarehouse, "")),
+		withItems(
+			&models.InventoryItem{ID: "mulch-1", ProductID: "mulch", Quantity: 5, Location: "wh"},
+			&models.InventoryItem{ID: "bolts-1", ProductID: "bolts", Quantity: 100, Location: "wh"},
+			&models.InventoryItem{ID: "seed-1", ProductID: "seed", Location: "wh"},
+		),
+	)
+}
+
+func TestValidateUnits(t *testing.T) {
+	svc := newUnitService(t)
+
+	bolts, err := svc.GetProduct("bolts")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if bolts.BaseUnit != models.DefaultUnit || bolts.Packs[0].Of != models.DefaultUnit {
+		t.Errorf("Expected bolts and their cases to count in each, got %+v", bolts)
+	}
+
+	tests := []struct {
+		name     string
+		baseUnit string
+		packs    []models.Pack
+	}{
+		{"unknown base unit", "crate", nil},
+		{"pack named after a standard unit", "", []models.Pack{{Unit: "kg", Quantity: 12}}},
+		{"pack without a name", "", []models.Pack{{Quantity: 12}}},
+		{"pack without a quantity", "", []models.Pack{{Unit: "case"}}},
+		{"pack named twice", "", []models.Pack{{Unit: "case", Quantity: 12}, {Unit: "case", Quantity: 6}}},
+		{"pack of an unknown unit", "", []models.Pack{{Unit: "case", Quantity: 12, Of: "box"}}},
+		{"pack of another dimension", "", []models.Pack{{Unit: "sack", Quantity: 25, Of: "kg"}}},
+		{"pack of a fraction of each", "", []models.Pack{{Unit: "case", Quantity: 2.5}}},
+		{"packs that contain each other", "", []models.Pack{
+			{Unit: "case", Quantity: 12, Of: "pallet"},
+			{Unit: "pallet", Quantity: 4, Of: "case"},
+		}},
+	}
+	for _, tt := range tests {
+		product := &models.Product{ID: "p-" + tt.name, Name: tt.name, VendorID: "v1", BaseUnit: tt.baseUnit, Packs: tt.packs}
+		if err := svc.CreateProduct(product); err != ErrInvalidUnit {
+			t.Errorf("%s: expected ErrInvalidUnit, got %v", tt.name, err)
+		}
+	}
+
+	// Each unit of a serialized product has a serial, so it cannot be split
+	scale := &models.Product{ID: "scale", Name: "Platform Scale", VendorID: "v1", BaseUnit: "kg", Serialized: true}
+	if err := svc.CreateProduct(scale); err != ErrInvalidUnit {
+		t.Errorf("Expected ErrInvalidUnit for a serialized product in kg, got %v", err)
+	}
+
+	sack := &models.Product{ID: "feed", Name: "Feed", VendorID: "v1", BaseUnit: "kg",
+		Packs: []models.Pack{{Unit: "sack", Quantity: 22.5, Of: "lb"}}}
+	if err := svc.CreateProduct(sack); err != nil {
+		t.Errorf("Expected a sack of a fractional weight to be valid, got %v", err)
+	}
+}
+
+func TestQuantitiesConvertToBaseUnit(t *testing.T) {
+	svc := newUnitService(t)
+
+	post := func(itemID string, delta float64, unit string) error {
+		return svc.PostMovement(&models.StockMovement{
+			ItemID: itemID,
+			Type:   models.MovementReceipt,
+			Delta:  delta,
+			Unit:   unit,
+			Reason: models.ReasonPurchase,
+			Actor:  "alice",
+		}, 0)
+	}
+	if err := post("bolts-1", 2, "case"); err != nil {
+		t.Fatalf("Failed to receive cases: %v", err)
+	}
+	checkItem(t, svc, "bolts-1", 124, 0)
+	if err := post("seed-1", 1, "kg"); err != nil {
+		t.Fatalf("Failed to receive seed: %v", err)
+	}
+	checkItem(t, svc, "seed-1", 2.204623, 0)
+	if err := post("mulch-1", 27, "bag"); err != nil {
+		t.Fatalf("Failed to receive bags: %v", err)
+	}
+	checkItem(t, svc, "mulch-1", 7, 0)
+
+	movements, err := svc.ListMovements("mulch-1")
+	if err != nil {
+		t.Fatalf("Failed to list movements: %v", err)
+	}
+	if last := movements[len(movements)-1]; last.Delta != 2 || last.Unit != "cubic_yard" {
+		t.Errorf("Expected the movement stored as 2 cubic yards, got %+v", last)
+	}
+
+	failures := []struct {
+		itemID string
+		delta  float64
+		unit   string
+		err    error
+	}{
+		{"bolts-1", 1.5, "", ErrFractionalQuantity},
+		{"bolts-1", 0.5, "case", ErrFractionalQuantity},
+		{"bolts-1", 1, "kg", ErrInvalidUnit},
+		{"bolts-1", 1, "bag", ErrInvalidUnit},
+		{"mulch-1", 1.5, "bag", ErrFractionalQuantity},
+	}
+	for _, tt := range failures {
+		if err := post(tt.itemID, tt.delta, tt.unit); err != tt.err {
+			t.Errorf("Expected %v for %v %s of %s, got %v", tt.err, tt.delta, tt.unit, tt.itemID, err)
+		}
+	}
+
+	reservation := &models.Reservation{ID: "r1", ItemID: "bolts-1", Quantity: 1, Unit: "pallet", Owner: "alice",
+		ExpiresAt: time.Now().Add(time.Hour)}
+	if err := svc.CreateReservation(reservation); err != nil {
+		t.Fatalf("Failed to reserve a pallet: %v", err)
+	}
+	if reservation.Quantity != 48 || reservation.Unit != models.DefaultUnit {
+		t.Errorf("Expected a reservation of 48 each, got %v %s", reservation.Quantity, reservation.Unit)
+	}
+	checkItem(t, svc, "bolts-1", 124, 48)
+
+	if _, err := svc.UpdateInventoryQuantity("bolts-1", 10, "case", 0); err != nil {
+		t.Fatalf("Failed to count bolts: %v", err)
+	}
+	checkItem(t, svc, "bolts-1", 120, 48)
+}
+
+func TestOrderLinesInPacks(t *testing.T) {
+	svc := newUnitService(t)
+
+	purchase := &models.PurchaseOrder{
+		ID:       "po1",
+		VendorID: "v1",
+		Lines:    []models.PurchaseOrderLine{{ProductID: "mulch", Quantity: 1, Unit: "truckload", ExpectedAt: time.Now()}},
+	}
+	if err := svc.CreatePurchaseOrder(purchase); err != nil {
+		t.Fatalf("Failed to create purchase order: %v", err)
+	}
+	if line := purchase.Lines[0]; line.Quantity != 20 || line.Unit != "cubic_yard" {
+		t.Errorf("Expected a line of 20 cubic yards, got %+v", line)
+	}
+	if _, err := svc.IssuePurchaseOrder("po1", 0); err != nil {
+		t.Fatalf("Failed to issue purchase order: %v", err)
+	}
+	receipt := Receipt{ItemID: "mulch-1", Quantity: 0.5, Unit: "truckload"}
+	if _, _, err := svc.ReceivePurchaseOrder("po1", 0, receipt); err != ErrFractionalQuantity {
+		t.Errorf("Expected ErrFractionalQuantity for half a truckload, got %v", err)
+	}
+	receipt = Receipt{ItemID: "mulch-1", Quantity: 270, Unit: "cubic_foot"}
+	if _, _, err := svc.ReceivePurchaseOrder("po1", 0, receipt); err != nil {
+		t.Fatalf("Failed to receive purchase order: %v", err)
+	}
+	checkItem(t, svc, "mulch-1", 15, 0)
+	onOrder, unit, err := svc.OnOrder("mulch")
+	if err != nil {
+		t.Fatalf("Failed to get quantity on order: %v", err)
+	}
+	if onOrder != 10 || unit != "cubic_yard" {
+		t.Errorf("Expected 10 cubic yards on order, got %v %s", onOrder, unit)
+	}
+
+	sale := &models.SalesOrder{
+		ID:      "so1",
+		BuyerID: "b1",
+		Lines:   []models.SalesOrderLine{{ProductID: "mulch", Quantity: 54, Unit: "bag"}},
+	}
+	if err := svc.CreateSalesOrder(sale); err != nil {
+		t.Fatalf("Failed to create sales order: %v", err)
+	}
+	if line := sale.Lines[0]; line.Quantity != 4 || line.Unit != "cubic_yard" {
+		t.Errorf("Expected a line of 4 cubic yards, got %+v", line)
+	}
+	for _, step := range []func(string, int64) (*models.SalesOrder, error){
+		svc.ConfirmSalesOrder, svc.AllocateSalesOrder, svc.PickSalesOrder, svc.ShipSalesOrder,
+	} {
+		if _, err := step("so1", 0); err != nil {
+			t.Fatalf("Failed to advance sales order: %v", err)
+		}
+	}
+	checkItem(t, svc, "mulch-1", 11, 0)
+
+	bad := &models.SalesOrder{ID: "so2", BuyerID: "b1", Lines: []models.SalesOrderLine{{ProductID: "mulch", Quantity: 1, Unit: "each"}}}
+	if err := svc.CreateSalesOrder(bad); err != ErrInvalidUnit {
+		t.Errorf("Expected ErrInvalidUnit for mulch by the each, got %v", err)
+	}
+}
+
+func TestSalesOrderPricesInTheirUnit(t *testing.T) {
+	svc := newUnitService(t)
+
+	order := &models.SalesOrder{ID: "so1", BuyerID: "b1", Lines: []models.SalesOrderLine{
+		{ProductID: "bolts", Quantity: 1, Unit: "case", UnitPrice: usd(1000)},
+		{ProductID: "bolts", Quantity: 3, Unit: "pallet", UnitPrice: usd(1000), PriceUnit: "case"},
+	}}
+	if err := svc.CreateSalesOrder(order); err != nil {
+		t.Fatalf("Failed to create sales order: %v", err)
+	}
+	// A case of 12 at 10.00 totals 10.00, not 12 at 0.83 each
+	checkLine := func(line models.SalesOrderLine, priceUnit string, total models.Money) {
+		t.Helper()
+		if line.UnitPrice != usd(1000) || line.PriceUnit != priceUnit || line.Total != total {
+			t.Errorf("Expected 10.00 USD a %s, %v in all, got %+v", priceUnit, total, line)
+		}
+	}
+	checkLine(order.Lines[0], "case", usd(1000))
+	checkLine(order.Lines[1], "case", usd(12000))
+
+	// The stored lines, in the base unit, total the same when sent back
+	stored, err := svc.GetSalesOrder("so1")
+	if err != nil {
+		t.Fatalf("Failed to get sales order: %v", err)
+	}
+	if err := svc.UpdateSalesOrder(stored); err != nil {
+		t.Fatalf("Failed to update sales order: %v", err)
+	}
+	if stored.Lines[0].Quantity != 12 || stored.Lines[0].Unit != "each" {
+		t.Errorf("Expected 12 each on line 0, got %+v", stored.Lines[0])
+	}
+	checkLine(stored.Lines[0], "case", usd(1000))
+	checkLine(stored.Lines[1], "case", usd(12000))
+}
+
+func TestPurchaseOrderCostsInTheirUnit(t *testing.T) {
+	svc := newUnitService(t)
+	expectedAt := time.Now().AddDate(0, 0, 7)
+
+	order := &models.PurchaseOrder{ID: "po1", VendorID: "v1", Lines: []models.PurchaseOrderLine{
+		{ProductID: "bolts", Quantity: 2, Unit: "case", Cost: usd(1500), ExpectedAt: expectedAt},
+		{ProductID: "bolts", Quantity: 7, Unit: "case", Cost: usd(1000), ExpectedAt: expectedAt},
+		{ProductID: "bolts", Quantity: 18, Cost: usd(1000), CostUnit: "case", ExpectedAt: expectedAt},
+		{ProductID: "mulch", Quantity: 3, ExpectedAt: expectedAt},
+	}}
+	if err := svc.CreatePurchaseOrder(order); err != nil {
+		t.Fatalf("Failed to create purchase order: %v", err)
+	}
+	// 10.00 a case of 12 is 0.8333... each, but 7 cases still total 70.00
+	lines := []struct {
+		cost     models.Money
+		costUnit string
+		total    models.Money
+	}{
+		{usd(1500), "case", usd(3000)},
+		{usd(1000), "case", usd(7000)},
+		{usd(1000), "case", usd(1500)},
+		{usd(0), "cubic_yard", usd(0)},
+	}
+	for i, want := range lines {
+		line := order.Lines[i]
+		if line.Cost != want.cost || line.CostUnit != want.costUnit || line.Total != want.total {
+			t.Errorf("Expected line %d to cost %v a %s, %v in all, got %v a %s, %v", i, want.cost, want.costUnit, want.total,
+				line.Cost, line.CostUnit, line.Total)
+		}
+	}
+	if order.Lines[1].Quantity != 84 || order.Lines[1].Unit != "each" {
+		t.Errorf("Expected 84 each on line 1, got %v %s", order.Lines[1].Quantity, order.Lines[1].Unit)
+	}
+
+	// Costs in a unit the product cannot be counted in fail
+	invalid := &models.PurchaseOrder{ID: "po2", VendorID: "v1", Lines: []models.PurchaseOrderLine{
+		{ProductID: "bolts", Quantity: 1, Cost: usd(100), CostUnit: "kg", ExpectedAt: expectedAt},
+	}}
+	if err := svc.CreatePurchaseOrder(invalid); err != ErrInvalidUnit {
+		t.Errorf("Expected ErrInvalidUnit for a cost by the kg, got %v", err)
+	}
+
+	for _, lines := range [][]models.PurchaseOrderLine{
+		{{ProductID: "bolts", Quantity: 1, Cost: usd(-1), ExpectedAt: expectedAt}},
+		{{ProductID: "bolts", Quantity: 1, Cost: models.Money{Minor: 100}, ExpectedAt: expectedAt}},
+		{
+			{ProductID: "bolts", Quantity: 1, Cost: usd(100), ExpectedAt: expectedAt},
+			{ProductID: "bolts", Quantity: 1, Cost: models.Money{Minor: 100, Currency: "EUR"}, ExpectedAt: expectedAt},
+		},
+	} {
+		invalid := &models.PurchaseOrder{ID: "po2", VendorID: "v1", Lines: lines}
+		if err := svc.CreatePurchaseOrder(invalid); err != ErrInvalidPrice {
+			t.Errorf("Expected ErrInvalidPrice for %+v, got %v", lines, err)
+		}
+	}
+
+	// Lines without a cost take the currency of the others
+	euros := &models.PurchaseOrder{ID: "po2", VendorID: "v1", Lines: []models.PurchaseOrderLine{
+		{ProductID: "bolts", Quantity: 1, ExpectedAt: expectedAt},
+		{ProductID: "bolts", Quantity: 1, Cost: models.Money{Minor: 100, Currency: "EUR"}, ExpectedAt: expectedAt},
+	}}
+	if err := svc.CreatePurchaseOrder(euros); err != nil {
+		t.Fatalf("Failed to create purchase order: %v", err)
+	}
+	if euros.Lines[0].Cost != (models.Money{Currency: "EUR"}) {
+		t.Errorf("Expected a cost of nothing in EUR, got %v", euros.Lines[0].Cost)
+	}
+}
+
+func TestBaseUnitChanges(t *testing.T) {
+	svc := newUnitService(t)
+
+	seed, err := svc.GetProduct("seed")
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	seed.BaseUnit = "kg"
+	if err := svc.UpdateProduct(seed); err != ErrBaseUnitInUse {
+		t.Errorf("Expected ErrBaseUnitInUse for a product with items, got %v", err)
+	}
+
+	fresh := &models.Product{ID: "fresh", Name: "Compost", VendorID: "v1", BaseUnit: "cubic_foot"}
+	if err := svc.CreateProduct(fresh); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	updated, err := svc.PatchProduct("fresh", 0, func(product *models.Product) error {
+		product.BaseUnit = "cubic_yard"
+		product.Packs = []models.Pack{{Unit: "bag", Quantity: 1, Of: "cubic_foot"}}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to change an unused base unit: %v", err)
+	}
+	if updated.BaseUnit != "cubic_yard" || len(updated.Packs) != 1 {
+		t.Errorf("Expected compost in cubic yards with a bag, got %+v", updated)
+	}
+
+	item, err := svc.GetInventoryItem("mulch-1")
+	if err != nil {
+		t.Fatalf("Failed to get inventory item: %v", err)
+	}
+	item.ProductID = "seed"
+	if err := svc.UpdateInventoryItem(item); err != ErrInvalidUnit {
+		t.Errorf("Expected ErrInvalidUnit moving stock to a product weighed in pounds, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+var (
+	ErrInvalidVariant = errors.New("invalid variant")
+	ErrVariantExists  = errors.New("a variant with these attributes already exists")
+)
+
+// validateAxes fails with ErrInvalidVariant unless each of product's axes
+// has a new, non-empty name and at least one value, every value new and
+// non-empty. A variant cannot have axes of its own.
+func validateAxes(product *models.Product) error {
+	if len(product.Axes) > 0 && product.ParentID != "" {
+		return ErrInvalidVariant
+	}
+	for i, axis := range product.Axes {
+		if axis.Name == "" || len(axis.Values) == 0 {
+			return ErrInvalidVariant
+		}
+		if slices.IndexFunc(product.Axes[:i], func(a models.Axis) bool { return a.Name == axis.Name }) >= 0 {
+			return ErrInvalidVariant
+		}
+		for j, value := range axis.Values {
+			if value == "" || slices.Contains(axis.Values[:j], value) {
+				return ErrInvalidVariant
+			}
+		}
+	}
+	return nil
+}
+
+// validAttributes reports whether attributes give one of the values of
+// each of axes and nothing else
+func validAttributes(axes []models.Axis, attributes map[string]string) bool {
+	if len(attributes) != len(axes) {
+		return false
+	}
+	for _, axis := range axes {
+		if !slices.Contains(axis.Values, attributes[axis.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// inheritParent checks a variant against its parent and copies the fields
+// it inherits, VendorID and Category, from the parent. A missing parent
+// fails with repository.ErrInvalidReference. A parent without axes or that
+// is itself a variant, or attributes that are not one value of each of the
+// parent's axes, fail with ErrInvalidVariant, and attributes another
+// variant of the parent already has with ErrVariantExists. A product that
+// is not a variant cannot have attributes.
+func (s *InventoryService) inheritParent(product *models.Product) error {
+	if product.ParentID == "" {
+		if len(product.Attributes) > 0 {
+			return ErrInvalidVariant
+		}
+		return nil
+	}
+	parent, err := s.repo.GetProduct(product.ParentID)
+	if err == repository.ErrNotFound {
+		return repository.ErrInvalidReference
+	} else if err != nil {
+		return err
+	}
+	if parent.ParentID != "" || len(parent.Axes) == 0 || !validAttributes(parent.Axes, product.Attributes) {
+		return ErrInvalidVariant
+	}
+	siblings, err := s.repo.FindProducts(repository.ProductFilter{ParentID: parent.ID, Attributes: product.Attributes})
+	if err != nil {
+		return err
+	}
+	for _, sibling := range siblings {
+		if sibling.ID != product.ID {
+			return ErrVariantExists
+		}
+	}
+	product.VendorID = parent.VendorID
+	product.Category = parent.Category
+	return nil
+}
+
+// validateVariant checks product's axes and, if it is a variant, its
+// parent, as described for validateAxes and inheritParent
+func (s *InventoryService) validateVariant(product *models.Product) error {
+	if err := validateAxes(product); err != nil {
+		return err
+	}
+	return s.inheritParent(product)
+}
+
+// checkAxesChange fails with ErrInvalidVariant if product has variants
+// whose attributes its new axes no longer allow
+func (s *InventoryService) checkAxesChange(product *models.Product) error {
+	variants, err := s.repo.FindProducts(repository.ProductFilter{ParentID: product.ID})
+	if err != nil {
+		return err
+	}
+	for _, variant := range variants {
+		if !validAttributes(product.Axes, variant.Attributes) {
+			return ErrInvalidVariant
+		}
+	}
+	return nil
+}
+
+// updateVariants copies the fields variants inherit from parent to each
+// of its variants that differs
+func (s *InventoryService) updateVariants(parent *models.Product) error {
+	variants, err := s.repo.FindProducts(repository.ProductFilter{ParentID: parent.ID})
+	if err != nil {
+		return err
+	}
+	for _, variant := range variants {
+		if variant.VendorID == parent.VendorID && variant.Category == parent.Category {
+			continue
+		}
+		variant.VendorID = parent.VendorID
+		variant.Category = parent.Category
+		if err := s.repo.UpdateProduct(variant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListVariants returns the variants of a product with each of attributes,
+// or ErrNotFound if the product does not exist
+func (s *InventoryService) ListVariants(parentID string, attributes map[string]string) ([]*models.Product, error) {
+	if _, err := s.repo.GetProduct(parentID); err != nil {
+		return nil, err
+	}
+	return s.repo.FindProducts(repository.ProductFilter{ParentID: parentID, Attributes: attributes})
+}
+
+// GenerateVariants creates a variant of a product for each combination of
+// the values of its axes that has none yet, and returns them. Variants
+// start as copies of the parent, priced as it is, named after it and
+// their values, with an ID made of the parent's and the values'. It fails
+// with ErrNotFound if the product does not exist and with
+// ErrInvalidVariant if it has no axes or is itself a variant.
+func (s *InventoryService) GenerateVariants(parentID string) ([]*models.Product, error) {
+	var created []*models.Product
+	err := s.Atomically(func(svc *InventoryService) error {
+		parent, err := svc.repo.GetProduct(parentID)
+		if err != nil {
+			return err
+		}
+		if parent.ParentID != "" || len(parent.Axes) == 0 {
+			return ErrInvalidVariant
+		}
+		for _, values := range combinations(parent.Axes) {
+			attributes := make(map[string]string, len(values))
+			for i, axis := range parent.Axes {
+				attributes[axis.Name] = values[i]
+			}
+			existing, err := svc.repo.FindProducts(repository.ProductFilter{ParentID: parentID, Attributes: attributes})
+			if err != nil {
+				return err
+			}
+			if len(existing) > 0 {
+				continue
+			}
+			variant := parent.Clone()
+			variant.ID = variantID(parent.ID, values)
+			variant.Name = parent.Name + " (" + strings.Join(values, ", ") + ")"
+			variant.ParentID = parent.ID
+			variant.Axes = nil
+			variant.Attributes = attributes
+			if err := svc.CreateProduct(variant); err != nil {
+				return err
+			}
+			created = append(created, variant)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// combinations returns every combination of one value of each of axes, in
+// order, the last axis varying fastest
+func combinations(axes []models.Axis) [][]string {
+	result := [][]string{nil}
+	for _, axis := range axes {
+		var next [][]string
+		for _, prefix := range result {
+			for _, value := range axis.Values {
+				next = append(next, append(slices.Clone(prefix), value))
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+// variantID returns the ID of the variant of parentID with values: the
+// parent's ID followed by each value, lowercased, with every run of other
+// than letters and digits made a hyphen
+func variantID(parentID string, values []string) string {
+	parts := []string{parentID}
+	for _, value := range values {
+		slug := strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		parts = append(parts, strings.Join(slug, "-"))
+	}
+	return strings.Join(parts, "-")
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/raybman/gomaterials-slt-sandbox/internal/models"
+	"github.com/raybman/gomaterials-slt-sandbox/internal/repository"
+)
+
+// newVariantService returns a service with vendors v1 and v2 and product
+// hose of v1, in 25, 50 and 100 ft lengths and green and black
+func newVariantService(t *testing.T) *InventoryService {
+	t.Helper()
+	return newTestService(t,
+		withVendors(&models.Vendor{ID: "v2", Name: "Hoses Direct"}),
+		withLocations(activeLocation("wh", models.LocationWarehouse, "")),
+		withProducts(&models.Product{
+			ID:       "hose",
+			Name:     "Garden Hose",
+			VendorID: "v1",
+			Category: "watering",
+			Price:    usd(2000),
+			Axes: []models.Axis{
+				{Name: "length", Values: []string{"25 ft", "50 ft", "100 ft"}},
+				{Name: "colour", Values: []string{"Green", "Black"}},
+			},
+		}),
+	)
+}
+
+func TestGenerateVariants(t *testing.T) {
+	svc := newVariantService(t)
+
+	variants, err := svc.GenerateVariants("hose")
+	if err != nil {
+		t.Fatalf("Failed to generate variants: %v", err)
+	}
+	if len(variants) != 6 {
+		t.Fatalf("Expected six variants, got %d", len(variants))
+	}
+	first := variants[0]
+	if first.ID != "hose-25-ft-green" || first.Name != "Garden Hose (25 ft, Green)" || first.ParentID != "hose" ||
+		first.Price != usd(2000) || first.VendorID != "v1" || first.Category != "watering" || len(first.Axes) != 0 {
+		t.Errorf("Expected a 25 ft green hose copied from its parent, got %+v", first)
+	}
+	if last := variants[5]; last.Attributes["length"] != "100 ft" || last.Attributes["colour"] != "Black" {
+		t.Errorf("Expected the last variant to be the 100 ft black hose, got %+v", last.Attributes)
+	}
+
+	if err := svc.DeleteProduct("hose-50-ft-black", 0, false); err != nil {
+		t.Fatalf("Failed to delete variant: %v", err)
+	}
+	again, err := svc.GenerateVariants("hose")
+	if err != nil {
+		t.Fatalf("Failed to generate variants: %v", err)
+	}
+	if len(again) != 1 || again[0].ID != "hose-50-ft-black" {
+		t.Errorf("Expected only the missing variant to be generated, got %+v", again)
+	}
+
+	greens, err := svc.ListVariants("hose", map[string]string{"colour": "Green"})
+	if err != nil {
+		t.Fatalf("Failed to list variants: %v", err)
+	}
+	if len(greens) != 3 {
+		t.Errorf("Expected three green hoses, got %d", len(greens))
+	}
+	if _, err := svc.GenerateVariants("hose-25-ft-green"); err != ErrInvalidVariant {
+		t.Errorf("Expected ErrInvalidVariant generating variants of a variant, got %v", err)
+	}
+	if _, err := svc.ListVariants("missing", nil); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing product, got %v", err)
+	}
+}
+
+func TestVariantsInheritFromParent(t *testing.T) {
+	svc := newVariantService(t)
+
+	variant := &models.Product{
+		ID:         "hose-50",
+		Name:       "Garden Hose, 50 ft green",
+		VendorID:   "v2",
+		Category:   "hoses",
+		Price:      usd(3500),
+		ParentID:   "hose",
+		Attributes: map[string]string{"length": "50 ft", "colour": "Green"},
+	}
+	if err := svc.CreateProduct(variant); err != nil {
+		t.Fatalf("Failed to create variant: %v", err)
+	}
+	if variant.VendorID != "v1" || variant.Category != "watering" || variant.Price != usd(3500) {
+		t.Errorf("Expected the variant's vendor and category from its parent and its own price, got %+v", variant)
+	}
+
+	if _, err := svc.PatchProduct("hose", 0, func(product *models.Product) error {
+		product.VendorID = "v2"
+		product.Category = "irrigation"
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to patch parent: %v", err)
+	}
+	got, err := svc.GetProduct("hose-50")
+	if err != nil {
+		t.Fatalf("Failed to get variant: %v", err)
+	}
+	if got.VendorID != "v2" || got.Category != "irrigation" {
+		t.Errorf("Expected the variant to follow its parent's vendor and category, got %+v", got)
+	}
+
+	item := &models.InventoryItem{ID: "hose-50-1", ProductID: "hose-50", Quantity: 4, Location: "wh"}
+	if err := svc.CreateInventoryItem(item); err != nil {
+		t.Fatalf("Failed to stock variant: %v", err)
+	}
+	checkItem(t, svc, "hose-50-1", 4, 0)
+}
+
+func TestInvalidVariants(t *testing.T) {
+	svc := newVariantService(t)
+
+	if err := svc.CreateProduct(&models.Product{ID: "hose-50", Name: "Hose", ParentID: "hose",
+		Attributes: map[string]string{"length": "50 ft", "colour": "Green"}}); err != nil {
+		t.Fatalf("Failed to create variant: %v", err)
+	}
+	if err := svc.CreateProduct(&models.Product{ID: "pot", Name: "Pot", VendorID: "v1"}); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		product *models.Product
+		err     error
+	}{
+		{"missing parent", &models.Product{ParentID: "missing"}, repository.ErrInvalidReference},
+		{"parent without axes", &models.Product{ParentID: "pot"}, ErrInvalidVariant},
+		{"variant of a variant", &models.Product{ParentID: "hose-50",
+			Attributes: map[string]string{"length": "50 ft", "colour": "Green"}}, ErrInvalidVariant},
+		{"missing attribute", &models.Product{ParentID: "hose",
+			Attributes: map[string]string{"length": "50 ft"}}, ErrInvalidVariant},
+		{"unknown value", &models.Product{ParentID: "hose",
+			Attributes: map[string]string{"length": "75 ft", "colour": "Green"}}, ErrInvalidVariant},
+		{"extra attribute", &models.Product{ParentID: "hose",
+			Attributes: map[string]string{"length": "25 ft", "colour": "Green", "size": "large"}}, ErrInvalidVariant},
+		{"taken attributes", &models.Product{ParentID: "hose",
+			Attributes: map[string]string{"length": "50 ft", "colour": "Green"}}, ErrVariantExists},
+		{"attributes without a parent", &models.Product{VendorID: "v1",
+			Attributes: map[string]string{"colour": "Green"}}, ErrInvalidVariant},
+		{"variant with axes", &models.Product{ParentID: "hose",
+			Attributes: map[string]string{"length": "25 ft", "colour": "Green"},
+			Axes:       []models.Axis{{Name: "width", Values: []string{"wide"}}}}, ErrInvalidVariant},
+		{"axis without values", &models.Product{VendorID: "v1",
+			Axes: []models.Axis{{Name: "colour"}}}, ErrInvalidVariant},
+		{"axis named twice", &models.Product{VendorID: "v1",
+			Axes: []models.Axis{{Name: "colour", Values: []string{"red"}}, {Name: "colour", Values: []string{"blue"}}}}, ErrInvalidVariant},
+		{"value given twice", &models.Product{VendorID: "v1",
+			Axes: []models.Axis{{Name: "colour", Values: []string{"red", "red"}}}}, ErrInvalidVariant},
+	}
+	for _, tt := range tests {
+		tt.product.ID = "p-" + tt.name
+		tt.product.Name = tt.name
+		if err := svc.CreateProduct(tt.product); err != tt.err {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	if _, err := svc.PatchProduct("hose", 0, func(product *models.Product) error {
+		product.Axes[0].Values = []string{"25 ft", "100 ft"}
+		return nil
+	}); err != ErrInvalidVariant {
+		t.Errorf("Expected ErrInvalidVariant removing a length a variant has, got %v", err)
+	}
+	if _, err := svc.PatchProduct("hose", 0, func(product *models.Product) error {
+		product.Axes[1].Values = append(product.Axes[1].Values, "Blue")
+		return nil
+	}); err != nil {
+		t.Errorf("Failed to add a colour: %v", err)
+	}
+}